@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Trade condition codes of auction prints. Ticks with IsOpening or IsClosing flags are auction prints too
+var (
+	OpeningPrintConditions = []string{"O", "Q"}
+	ClosingPrintConditions = []string{"6", "M"}
+)
+
+func (t *Tick) hasCondition(conditions []string) bool {
+	for _, c := range conditions {
+		if c == "" {
+			continue
+		}
+		if t.Cond1 == c || t.Cond2 == c || t.Cond3 == c || t.Cond4 == c {
+			return true
+		}
+	}
+	return false
+}
+
+//isOpeningPrint returns true for trade of opening auction. Its price is clearing price and its size is
+//auction volume
+func (t *Tick) isOpeningPrint() bool {
+	return t.HasTrade() && (t.IsOpening || t.hasCondition(OpeningPrintConditions))
+}
+
+//isClosingPrint returns true for trade of closing auction
+func (t *Tick) isClosingPrint() bool {
+	return t.HasTrade() && (t.IsClosing || t.hasCondition(ClosingPrintConditions))
+}
+
+//auctionExecQty returns qty of auction order that can be filled in auction print. Order can take only
+//participation part of auction volume. Participation out of (0, 1] means the whole auction volume
+func (b *simBrokerWorker) auctionExecQty(o *simBrokerOrder, tick *Tick) int64 {
+	available := tick.LastSize
+	if b.auctionParticipation > 0 && b.auctionParticipation < 1 {
+		available = int64(math.Floor(float64(tick.LastSize) * b.auctionParticipation))
+	}
+	qty := o.BrokerQty - o.BrokerExecQty
+	if qty > available {
+		qty = available
+	}
+	if qty < 0 {
+		return 0
+	}
+	return qty
+}
+
+//******* IMBALANCE FEED ******************************************************
+
+//parseLineToImbalance parses line of imbalance feed file:
+//"unix time,symbol,O|C,paired qty,imbalance qty,B|S,reference price,near price,far price". Empty prices are NaN
+func parseLineToImbalance(l string, tickersMap map[string]*Instrument) ([]event, error) {
+	ls := strings.Split(strings.TrimSpace(l), ",")
+	if len(ls) != 9 {
+		return nil, errors.New("Can't parse line to imbalance: " + l)
+	}
+
+	sec, err := strconv.ParseInt(ls[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "Wrong imbalance time")
+	}
+
+	ticker, ok := tickersMap[ls[1]]
+	if !ok {
+		return nil, nil
+	}
+
+	e := AuctionImbalanceEvent{BaseEvent: be(time.Unix(sec, 0), ticker)}
+	switch ls[2] {
+	case "O":
+		e.Auction = OpeningAuction
+	case "C":
+		e.Auction = ClosingAuction
+	default:
+		return nil, errors.New("Wrong imbalance auction type: " + ls[2])
+	}
+
+	if e.PairedQty, err = strconv.ParseInt(ls[3], 10, 64); err != nil {
+		return nil, errors.Wrap(err, "Wrong imbalance paired qty")
+	}
+	if e.ImbalanceQty, err = strconv.ParseInt(ls[4], 10, 64); err != nil {
+		return nil, errors.Wrap(err, "Wrong imbalance qty")
+	}
+
+	switch OrderSide(ls[5]) {
+	case OrderBuy, OrderSell:
+		e.ImbalanceSide = OrderSide(ls[5])
+	case "":
+	default:
+		return nil, errors.New("Wrong imbalance side: " + ls[5])
+	}
+
+	prices := []*float64{&e.ReferencePrice, &e.NearPrice, &e.FarPrice}
+	for i, p := range prices {
+		s := ls[6+i]
+		if s == "" {
+			*p = math.NaN()
+			continue
+		}
+		if *p, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, errors.Wrap(err, "Wrong imbalance price")
+		}
+	}
+
+	return []event{&e}, nil
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type imbalanceTestStrategy struct {
+	DummyStrategyWithLogic
+	imbalances []*AuctionImbalanceEvent
+}
+
+func (s *imbalanceTestStrategy) OnAuctionImbalance(b *BasicStrategy, e *AuctionImbalanceEvent) {
+	s.imbalances = append(s.imbalances, e)
+}
+
+func TestAuctions_ParseImbalance(t *testing.T) {
+	inst := newTestInstrument()
+	tickersMap := map[string]*Instrument{"Test": inst}
+
+	t.Log("Parse imbalance line")
+	{
+		events, err := parseLineToImbalance("1262705700,Test,C,50000,12000,S,20.01,20.02,", tickersMap)
+		assert.Nil(t, err)
+		if assert.Len(t, events, 1) {
+			e := events[0].(*AuctionImbalanceEvent)
+			assert.Equal(t, time.Unix(1262705700, 0), e.Time)
+			assert.Equal(t, inst, e.Ticker)
+			assert.Equal(t, ClosingAuction, e.Auction)
+			assert.Equal(t, int64(50000), e.PairedQty)
+			assert.Equal(t, int64(12000), e.ImbalanceQty)
+			assert.Equal(t, OrderSell, e.ImbalanceSide)
+			assert.Equal(t, 20.01, e.ReferencePrice)
+			assert.Equal(t, 20.02, e.NearPrice)
+			assert.True(t, math.IsNaN(e.FarPrice))
+		}
+	}
+
+	t.Log("Imbalance of unknown symbol is skipped")
+	{
+		events, err := parseLineToImbalance("1262705700,Other,O,100,0,,20,20,20", tickersMap)
+		assert.Nil(t, err)
+		assert.Len(t, events, 0)
+	}
+
+	t.Log("Wrong imbalance lines")
+	{
+		for _, l := range []string{
+			"1262705700,Test,C,100,0,S,20,20",
+			"time,Test,C,100,0,S,20,20,20",
+			"1262705700,Test,X,100,0,S,20,20,20",
+			"1262705700,Test,C,many,0,S,20,20,20",
+			"1262705700,Test,C,100,0,Z,20,20,20",
+			"1262705700,Test,C,100,0,S,price,20,20",
+		} {
+			_, err := parseLineToImbalance(l, tickersMap)
+			assert.NotNil(t, err, l)
+		}
+	}
+}
+
+func TestAuctions_ImbalanceFeed(t *testing.T) {
+	inst := newTestInstrument()
+
+	t.Log("Imbalances from files are put before market data events of the same time")
+	{
+		dir, err := ioutil.TempDir("", "imbalances")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		first := filepath.Join(dir, "close.csv")
+		second := filepath.Join(dir, "open.csv")
+		assert.Nil(t, ioutil.WriteFile(first, []byte("1262707200,Test,C,100,10,B,20,20,20\n"+
+			"1262707100,Other,C,100,10,B,20,20,20\n"), 0644))
+		assert.Nil(t, ioutil.WriteFile(second, []byte("1262701800,Test,O,100,10,S,20,20,20\n\n"), 0644))
+
+		m := BTM{Symbols: []*Instrument{inst}, ImbalanceFiles: []string{first, second}, mdChan: make(chan event, 10)}
+		assert.Nil(t, m.loadFeedEvents())
+		if assert.Len(t, m.feedEvents, 2) {
+			assert.Equal(t, OpeningAuction, m.feedEvents[0].(*AuctionImbalanceEvent).Auction)
+		}
+
+		m.newEvent(&EndOfDataEvent{BaseEvent: be(time.Unix(1262701800, 0), inst)})
+		m.newEvent(&EndOfDataEvent{BaseEvent: be(time.Unix(1262707300, 0), inst)})
+		close(m.mdChan)
+		var names []string
+		for e := range m.mdChan {
+			names = append(names, e.getName())
+		}
+		assert.Equal(t, []string{"AuctionImbalanceEvent", "EndOfDataEvent", "AuctionImbalanceEvent",
+			"EndOfDataEvent"}, names)
+	}
+
+	t.Log("Missing imbalance file")
+	{
+		m := BTM{Symbols: []*Instrument{inst}, ImbalanceFiles: []string{"/not/existing/imbalances.csv"}}
+		assert.NotNil(t, m.loadFeedEvents())
+	}
+
+	t.Log("Imbalances are delivered to OnAuctionImbalance")
+	{
+		us := imbalanceTestStrategy{}
+		bs := BasicStrategy{symbol: inst, nPeriods: 20, userStrategy: &us}
+		bs.init(CoreStrategyChannels{
+			errors:    make(chan error),
+			events:    make(chan event),
+			portfolio: make(chan *PortfolioNewPositionEvent, 5),
+		})
+		bs.mdChan = make(chan event, 1)
+		bs.mdChan <- &NewTickEvent{}
+		bs.handlersWaitGroup = &sync.WaitGroup{}
+
+		tm := time.Date(2010, 1, 5, 15, 50, 0, 0, time.UTC)
+		e := AuctionImbalanceEvent{BaseEvent: be(tm, inst), Auction: ClosingAuction, ImbalanceQty: 100}
+		bs.notify(&e)
+		bs.handlersWaitGroup.Wait()
+
+		assert.Equal(t, []*AuctionImbalanceEvent{&e}, us.imbalances)
+		assert.Equal(t, tm, bs.Now())
+	}
+}
@@ -26,40 +26,131 @@ type IBroker interface {
 	shutDown()
 }
 
+//simBrokerOrder keeps broker side values of order. Strategy changes its order only when it gets broker events,
+//so amended price, qty and tif are kept here. StateUpdTime is time order got its place in queue: order isn't
+//filled by market data before it
 type simBrokerOrder struct {
 	*Order
 	BrokerState   OrderState
 	StateUpdTime  time.Time
 	BrokerExecQty int64
 	BrokerPrice   float64
+	BrokerQty     int64
+	BrokerTif     OrderTIF
 }
 
 func (o *simBrokerOrder) getExpirationTime() time.Time {
-	switch o.Tif {
-	case GTCTIF:
+	switch o.BrokerTif {
+	case GTCTIF, GTCExtTIF:
 		return o.Time.AddDate(10, 0, 0)
-	case DayTIF:
-		nextDay := o.Time.AddDate(0, 0, 1)
-		return time.Date(nextDay.Year(), nextDay.Month(), nextDay.Day(), 0, 0, 0, 0, o.Time.Location())
-	case AuctionTIF:
+	case GTDTIF:
+		return o.ExpireTime
+	case DayTIF, DayExtTIF, IOCTIF, FOKTIF:
+		return o.Ticker.Exchange.endOfDay(o.Time)
+	case AuctionTIF, OPGTIF, CLSTIF:
 		if o.Type == MarketOnOpen || o.Type == LimitOnOpen {
-			mot := o.Ticker.Exchange.MarketOpenTime
-			t := time.Date(o.Time.Year(), o.Time.Month(), o.Time.Day(), mot.Hour, mot.Minute, mot.Second, 0, o.Time.Location())
-			return t.Add(3 * time.Minute)
+			return o.Ticker.Exchange.OpenTime(o.Time).Add(3 * time.Minute)
 		}
 
 		if o.Type == MarketOnClose || o.Type == LimitOnClose {
-			mct := o.Ticker.Exchange.MarketCloseTime
-			t := time.Date(o.Time.Year(), o.Time.Month(), o.Time.Day(), mct.Hour, mct.Minute, mct.Second, 0, o.Time.Location())
-			return t.Add(3 * time.Second)
+			return o.Ticker.Exchange.CloseTime(o.Time).Add(3 * time.Second)
 		}
 
 		panic("Found non auction order type with auction tif")
 	default:
-		panic("Unknown order tif: " + string(o.Tif))
+		panic("Unknown order tif: " + string(o.BrokerTif))
 	}
 }
 
+//validateOrderTif returns reason to reject order with type and tif that don't go together or don't match session
+//state at time t when order gets to broker. Empty string means order is fine
+func validateOrderTif(o *Order, t time.Time) string {
+	switch o.Tif {
+	case DayTIF, GTCTIF, IOCTIF, AuctionTIF, FOKTIF, GTDTIF, OPGTIF, CLSTIF, DayExtTIF, GTCExtTIF:
+	default:
+		return "Unknown order TIF: " + string(o.Tif)
+	}
+
+	if o.Type.isAuction() != o.Tif.isAuction() {
+		return fmt.Sprintf("Order type %v can't be sent with TIF %v", o.Type, o.Tif)
+	}
+	if o.Tif == OPGTIF && o.Type != MarketOnOpen && o.Type != LimitOnOpen {
+		return fmt.Sprintf("OPG TIF is allowed for on open orders only. Order type: %v", o.Type)
+	}
+	if o.Tif == CLSTIF && o.Type != MarketOnClose && o.Type != LimitOnClose {
+		return fmt.Sprintf("CLS TIF is allowed for on close orders only. Order type: %v", o.Type)
+	}
+	if o.Tif.isImmediate() && o.Type != LimitOrder && o.Type != MarketOrder {
+		return fmt.Sprintf("%v is allowed for market and limit orders only. Order type: %v", o.Tif, o.Type)
+	}
+
+	e := o.Ticker.Exchange
+	if o.Tif.isExtendedHours() && !e.hasExtendedHours() {
+		return fmt.Sprintf("Exchange %v has no extended hours session. TIF: %v", e.Name, o.Tif)
+	}
+
+	switch o.Tif {
+	case GTCTIF, GTCExtTIF:
+		return ""
+	case GTDTIF:
+		if !o.ExpireTime.After(t) {
+			return fmt.Sprintf("GTD order expire time %v should be after order time %v", o.ExpireTime, t)
+		}
+		return ""
+	}
+
+	//Exchange without session times has no trading days and session state to check
+	if !e.hasSession() {
+		return ""
+	}
+	openTime := e.OpenTime(t)
+	closeTime := e.CloseTime(t)
+	if !e.IsTradingDay(t) {
+		return "Market is closed on weekend or holiday. TIF: " + string(o.Tif)
+	}
+
+	switch o.Type {
+	case MarketOnOpen, LimitOnOpen:
+		if !t.Before(openTime) {
+			return fmt.Sprintf("%v order should be sent before opening auction", o.Type)
+		}
+		return ""
+	case MarketOnClose, LimitOnClose:
+		if !t.Before(closeTime) {
+			return fmt.Sprintf("%v order should be sent before closing auction", o.Type)
+		}
+		return ""
+	}
+
+	if o.Tif == DayExtTIF {
+		if !t.Before(e.extendedClose(t)) {
+			return "After hours session is closed. TIF: " + string(o.Tif)
+		}
+		return ""
+	}
+
+	if !t.Before(closeTime) {
+		return "Market is closed. TIF: " + string(o.Tif)
+	}
+	if o.Tif.isImmediate() && t.Before(openTime) {
+		return fmt.Sprintf("%v order can't be sent before market open", o.Tif)
+	}
+	return ""
+}
+
+//isInTradingSession returns true if order can be filled at time t. Exchanges without extended hours don't limit
+//executions by time. Auction orders are checked by auction rules
+func (o *simBrokerOrder) isInTradingSession(t time.Time) bool {
+	e := o.Ticker.Exchange
+	if !e.hasExtendedHours() || o.Type.isAuction() {
+		return true
+	}
+	if o.BrokerTif.isExtendedHours() {
+		return !t.Before(e.extendedOpen(t)) && !t.After(e.extendedClose(t))
+	}
+	return !t.Before(e.OpenTime(t)) && !t.After(e.CloseTime(t))
+}
+
 func (o *simBrokerOrder) isExpired(t time.Time) bool {
 	if t.After(o.getExpirationTime()) {
 		return true
@@ -67,6 +158,45 @@ func (o *simBrokerOrder) isExpired(t time.Time) bool {
 	return false
 }
 
+//validateAmendment returns reason to reject amendment or empty string if it can be applied
+func (o *simBrokerOrder) validateAmendment(a OrderAmendment) string {
+	if a.isEmpty() {
+		return fmt.Sprintf("Replace price %v is not valid and nothing else is changed", a.Price)
+	}
+	if a.Price < 0 {
+		return fmt.Sprintf("Replace price %v is not valid", a.Price)
+	}
+	if a.changesPrice() && !o.IsPriced() {
+		return "Can't change price of not priced order type " + string(o.Type)
+	}
+	if a.Qty < 0 {
+		return fmt.Sprintf("Replace qty %v is not valid", a.Qty)
+	}
+	if a.Qty != 0 && a.Qty < o.BrokerExecQty {
+		return fmt.Sprintf("Replace qty %v is less than executed qty %v", a.Qty, o.BrokerExecQty)
+	}
+	if a.Tif != "" {
+		if a.Tif != DayTIF && a.Tif != GTCTIF && a.Tif != DayExtTIF && a.Tif != GTCExtTIF {
+			return "Order TIF can't be changed to " + string(a.Tif)
+		}
+		if o.BrokerTif.isAuction() || o.BrokerTif.isImmediate() || o.BrokerTif == GTDTIF {
+			return "TIF of order can't be changed from " + string(o.BrokerTif)
+		}
+		if a.Tif.isExtendedHours() && !o.Ticker.Exchange.hasExtendedHours() {
+			return "Exchange has no extended hours session. TIF: " + string(a.Tif)
+		}
+	}
+	return ""
+}
+
+//changesPriority returns true if order loses its place in queue after amendment
+func (o *simBrokerOrder) changesPriority(a OrderAmendment) bool {
+	if a.changesPrice() && a.Price != o.BrokerPrice {
+		return true
+	}
+	return a.Qty > o.BrokerQty
+}
+
 func (o *simBrokerOrder) isActive() bool {
 	if o.BrokerState == ConfirmedOrder || o.BrokerState == PartialFilledOrder {
 		return true
@@ -79,7 +209,17 @@ type SimBroker struct {
 	delay                  int64
 	checkExecutionsOnTicks bool
 	strictLimitOrders      bool
-	workers                map[string]*simBrokerWorker
+	//AuctionParticipation is max part of auction print volume that auction order can take on ticks. Zero
+	//means the whole volume
+	AuctionParticipation float64
+	//LULDTier turns on limit up-limit down bands of tier 1 or 2. Limit orders out of bands are rejected
+	LULDTier int
+	//Locates is short sale availability by symbol. When it's set, short sales of symbols without locate are
+	//rejected and borrow fees are charged for short positions held overnight
+	Locates map[string]*Locate
+	//SSR turns on short sale restriction of Rule 201 after 10% drop from previous close
+	SSR     bool
+	workers map[string]*simBrokerWorker
 }
 
 func (b *SimBroker) Connect() {
@@ -98,14 +238,19 @@ func (b *SimBroker) Init(errChan chan error, events chan event, symbols []*Instr
 
 	for _, s := range symbols {
 		bw := simBrokerWorker{
-			symbol:            s,
-			errChan:           errChan,
-			events:            events,
-			delay:             b.delay,
-			strictLimitOrders: b.strictLimitOrders,
-			mpMutext:          &sync.RWMutex{},
-			waitGroup:         &sync.WaitGroup{},
-			orders:            make(map[string]*simBrokerOrder),
+			symbol:               s,
+			errChan:              errChan,
+			events:               events,
+			delay:                b.delay,
+			strictLimitOrders:    b.strictLimitOrders,
+			auctionParticipation: b.AuctionParticipation,
+			luldTier:             b.LULDTier,
+			checkLocates:         b.Locates != nil,
+			locate:               b.Locates[s.Symbol],
+			ssr:                  b.SSR,
+			mpMutext:             &sync.RWMutex{},
+			waitGroup:            &sync.WaitGroup{},
+			orders:               make(map[string]*simBrokerOrder),
 		}
 		b.workers[s.Symbol] = &bw
 
@@ -134,6 +279,25 @@ type simBrokerWorker struct {
 	events            chan event
 	delay             int64
 	strictLimitOrders bool
+	//auctionParticipation is max part of auction volume for auction orders
+	auctionParticipation float64
+	//halted worker doesn't execute orders. After resume the first trade is reopening cross
+	halted        bool
+	reopening     bool
+	luldTier      int
+	luldTrades    []luldTrade
+	luldReference float64
+	//position is net position of filled orders. Short sales are checked against locate and SSR
+	position     int64
+	checkLocates bool
+	locate       *Locate
+	ssr          bool
+	ssrToday     bool
+	ssrCarry     bool
+	sessionOpen  time.Time
+	prevClose    float64
+	lastPrice    float64
+	lastBid      float64
 
 	mpMutext        *sync.RWMutex
 	orders          map[string]*simBrokerOrder
@@ -158,6 +322,12 @@ func (b *simBrokerWorker) notify(e event) {
 		b.onCandleOpen(i)
 	case *CandleCloseEvent:
 		b.onCandleClose(i)
+	case *TradingHaltEvent:
+		b.onTradingHalt(i)
+	case *TradingResumeEvent:
+		b.onTradingResume(i)
+	case *CorporateActionEvent:
+		b.onCorporateAction(i)
 	default:
 		panic("Unexpected event type in broker: " + e.getName())
 	}
@@ -211,7 +381,7 @@ func (b *simBrokerWorker) validateOrderForExecution(order *simBrokerOrder, expec
 		return &err
 	}
 
-	lvsQty := order.Qty - order.BrokerExecQty
+	lvsQty := order.BrokerQty - order.BrokerExecQty
 	if lvsQty <= 0 {
 		return errors.New("Sim broker: Lvs qty is zero or less. Nothing to execute. ")
 	}
@@ -252,8 +422,23 @@ func (b *simBrokerWorker) addBrokerEvent(e event) {
 			panic(msg)
 		}
 
-		ord.StateUpdTime = e.getTime()
-		ord.BrokerPrice = i.NewPrice
+		//Order loses queue priority when price changes or qty goes up. Qty decrease and tif change keep it
+		a := i.amendment()
+		if ord.changesPriority(a) {
+			ord.StateUpdTime = e.getTime()
+		}
+		if a.changesPrice() {
+			ord.BrokerPrice = a.Price
+		}
+		if a.Qty != 0 {
+			ord.BrokerQty = a.Qty
+			if ord.BrokerQty == ord.BrokerExecQty {
+				ord.BrokerState = FilledOrder
+			}
+		}
+		if a.Tif != "" {
+			ord.BrokerTif = a.Tif
+		}
 
 	case *OrderFillEvent:
 		ord, ok := b.orders[i.OrdId]
@@ -264,16 +449,21 @@ func (b *simBrokerWorker) addBrokerEvent(e event) {
 
 		execQty := i.Qty
 
-		if execQty == ord.Qty-ord.BrokerExecQty {
+		if execQty == ord.BrokerQty-ord.BrokerExecQty {
 			ord.BrokerState = FilledOrder
 		} else {
-			if execQty > ord.Qty-ord.BrokerExecQty {
+			if execQty > ord.BrokerQty-ord.BrokerExecQty {
 				panic("Large qty")
 			}
 			ord.BrokerState = PartialFilledOrder
 		}
 
 		ord.BrokerExecQty += i.Qty
+		if ord.Side == OrderBuy {
+			b.position += i.Qty
+		} else {
+			b.position -= i.Qty
+		}
 
 	case *OrderRejectedEvent:
 		ord, ok := b.orders[i.OrdId]
@@ -314,8 +504,21 @@ func (b *simBrokerWorker) onNewOrder(e *NewOrderEvent) {
 	b.mpMutext.Lock()
 	defer b.mpMutext.Unlock()
 
+	r := ""
 	if !e.LinkedOrder.isValid() {
-		r := "Sim Broker: can't confirm order. Order is not valid"
+		r = "Sim Broker: can't confirm order. Order is not valid"
+	} else if reason := validateOrderTif(e.LinkedOrder, b.genTimeSingleTrip(e.getTime())); reason != "" {
+		r = "Sim Broker: can't confirm order. " + reason
+	} else if reason := b.validateHalt(e.LinkedOrder); reason != "" {
+		r = "Sim Broker: can't confirm order. " + reason
+	} else if reason := b.validateLULD(e.LinkedOrder, e.LinkedOrder.Price, b.genTimeSingleTrip(e.getTime())); reason != "" {
+		r = "Sim Broker: can't confirm order. " + reason
+	} else if reason := b.validateShortSale(e.LinkedOrder, e.LinkedOrder.Price, e.LinkedOrder.Qty,
+		b.genTimeSingleTrip(e.getTime())); reason != "" {
+		r = "Sim Broker: can't confirm order. " + reason
+	}
+
+	if r != "" {
 		rejectEvent := OrderRejectedEvent{
 			OrdId:     e.LinkedOrder.Id,
 			Reason:    r,
@@ -325,6 +528,8 @@ func (b *simBrokerWorker) onNewOrder(e *NewOrderEvent) {
 			Order:         e.LinkedOrder,
 			BrokerState:   RejectedOrder,
 			BrokerExecQty: 0,
+			BrokerQty:     e.LinkedOrder.Qty,
+			BrokerTif:     e.LinkedOrder.Tif,
 			StateUpdTime:  rejectEvent.getTime(),
 		}
 		b.addBrokerEvent(&rejectEvent)
@@ -353,6 +558,8 @@ func (b *simBrokerWorker) onNewOrder(e *NewOrderEvent) {
 		BrokerState:   NewOrder,
 		BrokerExecQty: 0,
 		BrokerPrice:   e.LinkedOrder.Price,
+		BrokerQty:     e.LinkedOrder.Qty,
+		BrokerTif:     e.LinkedOrder.Tif,
 		StateUpdTime:  confEvent.getTime(),
 	}
 
@@ -459,20 +666,56 @@ func (b *simBrokerWorker) onReplaceRequest(e *OrderReplaceRequestEvent) {
 		return
 	}
 
-	if math.IsNaN(e.NewPrice) || e.NewPrice == 0 {
+	if reason := b.orders[e.OrdId].validateAmendment(e.amendment()); reason != "" {
 		e := OrderReplaceRejectEvent{
 			BaseEvent: be(newEvTime, e.Ticker),
 			OrdId:     e.OrdId,
-			Reason:    fmt.Sprintf("Replace price %v is not valid", e.NewPrice),
+			Reason:    reason,
 		}
 		b.addBrokerEvent(&e)
 		return
 	}
 
+	if e.amendment().changesPrice() {
+		o := b.orders[e.OrdId].Order
+		if reason := b.validateLULD(o, e.NewPrice, b.genTimeSingleTrip(e.getTime())); reason != "" {
+			e := OrderReplaceRejectEvent{
+				BaseEvent: be(newEvTime, e.Ticker),
+				OrdId:     e.OrdId,
+				Reason:    reason,
+			}
+			b.addBrokerEvent(&e)
+			return
+		}
+	}
+
+	if a := e.amendment(); a.changesPrice() || a.Qty != 0 {
+		o := b.orders[e.OrdId]
+		price, qty := o.BrokerPrice, o.BrokerQty-o.BrokerExecQty
+		if a.changesPrice() {
+			price = a.Price
+		}
+		if a.Qty != 0 {
+			qty = a.Qty - o.BrokerExecQty
+		}
+		if reason := b.validateShortSale(o.Order, price, qty, b.genTimeSingleTrip(e.getTime())); reason != "" {
+			e := OrderReplaceRejectEvent{
+				BaseEvent: be(newEvTime, e.Ticker),
+				OrdId:     e.OrdId,
+				Reason:    reason,
+			}
+			b.addBrokerEvent(&e)
+			return
+		}
+	}
+
 	replacedEvent := OrderReplacedEvent{
-		OrdId:     e.OrdId,
-		NewPrice:  e.NewPrice,
-		BaseEvent: be(newEvTime, e.Ticker),
+		OrdId:          e.OrdId,
+		NewPrice:       e.NewPrice,
+		NewQty:         e.NewQty,
+		NewTif:         e.NewTif,
+		NewDestination: e.NewDestination,
+		BaseEvent:      be(newEvTime, e.Ticker),
 	}
 	b.addBrokerEvent(&replacedEvent)
 }
@@ -483,6 +726,9 @@ func (b *simBrokerWorker) onCandleOpen(e *CandleOpenEvent) {
 	}
 	b.lastCandleTime = e.CandleTime
 	b.proceedStoredRequests(e.getTime())
+	b.mpMutext.Lock()
+	b.updateShortSale(e.getTime(), e.Price, math.NaN())
+	b.mpMutext.Unlock()
 	b.findExecutions(e)
 }
 
@@ -492,6 +738,9 @@ func (b *simBrokerWorker) onCandleClose(e *CandleCloseEvent) {
 	}
 	b.lastCandleTime = e.getTime()
 	b.proceedStoredRequests(e.getTime())
+	b.mpMutext.Lock()
+	b.updateShortSale(e.getTime(), e.Candle.Close, math.NaN())
+	b.mpMutext.Unlock()
 	b.findExecutions(e)
 }
 
@@ -510,6 +759,10 @@ func (b *simBrokerWorker) onTick(e *NewTickEvent) {
 	}
 	b.lastTickTime = e.Tick.Datetime
 	b.proceedStoredRequests(e.getTime())
+	b.updateLULD(e.Tick)
+	b.mpMutext.Lock()
+	b.updateShortSale(e.Tick.Datetime, e.Tick.LastPrice, e.Tick.BidPrice)
+	b.mpMutext.Unlock()
 	b.findExecutions(e)
 
 }
@@ -529,6 +782,46 @@ func (b *simBrokerWorker) cancelByTif(o *simBrokerOrder, t time.Time) bool {
 	return true
 }
 
+//cancelImmediateRemainder returns cancel of IOC or FOK order part that wasn't filled by the first market data
+//after order got to broker. Cancel goes after fills of the order
+func (b *simBrokerWorker) cancelImmediateRemainder(o *simBrokerOrder, fills []event, t time.Time) event {
+	if !o.BrokerTif.isImmediate() {
+		return nil
+	}
+	cancelTime := b.genTimeRoundTrip(t)
+	lvsQty := o.BrokerQty - o.BrokerExecQty
+	for _, e := range fills {
+		if f, ok := e.(*OrderFillEvent); ok && f != nil && f.OrdId == o.Id {
+			lvsQty -= f.Qty
+			if f.getTime().After(cancelTime) {
+				cancelTime = f.getTime()
+			}
+		}
+	}
+	if lvsQty <= 0 {
+		return nil
+	}
+	return &OrderCancelEvent{OrdId: o.Id, BaseEvent: be(cancelTime, o.Ticker)}
+}
+
+//killPartialFill drops fills of FOK order which don't fill all its qty. Not filled FOK order is canceled by
+//cancelImmediateRemainder
+func (b *simBrokerWorker) killPartialFill(o *simBrokerOrder, fills []event) []event {
+	if o.BrokerTif != FOKTIF {
+		return fills
+	}
+	qty := int64(0)
+	for _, e := range fills {
+		if f, ok := e.(*OrderFillEvent); ok {
+			qty += f.Qty
+		}
+	}
+	if qty < o.BrokerQty-o.BrokerExecQty {
+		return nil
+	}
+	return fills
+}
+
 func (b *simBrokerWorker) proceedStoredRequests(beforeTime time.Time) {
 	if len(b.requestEvents) == 0 {
 		return
@@ -570,6 +863,7 @@ func (b *simBrokerWorker) findExecutions(mdEvent event) {
 
 	switch i := mdEvent.(type) {
 	case *NewTickEvent:
+		reopening := b.reopening && i.Tick.HasTrade()
 		for _, o := range b.orders {
 			if o.isActive() && o.Ticker.Symbol == i.Ticker.Symbol {
 				if o.StateUpdTime.Before(i.Tick.Datetime) {
@@ -577,32 +871,49 @@ func (b *simBrokerWorker) findExecutions(mdEvent event) {
 					if cancel {
 						continue
 					}
-					e := b.findExecutionsOnTick(o, i.Tick)
+					if b.halted || !o.isInTradingSession(i.Tick.Datetime) {
+						continue
+					}
+					var e []event
+					if reopening && (o.Type == MarketOrder || o.Type == LimitOrder) {
+						e = b.fillOnReopeningPrint(o, i.Tick)
+					} else {
+						e = b.findExecutionsOnTick(o, i.Tick)
+					}
 					if e != nil {
 						genEvents = append(genEvents, e...)
 					}
+					if c := b.cancelImmediateRemainder(o, e, i.Tick.Datetime); c != nil {
+						genEvents = append(genEvents, c)
+					}
 
 				}
 			}
 		}
+		if reopening {
+			b.reopening = false
+		}
 	case *CandleCloseEvent:
 		for _, o := range b.orders {
 			if o.Ticker.Symbol == i.Candle.Ticker.Symbol && o.isActive() {
 				if o.StateUpdTime.Before(i.getTime()) {
 					cancel := b.cancelByTif(o, i.Candle.Datetime)
-					if cancel {
+					if cancel || b.halted {
 						continue
 					}
 					e := b.findExecutionsOnCandleClose(o, i)
 					if e != nil {
 						genEvents = append(genEvents, e)
 					}
+					if c := b.cancelImmediateRemainder(o, []event{e}, i.getTime()); c != nil {
+						genEvents = append(genEvents, c)
+					}
 				}
 			}
 		}
 	case *CandleOpenEvent:
 		for _, o := range b.orders {
-			if o.Ticker == i.Ticker && o.isActive() {
+			if o.Ticker == i.Ticker && o.isActive() && !b.halted {
 				if o.StateUpdTime.Before(i.CandleTime) {
 					cancel := b.cancelByTif(o, i.CandleTime)
 					if cancel {
@@ -612,16 +923,24 @@ func (b *simBrokerWorker) findExecutions(mdEvent event) {
 					if e != nil {
 						genEvents = append(genEvents, e)
 					}
+					if c := b.cancelImmediateRemainder(o, []event{e}, i.getTime()); c != nil {
+						genEvents = append(genEvents, c)
+					}
 				} else {
 					if (o.Type == MarketOrder || o.Type == StopOrder) && !o.StateUpdTime.After(i.getTime()) {
 						e := b.findExecutionsOnCandleOpen(o, i)
 						if e != nil {
 							genEvents = append(genEvents, e)
 						}
+						if c := b.cancelImmediateRemainder(o, []event{e}, i.getTime()); c != nil {
+							genEvents = append(genEvents, c)
+						}
 					}
 				}
 			}
 		}
+	case *TradingHaltEvent, *TradingResumeEvent, *CorporateActionEvent:
+		//Halt, resume and corporate action only change state of worker. Orders are executed by market data
 	default:
 		panic("Unexpected event type for simBrokerWorker")
 
@@ -709,10 +1028,10 @@ func (b *simBrokerWorker) findExecutionsOnTick(orderSim *simBrokerOrder, tick *T
 
 	case MarketOrder:
 		e := convertToList(b.fillOnTickMarket(orderSim, tick))
-		return e
+		return b.killPartialFill(orderSim, e)
 	case LimitOrder:
 		e := convertToList(b.fillOnTickLimit(orderSim, tick))
-		return e
+		return b.killPartialFill(orderSim, e)
 	case StopOrder:
 		e := convertToList(b.fillOnTickStop(orderSim, tick))
 		return e
@@ -723,10 +1042,10 @@ func (b *simBrokerWorker) findExecutionsOnTick(orderSim *simBrokerOrder, tick *T
 		e := b.fillOnTickLOO(orderSim, tick)
 		return e
 	case MarketOnOpen:
-		e := convertToList(b.fillOnTickMOO(orderSim, tick))
+		e := b.fillOnTickMOO(orderSim, tick)
 		return e
 	case MarketOnClose:
-		e := convertToList(b.fillOnTickMOC(orderSim, tick))
+		e := b.fillOnTickMOC(orderSim, tick)
 		return e
 	default:
 		err := ErrUnknownOrderType{
@@ -788,7 +1107,7 @@ func (b *simBrokerWorker) fillOnCandleCloseLimit(o *simBrokerOrder, e *CandleClo
 		BaseEvent: be(e.getTime(), e.Ticker),
 		OrdId:     o.Id,
 		Price:     fillPrice,
-		Qty:       o.Qty - o.BrokerExecQty,
+		Qty:       o.BrokerQty - o.BrokerExecQty,
 	}
 
 	return &fe
@@ -803,7 +1122,7 @@ func (b *simBrokerWorker) fillOnCandleCloseMOC(o *simBrokerOrder, e *CandleClose
 		BaseEvent: be(e.getTime(), e.Ticker),
 		OrdId:     o.Id,
 		Price:     e.Candle.Close,
-		Qty:       o.Qty - o.BrokerExecQty,
+		Qty:       o.BrokerQty - o.BrokerExecQty,
 	}
 
 	return &fe
@@ -843,7 +1162,7 @@ func (b *simBrokerWorker) fillOnCandleCloseLOC(o *simBrokerOrder, e *CandleClose
 		BaseEvent: be(e.getTime(), e.Ticker),
 		OrdId:     o.Id,
 		Price:     fillPrice,
-		Qty:       o.Qty - o.BrokerExecQty,
+		Qty:       o.BrokerQty - o.BrokerExecQty,
 	}
 
 	return &fe
@@ -893,7 +1212,7 @@ func (b *simBrokerWorker) fillOnCandleCloseStop(o *simBrokerOrder, e *CandleClos
 		BaseEvent: be(e.getTime(), e.Ticker),
 		OrdId:     o.Id,
 		Price:     fillPrice,
-		Qty:       o.Qty - o.BrokerExecQty,
+		Qty:       o.BrokerQty - o.BrokerExecQty,
 	}
 
 	return &fe
@@ -907,16 +1226,12 @@ func (b *simBrokerWorker) fillOnCandleOpenMOO(o *simBrokerOrder, e *CandleOpenEv
 			BaseEvent: be(e.getTime(), e.Ticker),
 			OrdId:     o.Id,
 			Price:     e.Price,
-			Qty:       o.Qty - o.BrokerExecQty,
+			Qty:       o.BrokerQty - o.BrokerExecQty,
 		}
 
 		return &fe
 	}
-	if e.CandleTime.Hour() != o.Ticker.Exchange.MarketOpenTime.Hour {
-		return nil
-	}
-
-	if e.CandleTime.Minute() != o.Ticker.Exchange.MarketOpenTime.Minute {
+	if !o.Ticker.Exchange.isOpenTime(e.CandleTime) {
 		return nil
 	}
 
@@ -924,7 +1239,7 @@ func (b *simBrokerWorker) fillOnCandleOpenMOO(o *simBrokerOrder, e *CandleOpenEv
 		BaseEvent: be(e.getTime(), e.Ticker),
 		OrdId:     o.Id,
 		Price:     e.Price,
-		Qty:       o.Qty - o.BrokerExecQty,
+		Qty:       o.BrokerQty - o.BrokerExecQty,
 	}
 
 	return &fe
@@ -939,11 +1254,7 @@ func (b *simBrokerWorker) fillOnCandleOpenLOO(o *simBrokerOrder, e *CandleOpenEv
 	}
 
 	if !canBeFilled {
-		if e.CandleTime.Hour() != o.Ticker.Exchange.MarketOpenTime.Hour {
-			return nil
-		}
-
-		if e.CandleTime.Minute() != o.Ticker.Exchange.MarketOpenTime.Minute {
+		if !o.Ticker.Exchange.isOpenTime(e.CandleTime) {
 			return nil
 		}
 
@@ -958,7 +1269,7 @@ func (b *simBrokerWorker) fillOnCandleOpenLOO(o *simBrokerOrder, e *CandleOpenEv
 					BaseEvent: be(e.getTime(), e.Ticker),
 					OrdId:     o.Id,
 					Price:     e.Price,
-					Qty:       o.Qty - o.BrokerExecQty,
+					Qty:       o.BrokerQty - o.BrokerExecQty,
 				}
 
 				return &fe
@@ -969,7 +1280,7 @@ func (b *simBrokerWorker) fillOnCandleOpenLOO(o *simBrokerOrder, e *CandleOpenEv
 					BaseEvent: be(e.getTime(), e.Ticker),
 					OrdId:     o.Id,
 					Price:     e.Price,
-					Qty:       o.Qty - o.BrokerExecQty,
+					Qty:       o.BrokerQty - o.BrokerExecQty,
 				}
 
 				return &fe
@@ -994,7 +1305,7 @@ func (b *simBrokerWorker) fillOnCandleOpenMarket(o *simBrokerOrder, e *CandleOpe
 		BaseEvent: be(e.getTime(), e.Ticker),
 		OrdId:     o.Id,
 		Price:     e.Price,
-		Qty:       o.Qty - o.BrokerExecQty,
+		Qty:       o.BrokerQty - o.BrokerExecQty,
 	}
 
 	return &fe
@@ -1013,7 +1324,7 @@ func (b *simBrokerWorker) fillOnCandleOpenLimit(o *simBrokerOrder, e *CandleOpen
 				BaseEvent: be(e.getTime(), e.Ticker),
 				OrdId:     o.Id,
 				Price:     fillPrice,
-				Qty:       o.Qty - o.BrokerExecQty,
+				Qty:       o.BrokerQty - o.BrokerExecQty,
 			}
 
 			return &fe
@@ -1024,7 +1335,7 @@ func (b *simBrokerWorker) fillOnCandleOpenLimit(o *simBrokerOrder, e *CandleOpen
 				BaseEvent: be(e.getTime(), e.Ticker),
 				OrdId:     o.Id,
 				Price:     fillPrice,
-				Qty:       o.Qty - o.BrokerExecQty,
+				Qty:       o.BrokerQty - o.BrokerExecQty,
 			}
 
 			return &fe
@@ -1039,7 +1350,7 @@ func (b *simBrokerWorker) fillOnCandleOpenLimit(o *simBrokerOrder, e *CandleOpen
 			BaseEvent: be(e.getTime(), e.Ticker),
 			OrdId:     o.Id,
 			Price:     fillPrice,
-			Qty:       o.Qty - o.BrokerExecQty,
+			Qty:       o.BrokerQty - o.BrokerExecQty,
 		}
 
 		return &fe
@@ -1058,7 +1369,7 @@ func (b *simBrokerWorker) fillOnCandleOpenStop(o *simBrokerOrder, e *CandleOpenE
 				BaseEvent: be(e.getTime(), e.Ticker),
 				OrdId:     o.Id,
 				Price:     e.Price,
-				Qty:       o.Qty - o.BrokerExecQty,
+				Qty:       o.BrokerQty - o.BrokerExecQty,
 			}
 
 			return &fe
@@ -1069,7 +1380,7 @@ func (b *simBrokerWorker) fillOnCandleOpenStop(o *simBrokerOrder, e *CandleOpenE
 				BaseEvent: be(e.getTime(), e.Ticker),
 				OrdId:     o.Id,
 				Price:     e.Price,
-				Qty:       o.Qty - o.BrokerExecQty,
+				Qty:       o.BrokerQty - o.BrokerExecQty,
 			}
 
 			return &fe
@@ -1090,7 +1401,7 @@ func (b *simBrokerWorker) fillOnTickLOO(order *simBrokerOrder, tick *Tick) []eve
 		b.newError(err)
 		return nil
 	}
-	if !tick.IsOpening {
+	if !tick.isOpeningPrint() {
 		return nil
 	}
 
@@ -1105,7 +1416,7 @@ func (b *simBrokerWorker) fillOnTickLOC(order *simBrokerOrder, tick *Tick) []eve
 		return nil
 	}
 
-	if !tick.IsClosing {
+	if !tick.isClosingPrint() {
 		return nil
 	}
 
@@ -1113,6 +1424,8 @@ func (b *simBrokerWorker) fillOnTickLOC(order *simBrokerOrder, tick *Tick) []eve
 
 }
 
+//fillOnTickLimitAuction fills limit auction order on auction print when clearing price is within limit. Order
+//takes only its part of auction volume and the rest is canceled, because auction orders live until auction
 func (b *simBrokerWorker) fillOnTickLimitAuction(order *simBrokerOrder, tick *Tick) []event {
 	var generatedEvents []event
 	switch order.Side {
@@ -1156,21 +1469,25 @@ func (b *simBrokerWorker) fillOnTickLimitAuction(order *simBrokerOrder, tick *Ti
 		return generatedEvents
 	}
 
-	execQty := order.Qty
-	if execQty > tick.LastSize {
-		execQty = tick.LastSize
-	}
+	return b.fillOnAuctionPrint(order, tick)
+}
 
-	fillE := OrderFillEvent{
-		OrdId:     order.Id,
-		Price:     tick.LastPrice,
-		Qty:       execQty,
-		BaseEvent: be(b.genTimeRoundTrip(tick.Datetime), order.Ticker),
-	}
+//fillOnAuctionPrint fills auction order at clearing price of auction print and cancels the rest of order
+func (b *simBrokerWorker) fillOnAuctionPrint(order *simBrokerOrder, tick *Tick) []event {
+	var generatedEvents []event
+	execQty := b.auctionExecQty(order, tick)
+	if execQty > 0 {
+		fillE := OrderFillEvent{
+			OrdId:     order.Id,
+			Price:     tick.LastPrice,
+			Qty:       execQty,
+			BaseEvent: be(b.genTimeRoundTrip(tick.Datetime), order.Ticker),
+		}
 
-	generatedEvents = append(generatedEvents, &fillE)
+		generatedEvents = append(generatedEvents, &fillE)
+	}
 
-	if execQty < order.Qty {
+	if execQty < order.BrokerQty-order.BrokerExecQty {
 		cancelE := OrderCancelEvent{
 			OrdId:     order.Id,
 			BaseEvent: be(b.genTimeRoundTrip(tick.Datetime), order.Ticker),
@@ -1186,13 +1503,9 @@ func (b *simBrokerWorker) fillOnTickLimitAuction(order *simBrokerOrder, tick *Ti
 
 }
 
-func (b *simBrokerWorker) fillOnTickMOO(order *simBrokerOrder, tick *Tick) event {
-
-	if !tick.IsOpening {
-		return nil
-	}
+func (b *simBrokerWorker) fillOnTickMOO(order *simBrokerOrder, tick *Tick) []event {
 
-	if !tick.HasTrade() {
+	if !tick.isOpeningPrint() {
 		return nil
 	}
 
@@ -1202,23 +1515,13 @@ func (b *simBrokerWorker) fillOnTickMOO(order *simBrokerOrder, tick *Tick) event
 		return nil
 	}
 
-	fillE := OrderFillEvent{
-		OrdId:     order.Id,
-		Price:     tick.LastPrice,
-		Qty:       order.Qty,
-		BaseEvent: be(b.genTimeRoundTrip(tick.Datetime), order.Ticker),
-	}
-	return &fillE
+	return b.fillOnAuctionPrint(order, tick)
 
 }
 
-func (b *simBrokerWorker) fillOnTickMOC(order *simBrokerOrder, tick *Tick) event {
+func (b *simBrokerWorker) fillOnTickMOC(order *simBrokerOrder, tick *Tick) []event {
 	//Todo подумать над реализацией когда отркрывающего тика вообще нет
-	if !tick.IsClosing {
-		return nil
-	}
-
-	if !tick.HasTrade() {
+	if !tick.isClosingPrint() {
 		return nil
 	}
 
@@ -1228,14 +1531,7 @@ func (b *simBrokerWorker) fillOnTickMOC(order *simBrokerOrder, tick *Tick) event
 		return nil
 	}
 
-	fillE := OrderFillEvent{
-		OrdId:     order.Id,
-		Price:     tick.LastPrice,
-		Qty:       order.Qty,
-		BaseEvent: be(b.genTimeRoundTrip(tick.Datetime), order.Ticker),
-	}
-
-	return &fillE
+	return b.fillOnAuctionPrint(order, tick)
 
 }
 
@@ -1251,7 +1547,7 @@ func (b *simBrokerWorker) fillOnTickLimit(order *simBrokerOrder, tick *Tick) eve
 		return nil
 	}
 
-	lvsQty := order.Qty - order.BrokerExecQty
+	lvsQty := order.BrokerQty - order.BrokerExecQty
 
 	switch order.Side {
 	case OrderSell:
@@ -1349,7 +1645,7 @@ func (b *simBrokerWorker) fillOnTickStop(order *simBrokerOrder, tick *Tick) even
 			return nil
 		}
 		price := tick.LastPrice
-		lvsQty := order.Qty - order.BrokerExecQty
+		lvsQty := order.BrokerQty - order.BrokerExecQty
 		qty := lvsQty
 		if tick.LastSize < qty {
 			qty = tick.LastSize
@@ -1372,7 +1668,7 @@ func (b *simBrokerWorker) fillOnTickStop(order *simBrokerOrder, tick *Tick) even
 			return nil
 		}
 		price := tick.LastPrice
-		lvsQty := order.Qty - order.BrokerExecQty
+		lvsQty := order.BrokerQty - order.BrokerExecQty
 		qty := lvsQty
 		if tick.LastSize < qty {
 			qty = tick.LastSize
@@ -1428,7 +1724,7 @@ func (b *simBrokerWorker) fillOnTickMarket(order *simBrokerOrder, tick *Tick) ev
 	if tick.HasQuote() {
 		var qty int64 = 0
 		price := math.NaN()
-		lvsQty := order.Qty - order.BrokerExecQty
+		lvsQty := order.BrokerQty - order.BrokerExecQty
 
 		if order.Side == OrderBuy {
 			if lvsQty > tick.AskSize {
@@ -1471,7 +1767,7 @@ func (b *simBrokerWorker) fillOnTickMarket(order *simBrokerOrder, tick *Tick) ev
 		fillE := OrderFillEvent{
 			OrdId:     order.Id,
 			Price:     tick.LastPrice,
-			Qty:       order.Qty,
+			Qty:       order.BrokerQty,
 			BaseEvent: be(b.genTimeRoundTrip(tick.Datetime), order.Ticker),
 		}
 
@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//calendarDateLayout is layout of holidays and early closes dates
+const calendarDateLayout = "2006-01-02"
+
+//TradingCalendar keeps trading days of exchange. Session times of exchange are wall clock times in calendar
+//location, so they stay right across DST changes. Holidays and early closes are dates in calendar location.
+//Exchange without calendar trades on week days and takes session times in location of given time
+type TradingCalendar struct {
+	Location        *time.Location
+	Holidays        map[string]struct{}
+	EarlyCloses     map[string]TimeOfDay
+	PreMarketOpen   TimeOfDay
+	AfterHoursClose TimeOfDay
+}
+
+//calendarFile is JSON file of trading calendar. Times of day are "15:04" or "15:04:05", dates are "2006-01-02"
+type calendarFile struct {
+	TimeZone        string            `json:"timezone"`
+	Holidays        []string          `json:"holidays"`
+	EarlyCloses     map[string]string `json:"earlyCloses"`
+	PreMarketOpen   string            `json:"preMarketOpen"`
+	AfterHoursClose string            `json:"afterHoursClose"`
+}
+
+//LoadTradingCalendar reads trading calendar from JSON file
+func LoadTradingCalendar(path string) (*TradingCalendar, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTradingCalendar(data)
+}
+
+func parseTradingCalendar(data []byte) (*TradingCalendar, error) {
+	var f calendarFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.Wrap(err, "Can't parse trading calendar")
+	}
+
+	c := TradingCalendar{
+		Location:    time.UTC,
+		Holidays:    make(map[string]struct{}),
+		EarlyCloses: make(map[string]TimeOfDay),
+	}
+	if f.TimeZone != "" {
+		loc, err := time.LoadLocation(f.TimeZone)
+		if err != nil {
+			return nil, errors.Wrap(err, "Wrong trading calendar time zone")
+		}
+		c.Location = loc
+	}
+	for _, d := range f.Holidays {
+		if _, err := time.Parse(calendarDateLayout, d); err != nil {
+			return nil, errors.New("Wrong holiday date: " + d)
+		}
+		c.Holidays[d] = struct{}{}
+	}
+	for d, t := range f.EarlyCloses {
+		if _, err := time.Parse(calendarDateLayout, d); err != nil {
+			return nil, errors.New("Wrong early close date: " + d)
+		}
+		tod, err := parseTimeOfDay(t)
+		if err != nil {
+			return nil, err
+		}
+		c.EarlyCloses[d] = tod
+	}
+	var err error
+	if f.PreMarketOpen != "" {
+		if c.PreMarketOpen, err = parseTimeOfDay(f.PreMarketOpen); err != nil {
+			return nil, err
+		}
+	}
+	if f.AfterHoursClose != "" {
+		if c.AfterHoursClose, err = parseTimeOfDay(f.AfterHoursClose); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+//parseTimeOfDay parses "15:04" or "15:04:05"
+func parseTimeOfDay(s string) (TimeOfDay, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return TimeOfDay{}, errors.New("Wrong time of day: " + s)
+	}
+	var values [3]int
+	limits := [3]int{23, 59, 59}
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 || v > limits[i] {
+			return TimeOfDay{}, errors.New("Wrong time of day: " + s)
+		}
+		values[i] = v
+	}
+	return TimeOfDay{Hour: values[0], Minute: values[1], Second: values[2]}, nil
+}
+
+func (c *TradingCalendar) IsHoliday(t time.Time) bool {
+	_, ok := c.Holidays[t.In(c.Location).Format(calendarDateLayout)]
+	return ok
+}
+
+//EarlyClose returns close time of day t if market closes early that day
+func (c *TradingCalendar) EarlyClose(t time.Time) (TimeOfDay, bool) {
+	tod, ok := c.EarlyCloses[t.In(c.Location).Format(calendarDateLayout)]
+	return tod, ok
+}
+
+//******* EXCHANGE SESSIONS ***************************************************
+
+//localTime returns t in exchange location. Without calendar t is returned as is
+func (e *Exchange) localTime(t time.Time) time.Time {
+	if e.Calendar == nil || e.Calendar.Location == nil {
+		return t
+	}
+	return t.In(e.Calendar.Location)
+}
+
+//IsTradingDay returns false for weekends and holidays
+func (e *Exchange) IsTradingDay(t time.Time) bool {
+	lt := e.localTime(t)
+	if isWeekend(lt) {
+		return false
+	}
+	return e.Calendar == nil || !e.Calendar.IsHoliday(lt)
+}
+
+//OpenTime returns market open of day t
+func (e *Exchange) OpenTime(t time.Time) time.Time {
+	return sessionTime(e.localTime(t), e.MarketOpenTime)
+}
+
+//CloseTime returns market close of day t. It's early close for half days
+func (e *Exchange) CloseTime(t time.Time) time.Time {
+	lt := e.localTime(t)
+	if e.Calendar != nil {
+		if tod, ok := e.Calendar.EarlyClose(lt); ok {
+			return sessionTime(lt, tod)
+		}
+	}
+	return sessionTime(lt, e.MarketCloseTime)
+}
+
+//endOfDay returns midnight after day t in exchange location
+func (e *Exchange) endOfDay(t time.Time) time.Time {
+	next := e.localTime(t).AddDate(0, 0, 1)
+	return time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, next.Location())
+}
+
+//isOpenTime returns true if t is minute of market open
+func (e *Exchange) isOpenTime(t time.Time) bool {
+	lt := e.localTime(t)
+	return lt.Hour() == e.MarketOpenTime.Hour && lt.Minute() == e.MarketOpenTime.Minute
+}
+
+//isCloseTime returns true if t is minute of market close of its day
+func (e *Exchange) isCloseTime(t time.Time) bool {
+	lt := e.localTime(t)
+	c := e.CloseTime(lt)
+	return lt.Hour() == c.Hour() && lt.Minute() == c.Minute()
+}
+
+//lastTradingDayOfWeek returns the last trading day of week with day t. Weeks start on Monday. Week without
+//trading days ends on Friday
+func (e *Exchange) lastTradingDayOfWeek(t time.Time) time.Time {
+	lt := e.localTime(t)
+	day := time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, lt.Location())
+	monday := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	for d := monday.AddDate(0, 0, 4); !d.Before(monday); d = d.AddDate(0, 0, -1) {
+		if e.IsTradingDay(d) {
+			return d
+		}
+	}
+	return monday.AddDate(0, 0, 4)
+}
+
+func (e *Exchange) preMarketOpen() TimeOfDay {
+	if e.PreMarketOpenTime == (TimeOfDay{}) && e.Calendar != nil {
+		return e.Calendar.PreMarketOpen
+	}
+	return e.PreMarketOpenTime
+}
+
+func (e *Exchange) afterHoursClose() TimeOfDay {
+	if e.AfterHoursCloseTime == (TimeOfDay{}) && e.Calendar != nil {
+		return e.Calendar.AfterHoursClose
+	}
+	return e.AfterHoursCloseTime
+}
+
+func (e *Exchange) hasExtendedHours() bool {
+	return e.preMarketOpen() != (TimeOfDay{}) || e.afterHoursClose() != (TimeOfDay{})
+}
+
+//hasSession returns false for exchange without regular session times, e.g. zero Exchange
+func (e *Exchange) hasSession() bool {
+	return e.MarketOpenTime != e.MarketCloseTime
+}
+
+//extendedOpen returns start of pre market session of day t. It's market open if pre market isn't set
+func (e *Exchange) extendedOpen(t time.Time) time.Time {
+	if e.preMarketOpen() == (TimeOfDay{}) {
+		return e.OpenTime(t)
+	}
+	return sessionTime(e.localTime(t), e.preMarketOpen())
+}
+
+//extendedClose returns end of after hours session of day t. It's market close if after hours aren't set
+func (e *Exchange) extendedClose(t time.Time) time.Time {
+	if e.afterHoursClose() == (TimeOfDay{}) {
+		return e.CloseTime(t)
+	}
+	return sessionTime(e.localTime(t), e.afterHoursClose())
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testCalendarJSON = `{
+	"timezone": "America/New_York",
+	"holidays": ["2021-04-02", "2021-07-05", "2021-11-25"],
+	"earlyCloses": {"2021-11-26": "13:00"},
+	"preMarketOpen": "04:00",
+	"afterHoursClose": "20:00:00"
+}`
+
+func newTestCalendarExchange(t *testing.T) Exchange {
+	c, err := parseTradingCalendar([]byte(testCalendarJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Exchange{
+		Name:            "NYSE",
+		MarketOpenTime:  TimeOfDay{9, 30, 0},
+		MarketCloseTime: TimeOfDay{16, 0, 0},
+		Calendar:        c,
+	}
+}
+
+func TestTradingCalendar_Load(t *testing.T) {
+	t.Log("Load calendar from file")
+	{
+		dir, err := ioutil.TempDir("", "calendar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "nyse.json")
+		assert.Nil(t, ioutil.WriteFile(path, []byte(testCalendarJSON), 0644))
+
+		c, err := LoadTradingCalendar(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "America/New_York", c.Location.String())
+		assert.Len(t, c.Holidays, 3)
+		assert.Equal(t, TimeOfDay{13, 0, 0}, c.EarlyCloses["2021-11-26"])
+		assert.Equal(t, TimeOfDay{4, 0, 0}, c.PreMarketOpen)
+		assert.Equal(t, TimeOfDay{20, 0, 0}, c.AfterHoursClose)
+
+		_, err = LoadTradingCalendar(filepath.Join(dir, "missing.json"))
+		assert.NotNil(t, err)
+	}
+
+	t.Log("Wrong calendar files")
+	{
+		for _, data := range []string{
+			`{"timezone": "Nowhere/City"}`,
+			`{"holidays": ["2021-13-01"]}`,
+			`{"earlyCloses": {"2021-11-26": "25:00"}}`,
+			`{"preMarketOpen": "4"}`,
+			`[]`,
+		} {
+			_, err := parseTradingCalendar([]byte(data))
+			assert.NotNil(t, err, data)
+		}
+	}
+
+	t.Log("Calendar without timezone is in UTC")
+	{
+		c, err := parseTradingCalendar([]byte(`{}`))
+		assert.Nil(t, err)
+		assert.Equal(t, time.UTC, c.Location)
+	}
+}
+
+func TestExchange_CalendarSessions(t *testing.T) {
+	ex := newTestCalendarExchange(t)
+
+	t.Log("Weekends and holidays aren't trading days")
+	{
+		assert.True(t, ex.IsTradingDay(time.Date(2021, 7, 6, 14, 0, 0, 0, time.UTC)))
+		assert.False(t, ex.IsTradingDay(time.Date(2021, 7, 5, 14, 0, 0, 0, time.UTC)))
+		assert.False(t, ex.IsTradingDay(time.Date(2021, 7, 3, 14, 0, 0, 0, time.UTC)))
+		//Tuesday 01:00 UTC is Monday holiday in New York
+		assert.False(t, ex.IsTradingDay(time.Date(2021, 7, 6, 1, 0, 0, 0, time.UTC)))
+	}
+
+	t.Log("Session times keep wall clock across DST")
+	{
+		assert.Equal(t, time.Date(2021, 3, 12, 14, 30, 0, 0, time.UTC),
+			ex.OpenTime(time.Date(2021, 3, 12, 12, 0, 0, 0, time.UTC)).UTC())
+		assert.Equal(t, time.Date(2021, 3, 15, 13, 30, 0, 0, time.UTC),
+			ex.OpenTime(time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)).UTC())
+		assert.True(t, ex.isOpenTime(time.Date(2021, 3, 15, 13, 30, 0, 0, time.UTC)))
+		assert.False(t, ex.isOpenTime(time.Date(2021, 3, 15, 14, 30, 0, 0, time.UTC)))
+	}
+
+	t.Log("Early close")
+	{
+		day := time.Date(2021, 11, 26, 15, 0, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2021, 11, 26, 18, 0, 0, 0, time.UTC), ex.CloseTime(day).UTC())
+		assert.True(t, ex.isCloseTime(time.Date(2021, 11, 26, 18, 0, 0, 0, time.UTC)))
+		assert.True(t, isInSession(time.Date(2021, 11, 26, 17, 59, 0, 0, time.UTC), ex))
+		assert.False(t, isInSession(time.Date(2021, 11, 26, 18, 30, 0, 0, time.UTC), ex))
+		assert.Equal(t, time.Date(2021, 11, 27, 1, 0, 0, 0, time.UTC),
+			ex.extendedClose(day).UTC())
+	}
+
+	t.Log("Week closes on the last trading day")
+	{
+		_, end, err := candleBucket(time.Date(2021, 3, 30, 15, 0, 0, 0, time.UTC), "W", ex)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2021, 4, 1, 23, 59, 59, 0, ex.Calendar.Location), end)
+
+		c := Candle{Candle: &marketdata.Candle{Datetime: time.Date(2021, 3, 29, 0, 0, 0, 0, ex.Calendar.Location)},
+			Ticker: &Instrument{Symbol: "Test", Exchange: ex}}
+		assert.Equal(t, end, c.closeTime("W"))
+	}
+
+	t.Log("Day order expires at midnight of exchange")
+	{
+		o := simBrokerOrder{Order: &Order{Ticker: &Instrument{Symbol: "Test", Exchange: ex},
+			Time: time.Date(2021, 3, 12, 20, 0, 0, 0, time.UTC)}, BrokerTif: DayTIF}
+		assert.Equal(t, time.Date(2021, 3, 13, 5, 0, 0, 0, time.UTC), o.getExpirationTime().UTC())
+	}
+}
+
+func TestDailySchedule_Calendar(t *testing.T) {
+	ex := newTestCalendarExchange(t)
+	s := dailySchedule{anchor: anchorClose, offset: -5 * time.Minute, exchange: &ex}
+
+	t.Log("Fire time keeps wall clock across DST")
+	{
+		friday := time.Date(2021, 3, 12, 21, 0, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2021, 3, 15, 19, 55, 0, 0, time.UTC), s.nextAfter(friday).UTC())
+		monday := time.Date(2021, 3, 15, 19, 0, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2021, 3, 12, 20, 55, 0, 0, time.UTC), s.latest(monday).UTC())
+	}
+
+	t.Log("Holidays are skipped and early close is used")
+	{
+		wednesday := time.Date(2021, 11, 24, 22, 0, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2021, 11, 26, 17, 55, 0, 0, time.UTC), s.nextAfter(wednesday).UTC())
+	}
+
+	t.Log("Calendar without trading days gives zero time")
+	{
+		closed := newTestCalendarExchange(t)
+		closed.Calendar.Holidays = make(map[string]struct{})
+		for d := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC); d.Year() < 2031; d = d.AddDate(0, 0, 1) {
+			closed.Calendar.Holidays[d.Format(calendarDateLayout)] = struct{}{}
+		}
+		cs := dailySchedule{anchor: anchorOpen, exchange: &closed}
+		tm := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		assert.True(t, cs.nextAfter(tm).IsZero())
+		assert.True(t, cs.latest(tm).IsZero())
+	}
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+//Clock is the single source of time for engine components
+type Clock interface {
+	Now() time.Time
+	IsSimulated() bool
+}
+
+//SimulatedClock keeps time of market data events. It is advanced by engine when new event comes and never goes
+//back
+type SimulatedClock struct {
+	now time.Time
+	mut *sync.RWMutex
+}
+
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{now: start, mut: &sync.RWMutex{}}
+}
+
+func (c *SimulatedClock) Now() time.Time {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.now
+}
+
+func (c *SimulatedClock) IsSimulated() bool {
+	return true
+}
+
+//Advance moves clock to t. Returns false if t is before current time and clock wasn't changed
+func (c *SimulatedClock) Advance(t time.Time) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if t.Before(c.now) {
+		return false
+	}
+	c.now = t
+	return true
+}
+
+//RealTimeClock returns wall time. Used for live trading and market replay
+type RealTimeClock struct{}
+
+func (c RealTimeClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c RealTimeClock) IsSimulated() bool {
+	return false
+}
+
+//newClockForMode returns simulated clock for backtests and real time clock for other modes
+func newClockForMode(mode EngineMode) Clock {
+	if mode == BacktestMode {
+		return NewSimulatedClock(time.Time{})
+	}
+	return RealTimeClock{}
+}
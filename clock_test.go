@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSimulatedClock_Advance(t *testing.T) {
+	tm := newTestOrderTime()
+	c := NewSimulatedClock(tm)
+	assert.True(t, c.IsSimulated())
+	assert.Equal(t, tm, c.Now())
+
+	assert.True(t, c.Advance(tm.Add(time.Second)))
+	assert.Equal(t, tm.Add(time.Second), c.Now())
+
+	t.Log("Clock doesn't go back")
+	assert.False(t, c.Advance(tm))
+	assert.Equal(t, tm.Add(time.Second), c.Now())
+}
+
+func TestBasicStrategy_Now(t *testing.T) {
+	tm := newTestOrderTime()
+	st := BasicStrategy{mostRecentTime: tm}
+
+	t.Log("Strategy without clock uses time of the last handled event")
+	assert.Equal(t, tm, st.Now())
+
+	t.Log("Simulated clock: strategy time is time of the last handled event")
+	c := NewSimulatedClock(tm.Add(time.Minute))
+	st.setClock(c)
+	assert.Equal(t, tm, st.Now())
+
+	t.Log("Real time clock: strategy time is wall time")
+	st.setClock(RealTimeClock{})
+	assert.True(t, time.Since(st.Now()) < time.Second)
+}
+
+func TestTimerEventProducer_Clock(t *testing.T) {
+	events := make(chan event)
+	errors := make(chan error)
+	tm := newTestOrderTime()
+
+	timer := TimerEventProducer{}
+	timer.SetDelay(10)
+	timer.SetFraction(1)
+	timer.SetClock(NewSimulatedClock(tm))
+	timer.Connect(errors, events)
+	timer.Run()
+
+	e := <-events
+	timer.Stop()
+	assert.Equal(t, tm, e.getTime())
+}
+
+func TestMarketData_ClockIsNotAdvanced(t *testing.T) {
+	tm := newTestOrderTime()
+	e := &NewTickEvent{BaseEvent: be(tm.Add(time.Hour), newTestInstrument())}
+
+	t.Log("BTM doesn't move engine clock when it puts event in chan, its own time is time of the last event")
+	{
+		c := NewSimulatedClock(tm)
+		m := BTM{}
+		m.Init(make(chan error, 1), make(chan event, 1))
+		m.SetClock(c)
+		m.newEvent(e)
+		assert.Equal(t, tm, c.Now())
+		assert.Equal(t, tm.Add(time.Hour), m.now())
+	}
+
+	t.Log("Synthetic market data is the same")
+	{
+		c := NewSimulatedClock(tm)
+		m := SyntheticMarketData{}
+		m.Init(make(chan error, 1), make(chan event, 1))
+		m.SetClock(c)
+		m.sendEvent(e)
+		assert.Equal(t, tm, c.Now())
+		assert.Equal(t, tm.Add(time.Hour), m.now())
+	}
+}
@@ -0,0 +1,107 @@
+//prepcache lists, verifies and purges prepaired market data files of folder managed by engine.PreparedCache
+//
+//	prepcache -folder ./prepaired list
+//	prepcache -folder ./prepaired verify [file...]
+//	prepcache -folder ./prepaired purge [-invalid] [file...]
+package main
+
+import (
+	"alex/engine"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	folder := flag.String("folder", ".", "folder of prepaired files")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: prepcache [-folder dir] list|verify|purge [-invalid] [file...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cache := engine.NewPreparedCache(*folder, 0)
+	var err error
+	switch flag.Arg(0) {
+	case "list":
+		err = list(cache)
+	case "verify":
+		var failed []string
+		failed, err = verify(cache, flag.Args()[1:])
+		if err == nil && len(failed) > 0 {
+			os.Exit(1)
+		}
+	case "purge":
+		err = purge(cache, flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func list(cache *engine.PreparedCache) error {
+	entries, err := cache.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSYMBOLS\tMODE\tFROM\tTO\tROWS\tSIZE\tLAST USED")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", e.File, len(e.Symbols), e.Mode,
+			e.From.Format("2006-01-02"), e.To.Format("2006-01-02"), e.Rows, e.Size,
+			e.LastUsed.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+//verify checks files or all files of manifest and returns files which failed
+func verify(cache *engine.PreparedCache, files []string) ([]string, error) {
+	if len(files) == 0 {
+		entries, err := cache.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			files = append(files, e.File)
+		}
+	}
+	var failed []string
+	for _, f := range files {
+		if err := cache.Verify(f); err != nil {
+			fmt.Printf("%v: %v\n", f, err)
+			failed = append(failed, f)
+			continue
+		}
+		fmt.Printf("%v: OK\n", f)
+	}
+	return failed, nil
+}
+
+func purge(cache *engine.PreparedCache, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	invalid := fs.Bool("invalid", false, "purge only files which fail verification")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	files := fs.Args()
+	if *invalid {
+		var err error
+		if files, err = verify(cache, files); err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+	}
+	return cache.Purge(files...)
+}
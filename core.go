@@ -33,8 +33,11 @@ type Engine struct {
 	portfolioChan   chan *PortfolioNewPositionEvent
 	errChan         chan error
 	marketDataChan  chan event
+	timerChan       chan event
 
 	events     chan event
+	bus        *eventBus
+	clock      Clock
 	log        log.Logger
 	engineMode EngineMode
 
@@ -90,6 +93,7 @@ func NewEngine(sp map[string]ICoreStrategy, broker IBroker, md IMarketData, mode
 	}
 
 	eng.engineMode = mode
+	eng.SetClock(newClockForMode(mode))
 	eng.portfolioChan = portfolioChan
 	eng.portfolio = portfolio
 	eng.prepareLogger()
@@ -97,9 +101,11 @@ func NewEngine(sp map[string]ICoreStrategy, broker IBroker, md IMarketData, mode
 	eng.histDataTimeBack = time.Duration(20) * time.Minute
 
 	eng.terminationChan = make(chan struct{})
+	eng.timerChan = make(chan event, 10)
 
 	eng.mut = &sync.Mutex{}
 	eng.waitG = &sync.WaitGroup{}
+	eng.bus = newEventBus()
 
 	return &eng
 }
@@ -108,6 +114,32 @@ func (c *Engine) SetHistoryTimeBack(duration time.Duration) {
 	c.histDataTimeBack = duration
 }
 
+//SetClock replaces engine clock. Clock is shared with strategies and market data. Simulated broker doesn't get
+//it: broker acts only on events passed by engine after clock is advanced to their time, so time of event is
+//clock time. Live brokers use time of their own
+func (c *Engine) SetClock(clock Clock) {
+	if clock == nil {
+		panic("Clock is nil")
+	}
+	c.clock = clock
+	for _, st := range c.strategiesMap {
+		st.setClock(clock)
+	}
+	c.md.SetClock(clock)
+}
+
+//Now returns current engine time
+func (c *Engine) Now() time.Time {
+	return c.clock.Now()
+}
+
+//advanceClock moves simulated clock to time of market data event
+func (c *Engine) advanceClock(e event) {
+	if sc, ok := c.clock.(*SimulatedClock); ok {
+		sc.Advance(e.getTime())
+	}
+}
+
 func (c *Engine) getSymbolStrategy(symbol string) ICoreStrategy {
 	st, ok := c.strategiesMap[symbol]
 	if !ok {
@@ -142,7 +174,7 @@ func (c *Engine) logMessage(message string) {
 }
 
 func (c *Engine) eCandleOpen(e *CandleOpenEvent) {
-	if c.broker.IsSimulated() {
+	if c.broker.IsSimulated() && c.isBrokerTimeFrame(e.TimeFrame) {
 		c.broker.Notify(e)
 	}
 	st := c.getSymbolStrategy(e.Ticker.Symbol)
@@ -150,7 +182,7 @@ func (c *Engine) eCandleOpen(e *CandleOpenEvent) {
 }
 
 func (c *Engine) eCandleClose(e *CandleCloseEvent) {
-	if c.broker.IsSimulated() {
+	if c.broker.IsSimulated() && c.isBrokerTimeFrame(e.TimeFrame) {
 		c.broker.Notify(e)
 	}
 	st := c.getSymbolStrategy(e.Ticker.Symbol)
@@ -172,7 +204,8 @@ func (c *Engine) eTick(e *NewTickEvent) {
 }
 
 func (c *Engine) eCandleHistory(e *CandlesHistoryEvent) {
-
+	st := c.getSymbolStrategy(e.Ticker.Symbol)
+	st.notify(e)
 }
 
 func (c *Engine) eTickHistory(e *TickHistoryEvent) {
@@ -180,15 +213,32 @@ func (c *Engine) eTickHistory(e *TickHistoryEvent) {
 	st.notify(e)
 }
 
+func (c *Engine) eAuctionImbalance(e *AuctionImbalanceEvent) {
+	st := c.getSymbolStrategy(e.Ticker.Symbol)
+	st.notify(e)
+}
+
+//eTradingStatus sends halt, resume or corporate action to simulated broker and strategy of instrument
+func (c *Engine) eTradingStatus(e event) {
+	if c.broker.IsSimulated() {
+		c.broker.Notify(e)
+	}
+	st := c.getSymbolStrategy(e.getSymbol())
+	st.notify(e)
+}
+
 func (c *Engine) eUpdatePortfolio(e *PortfolioNewPositionEvent) {
 	c.waitG.Add(1)
 	go func() {
-		c.portfolio.onNewTrade(e.trade)
+		c.portfolio.onNewTrade(e.Trade)
 		c.waitG.Done()
 	}()
 }
 
 func (c *Engine) eEndOfData(e *EndOfDataEvent) {
+	for _, st := range c.strategiesMap {
+		st.notify(e)
+	}
 	c.waitG.Add(1)
 	go func() {
 		c.terminationChan <- struct{}{}
@@ -197,6 +247,16 @@ func (c *Engine) eEndOfData(e *EndOfDataEvent) {
 
 }
 
+//eTimer sends timer events of strategies schedules which fire time came
+func (c *Engine) eTimer(now time.Time) {
+	for _, st := range c.strategiesMap {
+		for _, e := range st.dueTimers(now) {
+			c.bus.publish(e)
+			st.notify(e)
+		}
+	}
+}
+
 func (c *Engine) errorIsCritical(err error) bool {
 	return false
 }
@@ -207,6 +267,11 @@ Loop:
 	for {
 		select {
 		case e := <-c.marketDataChan:
+			c.advanceClock(e)
+			if c.clock.IsSimulated() {
+				c.eTimer(c.clock.Now())
+			}
+			c.bus.publish(e)
 			switch i := e.(type) {
 			case *NewTickEvent:
 				c.eTick(i)
@@ -218,11 +283,21 @@ Loop:
 				c.eCandleHistory(i)
 			case *TickHistoryEvent:
 				c.eTickHistory(i)
+			case *AuctionImbalanceEvent:
+				c.eAuctionImbalance(i)
+			case *TradingHaltEvent, *TradingResumeEvent, *CorporateActionEvent:
+				c.eTradingStatus(i)
+			case *OrderConfirmationEvent, *OrderFillEvent, *OrderCancelEvent, *OrderCancelRejectEvent,
+				*OrderReplacedEvent, *OrderReplaceRejectEvent, *OrderRejectedEvent, *BorrowFeeEvent:
+				//Broker responses can come in market data stream when journal is replayed
+				c.proxyEvent(i)
 			case *EndOfDataEvent:
 				c.logMessage("EOD event")
 				c.eEndOfData(i)
 				break Loop
 			}
+		case <-c.timerChan:
+			c.eTimer(c.clock.Now())
 
 		}
 	}
@@ -234,8 +309,10 @@ LOOP:
 	for {
 		select {
 		case e := <-c.events:
+			c.bus.publish(e)
 			c.proxyEvent(e)
 		case e := <-c.portfolioChan:
+			c.bus.publish(e)
 			c.eUpdatePortfolio(e)
 		case e := <-c.errChan:
 			c.logError(e)
@@ -271,6 +348,8 @@ func (c *Engine) proxyEvent(e event) {
 		st.notify(e)
 	case *OrderFillEvent:
 		st.notify(e)
+	case *BorrowFeeEvent:
+		st.notify(e)
 
 	}
 }
@@ -281,9 +360,26 @@ func (c *Engine) Run() {
 	c.logMessage("Engine Run")
 	c.md.RequestHistoricalData(c.histDataTimeBack)
 	c.logMessage("Request historical market data")
+	for _, st := range c.strategiesMap {
+		if tf := c.primaryTimeFrame(); tf != "" {
+			st.setPrimaryTimeFrame(tf)
+		}
+		st.start()
+	}
 	c.md.Run()
 	c.logMessage("Market data listen quotes")
 
+	//In simulated time schedules are checked on every market data event. Otherwise timer producer wakes engine up
+	var timer ITimerEventProducer
+	if !c.clock.IsSimulated() {
+		timer = &TimerEventProducer{}
+		timer.SetDelay(100)
+		timer.SetFraction(1)
+		timer.SetClock(c.clock)
+		timer.Connect(c.errChan, c.timerChan)
+		timer.Run()
+	}
+
 	wg := &sync.WaitGroup{}
 	wg.Add(2)
 	go func() {
@@ -294,6 +390,9 @@ func (c *Engine) Run() {
 
 	go func() {
 		c.listendMD()
+		if timer != nil {
+			timer.Stop()
+		}
 		wg.Done()
 		c.logMessage("MD done")
 	}()
@@ -313,5 +412,6 @@ func (c *Engine) shutDown() {
 	c.broker.shutDown()
 	c.md.ShutDown()
 	c.waitG.Wait()
+	c.bus.close()
 	c.logMessage("Done!")
 }
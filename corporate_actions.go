@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//******* CORPORATE ACTIONS FEED **********************************************
+
+//parseLineToCorporateAction parses line of corporate actions file: "unix ex-date time,symbol,S|D,value". Value of
+//split is ratio of new shares to old shares like "2" or "3:2", value of dividend is cash amount per share
+func parseLineToCorporateAction(l string, tickersMap map[string]*Instrument) ([]event, error) {
+	ls := strings.Split(strings.TrimSpace(l), ",")
+	if len(ls) != 4 {
+		return nil, errors.New("Can't parse line to corporate action: " + l)
+	}
+
+	sec, err := strconv.ParseInt(ls[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "Wrong corporate action time")
+	}
+
+	ticker, ok := tickersMap[ls[1]]
+	if !ok {
+		return nil, nil
+	}
+
+	e := CorporateActionEvent{BaseEvent: be(time.Unix(sec, 0), ticker)}
+	switch ls[2] {
+	case "S":
+		e.Action = SplitAction
+		if e.Ratio, err = parseSplitRatio(ls[3]); err != nil {
+			return nil, err
+		}
+	case "D":
+		e.Action = DividendAction
+		if e.Amount, err = strconv.ParseFloat(ls[3], 64); err != nil {
+			return nil, errors.Wrap(err, "Wrong dividend amount")
+		}
+		if math.IsNaN(e.Amount) || e.Amount < 0 {
+			return nil, errors.New("Wrong dividend amount: " + ls[3])
+		}
+	default:
+		return nil, errors.New("Wrong corporate action type: " + ls[2])
+	}
+
+	return []event{&e}, nil
+}
+
+func parseSplitRatio(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 2 {
+		return 0, errors.New("Wrong split ratio: " + s)
+	}
+	ratio := 1.0
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, "Wrong split ratio")
+		}
+		if v <= 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, errors.New("Wrong split ratio: " + s)
+		}
+		if i == 0 {
+			ratio = v
+		} else {
+			ratio /= v
+		}
+	}
+	return ratio, nil
+}
+
+//******* SPLIT ADJUSTED PRICES ***********************************************
+
+type splitFactor struct {
+	time   time.Time
+	factor float64
+}
+
+//initSplitFactors takes splits out of feed events and keeps cumulative ratios of splits by symbol. Market data
+//and dividends before split are adjusted by ratios of all later splits
+func (m *BTM) initSplitFactors() {
+	m.splitFactors = make(map[string][]splitFactor)
+	var rest eventArray
+	for _, e := range m.feedEvents {
+		ca, ok := e.(*CorporateActionEvent)
+		if !ok || ca.Action != SplitAction {
+			rest = append(rest, e)
+			continue
+		}
+		m.splitFactors[ca.Ticker.Symbol] = append(m.splitFactors[ca.Ticker.Symbol],
+			splitFactor{time: ca.Time, factor: ca.Ratio})
+	}
+	m.feedEvents = rest
+
+	for symbol, splits := range m.splitFactors {
+		sort.SliceStable(splits, func(i, j int) bool {
+			return splits[i].time.Before(splits[j].time)
+		})
+		for i := len(splits) - 2; i >= 0; i-- {
+			splits[i].factor *= splits[i+1].factor
+		}
+		m.splitFactors[symbol] = splits
+	}
+
+	for _, e := range m.feedEvents {
+		if ca, ok := e.(*CorporateActionEvent); ok && ca.Action == DividendAction {
+			ca.Amount /= m.splitFactor(ca.Ticker.Symbol, ca.Time)
+		}
+	}
+}
+
+//splitFactor returns cumulative ratio of symbol splits after time t
+func (m *BTM) splitFactor(symbol string, t time.Time) float64 {
+	for _, s := range m.splitFactors[symbol] {
+		if s.time.After(t) {
+			return s.factor
+		}
+	}
+	return 1
+}
+
+func (m *BTM) adjustTick(t *marketdata.Tick) {
+	if !m.AdjustForSplits {
+		return
+	}
+	f := m.splitFactor(t.Symbol, t.Datetime)
+	if f == 1 {
+		return
+	}
+	t.LastPrice /= f
+	t.BidPrice /= f
+	t.AskPrice /= f
+	t.LastSize = int64(math.Round(float64(t.LastSize) * f))
+	t.BidSize = int64(math.Round(float64(t.BidSize) * f))
+	t.AskSize = int64(math.Round(float64(t.AskSize) * f))
+}
+
+func (m *BTM) adjustCandle(c *Candle) {
+	if !m.AdjustForSplits {
+		return
+	}
+	f := m.splitFactor(c.Symbol, c.Datetime)
+	if f == 1 {
+		return
+	}
+	c.Open /= f
+	c.High /= f
+	c.Low /= f
+	c.Close /= f
+	c.Volume = int64(math.Round(float64(c.Volume) * f))
+}
+
+//******* POSITIONS ***********************************************************
+
+//applySplit changes qty and prices of open trade by split ratio. Values of trade are kept. Fractional shares
+//are rounded
+func (t *Trade) applySplit(ratio float64) error {
+	if ratio <= 0 || math.IsNaN(ratio) {
+		return errors.New("Wrong split ratio: " + fmt.Sprint(ratio))
+	}
+	if !t.IsOpen() {
+		return nil
+	}
+	t.Qty = int64(math.Round(float64(t.Qty) * ratio))
+	if t.Qty == 0 {
+		return errors.New("Split made zero qty of open trade")
+	}
+	t.FirstPrice /= ratio
+	t.OpenPrice = t.OpenValue / float64(t.Qty)
+	return nil
+}
+
+//applyDividend credits cash dividend to long trade and debits it from short trade
+func (t *Trade) applyDividend(amount float64) error {
+	if amount < 0 || math.IsNaN(amount) {
+		return errors.New("Wrong dividend amount: " + fmt.Sprint(amount))
+	}
+	switch t.Type {
+	case LongTrade:
+		t.ClosedPnL += amount * float64(t.Qty)
+	case ShortTrade:
+		t.ClosedPnL -= amount * float64(t.Qty)
+	}
+	return nil
+}
+
+//onCorporateAction cancels active orders on split and changes position and reference prices by split ratio
+func (b *simBrokerWorker) onCorporateAction(e *CorporateActionEvent) {
+	b.proceedStoredRequests(e.getTime())
+	b.mpMutext.Lock()
+	if e.Action == SplitAction && e.Ratio > 0 {
+		for _, o := range b.orders {
+			if !o.isActive() {
+				continue
+			}
+			b.addBrokerEvent(&OrderCancelEvent{OrdId: o.Id, BaseEvent: be(e.getTime(), o.Ticker)})
+		}
+		b.position = int64(math.Round(float64(b.position) * e.Ratio))
+		b.prevClose /= e.Ratio
+		b.lastPrice /= e.Ratio
+		b.lastBid /= e.Ratio
+		b.luldReference /= e.Ratio
+		for i := range b.luldTrades {
+			b.luldTrades[i].price /= e.Ratio
+		}
+	}
+	b.mpMutext.Unlock()
+	b.findExecutions(e)
+}
@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type corporateActionTestStrategy struct {
+	DummyStrategyWithLogic
+	actions []*CorporateActionEvent
+}
+
+func (s *corporateActionTestStrategy) OnCorporateAction(b *BasicStrategy, e *CorporateActionEvent) {
+	s.actions = append(s.actions, e)
+}
+
+func TestCorporateActions_Parse(t *testing.T) {
+	inst := newTestInstrument()
+	tickersMap := map[string]*Instrument{"Test": inst}
+
+	t.Log("Parse splits and dividends")
+	{
+		events, err := parseLineToCorporateAction("1262700000,Test,S,3:2", tickersMap)
+		assert.Nil(t, err)
+		if assert.Len(t, events, 1) {
+			assert.Equal(t, &CorporateActionEvent{BaseEvent: be(time.Unix(1262700000, 0), inst), Action: SplitAction,
+				Ratio: 1.5}, events[0])
+		}
+
+		events, err = parseLineToCorporateAction("1262700000,Test,S,0.1", tickersMap)
+		assert.Nil(t, err)
+		assert.Equal(t, 0.1, events[0].(*CorporateActionEvent).Ratio)
+
+		events, err = parseLineToCorporateAction("1262700000,Test,D,0.24", tickersMap)
+		assert.Nil(t, err)
+		assert.Equal(t, &CorporateActionEvent{BaseEvent: be(time.Unix(1262700000, 0), inst), Action: DividendAction,
+			Amount: 0.24}, events[0])
+
+		events, err = parseLineToCorporateAction("1262700000,Other,S,2", tickersMap)
+		assert.Nil(t, err)
+		assert.Len(t, events, 0)
+	}
+
+	t.Log("Wrong corporate action lines")
+	{
+		for _, l := range []string{
+			"1262700000,Test,S",
+			"time,Test,S,2",
+			"1262700000,Test,X,2",
+			"1262700000,Test,S,0",
+			"1262700000,Test,S,2:0",
+			"1262700000,Test,S,1:2:3",
+			"1262700000,Test,D,-1",
+			"1262700000,Test,D,cash",
+		} {
+			_, err := parseLineToCorporateAction(l, tickersMap)
+			assert.NotNil(t, err, l)
+		}
+	}
+}
+
+func TestTrade_CorporateActions(t *testing.T) {
+	newTrade := func(tp TradeType) *Trade {
+		trade := newFlatTrade(newTestInstrument())
+		trade.Type = tp
+		trade.Qty = 100
+		trade.FirstPrice = 20
+		trade.OpenPrice = 20
+		trade.OpenValue = 2000
+		trade.MarketValue = 2100
+		return trade
+	}
+
+	t.Log("Split changes qty and prices, but keeps values")
+	{
+		trade := newTrade(LongTrade)
+		assert.Nil(t, trade.applySplit(2))
+		assert.Equal(t, int64(200), trade.Qty)
+		assert.Equal(t, 10.0, trade.OpenPrice)
+		assert.Equal(t, 10.0, trade.FirstPrice)
+		assert.Equal(t, 2000.0, trade.OpenValue)
+
+		assert.Nil(t, trade.updatePnL(10.5, time.Now()))
+		assert.Equal(t, 100.0, trade.OpenPnL)
+
+		trade = newTrade(ShortTrade)
+		assert.Nil(t, trade.applySplit(0.1))
+		assert.Equal(t, int64(10), trade.Qty)
+		assert.Equal(t, 200.0, trade.OpenPrice)
+
+		assert.NotNil(t, trade.applySplit(0))
+		assert.NotNil(t, trade.applySplit(0.001))
+		assert.Nil(t, newFlatTrade(newTestInstrument()).applySplit(2))
+	}
+
+	t.Log("Dividend is credited to long and debited from short")
+	{
+		trade := newTrade(LongTrade)
+		assert.Nil(t, trade.applyDividend(0.5))
+		assert.Equal(t, 50.0, trade.ClosedPnL)
+
+		trade = newTrade(ShortTrade)
+		assert.Nil(t, trade.applyDividend(0.5))
+		assert.Equal(t, -50.0, trade.ClosedPnL)
+
+		assert.NotNil(t, trade.applyDividend(-1))
+	}
+}
+
+func TestCorporateActions_SplitAdjustedFeed(t *testing.T) {
+	inst := newTestInstrument()
+	dir, err := ioutil.TempDir("", "corporate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pth := filepath.Join(dir, "actions.csv")
+	//Splits 2:1 on 2010-01-06 and 3:1 on 2010-01-08, dividend between them
+	assert.Nil(t, ioutil.WriteFile(pth, []byte("1262786400,Test,S,2\n1262872800,Test,D,0.3\n"+
+		"1262959200,Test,S,3\n"), 0644))
+
+	t.Log("Raw prices: splits are sent as events")
+	{
+		m := BTM{Symbols: []*Instrument{inst}, CorporateActionFiles: []string{pth}}
+		assert.Nil(t, m.loadFeedEvents())
+		assert.Len(t, m.feedEvents, 3)
+
+		tick := marketdata.Tick{Datetime: time.Date(2010, 1, 5, 10, 0, 0, 0, time.UTC), Symbol: "Test",
+			LastPrice: 60, LastSize: 100}
+		m.adjustTick(&tick)
+		assert.Equal(t, 60.0, tick.LastPrice)
+	}
+
+	t.Log("Split adjusted prices: splits aren't sent, market data and dividends are adjusted by later splits")
+	{
+		m := BTM{Symbols: []*Instrument{inst}, CorporateActionFiles: []string{pth}, AdjustForSplits: true}
+		assert.Nil(t, m.loadFeedEvents())
+		if assert.Len(t, m.feedEvents, 1) {
+			assert.InDelta(t, 0.1, m.feedEvents[0].(*CorporateActionEvent).Amount, 0.000001)
+		}
+
+		tick := marketdata.Tick{Datetime: time.Date(2010, 1, 5, 10, 0, 0, 0, time.UTC), Symbol: "Test",
+			LastPrice: 60, LastSize: 100, BidPrice: 59.94, BidSize: 10, AskPrice: 60.06, AskSize: 20}
+		m.adjustTick(&tick)
+		assert.InDelta(t, 10.0, tick.LastPrice, 0.000001)
+		assert.InDelta(t, 9.99, tick.BidPrice, 0.000001)
+		assert.InDelta(t, 10.01, tick.AskPrice, 0.000001)
+		assert.Equal(t, int64(600), tick.LastSize)
+		assert.Equal(t, int64(60), tick.BidSize)
+
+		c := Candle{Candle: &marketdata.Candle{Datetime: time.Date(2010, 1, 7, 0, 0, 0, 0, time.UTC), Symbol: "Test",
+			Open: 30, High: 33, Low: 27, Close: 31.5, Volume: 1000}, Ticker: inst}
+		m.adjustCandle(&c)
+		assert.Equal(t, []float64{10, 11, 9, 10.5}, []float64{c.Open, c.High, c.Low, c.Close})
+		assert.Equal(t, int64(3000), c.Volume)
+
+		c.Datetime = time.Date(2010, 1, 8, 15, 0, 0, 0, time.UTC)
+		c.Open = 10
+		m.adjustCandle(&c)
+		assert.Equal(t, 10.0, c.Open)
+	}
+}
+
+func TestSimulatedBroker_CorporateActions(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	b.events = make(chan event, 10)
+	tm := newTestOrderTime()
+
+	t.Log("Sim broker: split cancels active orders and changes position")
+	{
+		b.orders["c1"] = &simBrokerOrder{Order: newTestOrder(20, OrderBuy, 100, "c1"), BrokerState: ConfirmedOrder,
+			BrokerQty: 100, BrokerPrice: 20}
+		b.orders["c2"] = &simBrokerOrder{Order: newTestOrder(20, OrderBuy, 100, "c2"), BrokerState: FilledOrder,
+			BrokerQty: 100, BrokerExecQty: 100, BrokerPrice: 20}
+		b.position = -300
+		b.lastPrice = 20
+
+		b.onCorporateAction(&CorporateActionEvent{BaseEvent: be(tm, b.symbol), Action: SplitAction, Ratio: 2})
+		assert.Equal(t, CanceledOrder, b.orders["c1"].BrokerState)
+		assert.Equal(t, FilledOrder, b.orders["c2"].BrokerState)
+		assert.Equal(t, int64(-600), b.position)
+		assert.Equal(t, 10.0, b.lastPrice)
+		assert.Len(t, b.events, 2)
+	}
+
+	t.Log("Sim broker: dividend doesn't change orders")
+	{
+		b.events = make(chan event, 10)
+		b.orders["c3"] = &simBrokerOrder{Order: newTestOrder(20, OrderBuy, 100, "c3"), BrokerState: ConfirmedOrder,
+			BrokerQty: 100, BrokerPrice: 20}
+		b.onCorporateAction(&CorporateActionEvent{BaseEvent: be(tm, b.symbol), Action: DividendAction, Amount: 1})
+		assert.Equal(t, ConfirmedOrder, b.orders["c3"].BrokerState)
+		assert.Len(t, b.events, 1)
+	}
+}
+
+func TestBasicStrategy_CorporateActions(t *testing.T) {
+	us := corporateActionTestStrategy{}
+	bs := BasicStrategy{symbol: newTestInstrument(), nPeriods: 20, userStrategy: &us}
+	bs.init(CoreStrategyChannels{
+		errors:    make(chan error),
+		events:    make(chan event),
+		portfolio: make(chan *PortfolioNewPositionEvent, 5),
+	})
+	bs.mdChan = make(chan event, 1)
+	bs.mdChan <- &NewTickEvent{}
+	bs.handlersWaitGroup = &sync.WaitGroup{}
+	bs.currentTrade.Type = LongTrade
+	bs.currentTrade.Qty = 100
+	bs.currentTrade.OpenPrice = 20
+	bs.currentTrade.OpenValue = 2000
+	tm := newTestOrderTime()
+
+	t.Log("Split and dividend adjust current trade and are delivered to strategy")
+	{
+		bs.notify(&CorporateActionEvent{BaseEvent: be(tm, bs.symbol), Action: SplitAction, Ratio: 2})
+		bs.handlersWaitGroup.Wait()
+		assert.Equal(t, int64(100*2), bs.currentTrade.Qty)
+		assert.Equal(t, 10.0, bs.currentTrade.OpenPrice)
+
+		bs.notify(&CorporateActionEvent{BaseEvent: be(tm.Add(time.Hour), bs.symbol), Action: DividendAction,
+			Amount: 0.1})
+		bs.handlersWaitGroup.Wait()
+		assert.InDelta(t, 20.0, bs.currentTrade.ClosedPnL, 0.000001)
+		assert.Len(t, us.actions, 2)
+		assert.Equal(t, tm.Add(time.Hour), bs.Now())
+	}
+}
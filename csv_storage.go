@@ -0,0 +1,470 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Time formats of CSV files besides layouts of time package
+const (
+	CSVUnixTime   = "unix"
+	CSVUnixMsTime = "unixms"
+)
+
+//maxFileErrors is max number of rows errors kept in ErrStorageFile
+const maxFileErrors = 10
+
+//DefaultTickColumns and DefaultCandleColumns are column indexes of tick and candle fields. Fields names of
+//ticks: time, last, lastSize, lastExch, bid, bidSize, bidExch, ask, askSize, askExch, condQuote, cond1-cond4.
+//Fields names of candles: time, open, high, low, close, adjClose, volume, openInterest
+var (
+	DefaultTickColumns = map[string]int{"time": 0, "last": 1, "lastSize": 2, "bid": 3, "bidSize": 4, "ask": 5,
+		"askSize": 6}
+	DefaultCandleColumns = map[string]int{"time": 0, "open": 1, "high": 2, "low": 3, "close": 4, "volume": 5}
+)
+
+var (
+	tickFields = map[string]struct{}{"time": {}, "last": {}, "lastSize": {}, "lastExch": {}, "bid": {},
+		"bidSize": {}, "bidExch": {}, "ask": {}, "askSize": {}, "askExch": {}, "condQuote": {}, "cond1": {},
+		"cond2": {}, "cond3": {}, "cond4": {}}
+	candleFields = map[string]struct{}{"time": {}, "open": {}, "high": {}, "low": {}, "close": {}, "adjClose": {},
+		"volume": {}, "openInterest": {}}
+)
+
+//CSVStorage is marketdata.Storage of CSV files in Folder. TicksPath and CandlesPath are templates of files paths
+//with {symbol}, {timeframe}, {date} (2006-01-02), {year} and {month} placeholders. Files with .gz extension are
+//read with gzip, path without extension is tried with .gz too. Missing files are skipped. Times without zone are
+//in Location. Records are sorted by time unless KeepOrder is set, then they are in order of files, so BTM
+//cleaning can find out of order records
+type CSVStorage struct {
+	Folder        string
+	TicksPath     string
+	CandlesPath   string
+	TickColumns   map[string]int
+	CandleColumns map[string]int
+	Comma         rune
+	HasHeader     bool
+	TimeFormat    string
+	Location      *time.Location
+	KeepOrder     bool
+}
+
+//NewCSVStorage returns storage of "{symbol}/{date}.csv" ticks and "{symbol}/{timeframe}.csv" candles files with
+//default columns and unix time
+func NewCSVStorage(folder string) *CSVStorage {
+	return &CSVStorage{
+		Folder:        folder,
+		TicksPath:     "{symbol}/{date}.csv",
+		CandlesPath:   "{symbol}/{timeframe}.csv",
+		TickColumns:   DefaultTickColumns,
+		CandleColumns: DefaultCandleColumns,
+		Comma:         ',',
+		TimeFormat:    CSVUnixTime,
+		Location:      time.UTC,
+	}
+}
+
+//GetStoredTicks reads ticks of symbol in date range from all days files. Quotes or trades of ticks are dropped
+//when they are not requested. Invalid rows don't stop reading: valid ticks are returned with ErrStorageFile of
+//the first file with invalid rows
+func (s *CSVStorage) GetStoredTicks(symbol string, dRange marketdata.DateRange, quotes bool,
+	trades bool) (marketdata.TickArray, error) {
+	if err := checkColumns(s.TickColumns, tickFields); err != nil {
+		return nil, err
+	}
+	var res marketdata.TickArray
+	var rowsErr *ErrStorageFile
+	for _, pth := range s.filesPaths(s.TicksPath, symbol, "", dRange) {
+		err := s.readFile(pth, func(row []string) error {
+			t, err := s.parseTick(row)
+			if err != nil {
+				return err
+			}
+			if !quotes {
+				t.BidPrice, t.BidSize, t.BidExch = math.NaN(), 0, ""
+				t.AskPrice, t.AskSize, t.AskExch = math.NaN(), 0, ""
+			}
+			if !trades {
+				t.LastPrice, t.LastSize, t.LastExch = math.NaN(), 0, ""
+			}
+			if inDateRange(t.Datetime, dRange) && t.IsValid() {
+				t.Symbol = symbol
+				res = append(res, t)
+			}
+			return nil
+		})
+		if fileErr, ok := err.(*ErrStorageFile); ok {
+			if rowsErr == nil {
+				rowsErr = fileErr
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if !s.KeepOrder {
+		res.Sort()
+	}
+	if rowsErr != nil {
+		return res, rowsErr
+	}
+	return res, nil
+}
+
+//GetStoredCandles reads candles of symbol and timeframe in date range. Invalid rows are handled as in
+//GetStoredTicks
+func (s *CSVStorage) GetStoredCandles(symbol string, tf string,
+	dRange marketdata.DateRange) (marketdata.CandleArray, error) {
+	if err := checkColumns(s.CandleColumns, candleFields); err != nil {
+		return nil, err
+	}
+	var res marketdata.CandleArray
+	var rowsErr *ErrStorageFile
+	for _, pth := range s.filesPaths(s.CandlesPath, symbol, tf, dRange) {
+		err := s.readFile(pth, func(row []string) error {
+			c, err := s.parseCandle(row)
+			if err != nil {
+				return err
+			}
+			if inDateRange(c.Datetime, dRange) {
+				c.Symbol = symbol
+				res = append(res, c)
+			}
+			return nil
+		})
+		if fileErr, ok := err.(*ErrStorageFile); ok {
+			if rowsErr == nil {
+				rowsErr = fileErr
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if !s.KeepOrder {
+		res.Sort()
+	}
+	if rowsErr != nil {
+		return res, rowsErr
+	}
+	return res, nil
+}
+
+func checkColumns(columns map[string]int, fields map[string]struct{}) error {
+	if _, ok := columns["time"]; !ok {
+		return errors.New("CSV storage: time column isn't set")
+	}
+	for f, i := range columns {
+		if _, ok := fields[f]; !ok {
+			return errors.New("CSV storage: unknown column field: " + f)
+		}
+		if i < 0 {
+			return errors.New("CSV storage: negative column index of " + f)
+		}
+	}
+	return nil
+}
+
+//inDateRange returns true if t is in range. Zero bounds aren't checked
+func inDateRange(t time.Time, dRange marketdata.DateRange) bool {
+	if !dRange.From.IsZero() && t.Before(dRange.From) {
+		return false
+	}
+	if !dRange.To.IsZero() && t.After(dRange.To) {
+		return false
+	}
+	return true
+}
+
+//filesPaths returns paths of template for every day of range without duplicates. Template without date
+//placeholders gives one path, template with them needs both bounds of range
+func (s *CSVStorage) filesPaths(template string, symbol string, tf string, dRange marketdata.DateRange) []string {
+	r := strings.NewReplacer("{symbol}", symbol, "{timeframe}", tf)
+	template = r.Replace(template)
+	if !strings.Contains(template, "{date}") && !strings.Contains(template, "{year}") &&
+		!strings.Contains(template, "{month}") {
+		return []string{filepath.Join(s.Folder, template)}
+	}
+	if dRange.From.IsZero() || dRange.To.IsZero() {
+		return nil
+	}
+
+	loc := s.location()
+	from := dRange.From.In(loc)
+	to := dRange.To.In(loc)
+	var res []string
+	listed := make(map[string]struct{})
+	for d := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc); !d.After(to); d = d.AddDate(0, 0, 1) {
+		pth := strings.NewReplacer("{date}", d.Format("2006-01-02"), "{year}", d.Format("2006"),
+			"{month}", d.Format("01")).Replace(template)
+		if _, ok := listed[pth]; ok {
+			continue
+		}
+		listed[pth] = struct{}{}
+		res = append(res, filepath.Join(s.Folder, pth))
+	}
+	return res
+}
+
+func (s *CSVStorage) location() *time.Location {
+	if s.Location == nil {
+		return time.UTC
+	}
+	return s.Location
+}
+
+//StoredFiles returns existing files of symbol ticks in date range or of candles if timeframe isn't empty
+func (s *CSVStorage) StoredFiles(symbol string, tf string, dRange marketdata.DateRange) ([]string, error) {
+	template := s.TicksPath
+	if tf != "" {
+		template = s.CandlesPath
+	}
+	var res []string
+	for _, pth := range s.filesPaths(template, symbol, tf, dRange) {
+		if pth = existingFile(pth); pth != "" {
+			res = append(res, pth)
+		}
+	}
+	return res, nil
+}
+
+//existingFile returns path of file or of its .gz version. Empty path is returned for missing file
+func existingFile(pth string) string {
+	if _, err := os.Stat(pth); os.IsNotExist(err) {
+		if strings.HasSuffix(pth, ".gz") {
+			return ""
+		}
+		pth += ".gz"
+		if _, err := os.Stat(pth); os.IsNotExist(err) {
+			return ""
+		}
+	}
+	return pth
+}
+
+//openFile opens plain or gzip file. Nil reader is returned for missing file
+func openFile(pth string) (io.ReadCloser, error) {
+	if pth = existingFile(pth); pth == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(pth, ".gz") {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, pth)
+	}
+	return &gzipFile{Reader: gz, file: file}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	if err := f.Reader.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+//readFile passes rows of file to parse. Errors of rows are collected to ErrStorageFile of the file. Empty lines
+//are skipped and aren't counted as rows
+func (s *CSVStorage) readFile(pth string, parse func([]string) error) error {
+	f, err := openFile(pth)
+	if err != nil || f == nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	if s.Comma != 0 {
+		reader.Comma = s.Comma
+	}
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	fileErr := ErrStorageFile{Path: pth, Caller: "CSV storage"}
+	invalid := 0
+	for n := 1; ; n++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return errors.Wrap(err, pth)
+			}
+		} else if n == 1 && s.HasHeader {
+			continue
+		} else {
+			err = parse(row)
+		}
+		if err != nil {
+			invalid++
+			if len(fileErr.Rows) < maxFileErrors {
+				fileErr.Rows = append(fileErr.Rows, fmt.Sprintf("row %v: %v", n, err))
+			}
+		}
+	}
+
+	if invalid > 0 {
+		fileErr.Message = fmt.Sprintf("%v invalid rows.", invalid)
+		return &fileErr
+	}
+	return nil
+}
+
+func (s *CSVStorage) parseTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	switch s.TimeFormat {
+	case CSVUnixTime, "":
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.New("wrong unix time: " + v)
+		}
+		return time.Unix(sec, 0).In(s.location()), nil
+	case CSVUnixMsTime:
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.New("wrong unix ms time: " + v)
+		}
+		return time.Unix(0, ms*int64(time.Millisecond)).In(s.location()), nil
+	}
+	t, err := time.ParseInLocation(s.TimeFormat, v, s.location())
+	if err != nil {
+		return time.Time{}, errors.New("wrong time: " + v)
+	}
+	return t, nil
+}
+
+//csvRow gets fields of row by columns. Fields out of row and not mapped fields are empty
+type csvRow struct {
+	row     []string
+	columns map[string]int
+	err     error
+}
+
+func (r *csvRow) str(field string) string {
+	i, ok := r.columns[field]
+	if !ok || i >= len(r.row) {
+		return ""
+	}
+	return strings.TrimSpace(r.row[i])
+}
+
+//price returns NaN for empty field
+func (r *csvRow) price(field string) float64 {
+	v := r.str(field)
+	if v == "" || r.err != nil {
+		return math.NaN()
+	}
+	p, err := strconv.ParseFloat(v, 64)
+	if err != nil || p < 0 {
+		r.err = errors.New("wrong " + field + ": " + v)
+		return math.NaN()
+	}
+	return p
+}
+
+func (r *csvRow) qty(field string) int64 {
+	v := r.str(field)
+	if v == "" || r.err != nil {
+		return 0
+	}
+	q, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(v, 64)
+		if ferr != nil || f != math.Trunc(f) {
+			r.err = errors.New("wrong " + field + ": " + v)
+			return 0
+		}
+		q = int64(f)
+	}
+	if q < 0 {
+		r.err = errors.New("wrong " + field + ": " + v)
+		return 0
+	}
+	return q
+}
+
+func (s *CSVStorage) parseTick(row []string) (*marketdata.Tick, error) {
+	r := csvRow{row: row, columns: s.TickColumns}
+	tm, err := s.parseTime(r.str("time"))
+	if err != nil {
+		return nil, err
+	}
+	t := marketdata.Tick{
+		Datetime:  tm,
+		LastPrice: r.price("last"),
+		LastSize:  r.qty("lastSize"),
+		LastExch:  r.str("lastExch"),
+		BidPrice:  r.price("bid"),
+		BidSize:   r.qty("bidSize"),
+		BidExch:   r.str("bidExch"),
+		AskPrice:  r.price("ask"),
+		AskSize:   r.qty("askSize"),
+		AskExch:   r.str("askExch"),
+		CondQuote: r.str("condQuote"),
+		Cond1:     r.str("cond1"),
+		Cond2:     r.str("cond2"),
+		Cond3:     r.str("cond3"),
+		Cond4:     r.str("cond4"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !t.IsValid() {
+		return nil, errors.New("tick has neither trade nor quote")
+	}
+	return &t, nil
+}
+
+func (s *CSVStorage) parseCandle(row []string) (*marketdata.Candle, error) {
+	r := csvRow{row: row, columns: s.CandleColumns}
+	tm, err := s.parseTime(r.str("time"))
+	if err != nil {
+		return nil, err
+	}
+	c := marketdata.Candle{
+		Datetime:     tm,
+		Open:         r.price("open"),
+		High:         r.price("high"),
+		Low:          r.price("low"),
+		Close:        r.price("close"),
+		AdjClose:     r.price("adjClose"),
+		Volume:       r.qty("volume"),
+		OpenInterest: r.qty("openInterest"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, p := range []float64{c.Open, c.High, c.Low, c.Close} {
+		if math.IsNaN(p) || p == 0 {
+			return nil, errors.New("candle price is empty or zero")
+		}
+	}
+	if c.High < c.Low || c.High < math.Max(c.Open, c.Close) || c.Low > math.Min(c.Open, c.Close) {
+		return nil, errors.New("candle prices are out of high-low range")
+	}
+	if _, ok := s.CandleColumns["adjClose"]; !ok {
+		c.AdjClose = c.Close
+	}
+	return &c, nil
+}
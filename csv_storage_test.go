@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestStorageFile(t *testing.T, pth string, data string) {
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b := []byte(data)
+	if filepath.Ext(pth) == ".gz" {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(b)
+		w.Close()
+		b = buf.Bytes()
+	}
+	if err := ioutil.WriteFile(pth, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSVStorage_Ticks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csvstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestStorageFile(t, filepath.Join(dir, "Test", "2010-01-04.csv"),
+		"1262617200,10.01,100,10,200,10.02,300\n1262617201,,,10.01,100,10.03,100\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Test", "2010-01-05.csv.gz"),
+		"1262703600,10.5,200,,,,\n\n1262703660,10.6,100,,,,\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Test", "2010-01-07.csv"), "1262876400,11,100,,,,\n")
+
+	s := NewCSVStorage(dir)
+	rng := marketdata.DateRange{From: time.Date(2010, 1, 4, 0, 0, 0, 0, time.UTC),
+		To: time.Date(2010, 1, 6, 23, 59, 59, 0, time.UTC)}
+
+	t.Log("CSV storage: ticks of several days files, gzip file and missing day")
+	{
+		ticks, err := s.GetStoredTicks("Test", rng, true, true)
+		assert.Nil(t, err)
+		if assert.Len(t, ticks, 4) {
+			assert.Equal(t, "Test", ticks[0].Symbol)
+			assert.Equal(t, time.Unix(1262617200, 0).UTC(), ticks[0].Datetime)
+			assert.Equal(t, 10.01, ticks[0].LastPrice)
+			assert.Equal(t, int64(300), ticks[0].AskSize)
+			assert.True(t, math.IsNaN(ticks[1].LastPrice))
+			assert.Equal(t, 10.6, ticks[3].LastPrice)
+		}
+	}
+
+	t.Log("CSV storage: quotes and trades filters")
+	{
+		ticks, err := s.GetStoredTicks("Test", rng, false, true)
+		assert.Nil(t, err)
+		if assert.Len(t, ticks, 3) {
+			assert.True(t, math.IsNaN(ticks[0].BidPrice))
+		}
+
+		ticks, err = s.GetStoredTicks("Test", rng, true, false)
+		assert.Nil(t, err)
+		assert.Len(t, ticks, 2)
+	}
+
+	t.Log("CSV storage: range inside of day file")
+	{
+		ticks, err := s.GetStoredTicks("Test", marketdata.DateRange{From: time.Unix(1262703630, 0),
+			To: time.Unix(1262703700, 0)}, true, true)
+		assert.Nil(t, err)
+		assert.Len(t, ticks, 1)
+	}
+
+	t.Log("CSV storage: column mapping, header, time layout and location")
+	{
+		ny, err := time.LoadLocation("America/New_York")
+		if err != nil {
+			t.Skip("No time zone data")
+		}
+		writeTestStorageFile(t, filepath.Join(dir, "2010", "01", "Other.csv"),
+			"time;exchange;price;size\n2010-01-04 09:30:00;Q;20.5;100\n2010-01-05 09:30:00;N;20.7;50\n")
+		s := CSVStorage{
+			Folder:      dir,
+			TicksPath:   "{year}/{month}/{symbol}.csv",
+			TickColumns: map[string]int{"time": 0, "lastExch": 1, "last": 2, "lastSize": 3},
+			Comma:       ';',
+			HasHeader:   true,
+			TimeFormat:  "2006-01-02 15:04:05",
+			Location:    ny,
+		}
+		ticks, err := s.GetStoredTicks("Other", rng, true, true)
+		assert.Nil(t, err)
+		if assert.Len(t, ticks, 2) {
+			assert.Equal(t, time.Date(2010, 1, 4, 14, 30, 0, 0, time.UTC), ticks[0].Datetime.UTC())
+			assert.Equal(t, "Q", ticks[0].LastExch)
+			assert.Equal(t, 20.7, ticks[1].LastPrice)
+		}
+	}
+
+	t.Log("CSV storage: invalid rows give error of file")
+	{
+		writeTestStorageFile(t, filepath.Join(dir, "Bad", "2010-01-04.csv"),
+			"1262617200,10.01,100,,,,\ntime,10,100,,,,\n1262617202,price,100,,,,\n1262617203,,,,,,\n")
+		ticks, err := s.GetStoredTicks("Bad", rng, true, true)
+		assert.Len(t, ticks, 1, "Valid rows are kept")
+		if assert.IsType(t, &ErrStorageFile{}, err) {
+			fileErr := err.(*ErrStorageFile)
+			assert.Equal(t, filepath.Join(dir, "Bad", "2010-01-04.csv"), fileErr.Path)
+			assert.Len(t, fileErr.Rows, 3)
+			assert.Contains(t, fileErr.Rows[0], "row 2")
+		}
+	}
+
+	t.Log("CSV storage: wrong columns mapping")
+	{
+		s := NewCSVStorage(dir)
+		s.TickColumns = map[string]int{"last": 1}
+		_, err := s.GetStoredTicks("Test", rng, true, true)
+		assert.NotNil(t, err)
+		s.TickColumns = map[string]int{"time": 0, "price": 1}
+		_, err = s.GetStoredTicks("Test", rng, true, true)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestCSVStorage_Candles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csvstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestStorageFile(t, filepath.Join(dir, "Test", "D.csv"),
+		"1262649600,10,11,9.5,10.5,1000\n1262563200,9,10.2,8.8,10,2000\n1262736000,10.5,10.8,10,10.1,500\n")
+	s := NewCSVStorage(dir)
+
+	t.Log("CSV storage: candles file of timeframe is sorted and filtered by range")
+	{
+		candles, err := s.GetStoredCandles("Test", "D", marketdata.DateRange{
+			From: time.Date(2010, 1, 4, 0, 0, 0, 0, time.UTC), To: time.Date(2010, 1, 5, 0, 0, 0, 0, time.UTC)})
+		assert.Nil(t, err)
+		if assert.Len(t, candles, 2) {
+			assert.Equal(t, 9.0, candles[0].Open)
+			assert.Equal(t, 10.0, candles[0].AdjClose)
+			assert.Equal(t, int64(1000), candles[1].Volume)
+			assert.Equal(t, "Test", candles[1].Symbol)
+		}
+
+		candles, err = s.GetStoredCandles("Test", "60", marketdata.DateRange{})
+		assert.Nil(t, err)
+		assert.Len(t, candles, 0)
+	}
+
+	t.Log("CSV storage: candle out of high-low range is invalid")
+	{
+		writeTestStorageFile(t, filepath.Join(dir, "Bad", "D.csv"),
+			"1262649600,10,11,9.5,11.5,1000\n1262563200,9,8,8.8,8.9,2000\n1262563200,9,10,8,9,-1\n")
+		_, err := s.GetStoredCandles("Bad", "D", marketdata.DateRange{})
+		if assert.IsType(t, &ErrStorageFile{}, err) {
+			assert.Len(t, err.(*ErrStorageFile).Rows, 3)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+//QuoteAction is what cleaning does with crossed or locked quote of tick
+type QuoteAction int
+
+const (
+	KeepQuote QuoteAction = iota
+	RemoveQuote
+	DropTick
+)
+
+//DataCleaning is configuration of market data cleaning in BTM. Records are checked before events are put and
+//every problem is counted in quality report even if record is kept.
+//
+//SpikeWindow is number of the last trades (candles closes) of symbol in rolling median, price which differs from
+//median more than SpikeThreshold part of it is spike. Spikes are dropped, zero window turns filter off. Crossed
+//and locked quotes are kept, removed from tick or dropped with tick. Ticks with zero or negative sizes of trade
+//or quote are dropped with RemoveBadSizes. Duplicates are records equal to record of the same symbol and time.
+//Out of order records are earlier than the previous record of symbol as storage returns them, they are checked
+//before BTM sorts records, so storage which sorts records itself has none. Prepaired data is checked when it's
+//made and keeps out of order records unless they are removed. Invalid candles are always dropped.
+//GapThreshold turns on reporting of gaps without records inside of regular session longer than threshold
+type DataCleaning struct {
+	SpikeWindow      int
+	SpikeThreshold   float64
+	CrossedQuotes    QuoteAction
+	LockedQuotes     QuoteAction
+	RemoveBadSizes   bool
+	RemoveDuplicates bool
+	RemoveOutOfOrder bool
+	GapThreshold     time.Duration
+}
+
+//DataGap is time inside of session without market data records
+type DataGap struct {
+	From time.Time
+	To   time.Time
+}
+
+//DataQualityDay is data quality of symbol for day in exchange location. Missing is trading day without records
+type DataQualityDay struct {
+	Symbol         string
+	Date           time.Time
+	Records        int
+	Dropped        int
+	Spikes         int
+	CrossedQuotes  int
+	LockedQuotes   int
+	BadSizes       int
+	Duplicates     int
+	OutOfOrder     int
+	InvalidCandles int
+	Gaps           []DataGap
+	Missing        bool
+}
+
+//DataQualityReport has days of symbols sorted by symbol and date
+type DataQualityReport struct {
+	Days []*DataQualityDay
+}
+
+func (r *DataQualityReport) String() string {
+	var b strings.Builder
+	b.WriteString("symbol,date,records,dropped,spikes,crossed,locked,badSizes,duplicates,outOfOrder," +
+		"invalidCandles,gaps,missing\n")
+	for _, d := range r.Days {
+		b.WriteString(fmt.Sprintf("%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,%v\n", d.Symbol,
+			d.Date.Format("2006-01-02"), d.Records, d.Dropped, d.Spikes, d.CrossedQuotes, d.LockedQuotes, d.BadSizes,
+			d.Duplicates, d.OutOfOrder, d.InvalidCandles, len(d.Gaps), d.Missing))
+	}
+	return b.String()
+}
+
+//symbolQuality is state of cleaning of symbol records
+type symbolQuality struct {
+	prices   []float64
+	lastTime time.Time
+	sameTime map[string]struct{}
+	day      *DataQualityDay
+	gapFrom  time.Time
+}
+
+//dataCleaner checks records of BTM and collects quality days
+type dataCleaner struct {
+	config  DataCleaning
+	symbols map[string]*symbolQuality
+	days    map[string]*DataQualityDay
+	//storageTimes are times of the last records of symbols returned by storage
+	storageTimes map[string]time.Time
+}
+
+func newDataCleaner(config DataCleaning) *dataCleaner {
+	return &dataCleaner{
+		config:       config,
+		symbols:      make(map[string]*symbolQuality),
+		days:         make(map[string]*DataQualityDay),
+		storageTimes: make(map[string]time.Time),
+	}
+}
+
+//day returns quality day of symbol for time t
+func (c *dataCleaner) day(symbol *Instrument, t time.Time) *DataQualityDay {
+	lt := symbol.Exchange.localTime(t)
+	date := time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, time.UTC)
+	dayKey := symbol.Symbol + date.Format("2006-01-02")
+	d, ok := c.days[dayKey]
+	if !ok {
+		d = &DataQualityDay{Symbol: symbol.Symbol, Date: date}
+		c.days[dayKey] = d
+	}
+	return d
+}
+
+//record returns state of symbol and quality day of record time t. Gaps are checked when record is in session
+func (c *dataCleaner) record(symbol *Instrument, key string, t time.Time) (*symbolQuality, *DataQualityDay) {
+	s, ok := c.symbols[key]
+	if !ok {
+		s = &symbolQuality{}
+		c.symbols[key] = s
+	}
+
+	lt := symbol.Exchange.localTime(t)
+	if day := c.day(symbol, t); s.day != day {
+		c.finishDay(symbol, s)
+		s.day = day
+		s.gapFrom = symbol.Exchange.OpenTime(lt)
+	}
+	s.day.Records++
+
+	if c.hasSession(symbol) && !lt.Before(s.gapFrom) && !lt.After(symbol.Exchange.CloseTime(lt)) {
+		c.checkGap(s, lt)
+		s.gapFrom = lt
+	}
+	return s, s.day
+}
+
+func (c *dataCleaner) hasSession(symbol *Instrument) bool {
+	return c.config.GapThreshold > 0 && symbol.Exchange.MarketCloseTime != symbol.Exchange.MarketOpenTime
+}
+
+func (c *dataCleaner) checkGap(s *symbolQuality, t time.Time) {
+	if t.Sub(s.gapFrom) > c.config.GapThreshold {
+		s.day.Gaps = append(s.day.Gaps, DataGap{From: s.gapFrom, To: t})
+	}
+}
+
+//finishDay checks gap between the last record of day and market close
+func (c *dataCleaner) finishDay(symbol *Instrument, s *symbolQuality) {
+	if s.day == nil || !c.hasSession(symbol) || s.gapFrom.IsZero() {
+		return
+	}
+	closeTime := symbol.Exchange.CloseTime(s.gapFrom)
+	if s.gapFrom.Before(closeTime) {
+		c.checkGap(s, closeTime)
+	}
+}
+
+//checkStorageOrder counts record of symbol which is earlier than the previous record returned by storage.
+//Returns false if record is dropped
+func (c *dataCleaner) checkStorageOrder(symbol *Instrument, key string, t time.Time) bool {
+	last, ok := c.storageTimes[key]
+	if !ok || !t.Before(last) {
+		c.storageTimes[key] = t
+		return true
+	}
+	day := c.day(symbol, t)
+	day.OutOfOrder++
+	if !c.config.RemoveOutOfOrder {
+		return true
+	}
+	day.Records++
+	day.Dropped++
+	return false
+}
+
+//checkDuplicate counts duplicates of sorted records of symbol. Returns false if record is dropped
+func (c *dataCleaner) checkDuplicate(s *symbolQuality, day *DataQualityDay, t time.Time, line string) bool {
+	if !t.Equal(s.lastTime) {
+		s.lastTime = t
+		s.sameTime = make(map[string]struct{})
+	}
+	if _, ok := s.sameTime[line]; ok {
+		day.Duplicates++
+		return !c.config.RemoveDuplicates
+	}
+	s.sameTime[line] = struct{}{}
+	return true
+}
+
+//isSpike checks price against rolling median of the last prices of symbol. Price is put to window anyway, so
+//median follows real change of price level
+func (c *dataCleaner) isSpike(s *symbolQuality, price float64) bool {
+	if c.config.SpikeWindow <= 0 {
+		return false
+	}
+	spike := false
+	if len(s.prices) == c.config.SpikeWindow {
+		sorted := append([]float64(nil), s.prices...)
+		sort.Float64s(sorted)
+		median := sorted[len(sorted)/2]
+		if len(sorted)%2 == 0 {
+			median = (median + sorted[len(sorted)/2-1]) / 2
+		}
+		spike = median > 0 && math.Abs(price-median)/median > c.config.SpikeThreshold
+		s.prices = s.prices[1:]
+	}
+	s.prices = append(s.prices, price)
+	return spike
+}
+
+//quoteAction returns action for quote of tick
+func (c *dataCleaner) quoteAction(t *marketdata.Tick, day *DataQualityDay) QuoteAction {
+	if !t.HasQuote() {
+		return KeepQuote
+	}
+	if t.BidPrice > t.AskPrice {
+		day.CrossedQuotes++
+		return c.config.CrossedQuotes
+	}
+	if t.BidPrice == t.AskPrice {
+		day.LockedQuotes++
+		return c.config.LockedQuotes
+	}
+	return KeepQuote
+}
+
+//cleanTick returns false if tick is dropped. Crossed or locked quote can be removed from tick
+func (c *dataCleaner) cleanTick(t *marketdata.Tick, ticker *Instrument) bool {
+	s, day := c.record(ticker, ticker.Symbol, t.Datetime)
+	keep := c.checkTick(s, day, t)
+	if !keep {
+		day.Dropped++
+	}
+	return keep
+}
+
+func (c *dataCleaner) checkTick(s *symbolQuality, day *DataQualityDay, t *marketdata.Tick) bool {
+	if !c.checkDuplicate(s, day, t.Datetime, t.String()) {
+		return false
+	}
+
+	hasTradePrice := !math.IsNaN(t.LastPrice) && t.LastPrice > 0
+	hasQuotePrice := !math.IsNaN(t.BidPrice) && t.BidPrice > 0 || !math.IsNaN(t.AskPrice) && t.AskPrice > 0
+	if hasTradePrice && t.LastSize <= 0 || hasQuotePrice && (t.BidSize <= 0 || t.AskSize <= 0) {
+		day.BadSizes++
+		if c.config.RemoveBadSizes {
+			return false
+		}
+	}
+
+	switch c.quoteAction(t, day) {
+	case DropTick:
+		return false
+	case RemoveQuote:
+		t.BidPrice, t.AskPrice = math.NaN(), math.NaN()
+		t.BidSize, t.AskSize = 0, 0
+		if !t.HasTrade() {
+			return false
+		}
+	}
+
+	if t.HasTrade() && c.isSpike(s, t.LastPrice) {
+		day.Spikes++
+		return false
+	}
+	return true
+}
+
+//cleanCandle returns false if candle is dropped. Candles of different timeframes are checked separately
+func (c *dataCleaner) cleanCandle(candle *Candle) bool {
+	s, day := c.record(candle.Ticker, candle.Symbol+","+candle.TimeFrame, candle.Datetime)
+	keep := c.checkCandle(s, day, candle)
+	if !keep {
+		day.Dropped++
+	}
+	return keep
+}
+
+func (c *dataCleaner) checkCandle(s *symbolQuality, day *DataQualityDay, candle *Candle) bool {
+	if !c.checkDuplicate(s, day, candle.Datetime, candle.Candle.String()) {
+		return false
+	}
+	if !candle.isValid() || candle.Volume < 0 {
+		day.InvalidCandles++
+		return false
+	}
+	if c.isSpike(s, candle.Close) {
+		day.Spikes++
+		return false
+	}
+	return true
+}
+
+//report returns days with records and missing trading days of symbols in date range. Missing days are found
+//for ticks and candles up to daily timeframe
+func (c *dataCleaner) report(symbols []*Instrument, from, to time.Time, timeFrame string) *DataQualityReport {
+	for key, s := range c.symbols {
+		symbol := strings.Split(key, ",")[0]
+		for _, inst := range symbols {
+			if inst.Symbol == symbol {
+				c.finishDay(inst, s)
+			}
+		}
+		s.day = nil
+	}
+
+	checkMissing := timeFrame == "" || timeFrame == "D" || isIntradayTimeFrame(timeFrame)
+	for _, inst := range symbols {
+		if !checkMissing {
+			break
+		}
+		lf, lt := inst.Exchange.localTime(from), inst.Exchange.localTime(to)
+		last := time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, lt.Location())
+		for d := time.Date(lf.Year(), lf.Month(), lf.Day(), 0, 0, 0, 0, lf.Location()); !d.After(last); d = d.AddDate(0, 0, 1) {
+			dayKey := inst.Symbol + d.Format("2006-01-02")
+			if _, ok := c.days[dayKey]; ok || !inst.Exchange.IsTradingDay(d) {
+				continue
+			}
+			c.days[dayKey] = &DataQualityDay{Symbol: inst.Symbol, Date: time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0,
+				0, time.UTC), Missing: true}
+		}
+	}
+
+	r := DataQualityReport{}
+	for _, d := range c.days {
+		r.Days = append(r.Days, d)
+	}
+	sort.Slice(r.Days, func(i, j int) bool {
+		if r.Days[i].Symbol == r.Days[j].Symbol {
+			return r.Days[i].Date.Before(r.Days[j].Date)
+		}
+		return r.Days[i].Symbol < r.Days[j].Symbol
+	})
+	return &r
+}
+
+//******* BTM *****************************************************************
+
+//initCleaning makes cleaner of run. Cleaner made when prepaired data is made in the same run is kept, it has
+//out of order records of storage
+func (m *BTM) initCleaning() {
+	m.qualityReport = nil
+	if m.Cleaning == nil {
+		m.cleaner = nil
+		return
+	}
+	if m.cleaner == nil {
+		m.cleaner = newDataCleaner(*m.Cleaning)
+	}
+}
+
+//storage returns storage of BTM which records are checked for order by cleaning
+func (m *BTM) storage() marketdata.Storage {
+	if m.cleaner == nil {
+		return m.Storage
+	}
+	return &orderCheckedStorage{Storage: m.Storage, cleaner: m.cleaner, tickers: m.getTickersMap()}
+}
+
+//cleanTick returns false if tick is dropped by cleaning
+func (m *BTM) cleanTick(t *marketdata.Tick, ticker *Instrument) bool {
+	if m.cleaner == nil || ticker == nil {
+		return true
+	}
+	return m.cleaner.cleanTick(t, ticker)
+}
+
+//cleanCandle returns false if candle is dropped by cleaning
+func (m *BTM) cleanCandle(c *Candle) bool {
+	if m.cleaner == nil || c.Ticker == nil {
+		return true
+	}
+	return m.cleaner.cleanCandle(c)
+}
+
+func (m *BTM) finishCleaning() {
+	if m.cleaner == nil {
+		return
+	}
+	timeFrame := ""
+	if m.mode == MarketDataModeCandles {
+		timeFrame = m.candlesTimeFrame
+	}
+	m.qualityReport = m.cleaner.report(m.Symbols, m.FromDate, m.ToDate, timeFrame)
+	m.cleaner = nil
+}
+
+//QualityReport returns data quality report of cleaning. It's ready after the end of data, nil without cleaning
+func (m *BTM) QualityReport() *DataQualityReport {
+	return m.qualityReport
+}
+
+//orderCheckedStorage checks order of records as storage returns them, before BTM sorts them
+type orderCheckedStorage struct {
+	marketdata.Storage
+	cleaner *dataCleaner
+	tickers map[string]*Instrument
+}
+
+func (s *orderCheckedStorage) GetStoredTicks(symbol string, dRange marketdata.DateRange, quotes bool,
+	trades bool) (marketdata.TickArray, error) {
+	ticks, err := s.Storage.GetStoredTicks(symbol, dRange, quotes, trades)
+	ticker, ok := s.tickers[symbol]
+	if !ok {
+		return ticks, err
+	}
+	var res marketdata.TickArray
+	for _, t := range ticks {
+		if s.cleaner.checkStorageOrder(ticker, symbol, t.Datetime) {
+			res = append(res, t)
+		}
+	}
+	return res, err
+}
+
+func (s *orderCheckedStorage) GetStoredCandles(symbol string, tf string,
+	dRange marketdata.DateRange) (marketdata.CandleArray, error) {
+	candles, err := s.Storage.GetStoredCandles(symbol, tf, dRange)
+	ticker, ok := s.tickers[symbol]
+	if !ok {
+		return candles, err
+	}
+	var res marketdata.CandleArray
+	for _, c := range candles {
+		if s.cleaner.checkStorageOrder(ticker, symbol+","+tf, c.Datetime) {
+			res = append(res, c)
+		}
+	}
+	return res, err
+}
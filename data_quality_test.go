@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestQualityTick(tm time.Time, price float64, size int64, bid, ask float64) *marketdata.Tick {
+	t := marketdata.Tick{Datetime: tm, Symbol: "Test", LastPrice: price, LastSize: size, BidPrice: bid, AskPrice: ask}
+	if bid > 0 {
+		t.BidSize, t.AskSize = 100, 100
+	}
+	return &t
+}
+
+func TestDataCleaner_Ticks(t *testing.T) {
+	inst := newTestInstrument()
+	c := newDataCleaner(DataCleaning{
+		SpikeWindow:      3,
+		SpikeThreshold:   0.1,
+		CrossedQuotes:    RemoveQuote,
+		LockedQuotes:     DropTick,
+		RemoveBadSizes:   true,
+		RemoveDuplicates: true,
+		RemoveOutOfOrder: true,
+		GapThreshold:     time.Hour,
+	})
+	open := time.Date(2010, 1, 4, 9, 30, 0, 0, time.UTC)
+
+	t.Log("Out of order records are found in order of storage")
+	{
+		assert.True(t, c.checkStorageOrder(inst, "Test", open.Add(2*time.Minute)))
+		assert.False(t, c.checkStorageOrder(inst, "Test", open.Add(time.Minute)))
+		assert.True(t, c.checkStorageOrder(inst, "Test", open.Add(2*time.Minute)))
+	}
+
+	t.Log("Cleaning drops spikes, duplicates, locked and bad sizes ticks")
+	{
+		crossed := newTestQualityTick(open.Add(4*time.Minute), 10.03, 100, 10.05, 10.04)
+		for _, tc := range []struct {
+			tick *marketdata.Tick
+			keep bool
+		}{
+			{newTestQualityTick(open, 10, 100, 0, 0), true},
+			{newTestQualityTick(open.Add(time.Minute), 10.01, 100, 0, 0), true},
+			{newTestQualityTick(open.Add(2*time.Minute), 10.02, 100, 0, 0), true},
+			{newTestQualityTick(open.Add(3*time.Minute), 12, 100, 0, 0), false},
+			{newTestQualityTick(open.Add(3*time.Minute), 12, 100, 0, 0), false},
+			{crossed, true},
+			{newTestQualityTick(open.Add(5*time.Minute), 10.03, 100, 10.03, 10.03), false},
+			{newTestQualityTick(open.Add(6*time.Minute), 10.03, 0, 0, 0), false},
+			{newTestQualityTick(open.Add(150*time.Minute), 10.04, 100, 0, 0), true},
+		} {
+			assert.Equal(t, tc.keep, c.cleanTick(tc.tick, inst), tc.tick.String())
+		}
+		assert.True(t, math.IsNaN(crossed.BidPrice))
+		assert.Equal(t, int64(0), crossed.AskSize)
+	}
+
+	t.Log("Report has problems and gaps of days and missing trading days")
+	{
+		r := c.report([]*Instrument{inst}, time.Date(2010, 1, 4, 0, 0, 0, 0, time.UTC),
+			time.Date(2010, 1, 9, 0, 0, 0, 0, time.UTC), "")
+		if assert.Len(t, r.Days, 5) {
+			d := r.Days[0]
+			assert.Equal(t, time.Date(2010, 1, 4, 0, 0, 0, 0, time.UTC), d.Date)
+			assert.Equal(t, []int{10, 5, 1, 1, 1, 1, 1, 1}, []int{d.Records, d.Dropped, d.Spikes, d.Duplicates,
+				d.OutOfOrder, d.CrossedQuotes, d.LockedQuotes, d.BadSizes})
+			assert.Equal(t, []DataGap{
+				{From: open.Add(6 * time.Minute), To: open.Add(150 * time.Minute)},
+				{From: open.Add(150 * time.Minute), To: time.Date(2010, 1, 4, 16, 0, 0, 0, time.UTC)},
+			}, d.Gaps)
+			assert.False(t, d.Missing)
+			assert.True(t, r.Days[1].Missing)
+			assert.Equal(t, time.Date(2010, 1, 8, 0, 0, 0, 0, time.UTC), r.Days[4].Date)
+		}
+		assert.True(t, strings.HasPrefix(r.String(), "symbol,date,records"))
+	}
+}
+
+func TestDataCleaner_Candles(t *testing.T) {
+	inst := newTestInstrument()
+	c := newDataCleaner(DataCleaning{RemoveOutOfOrder: true})
+	newCandle := func(day int, o, h, l, cl float64) *Candle {
+		return &Candle{Candle: &marketdata.Candle{Datetime: time.Date(2010, 1, day, 0, 0, 0, 0, time.UTC),
+			Symbol: "Test", Open: o, High: h, Low: l, Close: cl, Volume: 100}, Ticker: inst, TimeFrame: "D"}
+	}
+
+	t.Log("Candle with close below low is invalid")
+	{
+		assert.True(t, newCandle(4, 10, 11, 9, 10).isValid())
+		assert.False(t, newCandle(4, 10, 11, 9, 8.5).isValid())
+		assert.False(t, newCandle(4, 12, 11, 9, 10).isValid())
+	}
+
+	t.Log("Cleaning drops invalid and out of order candles")
+	{
+		assert.True(t, c.checkStorageOrder(inst, "Test,D", newCandle(5, 10, 11, 9, 10).Datetime))
+		assert.False(t, c.checkStorageOrder(inst, "Test,D", newCandle(4, 10, 11, 9, 10).Datetime))
+		assert.True(t, c.cleanCandle(newCandle(5, 10, 11, 9, 10)))
+		assert.False(t, c.cleanCandle(newCandle(6, 10, 11, 9, 8.5)))
+		r := c.report([]*Instrument{inst}, time.Date(2010, 1, 4, 0, 0, 0, 0, time.UTC),
+			time.Date(2010, 1, 6, 0, 0, 0, 0, time.UTC), "D")
+		if assert.Len(t, r.Days, 3) {
+			assert.Equal(t, 1, r.Days[0].OutOfOrder)
+			assert.Equal(t, 1, r.Days[2].InvalidCandles)
+		}
+	}
+}
+
+func TestBTM_Cleaning(t *testing.T) {
+	m, clean := newTestStreamBTM(t, MarketDataModeTicks)
+	defer clean()
+	m.Cleaning = &DataCleaning{SpikeWindow: 1, SpikeThreshold: 0.001}
+
+	t.Log("BTM drops spikes before events and makes quality report")
+	{
+		m.genTickEvents()
+		events := readTestEvents(m)
+		assert.Len(t, events, 3)
+		r := m.QualityReport()
+		if assert.NotNil(t, r) {
+			var spikes int
+			for _, d := range r.Days {
+				spikes += d.Spikes
+			}
+			assert.Equal(t, 3, spikes)
+		}
+	}
+	countOutOfOrder := func(r *DataQualityReport) int {
+		n := 0
+		for _, d := range r.Days {
+			n += d.OutOfOrder
+		}
+		return n
+	}
+
+	t.Log("BTM finds out of order records of storage before they are sorted")
+	{
+		m, clean := newTestStreamBTM(t, MarketDataModeTicksQuotes)
+		defer clean()
+		m.Storage.(*CSVStorage).KeepOrder = true
+		m.Cleaning = &DataCleaning{RemoveOutOfOrder: true}
+		m.genTickEvents()
+		readTestEvents(m)
+		if assert.NotNil(t, m.QualityReport()) {
+			assert.Equal(t, 1, countOutOfOrder(m.QualityReport()))
+		}
+	}
+
+	t.Log("Out of order records are found when prepaired data is made")
+	{
+		m, clean := newTestStreamBTM(t, MarketDataModeTicksQuotes)
+		defer clean()
+		m.Storage.(*CSVStorage).KeepOrder = true
+		m.Streaming = false
+		m.Cleaning = &DataCleaning{}
+		m.prepare()
+		m.genTickEvents()
+		assert.Len(t, readTestEvents(m), 6)
+		if assert.NotNil(t, m.QualityReport()) {
+			assert.Equal(t, 1, countOutOfOrder(m.QualityReport()))
+		}
+	}
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"strings"
 )
 
 type ErrBrokenTick struct {
@@ -115,3 +116,16 @@ func (e *ErrOrderIdIncorrect) Error() string {
 	return fmt.Sprintf("%v: ErrOrderIdIncorrect (id:%v). %v", e.Caller, e.OrdId, e.Message)
 
 }
+
+//ErrStorageFile keeps validation errors of rows of market data file
+type ErrStorageFile struct {
+	Path    string
+	Rows    []string
+	Message string
+	Caller  string
+}
+
+func (e *ErrStorageFile) Error() string {
+	return fmt.Sprintf("%v: ErrStorageFile (path:%v). %v %v", e.Caller, e.Path, e.Message, strings.Join(e.Rows, "; "))
+
+}
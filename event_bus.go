@@ -0,0 +1,353 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const defaultSubscriberBufferSize = 1000
+
+//Event is read-only view of engine events for external observers. Use type switch to get concrete event
+type Event interface {
+	event
+}
+
+//EventFilter selects events delivered to subscriber. Event should match every not empty list, empty list means
+//no restriction by this field. Types are event names like "OrderFillEvent", Strategies are keys of strategies
+//map passed to NewEngine
+type EventFilter struct {
+	Types      []string
+	Symbols    []string
+	Strategies []string
+	BufferSize int
+}
+
+//Subscription is a registered observer. Events are buffered and delivered in separate goroutine. When buffer
+//is full new events are dropped and counted
+type Subscription struct {
+	types      map[string]struct{}
+	symbols    map[string]struct{}
+	strategies map[string]struct{}
+	handler    func(e Event)
+	buffer     chan Event
+	dropped    int64
+	bus        *eventBus
+	done       chan struct{}
+}
+
+//Dropped returns number of events that weren't delivered because subscriber buffer was full
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+//Unsubscribe stops delivery of new events. Events already buffered are delivered before return, so it
+//shouldn't be called from subscription handler
+func (s *Subscription) Unsubscribe() {
+	s.bus.remove(s)
+	<-s.done
+}
+
+func (s *Subscription) match(e event) bool {
+	if len(s.types) > 0 {
+		if _, ok := s.types[e.getName()]; !ok {
+			return false
+		}
+	}
+	if len(s.symbols) > 0 {
+		if _, ok := s.symbols[eventSymbol(e)]; !ok {
+			return false
+		}
+	}
+	if len(s.strategies) > 0 {
+		if _, ok := s.strategies[eventSymbol(e)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+//eventSymbol returns symbol of event or empty string for events without instrument
+func eventSymbol(e event) string {
+	if t, ok := e.(interface{ getTicker() *Instrument }); ok && t.getTicker() != nil {
+		return t.getTicker().Symbol
+	}
+	return ""
+}
+
+func (s *Subscription) run() {
+	for e := range s.buffer {
+		s.handler(e)
+	}
+	close(s.done)
+}
+
+type eventBus struct {
+	subscriptions map[*Subscription]struct{}
+	mut           *sync.RWMutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscriptions: make(map[*Subscription]struct{}), mut: &sync.RWMutex{}}
+}
+
+func (b *eventBus) add(s *Subscription) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.subscriptions[s] = struct{}{}
+	go s.run()
+}
+
+func (b *eventBus) remove(s *Subscription) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if _, ok := b.subscriptions[s]; !ok {
+		return
+	}
+	delete(b.subscriptions, s)
+	close(s.buffer)
+}
+
+//publish never blocks. Every subscriber gets its own copy of event
+func (b *eventBus) publish(e event) {
+	if e == nil {
+		return
+	}
+	b.mut.RLock()
+	defer b.mut.RUnlock()
+	for s := range b.subscriptions {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.buffer <- cloneEvent(e):
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+//close unsubscribes all observers and waits until buffered events are delivered
+func (b *eventBus) close() {
+	b.mut.RLock()
+	var subs []*Subscription
+	for s := range b.subscriptions {
+		subs = append(subs, s)
+	}
+	b.mut.RUnlock()
+
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+}
+
+//cloneEvent returns copy of event with copies of its orders, trades, candles and ticks, because engine and
+//strategies keep changing them after event was published. Instruments are shared, they are read-only
+func cloneEvent(e event) event {
+	switch i := e.(type) {
+	case *CandleOpenEvent:
+		c := *i
+		return &c
+	case *CandleCloseEvent:
+		c := *i
+		c.Candle = cloneCandle(i.Candle)
+		return &c
+	case *CandlesHistoryEvent:
+		c := *i
+		c.Candles = make(CandleArray, len(i.Candles))
+		for n, v := range i.Candles {
+			c.Candles[n] = cloneCandle(v)
+		}
+		return &c
+	case *NewTickEvent:
+		c := *i
+		c.Tick = cloneTick(i.Tick)
+		return &c
+	case *TickHistoryEvent:
+		c := *i
+		c.Ticks = make(TickArray, len(i.Ticks))
+		for n, v := range i.Ticks {
+			c.Ticks[n] = cloneTick(v)
+		}
+		return &c
+	case *NewOrderEvent:
+		c := *i
+		c.LinkedOrder = cloneOrder(i.LinkedOrder)
+		return &c
+	case *OrderConfirmationEvent:
+		c := *i
+		return &c
+	case *OrderFillEvent:
+		c := *i
+		return &c
+	case *OrderCancelEvent:
+		c := *i
+		return &c
+	case *OrderCancelRejectEvent:
+		c := *i
+		return &c
+	case *OrderCancelRequestEvent:
+		c := *i
+		return &c
+	case *OrderReplaceRequestEvent:
+		c := *i
+		return &c
+	case *OrderReplaceRejectEvent:
+		c := *i
+		return &c
+	case *OrderReplacedEvent:
+		c := *i
+		return &c
+	case *OrderRejectedEvent:
+		c := *i
+		return &c
+	case *StrategyRequestNotDeliveredEvent:
+		c := *i
+		if i.Request != nil {
+			c.Request = cloneEvent(i.Request)
+		}
+		return &c
+	case *TimerTickEvent:
+		c := *i
+		return &c
+	case *AuctionImbalanceEvent:
+		c := *i
+		return &c
+	case *TradingHaltEvent:
+		c := *i
+		return &c
+	case *TradingResumeEvent:
+		c := *i
+		return &c
+	case *BorrowFeeEvent:
+		c := *i
+		return &c
+	case *CorporateActionEvent:
+		c := *i
+		return &c
+	case *EndOfDataEvent:
+		c := *i
+		return &c
+	case *PortfolioNewPositionEvent:
+		c := *i
+		c.Trade = cloneTrade(i.Trade)
+		return &c
+	case *StrategyFinishedEvent:
+		c := *i
+		return &c
+	}
+	return e
+}
+
+func cloneOrder(o *Order) *Order {
+	if o == nil {
+		return nil
+	}
+	c := *o
+	c.Fills = append([]OrderExecution(nil), o.Fills...)
+	c.Replaces = append([]OrderReplacement(nil), o.Replaces...)
+	return &c
+}
+
+func cloneCandle(c *Candle) *Candle {
+	if c == nil {
+		return nil
+	}
+	res := *c
+	if c.Candle != nil {
+		raw := *c.Candle
+		res.Candle = &raw
+	}
+	return &res
+}
+
+func cloneTick(t *Tick) *Tick {
+	if t == nil {
+		return nil
+	}
+	res := *t
+	if t.Tick != nil {
+		raw := *t.Tick
+		res.Tick = &raw
+	}
+	return &res
+}
+
+//cloneTrade copies trade with its orders and returns. Order which is in several maps of trade is copied once
+func cloneTrade(t *Trade) *Trade {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	orders := make(map[*Order]*Order)
+	cloneOrders := func(m map[string]*Order) map[string]*Order {
+		if m == nil {
+			return nil
+		}
+		res := make(map[string]*Order, len(m))
+		for k, o := range m {
+			if _, ok := orders[o]; !ok {
+				orders[o] = cloneOrder(o)
+			}
+			res[k] = orders[o]
+		}
+		return res
+	}
+	c.FilledOrders = cloneOrders(t.FilledOrders)
+	c.CanceledOrders = cloneOrders(t.CanceledOrders)
+	c.NewOrders = cloneOrders(t.NewOrders)
+	c.ConfirmedOrders = cloneOrders(t.ConfirmedOrders)
+	c.RejectedOrders = cloneOrders(t.RejectedOrders)
+	if t.AllOrdersIDMap != nil {
+		c.AllOrdersIDMap = make(map[string]struct{}, len(t.AllOrdersIDMap))
+		for k := range t.AllOrdersIDMap {
+			c.AllOrdersIDMap[k] = struct{}{}
+		}
+	}
+	if t.Returns != nil {
+		c.Returns = make([]*TradeReturn, len(t.Returns))
+		for n, r := range t.Returns {
+			if r != nil {
+				v := *r
+				c.Returns[n] = &v
+			}
+		}
+	}
+	return &c
+}
+
+//Subscribe registers observer of engine events. Handler is called in separate goroutine in order of events.
+//Slow handler never blocks engine: when subscriber buffer is full events are dropped (see Subscription.Dropped)
+func (c *Engine) Subscribe(filter EventFilter, handler func(e Event)) *Subscription {
+	if handler == nil {
+		panic("Subscription handler is nil")
+	}
+	size := filter.BufferSize
+	if size <= 0 {
+		size = defaultSubscriberBufferSize
+	}
+	s := Subscription{
+		types:      make(map[string]struct{}),
+		symbols:    make(map[string]struct{}),
+		strategies: make(map[string]struct{}),
+		handler:    handler,
+		buffer:     make(chan Event, size),
+		bus:        c.bus,
+		done:       make(chan struct{}),
+	}
+	for _, t := range filter.Types {
+		s.types[t] = struct{}{}
+	}
+	for _, sym := range filter.Symbols {
+		s.symbols[sym] = struct{}{}
+	}
+	for _, name := range filter.Strategies {
+		st, ok := c.strategiesMap[name]
+		if !ok {
+			panic("Can't subscribe. Strategy not found: " + name)
+		}
+		s.strategies[st.getInstrument().Symbol] = struct{}{}
+	}
+
+	c.bus.add(&s)
+	return &s
+}
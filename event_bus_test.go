@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func newTestEngineWithBus() *Engine {
+	st := BasicStrategy{symbol: newTestInstrument()}
+	return &Engine{bus: newEventBus(), strategiesMap: map[string]ICoreStrategy{"TestStrategy": &st}}
+}
+
+func TestEngine_Subscribe(t *testing.T) {
+	inst := newTestInstrument()
+	other := newTestInstrument()
+	other.Symbol = "Other"
+	tm := newTestOrderTime()
+
+	t.Log("Subscribe: filter by type and symbol")
+	{
+		eng := newTestEngineWithBus()
+		var fills, bySymbol, all []Event
+		mut := &sync.Mutex{}
+		collect := func(dst *[]Event) func(e Event) {
+			return func(e Event) {
+				mut.Lock()
+				*dst = append(*dst, e)
+				mut.Unlock()
+			}
+		}
+		s1 := eng.Subscribe(EventFilter{Types: []string{"OrderFillEvent"}}, collect(&fills))
+		s2 := eng.Subscribe(EventFilter{Strategies: []string{"TestStrategy"}}, collect(&bySymbol))
+		s3 := eng.Subscribe(EventFilter{}, collect(&all))
+
+		eng.bus.publish(&OrderFillEvent{BaseEvent: be(tm, inst), OrdId: "1", Price: 10, Qty: 100})
+		eng.bus.publish(&OrderFillEvent{BaseEvent: be(tm, other), OrdId: "2", Price: 10, Qty: 100})
+		eng.bus.publish(&OrderCancelEvent{BaseEvent: be(tm, inst), OrdId: "3"})
+		eng.bus.publish(&EndOfDataEvent{BaseEvent: be(tm, nil)})
+
+		eng.bus.close()
+
+		assert.Len(t, fills, 2)
+		if assert.Len(t, bySymbol, 2) {
+			assert.Equal(t, "1", bySymbol[0].(*OrderFillEvent).OrdId)
+			assert.Equal(t, "3", bySymbol[1].(*OrderCancelEvent).OrdId)
+		}
+		assert.Len(t, all, 4)
+		for _, s := range []*Subscription{s1, s2, s3} {
+			assert.Equal(t, int64(0), s.Dropped())
+		}
+	}
+
+	t.Log("Subscribe: symbols and strategies restrict events together")
+	{
+		eng := newTestEngineWithBus()
+		var same, different []Event
+		mut := &sync.Mutex{}
+		collect := func(dst *[]Event) func(e Event) {
+			return func(e Event) {
+				mut.Lock()
+				*dst = append(*dst, e)
+				mut.Unlock()
+			}
+		}
+		eng.Subscribe(EventFilter{Symbols: []string{"Test"}, Strategies: []string{"TestStrategy"}}, collect(&same))
+		eng.Subscribe(EventFilter{Symbols: []string{"Other"}, Strategies: []string{"TestStrategy"}},
+			collect(&different))
+
+		eng.bus.publish(&OrderFillEvent{BaseEvent: be(tm, inst), OrdId: "1", Price: 10, Qty: 100})
+		eng.bus.publish(&OrderFillEvent{BaseEvent: be(tm, other), OrdId: "2", Price: 10, Qty: 100})
+		eng.bus.close()
+
+		if assert.Len(t, same, 1) {
+			assert.Equal(t, "1", same[0].(*OrderFillEvent).OrdId)
+		}
+		assert.Len(t, different, 0)
+	}
+
+	t.Log("Subscribe: slow subscriber doesn't block publisher and counts dropped events")
+	{
+		eng := newTestEngineWithBus()
+		block := make(chan struct{})
+		received := 0
+		s := eng.Subscribe(EventFilter{BufferSize: 2}, func(e Event) {
+			<-block
+			received++
+		})
+
+		for i := 0; i < 10; i++ {
+			eng.bus.publish(&OrderCancelEvent{BaseEvent: be(tm, inst), OrdId: "1"})
+		}
+		close(block)
+		s.Unsubscribe()
+
+		assert.True(t, s.Dropped() >= 7)
+		assert.Equal(t, int64(10), s.Dropped()+int64(received))
+
+		//Events published after unsubscribe are ignored
+		eng.bus.publish(&OrderCancelEvent{BaseEvent: be(tm, inst), OrdId: "1"})
+		assert.Equal(t, int64(10), s.Dropped()+int64(received))
+		s.Unsubscribe()
+	}
+
+	t.Log("Subscribe: subscribers receive copies of events")
+	{
+		eng := newTestEngineWithBus()
+		var got *NewOrderEvent
+		eng.Subscribe(EventFilter{}, func(e Event) {
+			got = e.(*NewOrderEvent)
+		})
+		ord := newTestOrder(10, OrderBuy, 100, "1")
+		orig := &NewOrderEvent{BaseEvent: be(tm, inst), LinkedOrder: ord}
+		eng.bus.publish(orig)
+		ord.State = FilledOrder
+		eng.bus.close()
+
+		if assert.NotNil(t, got) {
+			assert.False(t, got == orig)
+			assert.Equal(t, NewOrder, got.LinkedOrder.State)
+		}
+	}
+	t.Log("Subscribe: trades, candles and ticks of events are copied")
+	{
+		eng := newTestEngineWithBus()
+		var got []Event
+		eng.Subscribe(EventFilter{}, func(e Event) {
+			got = append(got, e)
+		})
+		ord := newTestOrder(10, OrderBuy, 100, "1")
+		ord.Fills = []OrderExecution{{Time: tm, Price: 10, Qty: 100}}
+		trade := newFlatTrade(inst)
+		trade.FilledOrders[ord.Id] = ord
+		trade.ConfirmedOrders[ord.Id] = ord
+		trade.Returns = []*TradeReturn{{OpenPnL: 1, Time: tm}}
+		raw := newTestQualityTick(tm, 10, 100, 0, 0)
+		candle := &Candle{Candle: &marketdata.Candle{Datetime: tm, Symbol: "Test", Open: 10, High: 11, Low: 9,
+			Close: 10.5}, Ticker: inst}
+
+		eng.bus.publish(&PortfolioNewPositionEvent{BaseEvent: be(tm, inst), Trade: trade})
+		eng.bus.publish(&CandleCloseEvent{BaseEvent: be(tm, inst), Candle: candle})
+		eng.bus.publish(&NewTickEvent{BaseEvent: be(tm, inst), Tick: &Tick{Tick: raw, Ticker: inst}})
+		ord.State = FilledOrder
+		ord.Fills[0].Qty = 50
+		trade.Returns[0].OpenPnL = 5
+		trade.NewOrders["2"] = newTestOrder(10, OrderBuy, 100, "2")
+		candle.Close = 9.5
+		raw.LastPrice = 11
+		eng.bus.close()
+
+		if assert.Len(t, got, 3) {
+			gt := got[0].(*PortfolioNewPositionEvent).Trade
+			assert.Equal(t, NewOrder, gt.FilledOrders["1"].State)
+			assert.Equal(t, int64(100), gt.FilledOrders["1"].Fills[0].Qty)
+			assert.True(t, gt.FilledOrders["1"] == gt.ConfirmedOrders["1"])
+			assert.Equal(t, 1.0, gt.Returns[0].OpenPnL)
+			assert.Len(t, gt.NewOrders, 0)
+			assert.Equal(t, 10.5, got[1].(*CandleCloseEvent).Candle.Close)
+			assert.Equal(t, 10.0, got[2].(*NewTickEvent).Tick.LastPrice)
+		}
+	}
+}
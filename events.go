@@ -2,7 +2,6 @@ package engine
 
 import (
 	"fmt"
-	"strconv"
 	"time"
 )
 
@@ -22,6 +21,10 @@ func (c *BaseEvent) getSymbol() string {
 	return c.Ticker.Symbol
 }
 
+func (c *BaseEvent) getTicker() *Instrument {
+	return c.Ticker
+}
+
 func (c *BaseEvent) getTime() time.Time {
 	return c.Time
 }
@@ -64,25 +67,7 @@ func (e *CandleCloseEvent) setEventTimeFromCandle() {
 	if e.Candle == nil {
 		panic("Can't get Event time from Nil candle")
 	}
-	switch e.TimeFrame {
-	case "D":
-		closeTime := e.Candle.Datetime
-		closeTime = time.Date(closeTime.Year(), closeTime.Month(), closeTime.Day(), 23, 59, 59, 0,
-			e.Candle.Datetime.Location())
-		e.BaseEvent.Time = closeTime
-	case "W":
-		closeTime := e.Candle.Datetime.AddDate(0, 0, 7)
-		closeTime = time.Date(closeTime.Year(), closeTime.Month(), closeTime.Day(), 23, 59, 59, 0,
-			e.Candle.Datetime.Location())
-		e.BaseEvent.Time = closeTime
-	default:
-		minutes, err := strconv.ParseInt(e.TimeFrame, 10, 8)
-		if err != nil {
-			panic("Unknown timeframe: " + e.TimeFrame)
-		}
-		closeTime := e.Candle.Datetime.Add(time.Duration(minutes) * time.Minute)
-		e.BaseEvent.Time = closeTime
-	}
+	e.BaseEvent.Time = e.Candle.closeTime(e.TimeFrame)
 }
 
 func (c *CandleCloseEvent) getName() string {
@@ -214,10 +199,15 @@ func (c *OrderCancelRequestEvent) String() string {
 	return fmt.Sprintf("%v **%v** OrderID: %v", c.getStringTime(), c.getName(), c.OrdId)
 }
 
+//OrderReplaceRequestEvent amends working order. Zero or NaN NewPrice, zero NewQty and empty NewTif or
+//NewDestination mean that field isn't changed. For stop orders NewPrice is trigger price
 type OrderReplaceRequestEvent struct {
 	BaseEvent
-	OrdId    string
-	NewPrice float64
+	OrdId          string
+	NewPrice       float64
+	NewQty         int64
+	NewTif         OrderTIF
+	NewDestination string
 }
 
 func (c *OrderReplaceRequestEvent) getName() string {
@@ -225,7 +215,12 @@ func (c *OrderReplaceRequestEvent) getName() string {
 }
 
 func (c *OrderReplaceRequestEvent) String() string {
-	return fmt.Sprintf("%v **%v** OrderId:%v New Price: %v", c.getStringTime(), c.getName(), c.OrdId, c.NewPrice)
+	return fmt.Sprintf("%v **%v** OrderId:%v New Price: %v New Qty: %v New TIF: %v New Destination: %v",
+		c.getStringTime(), c.getName(), c.OrdId, c.NewPrice, c.NewQty, c.NewTif, c.NewDestination)
+}
+
+func (c *OrderReplaceRequestEvent) amendment() OrderAmendment {
+	return OrderAmendment{Price: c.NewPrice, Qty: c.NewQty, Tif: c.NewTif, Destination: c.NewDestination}
 }
 
 type OrderReplaceRejectEvent struct {
@@ -242,10 +237,14 @@ func (c *OrderReplaceRejectEvent) String() string {
 	return fmt.Sprintf("%v **%v** OrderId: %v Reason: %v", c.getStringTime(), c.getName(), c.OrdId, c.Reason)
 }
 
+//OrderReplacedEvent confirms amendment. Unchanged fields are empty as in OrderReplaceRequestEvent
 type OrderReplacedEvent struct {
 	BaseEvent
-	OrdId    string
-	NewPrice float64
+	OrdId          string
+	NewPrice       float64
+	NewQty         int64
+	NewTif         OrderTIF
+	NewDestination string
 }
 
 func (c *OrderReplacedEvent) getName() string {
@@ -253,7 +252,12 @@ func (c *OrderReplacedEvent) getName() string {
 }
 
 func (c *OrderReplacedEvent) String() string {
-	return fmt.Sprintf("%v **%v** OrderId: %v New Price: %v", c.getStringTime(), c.getName(), c.OrdId, c.NewPrice)
+	return fmt.Sprintf("%v **%v** OrderId: %v New Price: %v New Qty: %v New TIF: %v New Destination: %v",
+		c.getStringTime(), c.getName(), c.OrdId, c.NewPrice, c.NewQty, c.NewTif, c.NewDestination)
+}
+
+func (c *OrderReplacedEvent) amendment() OrderAmendment {
+	return OrderAmendment{Price: c.NewPrice, Qty: c.NewQty, Tif: c.NewTif, Destination: c.NewDestination}
 }
 
 type OrderRejectedEvent struct {
@@ -263,7 +267,7 @@ type OrderRejectedEvent struct {
 }
 
 func (c *OrderRejectedEvent) getName() string {
-	return fmt.Sprintf("OrderRejectedEvent: %v Reason: %v", c.OrdId, c.Reason)
+	return "OrderRejectedEvent"
 }
 
 func (c *OrderRejectedEvent) String() string {
@@ -276,7 +280,7 @@ type StrategyRequestNotDeliveredEvent struct {
 }
 
 func (c *StrategyRequestNotDeliveredEvent) getName() string {
-	return fmt.Sprintf("StrategyRequestNotDeliveredEvent: Reason: %+v", c.Request)
+	return "StrategyRequestNotDeliveredEvent"
 }
 
 func (c *StrategyRequestNotDeliveredEvent) String() string {
@@ -285,12 +289,118 @@ func (c *StrategyRequestNotDeliveredEvent) String() string {
 
 type TimerTickEvent struct {
 	BaseEvent
+	ScheduleId string
 }
 
 func (c *TimerTickEvent) getName() string {
 	return "TimerTickEvent"
 }
 
+func (c *TimerTickEvent) String() string {
+	return fmt.Sprintf("%v **%v** Schedule: %v", c.getStringTime(), c.getName(), c.ScheduleId)
+}
+
+type AuctionType string
+
+const (
+	OpeningAuction AuctionType = "Opening"
+	ClosingAuction AuctionType = "Closing"
+)
+
+//AuctionImbalanceEvent is imbalance message of exchange before opening or closing auction. Paired qty is
+//qty that can be matched at reference price, imbalance qty is unmatched qty of imbalance side. Near price
+//is indicative clearing price with auction orders, far price is clearing price with auction orders only
+type AuctionImbalanceEvent struct {
+	BaseEvent
+	Auction        AuctionType
+	PairedQty      int64
+	ImbalanceQty   int64
+	ImbalanceSide  OrderSide
+	ReferencePrice float64
+	NearPrice      float64
+	FarPrice       float64
+}
+
+func (c *AuctionImbalanceEvent) getName() string {
+	return "AuctionImbalanceEvent"
+}
+
+func (c *AuctionImbalanceEvent) String() string {
+	return fmt.Sprintf("%v **%v** %v %v Paired: %v Imbalance: %v %v Ref: %v Near: %v Far: %v",
+		c.getStringTime(), c.getName(), c.Ticker.Symbol, c.Auction, c.PairedQty, c.ImbalanceQty,
+		c.ImbalanceSide, c.ReferencePrice, c.NearPrice, c.FarPrice)
+}
+
+//TradingHaltEvent is sent when trading of instrument is halted. Orders are queued until resume
+type TradingHaltEvent struct {
+	BaseEvent
+	Reason string
+}
+
+func (c *TradingHaltEvent) getName() string {
+	return "TradingHaltEvent"
+}
+
+func (c *TradingHaltEvent) String() string {
+	return fmt.Sprintf("%v **%v** %v Reason: %v", c.getStringTime(), c.getName(), c.Ticker.Symbol, c.Reason)
+}
+
+//TradingResumeEvent is sent when halted instrument is resumed. The first trade after resume is reopening cross
+type TradingResumeEvent struct {
+	BaseEvent
+}
+
+func (c *TradingResumeEvent) getName() string {
+	return "TradingResumeEvent"
+}
+
+func (c *TradingResumeEvent) String() string {
+	return fmt.Sprintf("%v **%v** %v", c.getStringTime(), c.getName(), c.Ticker.Symbol)
+}
+
+type CorporateActionType string
+
+const (
+	SplitAction    CorporateActionType = "Split"
+	DividendAction CorporateActionType = "Dividend"
+)
+
+//CorporateActionEvent is split or cash dividend of instrument on ex-date. Ratio is number of new shares for one
+//old share of split. Amount is cash dividend per share
+type CorporateActionEvent struct {
+	BaseEvent
+	Action CorporateActionType
+	Ratio  float64
+	Amount float64
+}
+
+func (c *CorporateActionEvent) getName() string {
+	return "CorporateActionEvent"
+}
+
+func (c *CorporateActionEvent) String() string {
+	return fmt.Sprintf("%v **%v** %v %v Ratio: %v Amount: %v", c.getStringTime(), c.getName(), c.Ticker.Symbol,
+		c.Action, c.Ratio, c.Amount)
+}
+
+//BorrowFeeEvent is fee of borrowed shares of short position held overnight. Fee is charged for Qty at Price of
+//previous close and decreases closed PnL of trade
+type BorrowFeeEvent struct {
+	BaseEvent
+	Qty   int64
+	Price float64
+	Fee   float64
+}
+
+func (c *BorrowFeeEvent) getName() string {
+	return "BorrowFeeEvent"
+}
+
+func (c *BorrowFeeEvent) String() string {
+	return fmt.Sprintf("%v **%v** %v Qty: %v Price: %v Fee: %v", c.getStringTime(), c.getName(), c.Ticker.Symbol,
+		c.Qty, c.Price, c.Fee)
+}
+
 type EndOfDataEvent struct {
 	BaseEvent
 }
@@ -306,7 +416,7 @@ func (c *EndOfDataEvent) String() string {
 
 type PortfolioNewPositionEvent struct {
 	BaseEvent
-	trade *Trade
+	Trade *Trade
 }
 
 func (c *PortfolioNewPositionEvent) getName() string {
@@ -314,12 +424,12 @@ func (c *PortfolioNewPositionEvent) getName() string {
 }
 
 func (c *PortfolioNewPositionEvent) String() string {
-	return fmt.Sprintf("%v **%v** Trade: %+v", c.getStringTime(), c.getName(), c.trade.Id)
+	return fmt.Sprintf("%v **%v** Trade: %+v", c.getStringTime(), c.getName(), c.Trade.Id)
 }
 
 type StrategyFinishedEvent struct {
 	BaseEvent
-	strategy string
+	Strategy string
 }
 
 func (c *StrategyFinishedEvent) getName() string {
@@ -327,5 +437,5 @@ func (c *StrategyFinishedEvent) getName() string {
 }
 
 func (c *StrategyFinishedEvent) String() string {
-	return fmt.Sprintf("%v **%v** Strategy: %+v", c.getStringTime(), c.getName(), c.strategy)
+	return fmt.Sprintf("%v **%v** Strategy: %+v", c.getStringTime(), c.getName(), c.Strategy)
 }
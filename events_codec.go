@@ -0,0 +1,1415 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+//EventsSchemaVersion is version of serialized events schema. It's written in every serialized event.
+//Codecs refuse to decode events with greater version
+const EventsSchemaVersion = 9
+
+//eventsRegistry maps event name (getName) to constructor of empty event
+var eventsRegistry = map[string]func() event{
+	"CandleOpenEvent":                  func() event { return &CandleOpenEvent{} },
+	"CandleCloseEvent":                 func() event { return &CandleCloseEvent{} },
+	"CandlesHistoryEvent":              func() event { return &CandlesHistoryEvent{} },
+	"NewTickEvent":                     func() event { return &NewTickEvent{} },
+	"TickHistoryEvent":                 func() event { return &TickHistoryEvent{} },
+	"NewOrderEvent":                    func() event { return &NewOrderEvent{} },
+	"OrderConfirmationEvent":           func() event { return &OrderConfirmationEvent{} },
+	"OrderFillEvent":                   func() event { return &OrderFillEvent{} },
+	"OrderCancelEvent":                 func() event { return &OrderCancelEvent{} },
+	"OrderCancelRejectEvent":           func() event { return &OrderCancelRejectEvent{} },
+	"OrderCancelRequestEvent":          func() event { return &OrderCancelRequestEvent{} },
+	"OrderReplaceRequestEvent":         func() event { return &OrderReplaceRequestEvent{} },
+	"OrderReplaceRejectEvent":          func() event { return &OrderReplaceRejectEvent{} },
+	"OrderReplacedEvent":               func() event { return &OrderReplacedEvent{} },
+	"OrderRejectedEvent":               func() event { return &OrderRejectedEvent{} },
+	"StrategyRequestNotDeliveredEvent": func() event { return &StrategyRequestNotDeliveredEvent{} },
+	"TimerTickEvent":                   func() event { return &TimerTickEvent{} },
+	"AuctionImbalanceEvent":            func() event { return &AuctionImbalanceEvent{} },
+	"TradingHaltEvent":                 func() event { return &TradingHaltEvent{} },
+	"TradingResumeEvent":               func() event { return &TradingResumeEvent{} },
+	"BorrowFeeEvent":                   func() event { return &BorrowFeeEvent{} },
+	"CorporateActionEvent":             func() event { return &CorporateActionEvent{} },
+	"EndOfDataEvent":                   func() event { return &EndOfDataEvent{} },
+	"PortfolioNewPositionEvent":        func() event { return &PortfolioNewPositionEvent{} },
+	"StrategyFinishedEvent":            func() event { return &StrategyFinishedEvent{} },
+}
+
+//IEventCodec converts events to bytes and back
+type IEventCodec interface {
+	Encode(e event) ([]byte, error)
+	Decode(data []byte) (event, error)
+}
+
+//instrumentsResolver makes decoded events to point on the same Instrument objects. Engine compares
+//instruments by pointers, so events decoded for running strategies should use strategies instruments
+type instrumentsResolver struct {
+	instruments map[string]*Instrument
+	mut         *sync.Mutex
+}
+
+func newInstrumentsResolver() instrumentsResolver {
+	return instrumentsResolver{instruments: make(map[string]*Instrument), mut: &sync.Mutex{}}
+}
+
+//SetInstruments registers instruments that should be used in decoded events instead of new copies
+func (r *instrumentsResolver) SetInstruments(instruments []*Instrument) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, i := range instruments {
+		r.instruments[i.Symbol] = i
+	}
+}
+
+func (r *instrumentsResolver) resolve(w *wireInstrument) *Instrument {
+	if w == nil {
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if i, ok := r.instruments[w.Symbol]; ok && w.Symbol != "" {
+		return i
+	}
+	i := w.toInstrument()
+	if w.Symbol != "" {
+		r.instruments[w.Symbol] = i
+	}
+	return i
+}
+
+//JSONEventCodec serializes events to JSON objects with "v" (schema version) and "type" (event name) fields
+type JSONEventCodec struct {
+	instrumentsResolver
+}
+
+func NewJSONEventCodec() *JSONEventCodec {
+	return &JSONEventCodec{newInstrumentsResolver()}
+}
+
+func (c *JSONEventCodec) Encode(e event) ([]byte, error) {
+	w, err := eventToWire(e)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(w)
+}
+
+func (c *JSONEventCodec) Decode(data []byte) (event, error) {
+	w := wireEvent{}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, err
+	}
+	return eventFromWire(&w, &c.instrumentsResolver)
+}
+
+//BinaryEventCodec serializes events to compact binary format. Numbers are written as varints, prices as
+//IEEE 754 bits, absent optional fields are marked in presence bitmask
+type BinaryEventCodec struct {
+	instrumentsResolver
+}
+
+func NewBinaryEventCodec() *BinaryEventCodec {
+	return &BinaryEventCodec{newInstrumentsResolver()}
+}
+
+func (c *BinaryEventCodec) Encode(e event) ([]byte, error) {
+	w, err := eventToWire(e)
+	if err != nil {
+		return nil, err
+	}
+	bw := binaryWriter{}
+	bw.event(w)
+	return bw.buf.Bytes(), nil
+}
+
+func (c *BinaryEventCodec) Decode(data []byte) (event, error) {
+	br := binaryReader{r: bytes.NewReader(data)}
+	w := br.event()
+	if br.err != nil {
+		return nil, errors.Wrap(br.err, "Can't decode binary event")
+	}
+	return eventFromWire(w, &c.instrumentsResolver)
+}
+
+//WriteEvents writes events with given codec. Every event is prefixed with its length, so any codec can be used
+//for journal files
+func WriteEvents(w io.Writer, codec IEventCodec, events []event) error {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, e := range events {
+		data, err := codec.Encode(e)
+		if err != nil {
+			return err
+		}
+		n := binary.PutUvarint(lenBuf, uint64(len(data)))
+		if _, err := w.Write(lenBuf[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//ReadEvents reads all events written by WriteEvents with the same codec
+func ReadEvents(r io.Reader, codec IEventCodec) ([]event, error) {
+	br := bufio.NewReader(r)
+	var events []event
+	for {
+		l, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		data := make([]byte, l)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return events, err
+		}
+		e, err := codec.Decode(data)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+}
+
+//******* WIRE STRUCTS ********************************************************
+
+//wireFloat is float64 that keeps NaN and Inf in JSON as strings
+type wireFloat float64
+
+func (f wireFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return json.Marshal(v)
+}
+
+func (f *wireFloat) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `"NaN"`:
+		*f = wireFloat(math.NaN())
+		return nil
+	case `"+Inf"`:
+		*f = wireFloat(math.Inf(1))
+		return nil
+	case `"-Inf"`:
+		*f = wireFloat(math.Inf(-1))
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = wireFloat(v)
+	return nil
+}
+
+//wireInstrument keeps exchange sessions without trading calendar. Calendar is taken from registered instruments
+type wireInstrument struct {
+	Symbol          string    `json:"symbol"`
+	MinTick         wireFloat `json:"minTick"`
+	LotSize         int64     `json:"lotSize"`
+	Exchange        string    `json:"exchange"`
+	MarketOpenTime  TimeOfDay `json:"marketOpen"`
+	MarketCloseTime TimeOfDay `json:"marketClose"`
+	PreMarketOpen   TimeOfDay `json:"preMarketOpen"`
+	AfterHoursClose TimeOfDay `json:"afterHoursClose"`
+}
+
+func newWireInstrument(i *Instrument) *wireInstrument {
+	if i == nil {
+		return nil
+	}
+	return &wireInstrument{
+		Symbol:          i.Symbol,
+		MinTick:         wireFloat(i.MinTick),
+		LotSize:         i.LotSize,
+		Exchange:        i.Exchange.Name,
+		MarketOpenTime:  i.Exchange.MarketOpenTime,
+		MarketCloseTime: i.Exchange.MarketCloseTime,
+		PreMarketOpen:   i.Exchange.PreMarketOpenTime,
+		AfterHoursClose: i.Exchange.AfterHoursCloseTime,
+	}
+}
+
+func (w *wireInstrument) toInstrument() *Instrument {
+	return &Instrument{
+		Symbol:  w.Symbol,
+		MinTick: float64(w.MinTick),
+		LotSize: w.LotSize,
+		Exchange: Exchange{
+			Name:                w.Exchange,
+			MarketOpenTime:      w.MarketOpenTime,
+			MarketCloseTime:     w.MarketCloseTime,
+			PreMarketOpenTime:   w.PreMarketOpen,
+			AfterHoursCloseTime: w.AfterHoursClose,
+		},
+	}
+}
+
+type wireTick struct {
+	Datetime  time.Time `json:"time"`
+	Symbol    string    `json:"symbol"`
+	LastPrice wireFloat `json:"last"`
+	LastSize  int64     `json:"lastSize"`
+	LastExch  string    `json:"lastExch,omitempty"`
+	BidPrice  wireFloat `json:"bid"`
+	BidSize   int64     `json:"bidSize"`
+	BidExch   string    `json:"bidExch,omitempty"`
+	AskPrice  wireFloat `json:"ask"`
+	AskSize   int64     `json:"askSize"`
+	AskExch   string    `json:"askExch,omitempty"`
+	CondQuote string    `json:"condQuote,omitempty"`
+	Cond1     string    `json:"cond1,omitempty"`
+	Cond2     string    `json:"cond2,omitempty"`
+	Cond3     string    `json:"cond3,omitempty"`
+	Cond4     string    `json:"cond4,omitempty"`
+	IsOpening bool      `json:"isOpening,omitempty"`
+	IsClosing bool      `json:"isClosing,omitempty"`
+}
+
+type wireCandle struct {
+	Datetime     time.Time `json:"time"`
+	Symbol       string    `json:"symbol"`
+	Open         wireFloat `json:"open"`
+	High         wireFloat `json:"high"`
+	Low          wireFloat `json:"low"`
+	Close        wireFloat `json:"close"`
+	AdjClose     wireFloat `json:"adjClose"`
+	Volume       int64     `json:"volume"`
+	OpenInterest int64     `json:"openInterest"`
+	TimeFrame    string    `json:"timeFrame,omitempty"`
+	CloseTime    time.Time `json:"closeTime,omitempty"`
+}
+
+type wireOrder struct {
+	Side        OrderSide  `json:"side"`
+	Qty         int64      `json:"qty"`
+	ExecQty     int64      `json:"execQty"`
+	State       OrderState `json:"state"`
+	Price       wireFloat  `json:"price"`
+	ExecPrice   wireFloat  `json:"execPrice"`
+	Type        OrderType  `json:"type"`
+	Tif         OrderTIF   `json:"tif"`
+	Destination string     `json:"destination"`
+	Id          string     `json:"id"`
+	Mark1       string     `json:"mark1,omitempty"`
+	Mark2       string     `json:"mark2,omitempty"`
+	Time        time.Time  `json:"time"`
+	ExpireTime  time.Time  `json:"expireTime,omitempty"`
+
+	SentTime      time.Time         `json:"sentTime,omitempty"`
+	ConfirmedTime time.Time         `json:"confirmedTime,omitempty"`
+	CanceledTime  time.Time         `json:"canceledTime,omitempty"`
+	RejectedTime  time.Time         `json:"rejectedTime,omitempty"`
+	Fills         []wireExecution   `json:"fills,omitempty"`
+	Replaces      []wireReplacement `json:"replaces,omitempty"`
+}
+
+type wireExecution struct {
+	Time  time.Time `json:"time"`
+	Price wireFloat `json:"price"`
+	Qty   int64     `json:"qty"`
+}
+
+type wireReplacement struct {
+	Time           time.Time `json:"time"`
+	OldPrice       wireFloat `json:"oldPrice"`
+	NewPrice       wireFloat `json:"newPrice"`
+	OldQty         int64     `json:"oldQty"`
+	NewQty         int64     `json:"newQty"`
+	OldTif         OrderTIF  `json:"oldTif"`
+	NewTif         OrderTIF  `json:"newTif"`
+	OldDestination string    `json:"oldDestination"`
+	NewDestination string    `json:"newDestination"`
+}
+
+type wireImbalance struct {
+	Auction        AuctionType `json:"auction"`
+	PairedQty      int64       `json:"pairedQty"`
+	ImbalanceQty   int64       `json:"imbalanceQty"`
+	ImbalanceSide  OrderSide   `json:"imbalanceSide"`
+	ReferencePrice wireFloat   `json:"referencePrice"`
+	NearPrice      wireFloat   `json:"nearPrice"`
+	FarPrice       wireFloat   `json:"farPrice"`
+}
+
+type wireCorporateAction struct {
+	Action CorporateActionType `json:"action"`
+	Ratio  wireFloat           `json:"ratio"`
+	Amount wireFloat           `json:"amount"`
+}
+
+//wireTrade keeps position values of trade. Orders maps are not serialized
+type wireTrade struct {
+	Id          string    `json:"id"`
+	Type        TradeType `json:"type"`
+	Qty         int64     `json:"qty"`
+	FirstPrice  wireFloat `json:"firstPrice"`
+	OpenPrice   wireFloat `json:"openPrice"`
+	OpenValue   wireFloat `json:"openValue"`
+	MarketValue wireFloat `json:"marketValue"`
+	OpenTime    time.Time `json:"openTime"`
+	CloseTime   time.Time `json:"closeTime"`
+	ClosedPnL   wireFloat `json:"closedPnL"`
+	OpenPnL     wireFloat `json:"openPnL"`
+	BorrowFees  wireFloat `json:"borrowFees,omitempty"`
+}
+
+type wireEvent struct {
+	Version     int                  `json:"v"`
+	Name        string               `json:"type"`
+	Time        time.Time            `json:"time"`
+	Ticker      *wireInstrument      `json:"ticker,omitempty"`
+	OrdId       string               `json:"ordId,omitempty"`
+	Price       wireFloat            `json:"price,omitempty"`
+	Qty         int64                `json:"qty,omitempty"`
+	Reason      string               `json:"reason,omitempty"`
+	TimeFrame   string               `json:"timeFrame,omitempty"`
+	CandleTime  time.Time            `json:"candleTime,omitempty"`
+	Strategy    string               `json:"strategy,omitempty"`
+	ScheduleId  string               `json:"scheduleId,omitempty"`
+	Tif         OrderTIF             `json:"tif,omitempty"`
+	Destination string               `json:"destination,omitempty"`
+	Fee         wireFloat            `json:"fee,omitempty"`
+	Tick        *wireTick            `json:"tick,omitempty"`
+	Candle      *wireCandle          `json:"candle,omitempty"`
+	Ticks       []*wireTick          `json:"ticks,omitempty"`
+	Candles     []*wireCandle        `json:"candles,omitempty"`
+	Order       *wireOrder           `json:"order,omitempty"`
+	Trade       *wireTrade           `json:"trade,omitempty"`
+	Request     *wireEvent           `json:"request,omitempty"`
+	Imbalance   *wireImbalance       `json:"imbalance,omitempty"`
+	Corporate   *wireCorporateAction `json:"corporateAction,omitempty"`
+}
+
+func tickToWire(t *Tick) *wireTick {
+	if t == nil || t.Tick == nil {
+		return nil
+	}
+	return &wireTick{
+		Datetime:  t.Datetime,
+		Symbol:    t.Symbol,
+		LastPrice: wireFloat(t.LastPrice),
+		LastSize:  t.LastSize,
+		LastExch:  t.LastExch,
+		BidPrice:  wireFloat(t.BidPrice),
+		BidSize:   t.BidSize,
+		BidExch:   t.BidExch,
+		AskPrice:  wireFloat(t.AskPrice),
+		AskSize:   t.AskSize,
+		AskExch:   t.AskExch,
+		CondQuote: t.CondQuote,
+		Cond1:     t.Cond1,
+		Cond2:     t.Cond2,
+		Cond3:     t.Cond3,
+		Cond4:     t.Cond4,
+		IsOpening: t.IsOpening,
+		IsClosing: t.IsClosing,
+	}
+}
+
+func candleToWire(c *Candle) *wireCandle {
+	if c == nil || c.Candle == nil {
+		return nil
+	}
+	return &wireCandle{
+		Datetime:     c.Datetime,
+		Symbol:       c.Symbol,
+		Open:         wireFloat(c.Open),
+		High:         wireFloat(c.High),
+		Low:          wireFloat(c.Low),
+		Close:        wireFloat(c.Close),
+		AdjClose:     wireFloat(c.AdjClose),
+		Volume:       c.Volume,
+		OpenInterest: c.OpenInterest,
+		TimeFrame:    c.TimeFrame,
+		CloseTime:    c.CloseTime,
+	}
+}
+
+func orderToWire(o *Order) *wireOrder {
+	if o == nil {
+		return nil
+	}
+	w := wireOrder{
+		Side:          o.Side,
+		Qty:           o.Qty,
+		ExecQty:       o.ExecQty,
+		State:         o.State,
+		Price:         wireFloat(o.Price),
+		ExecPrice:     wireFloat(o.ExecPrice),
+		Type:          o.Type,
+		Tif:           o.Tif,
+		Destination:   o.Destination,
+		Id:            o.Id,
+		Mark1:         o.Mark1,
+		Mark2:         o.Mark2,
+		Time:          o.Time,
+		ExpireTime:    o.ExpireTime,
+		SentTime:      o.SentTime,
+		ConfirmedTime: o.ConfirmedTime,
+		CanceledTime:  o.CanceledTime,
+		RejectedTime:  o.RejectedTime,
+	}
+	for _, f := range o.Fills {
+		w.Fills = append(w.Fills, wireExecution{Time: f.Time, Price: wireFloat(f.Price), Qty: f.Qty})
+	}
+	for _, r := range o.Replaces {
+		w.Replaces = append(w.Replaces, wireReplacement{
+			Time:           r.Time,
+			OldPrice:       wireFloat(r.OldPrice),
+			NewPrice:       wireFloat(r.NewPrice),
+			OldQty:         r.OldQty,
+			NewQty:         r.NewQty,
+			OldTif:         r.OldTif,
+			NewTif:         r.NewTif,
+			OldDestination: r.OldDestination,
+			NewDestination: r.NewDestination,
+		})
+	}
+	return &w
+}
+
+func orderFromWire(o *wireOrder, ticker *Instrument) *Order {
+	if o == nil {
+		return nil
+	}
+	order := Order{
+		Side:          o.Side,
+		Qty:           o.Qty,
+		ExecQty:       o.ExecQty,
+		Ticker:        ticker,
+		State:         o.State,
+		Price:         float64(o.Price),
+		ExecPrice:     float64(o.ExecPrice),
+		Type:          o.Type,
+		Tif:           o.Tif,
+		Destination:   o.Destination,
+		Id:            o.Id,
+		Mark1:         o.Mark1,
+		Mark2:         o.Mark2,
+		Time:          o.Time,
+		ExpireTime:    o.ExpireTime,
+		SentTime:      o.SentTime,
+		ConfirmedTime: o.ConfirmedTime,
+		CanceledTime:  o.CanceledTime,
+		RejectedTime:  o.RejectedTime,
+	}
+	for _, f := range o.Fills {
+		order.Fills = append(order.Fills, OrderExecution{Time: f.Time, Price: float64(f.Price), Qty: f.Qty})
+	}
+	for _, r := range o.Replaces {
+		order.Replaces = append(order.Replaces, OrderReplacement{
+			Time:           r.Time,
+			OldPrice:       float64(r.OldPrice),
+			NewPrice:       float64(r.NewPrice),
+			OldQty:         r.OldQty,
+			NewQty:         r.NewQty,
+			OldTif:         r.OldTif,
+			NewTif:         r.NewTif,
+			OldDestination: r.OldDestination,
+			NewDestination: r.NewDestination,
+		})
+	}
+	return &order
+}
+
+func tradeToWire(t *Trade) *wireTrade {
+	if t == nil {
+		return nil
+	}
+	return &wireTrade{
+		Id:          t.Id,
+		Type:        t.Type,
+		Qty:         t.Qty,
+		FirstPrice:  wireFloat(t.FirstPrice),
+		OpenPrice:   wireFloat(t.OpenPrice),
+		OpenValue:   wireFloat(t.OpenValue),
+		MarketValue: wireFloat(t.MarketValue),
+		OpenTime:    t.OpenTime,
+		CloseTime:   t.CloseTime,
+		ClosedPnL:   wireFloat(t.ClosedPnL),
+		OpenPnL:     wireFloat(t.OpenPnL),
+		BorrowFees:  wireFloat(t.BorrowFees),
+	}
+}
+
+func eventToWire(e event) (*wireEvent, error) {
+	if e == nil {
+		return nil, errors.New("Can't serialize nil event")
+	}
+	if _, ok := eventsRegistry[e.getName()]; !ok {
+		return nil, errors.New("Can't serialize event. Unknown event type: " + e.getName())
+	}
+
+	w := wireEvent{Version: EventsSchemaVersion, Name: e.getName(), Time: e.getTime()}
+
+	switch i := e.(type) {
+	case *CandleOpenEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.CandleTime = i.CandleTime
+		w.Price = wireFloat(i.Price)
+		w.TimeFrame = i.TimeFrame
+	case *CandleCloseEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Candle = candleToWire(i.Candle)
+		w.TimeFrame = i.TimeFrame
+	case *CandlesHistoryEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		for _, c := range i.Candles {
+			w.Candles = append(w.Candles, candleToWire(c))
+		}
+	case *NewTickEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Tick = tickToWire(i.Tick)
+	case *TickHistoryEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		for _, t := range i.Ticks {
+			w.Ticks = append(w.Ticks, tickToWire(t))
+		}
+	case *NewOrderEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Order = orderToWire(i.LinkedOrder)
+	case *OrderConfirmationEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+	case *OrderFillEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+		w.Price = wireFloat(i.Price)
+		w.Qty = i.Qty
+	case *OrderCancelEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+	case *OrderCancelRejectEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+		w.Reason = i.Reason
+	case *OrderCancelRequestEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+	case *OrderReplaceRequestEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+		w.Price = wireFloat(i.NewPrice)
+		w.Qty = i.NewQty
+		w.Tif = i.NewTif
+		w.Destination = i.NewDestination
+	case *OrderReplaceRejectEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+		w.Reason = i.Reason
+	case *OrderReplacedEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+		w.Price = wireFloat(i.NewPrice)
+		w.Qty = i.NewQty
+		w.Tif = i.NewTif
+		w.Destination = i.NewDestination
+	case *OrderRejectedEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.OrdId = i.OrdId
+		w.Reason = i.Reason
+	case *StrategyRequestNotDeliveredEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		if i.Request != nil {
+			r, err := eventToWire(i.Request)
+			if err != nil {
+				return nil, err
+			}
+			w.Request = r
+		}
+	case *TimerTickEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.ScheduleId = i.ScheduleId
+	case *AuctionImbalanceEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Imbalance = &wireImbalance{
+			Auction:        i.Auction,
+			PairedQty:      i.PairedQty,
+			ImbalanceQty:   i.ImbalanceQty,
+			ImbalanceSide:  i.ImbalanceSide,
+			ReferencePrice: wireFloat(i.ReferencePrice),
+			NearPrice:      wireFloat(i.NearPrice),
+			FarPrice:       wireFloat(i.FarPrice),
+		}
+	case *TradingHaltEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Reason = i.Reason
+	case *CorporateActionEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Corporate = &wireCorporateAction{
+			Action: i.Action,
+			Ratio:  wireFloat(i.Ratio),
+			Amount: wireFloat(i.Amount),
+		}
+	case *TradingResumeEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+	case *BorrowFeeEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Qty = i.Qty
+		w.Price = wireFloat(i.Price)
+		w.Fee = wireFloat(i.Fee)
+	case *EndOfDataEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+	case *PortfolioNewPositionEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Trade = tradeToWire(i.Trade)
+	case *StrategyFinishedEvent:
+		w.Ticker = newWireInstrument(i.Ticker)
+		w.Strategy = i.Strategy
+	default:
+		return nil, errors.New("Can't serialize event. Unknown event type: " + e.getName())
+	}
+
+	return &w, nil
+}
+
+func eventFromWire(w *wireEvent, r *instrumentsResolver) (event, error) {
+	if w.Version > EventsSchemaVersion || w.Version <= 0 {
+		return nil, fmt.Errorf("Can't decode event. Unsupported schema version: %v", w.Version)
+	}
+	constructor, ok := eventsRegistry[w.Name]
+	if !ok {
+		return nil, errors.New("Can't decode event. Unknown event type: " + w.Name)
+	}
+
+	e := constructor()
+	ticker := r.resolve(w.Ticker)
+	base := be(w.Time, ticker)
+
+	tickFromWire := func(t *wireTick) *Tick {
+		if t == nil {
+			return nil
+		}
+		raw := marketdataTickFromWire(t)
+		return &Tick{Tick: raw, Ticker: ticker}
+	}
+	candleFromWire := func(c *wireCandle) *Candle {
+		if c == nil {
+			return nil
+		}
+		raw := marketdataCandleFromWire(c)
+		return &Candle{Candle: raw, Ticker: ticker, TimeFrame: c.TimeFrame, CloseTime: c.CloseTime}
+	}
+
+	switch i := e.(type) {
+	case *CandleOpenEvent:
+		i.BaseEvent = base
+		i.CandleTime = w.CandleTime
+		i.Price = float64(w.Price)
+		i.TimeFrame = w.TimeFrame
+	case *CandleCloseEvent:
+		i.BaseEvent = base
+		i.Candle = candleFromWire(w.Candle)
+		i.TimeFrame = w.TimeFrame
+	case *CandlesHistoryEvent:
+		i.BaseEvent = base
+		for _, c := range w.Candles {
+			i.Candles = append(i.Candles, candleFromWire(c))
+		}
+	case *NewTickEvent:
+		i.BaseEvent = base
+		i.Tick = tickFromWire(w.Tick)
+	case *TickHistoryEvent:
+		i.BaseEvent = base
+		for _, t := range w.Ticks {
+			i.Ticks = append(i.Ticks, tickFromWire(t))
+		}
+	case *NewOrderEvent:
+		i.BaseEvent = base
+		i.LinkedOrder = orderFromWire(w.Order, ticker)
+	case *OrderConfirmationEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+	case *OrderFillEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+		i.Price = float64(w.Price)
+		i.Qty = w.Qty
+	case *OrderCancelEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+	case *OrderCancelRejectEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+		i.Reason = w.Reason
+	case *OrderCancelRequestEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+	case *OrderReplaceRequestEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+		i.NewPrice = float64(w.Price)
+		i.NewQty = w.Qty
+		i.NewTif = w.Tif
+		i.NewDestination = w.Destination
+	case *OrderReplaceRejectEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+		i.Reason = w.Reason
+	case *OrderReplacedEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+		i.NewPrice = float64(w.Price)
+		i.NewQty = w.Qty
+		i.NewTif = w.Tif
+		i.NewDestination = w.Destination
+	case *OrderRejectedEvent:
+		i.BaseEvent = base
+		i.OrdId = w.OrdId
+		i.Reason = w.Reason
+	case *StrategyRequestNotDeliveredEvent:
+		i.BaseEvent = base
+		if w.Request != nil {
+			req, err := eventFromWire(w.Request, r)
+			if err != nil {
+				return nil, err
+			}
+			i.Request = req
+		}
+	case *TimerTickEvent:
+		i.BaseEvent = base
+		i.ScheduleId = w.ScheduleId
+	case *AuctionImbalanceEvent:
+		i.BaseEvent = base
+		if m := w.Imbalance; m != nil {
+			i.Auction = m.Auction
+			i.PairedQty = m.PairedQty
+			i.ImbalanceQty = m.ImbalanceQty
+			i.ImbalanceSide = m.ImbalanceSide
+			i.ReferencePrice = float64(m.ReferencePrice)
+			i.NearPrice = float64(m.NearPrice)
+			i.FarPrice = float64(m.FarPrice)
+		}
+	case *CorporateActionEvent:
+		i.BaseEvent = base
+		if c := w.Corporate; c != nil {
+			i.Action = c.Action
+			i.Ratio = float64(c.Ratio)
+			i.Amount = float64(c.Amount)
+		}
+	case *TradingHaltEvent:
+		i.BaseEvent = base
+		i.Reason = w.Reason
+	case *TradingResumeEvent:
+		i.BaseEvent = base
+	case *BorrowFeeEvent:
+		i.BaseEvent = base
+		i.Qty = w.Qty
+		i.Price = float64(w.Price)
+		i.Fee = float64(w.Fee)
+	case *EndOfDataEvent:
+		i.BaseEvent = base
+	case *PortfolioNewPositionEvent:
+		i.BaseEvent = base
+		if w.Trade != nil {
+			t := newFlatTrade(ticker)
+			t.Id = w.Trade.Id
+			t.Type = w.Trade.Type
+			t.Qty = w.Trade.Qty
+			t.FirstPrice = float64(w.Trade.FirstPrice)
+			t.OpenPrice = float64(w.Trade.OpenPrice)
+			t.OpenValue = float64(w.Trade.OpenValue)
+			t.MarketValue = float64(w.Trade.MarketValue)
+			t.OpenTime = w.Trade.OpenTime
+			t.CloseTime = w.Trade.CloseTime
+			t.ClosedPnL = float64(w.Trade.ClosedPnL)
+			t.OpenPnL = float64(w.Trade.OpenPnL)
+			t.BorrowFees = float64(w.Trade.BorrowFees)
+			i.Trade = t
+		}
+	case *StrategyFinishedEvent:
+		i.BaseEvent = base
+		i.Strategy = w.Strategy
+	}
+
+	return e, nil
+}
+
+func marketdataTickFromWire(t *wireTick) *marketdata.Tick {
+	return &marketdata.Tick{
+		Datetime:  t.Datetime,
+		Symbol:    t.Symbol,
+		LastPrice: float64(t.LastPrice),
+		LastSize:  t.LastSize,
+		LastExch:  t.LastExch,
+		BidPrice:  float64(t.BidPrice),
+		BidSize:   t.BidSize,
+		BidExch:   t.BidExch,
+		AskPrice:  float64(t.AskPrice),
+		AskSize:   t.AskSize,
+		AskExch:   t.AskExch,
+		CondQuote: t.CondQuote,
+		Cond1:     t.Cond1,
+		Cond2:     t.Cond2,
+		Cond3:     t.Cond3,
+		Cond4:     t.Cond4,
+		IsOpening: t.IsOpening,
+		IsClosing: t.IsClosing,
+	}
+}
+
+func marketdataCandleFromWire(c *wireCandle) *marketdata.Candle {
+	return &marketdata.Candle{
+		Datetime:     c.Datetime,
+		Symbol:       c.Symbol,
+		Open:         float64(c.Open),
+		High:         float64(c.High),
+		Low:          float64(c.Low),
+		Close:        float64(c.Close),
+		AdjClose:     float64(c.AdjClose),
+		Volume:       c.Volume,
+		OpenInterest: c.OpenInterest,
+	}
+}
+
+//******* BINARY FORMAT *******************************************************
+
+//Presence flags of optional parts of binary event
+const (
+	binHasTicker = 1 << iota
+	binHasTick
+	binHasCandle
+	binHasOrder
+	binHasTrade
+	binHasRequest
+	binHasImbalance
+	binHasCorporateAction
+)
+
+type binaryWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(b, v)
+	w.buf.Write(b[:n])
+}
+
+func (w *binaryWriter) varint(v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(b, v)
+	w.buf.Write(b[:n])
+}
+
+func (w *binaryWriter) float(v wireFloat) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(float64(v)))
+	w.buf.Write(b)
+}
+
+func (w *binaryWriter) str(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *binaryWriter) boolean(v bool) {
+	if v {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+//time writes unix nanoseconds and location. Zero time is written as single zero flag
+func (w *binaryWriter) time(t time.Time) {
+	if t.IsZero() {
+		w.boolean(false)
+		return
+	}
+	w.boolean(true)
+	w.varint(t.UnixNano())
+	name, offset := t.Zone()
+	if t.Location() == time.UTC {
+		name = "UTC"
+	}
+	w.str(name)
+	w.varint(int64(offset))
+}
+
+func (w *binaryWriter) timeOfDay(t TimeOfDay) {
+	w.varint(int64(t.Hour))
+	w.varint(int64(t.Minute))
+	w.varint(int64(t.Second))
+}
+
+func (w *binaryWriter) tick(t *wireTick) {
+	w.time(t.Datetime)
+	w.str(t.Symbol)
+	w.float(t.LastPrice)
+	w.varint(t.LastSize)
+	w.str(t.LastExch)
+	w.float(t.BidPrice)
+	w.varint(t.BidSize)
+	w.str(t.BidExch)
+	w.float(t.AskPrice)
+	w.varint(t.AskSize)
+	w.str(t.AskExch)
+	w.str(t.CondQuote)
+	w.str(t.Cond1)
+	w.str(t.Cond2)
+	w.str(t.Cond3)
+	w.str(t.Cond4)
+	w.boolean(t.IsOpening)
+	w.boolean(t.IsClosing)
+}
+
+func (w *binaryWriter) candle(c *wireCandle) {
+	w.time(c.Datetime)
+	w.str(c.Symbol)
+	w.float(c.Open)
+	w.float(c.High)
+	w.float(c.Low)
+	w.float(c.Close)
+	w.float(c.AdjClose)
+	w.varint(c.Volume)
+	w.varint(c.OpenInterest)
+	w.str(c.TimeFrame)
+	w.time(c.CloseTime)
+}
+
+func (w *binaryWriter) event(e *wireEvent) {
+	w.uvarint(uint64(e.Version))
+	w.str(e.Name)
+
+	var flags uint64
+	if e.Ticker != nil {
+		flags |= binHasTicker
+	}
+	if e.Tick != nil {
+		flags |= binHasTick
+	}
+	if e.Candle != nil {
+		flags |= binHasCandle
+	}
+	if e.Order != nil {
+		flags |= binHasOrder
+	}
+	if e.Trade != nil {
+		flags |= binHasTrade
+	}
+	if e.Request != nil {
+		flags |= binHasRequest
+	}
+	if e.Imbalance != nil {
+		flags |= binHasImbalance
+	}
+	if e.Corporate != nil {
+		flags |= binHasCorporateAction
+	}
+	w.uvarint(flags)
+
+	w.time(e.Time)
+	w.str(e.OrdId)
+	w.float(e.Price)
+	w.varint(e.Qty)
+	w.str(e.Reason)
+	w.str(e.TimeFrame)
+	w.time(e.CandleTime)
+	w.str(e.Strategy)
+	w.str(e.ScheduleId)
+	w.str(string(e.Tif))
+	w.str(e.Destination)
+	w.float(e.Fee)
+
+	if i := e.Ticker; i != nil {
+		w.str(i.Symbol)
+		w.float(i.MinTick)
+		w.varint(i.LotSize)
+		w.str(i.Exchange)
+		w.timeOfDay(i.MarketOpenTime)
+		w.timeOfDay(i.MarketCloseTime)
+		w.timeOfDay(i.PreMarketOpen)
+		w.timeOfDay(i.AfterHoursClose)
+	}
+	if e.Tick != nil {
+		w.tick(e.Tick)
+	}
+	if e.Candle != nil {
+		w.candle(e.Candle)
+	}
+	if o := e.Order; o != nil {
+		w.str(string(o.Side))
+		w.varint(o.Qty)
+		w.varint(o.ExecQty)
+		w.str(string(o.State))
+		w.float(o.Price)
+		w.float(o.ExecPrice)
+		w.str(string(o.Type))
+		w.str(string(o.Tif))
+		w.str(o.Destination)
+		w.str(o.Id)
+		w.str(o.Mark1)
+		w.str(o.Mark2)
+		w.time(o.Time)
+		w.time(o.ExpireTime)
+		w.time(o.SentTime)
+		w.time(o.ConfirmedTime)
+		w.time(o.CanceledTime)
+		w.time(o.RejectedTime)
+		w.uvarint(uint64(len(o.Fills)))
+		for _, f := range o.Fills {
+			w.time(f.Time)
+			w.float(f.Price)
+			w.varint(f.Qty)
+		}
+		w.uvarint(uint64(len(o.Replaces)))
+		for _, r := range o.Replaces {
+			w.time(r.Time)
+			w.float(r.OldPrice)
+			w.float(r.NewPrice)
+			w.varint(r.OldQty)
+			w.varint(r.NewQty)
+			w.str(string(r.OldTif))
+			w.str(string(r.NewTif))
+			w.str(r.OldDestination)
+			w.str(r.NewDestination)
+		}
+	}
+	if t := e.Trade; t != nil {
+		w.str(t.Id)
+		w.str(string(t.Type))
+		w.varint(t.Qty)
+		w.float(t.FirstPrice)
+		w.float(t.OpenPrice)
+		w.float(t.OpenValue)
+		w.float(t.MarketValue)
+		w.time(t.OpenTime)
+		w.time(t.CloseTime)
+		w.float(t.ClosedPnL)
+		w.float(t.OpenPnL)
+		w.float(t.BorrowFees)
+	}
+	if e.Request != nil {
+		w.event(e.Request)
+	}
+	if m := e.Imbalance; m != nil {
+		w.str(string(m.Auction))
+		w.varint(m.PairedQty)
+		w.varint(m.ImbalanceQty)
+		w.str(string(m.ImbalanceSide))
+		w.float(m.ReferencePrice)
+		w.float(m.NearPrice)
+		w.float(m.FarPrice)
+	}
+	if c := e.Corporate; c != nil {
+		w.str(string(c.Action))
+		w.float(c.Ratio)
+		w.float(c.Amount)
+	}
+
+	w.uvarint(uint64(len(e.Ticks)))
+	for _, t := range e.Ticks {
+		w.tick(t)
+	}
+	w.uvarint(uint64(len(e.Candles)))
+	for _, c := range e.Candles {
+		w.candle(c)
+	}
+}
+
+//binaryReader reads values written by binaryWriter. First error stops reading, all next values are zero
+type binaryReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.r)
+	r.err = err
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r.r)
+	r.err = err
+	return v
+}
+
+func (r *binaryReader) float() wireFloat {
+	if r.err != nil {
+		return 0
+	}
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = err
+		return 0
+	}
+	return wireFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+}
+
+func (r *binaryReader) str() string {
+	l := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if l > uint64(r.r.Len()) {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = err
+		return ""
+	}
+	return string(b)
+}
+
+func (r *binaryReader) boolean() bool {
+	if r.err != nil {
+		return false
+	}
+	b, err := r.r.ReadByte()
+	r.err = err
+	return b == 1
+}
+
+func (r *binaryReader) time() time.Time {
+	if !r.boolean() {
+		return time.Time{}
+	}
+	nanos := r.varint()
+	name := r.str()
+	offset := r.varint()
+	if r.err != nil {
+		return time.Time{}
+	}
+	t := time.Unix(0, nanos)
+	if name == "UTC" && offset == 0 {
+		return t.UTC()
+	}
+	if loc, err := time.LoadLocation(name); err == nil {
+		if _, o := t.In(loc).Zone(); int64(o) == offset {
+			return t.In(loc)
+		}
+	}
+	return t.In(time.FixedZone(name, int(offset)))
+}
+
+func (r *binaryReader) timeOfDay() TimeOfDay {
+	return TimeOfDay{Hour: int(r.varint()), Minute: int(r.varint()), Second: int(r.varint())}
+}
+
+func (r *binaryReader) tick() *wireTick {
+	return &wireTick{
+		Datetime:  r.time(),
+		Symbol:    r.str(),
+		LastPrice: r.float(),
+		LastSize:  r.varint(),
+		LastExch:  r.str(),
+		BidPrice:  r.float(),
+		BidSize:   r.varint(),
+		BidExch:   r.str(),
+		AskPrice:  r.float(),
+		AskSize:   r.varint(),
+		AskExch:   r.str(),
+		CondQuote: r.str(),
+		Cond1:     r.str(),
+		Cond2:     r.str(),
+		Cond3:     r.str(),
+		Cond4:     r.str(),
+		IsOpening: r.boolean(),
+		IsClosing: r.boolean(),
+	}
+}
+
+//candle reads candle of event with schema version. Timeframe and close time were added in version 3
+func (r *binaryReader) candle(version int) *wireCandle {
+	c := wireCandle{
+		Datetime:     r.time(),
+		Symbol:       r.str(),
+		Open:         r.float(),
+		High:         r.float(),
+		Low:          r.float(),
+		Close:        r.float(),
+		AdjClose:     r.float(),
+		Volume:       r.varint(),
+		OpenInterest: r.varint(),
+	}
+	if version >= 3 {
+		c.TimeFrame = r.str()
+		c.CloseTime = r.time()
+	}
+	return &c
+}
+
+func (r *binaryReader) orderLifecycle(o *wireOrder) {
+	o.SentTime = r.time()
+	o.ConfirmedTime = r.time()
+	o.CanceledTime = r.time()
+	o.RejectedTime = r.time()
+	for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+		o.Fills = append(o.Fills, wireExecution{Time: r.time(), Price: r.float(), Qty: r.varint()})
+	}
+	for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+		o.Replaces = append(o.Replaces, wireReplacement{
+			Time:           r.time(),
+			OldPrice:       r.float(),
+			NewPrice:       r.float(),
+			OldQty:         r.varint(),
+			NewQty:         r.varint(),
+			OldTif:         OrderTIF(r.str()),
+			NewTif:         OrderTIF(r.str()),
+			OldDestination: r.str(),
+			NewDestination: r.str(),
+		})
+	}
+}
+
+func (r *binaryReader) event() *wireEvent {
+	e := wireEvent{}
+	e.Version = int(r.uvarint())
+	if r.err == nil && (e.Version > EventsSchemaVersion || e.Version <= 0) {
+		r.err = fmt.Errorf("Unsupported schema version: %v", e.Version)
+		return &e
+	}
+	e.Name = r.str()
+	flags := r.uvarint()
+
+	e.Time = r.time()
+	e.OrdId = r.str()
+	e.Price = r.float()
+	e.Qty = r.varint()
+	e.Reason = r.str()
+	e.TimeFrame = r.str()
+	e.CandleTime = r.time()
+	e.Strategy = r.str()
+	if e.Version >= 2 {
+		e.ScheduleId = r.str()
+	}
+	//Amended tif and destination of replace events were added in version 4
+	if e.Version >= 4 {
+		e.Tif = OrderTIF(r.str())
+		e.Destination = r.str()
+	}
+	//Borrow fee and borrow fees of trade were added in version 7
+	if e.Version >= 7 {
+		e.Fee = r.float()
+	}
+
+	if flags&binHasTicker != 0 {
+		e.Ticker = &wireInstrument{
+			Symbol:          r.str(),
+			MinTick:         r.float(),
+			LotSize:         r.varint(),
+			Exchange:        r.str(),
+			MarketOpenTime:  r.timeOfDay(),
+			MarketCloseTime: r.timeOfDay(),
+		}
+		//Extended hours of exchange and expire time of order were added in version 5
+		if e.Version >= 5 {
+			e.Ticker.PreMarketOpen = r.timeOfDay()
+			e.Ticker.AfterHoursClose = r.timeOfDay()
+		}
+	}
+	if flags&binHasTick != 0 {
+		e.Tick = r.tick()
+	}
+	if flags&binHasCandle != 0 {
+		e.Candle = r.candle(e.Version)
+	}
+	if flags&binHasOrder != 0 {
+		e.Order = &wireOrder{
+			Side:        OrderSide(r.str()),
+			Qty:         r.varint(),
+			ExecQty:     r.varint(),
+			State:       OrderState(r.str()),
+			Price:       r.float(),
+			ExecPrice:   r.float(),
+			Type:        OrderType(r.str()),
+			Tif:         OrderTIF(r.str()),
+			Destination: r.str(),
+			Id:          r.str(),
+			Mark1:       r.str(),
+			Mark2:       r.str(),
+			Time:        r.time(),
+		}
+		if e.Version >= 5 {
+			e.Order.ExpireTime = r.time()
+		}
+		//Lifecycle times, fills and replaces of order were added in version 9
+		if e.Version >= 9 {
+			r.orderLifecycle(e.Order)
+		}
+	}
+	if flags&binHasTrade != 0 {
+		e.Trade = &wireTrade{
+			Id:          r.str(),
+			Type:        TradeType(r.str()),
+			Qty:         r.varint(),
+			FirstPrice:  r.float(),
+			OpenPrice:   r.float(),
+			OpenValue:   r.float(),
+			MarketValue: r.float(),
+			OpenTime:    r.time(),
+			CloseTime:   r.time(),
+			ClosedPnL:   r.float(),
+			OpenPnL:     r.float(),
+		}
+		if e.Version >= 7 {
+			e.Trade.BorrowFees = r.float()
+		}
+	}
+	if flags&binHasRequest != 0 {
+		e.Request = r.event()
+	}
+	//Imbalance part was added in version 6
+	if flags&binHasImbalance != 0 {
+		e.Imbalance = &wireImbalance{
+			Auction:        AuctionType(r.str()),
+			PairedQty:      r.varint(),
+			ImbalanceQty:   r.varint(),
+			ImbalanceSide:  OrderSide(r.str()),
+			ReferencePrice: r.float(),
+			NearPrice:      r.float(),
+			FarPrice:       r.float(),
+		}
+	}
+	//Corporate action part was added in version 8
+	if flags&binHasCorporateAction != 0 {
+		e.Corporate = &wireCorporateAction{
+			Action: CorporateActionType(r.str()),
+			Ratio:  r.float(),
+			Amount: r.float(),
+		}
+	}
+
+	for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+		e.Ticks = append(e.Ticks, r.tick())
+	}
+	for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+		e.Candles = append(e.Candles, r.candle(e.Version))
+	}
+
+	return &e
+}
@@ -0,0 +1,243 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type unknownTestEvent struct {
+	BaseEvent
+}
+
+func (c *unknownTestEvent) getName() string {
+	return "UnknownTestEvent"
+}
+
+func newTestCodecEvents() []event {
+	inst := newTestInstrument()
+	inst.Exchange.PreMarketOpenTime = TimeOfDay{Hour: 4}
+	inst.Exchange.AfterHoursCloseTime = TimeOfDay{Hour: 20}
+	tm := newTestOrderTime()
+
+	ord := newTestOrder(10.05, OrderBuy, 100, "Test|B|1")
+	ord.Ticker = inst
+	ord.Tif = GTDTIF
+	ord.ExpireTime = tm.Add(48 * time.Hour)
+	ord.SentTime = tm
+	ord.ConfirmedTime = tm.Add(time.Millisecond)
+	ord.Fills = []OrderExecution{{Time: tm.Add(time.Second), Price: 10.05, Qty: 40}}
+	ord.Replaces = []OrderReplacement{{Time: tm.Add(2 * time.Second), OldPrice: 10.05, NewPrice: 10.1, OldQty: 100,
+		NewQty: 200, OldTif: GTDTIF, NewTif: DayTIF, OldDestination: "NSDQ", NewDestination: "ARCA"}}
+
+	tick := &Tick{Tick: &marketdata.Tick{Datetime: tm, Symbol: "Test", LastPrice: 10.01, LastSize: 200, LastExch: "Q",
+		BidPrice: math.NaN(), AskPrice: math.Inf(1), Cond1: "O", IsOpening: true}, Ticker: inst}
+	candle := &Candle{Candle: &marketdata.Candle{Datetime: tm, Symbol: "Test", Open: 10, High: 11, Low: 9.5,
+		Close: 10.5, AdjClose: 10.5, Volume: 1000}, Ticker: inst, TimeFrame: "D", CloseTime: tm.Add(6 * time.Hour)}
+
+	trade := newFlatTrade(inst)
+	trade.Id = "T1"
+	trade.Type = LongTrade
+	trade.Qty = 100
+	trade.OpenTime = tm
+	trade.BorrowFees = 1.25
+
+	return []event{
+		&CandleOpenEvent{BaseEvent: be(tm, inst), CandleTime: tm, Price: 10, TimeFrame: "D"},
+		&CandleCloseEvent{BaseEvent: be(tm, inst), Candle: candle, TimeFrame: "D"},
+		&CandlesHistoryEvent{BaseEvent: be(tm, inst), Candles: CandleArray{candle, candle}},
+		&NewTickEvent{BaseEvent: be(tm, inst), Tick: tick},
+		&TickHistoryEvent{BaseEvent: be(tm, inst), Ticks: TickArray{tick}},
+		&NewOrderEvent{BaseEvent: be(tm, inst), LinkedOrder: ord},
+		&OrderConfirmationEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1"},
+		&OrderFillEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1", Price: 10.05, Qty: 100},
+		&OrderCancelEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1"},
+		&OrderCancelRejectEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1", Reason: "Not found"},
+		&OrderCancelRequestEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1"},
+		&OrderReplaceRequestEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1", NewPrice: 10.1, NewQty: 300,
+			NewTif: DayTIF, NewDestination: "ARCA"},
+		&OrderReplaceRejectEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1", Reason: "Not found"},
+		&OrderReplacedEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1", NewPrice: 10.1, NewQty: 300,
+			NewTif: DayTIF, NewDestination: "ARCA"},
+		&OrderRejectedEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1", Reason: "Bad order"},
+		&StrategyRequestNotDeliveredEvent{BaseEvent: be(tm, inst),
+			Request: &OrderCancelRequestEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|1"}},
+		&TimerTickEvent{BaseEvent: be(tm, nil)},
+		&AuctionImbalanceEvent{BaseEvent: be(tm, inst), Auction: ClosingAuction, PairedQty: 5000,
+			ImbalanceQty: 1200, ImbalanceSide: OrderSell, ReferencePrice: 10.02, NearPrice: 10.01,
+			FarPrice: math.NaN()},
+		&TradingHaltEvent{BaseEvent: be(tm, inst), Reason: "LULD pause"},
+		&TradingResumeEvent{BaseEvent: be(tm, inst)},
+		&BorrowFeeEvent{BaseEvent: be(tm, inst), Qty: 300, Price: 10.05, Fee: 0.42},
+		&CorporateActionEvent{BaseEvent: be(tm, inst), Action: SplitAction, Ratio: 1.5},
+		&EndOfDataEvent{BaseEvent: be(tm, nil)},
+		&PortfolioNewPositionEvent{BaseEvent: be(tm, inst), Trade: trade},
+		&StrategyFinishedEvent{BaseEvent: be(tm, inst), Strategy: "Test"},
+	}
+}
+
+//assertEventsEqual compares decoded event with original one ignoring time locations. Both events are
+//normalized, so expected event shouldn't be encoded after it
+func assertEventsEqual(t *testing.T, expected event, actual event) {
+	if !assert.NotNil(t, actual) {
+		return
+	}
+	normalizeTestValue(reflect.ValueOf(expected), make(map[uintptr]bool))
+	normalizeTestValue(reflect.ValueOf(actual), make(map[uintptr]bool))
+	assert.Equal(t, expected, actual, expected.getName())
+}
+
+//normalizeTestValue sets UTC location of all times and replaces NaN, which isn't equal to itself, with the
+//lowest float
+func normalizeTestValue(v reflect.Value, visited map[uintptr]bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || visited[v.Pointer()] {
+			return
+		}
+		visited[v.Pointer()] = true
+		normalizeTestValue(v.Elem(), visited)
+	case reflect.Interface:
+		if !v.IsNil() {
+			normalizeTestValue(v.Elem(), visited)
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			if v.CanSet() {
+				v.Set(reflect.ValueOf(v.Interface().(time.Time).UTC()))
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				normalizeTestValue(v.Field(i), visited)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			normalizeTestValue(v.Index(i), visited)
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			normalizeTestValue(v.MapIndex(k), visited)
+		}
+	case reflect.Float32, reflect.Float64:
+		if v.CanSet() && math.IsNaN(v.Float()) {
+			v.SetFloat(-math.MaxFloat64)
+		}
+	}
+}
+
+func TestEventCodecs_RoundTrip(t *testing.T) {
+	codecs := map[string]IEventCodec{
+		"JSON":   NewJSONEventCodec(),
+		"Binary": NewBinaryEventCodec(),
+	}
+
+	for name, codec := range codecs {
+		t.Log(name + " codec: round trip of all events types")
+		{
+			events := newTestCodecEvents()
+			assert.Len(t, events, len(eventsRegistry))
+			for i, e := range events {
+				data, err := codec.Encode(e)
+				assert.Nil(t, err, e.getName())
+				decoded, err := codec.Decode(data)
+				assert.Nil(t, err, e.getName())
+				assertEventsEqual(t, newTestCodecEvents()[i], decoded)
+			}
+		}
+
+		t.Log(name + " codec: NaN and Inf prices are kept")
+		{
+			e := newTestCodecEvents()[3]
+			data, err := codec.Encode(e)
+			assert.Nil(t, err)
+			decoded, err := codec.Decode(data)
+			assert.Nil(t, err)
+			tick := decoded.(*NewTickEvent).Tick
+			assert.True(t, math.IsNaN(tick.BidPrice))
+			assert.True(t, math.IsInf(tick.AskPrice, 1))
+			assert.Equal(t, 10.01, tick.LastPrice)
+		}
+
+		t.Log(name + " codec: registered instruments are used in decoded events")
+		{
+			inst := newTestInstrument()
+			switch c := codec.(type) {
+			case *JSONEventCodec:
+				c.SetInstruments([]*Instrument{inst})
+			case *BinaryEventCodec:
+				c.SetInstruments([]*Instrument{inst})
+			}
+			data, _ := codec.Encode(newTestCodecEvents()[6])
+			decoded, err := codec.Decode(data)
+			assert.Nil(t, err)
+			assert.True(t, inst == decoded.(*OrderConfirmationEvent).Ticker)
+		}
+
+		t.Log(name + " codec: unknown event type")
+		{
+			_, err := codec.Encode(&unknownTestEvent{be(newTestOrderTime(), nil)})
+			assert.NotNil(t, err)
+		}
+	}
+}
+
+func TestEventCodecs_Versions(t *testing.T) {
+	t.Log("JSON codec: event with future schema version")
+	{
+		codec := NewJSONEventCodec()
+		future := fmt.Sprintf(`{"v":%v,"type":"OrderCancelEvent","time":"2010-01-05T10:00:00Z"}`,
+			EventsSchemaVersion+1)
+		_, err := codec.Decode([]byte(future))
+		assert.NotNil(t, err)
+		_, err = codec.Decode([]byte(`{"v":1,"type":"SomeNewEvent","time":"2010-01-05T10:00:00Z"}`))
+		assert.NotNil(t, err)
+	}
+
+	t.Log("Binary codec: event with future schema version and truncated event")
+	{
+		codec := NewBinaryEventCodec()
+		data, _ := codec.Encode(newTestCodecEvents()[7])
+		future := append([]byte{}, data...)
+		future[0] = EventsSchemaVersion + 1
+		_, err := codec.Decode(future)
+		assert.NotNil(t, err)
+
+		_, err = codec.Decode(data[:len(data)/2])
+		assert.NotNil(t, err)
+	}
+}
+
+func TestEventCodecs_WriteReadEvents(t *testing.T) {
+	for _, codec := range []IEventCodec{NewJSONEventCodec(), NewBinaryEventCodec()} {
+		events := newTestCodecEvents()
+		buf := bytes.Buffer{}
+		assert.Nil(t, WriteEvents(&buf, codec, events))
+
+		decoded, err := ReadEvents(&buf, codec)
+		assert.Nil(t, err)
+		if assert.Len(t, decoded, len(events)) {
+			for i := range events {
+				assertEventsEqual(t, newTestCodecEvents()[i], decoded[i])
+			}
+		}
+	}
+
+	t.Log("Binary codec keeps time location")
+	{
+		loc := time.FixedZone("EST", -5*3600)
+		tm := time.Date(2010, 1, 5, 10, 0, 0, 15, loc)
+		codec := NewBinaryEventCodec()
+		data, _ := codec.Encode(&EndOfDataEvent{BaseEvent: be(tm, nil)})
+		decoded, err := codec.Decode(data)
+		assert.Nil(t, err)
+		assert.Equal(t, tm.String(), decoded.getTime().String())
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"math"
-	"strconv"
 	"time"
 )
 
@@ -51,8 +50,31 @@ const (
 	GTCTIF     OrderTIF = "GTCTIF"
 	IOCTIF     OrderTIF = "IOCTIF"
 	AuctionTIF OrderTIF = "AuctionTIF"
+	//FOKTIF is fill or kill: order is filled for all qty by the first market data or canceled
+	FOKTIF OrderTIF = "FOKTIF"
+	//GTDTIF is good till date: order expires at Order.ExpireTime
+	GTDTIF OrderTIF = "GTDTIF"
+	//OPGTIF and CLSTIF are for on open and on close orders of opening and closing auctions
+	OPGTIF OrderTIF = "OPGTIF"
+	CLSTIF OrderTIF = "CLSTIF"
+	//DayExtTIF and GTCExtTIF orders are active in pre market and after hours sessions too
+	DayExtTIF OrderTIF = "DayExtTIF"
+	GTCExtTIF OrderTIF = "GTCExtTIF"
 )
 
+func (t OrderTIF) isAuction() bool {
+	return t == AuctionTIF || t == OPGTIF || t == CLSTIF
+}
+
+func (t OrderTIF) isExtendedHours() bool {
+	return t == DayExtTIF || t == GTCExtTIF
+}
+
+//isImmediate returns true for TIFs which cancel order part not filled by the first market data
+func (t OrderTIF) isImmediate() bool {
+	return t == IOCTIF || t == FOKTIF
+}
+
 type TimeOfDay struct {
 	Hour   int
 	Minute int
@@ -86,10 +108,16 @@ type Instrument struct {
 	LotSize  int64
 }
 
+//Exchange has regular session from MarketOpenTime to MarketCloseTime. PreMarketOpenTime and AfterHoursCloseTime
+//are optional bounds of extended hours sessions. When they are set, orders without extended hours TIF are filled
+//by ticks of regular session only. Optional Calendar sets location, holidays and early closes (see calendar.go)
 type Exchange struct {
-	Name            string
-	MarketOpenTime  TimeOfDay
-	MarketCloseTime TimeOfDay
+	Name                string
+	MarketOpenTime      TimeOfDay
+	MarketCloseTime     TimeOfDay
+	PreMarketOpenTime   TimeOfDay
+	AfterHoursCloseTime TimeOfDay
+	Calendar            *TradingCalendar
 }
 
 type Tick struct {
@@ -99,9 +127,13 @@ type Tick struct {
 
 type TickArray []*Tick
 
+//Candle is a bar of market data. TimeFrame is empty for candles of market data without timeframes. CloseTime is
+//set for candles built by engine, for example the last candle of session which closes with market
 type Candle struct {
 	*marketdata.Candle
-	Ticker *Instrument
+	Ticker    *Instrument
+	TimeFrame string
+	CloseTime time.Time
 }
 
 func (c *Candle) isValid() bool {
@@ -123,34 +155,21 @@ func (c *Candle) isValid() bool {
 	if c.Open < c.Low {
 		return false
 	}
-	if c.Open > c.High {
+	if c.Close < c.Low {
 		return false
 	}
 	return true
 }
 
 func (c *Candle) isOpening() bool {
-	e := c.Ticker.Exchange
-	if c.Datetime.Hour() == e.MarketOpenTime.Hour && c.Datetime.Minute() == e.MarketOpenTime.Minute {
-		return true
-	}
-	return false
+	return c.Ticker.Exchange.isOpenTime(c.Datetime)
 }
 
 func (c *Candle) isClosingForTimeFrame(tf string) bool {
 	if tf == "D" || tf == "W" {
 		return true
 	}
-	mins, err := strconv.ParseInt(tf, 10, 8)
-	if err != nil {
-		panic("Unknown timeframe. ")
-	}
-	e := c.Ticker.Exchange
-	closeTime := c.Datetime.Add(time.Minute * time.Duration(mins))
-	if closeTime.Hour() == e.MarketCloseTime.Hour && closeTime.Minute() == e.MarketCloseTime.Minute {
-		return true
-	}
-	return false
+	return c.Ticker.Exchange.isCloseTime(c.closeTime(tf))
 }
 
 type CandleArray []*Candle
@@ -170,6 +189,66 @@ type Order struct {
 	Mark1       string
 	Mark2       string
 	Time        time.Time
+	//ExpireTime is expiration of GTD orders
+	ExpireTime time.Time
+
+	//Lifecycle of order. Times are zero until order gets to the state
+	SentTime      time.Time
+	ConfirmedTime time.Time
+	CanceledTime  time.Time
+	RejectedTime  time.Time
+	Fills         []OrderExecution
+	Replaces      []OrderReplacement
+}
+
+//OrderExecution is a single fill of order
+type OrderExecution struct {
+	Time  time.Time
+	Price float64
+	Qty   int64
+}
+
+//OrderReplacement is a confirmed amendment of order with values before and after it
+type OrderReplacement struct {
+	Time           time.Time
+	OldPrice       float64
+	NewPrice       float64
+	OldQty         int64
+	NewQty         int64
+	OldTif         OrderTIF
+	NewTif         OrderTIF
+	OldDestination string
+	NewDestination string
+}
+
+//OrderAmendment is a change of working order. Zero or NaN Price, zero Qty and empty Tif or Destination mean that
+//value isn't changed. For stop orders Price is trigger price
+type OrderAmendment struct {
+	Price       float64
+	Qty         int64
+	Tif         OrderTIF
+	Destination string
+}
+
+func (a OrderAmendment) changesPrice() bool {
+	return !math.IsNaN(a.Price) && a.Price != 0
+}
+
+func (a OrderAmendment) isEmpty() bool {
+	return !a.changesPrice() && a.Qty == 0 && a.Tif == "" && a.Destination == ""
+}
+
+//snapshot returns copy of order that doesn't change with order
+func (o *Order) snapshot() Order {
+	s := *o
+	s.Fills = append([]OrderExecution(nil), o.Fills...)
+	s.Replaces = append([]OrderReplacement(nil), o.Replaces...)
+	return s
+}
+
+//IsWorking returns true for orders which can still be filled
+func (o *Order) IsWorking() bool {
+	return o.State == NewOrder || o.State == ConfirmedOrder || o.State == PartialFilledOrder
 }
 
 //isValid returns if order has right prices (NaN for market orders and specified for Limit and Stop)
@@ -285,12 +364,45 @@ func (o *Order) confirm() error {
 
 }
 
-func (o *Order) replace(newPrice float64) error {
-	if !o.IsPriced() {
-		return errors.New("Can't replace order. Order should be priced")
+//amend changes order and returns replacement with old and new values. Qty can't go below executed qty
+func (o *Order) amend(a OrderAmendment) (OrderReplacement, error) {
+	r := OrderReplacement{
+		OldPrice:       o.Price,
+		NewPrice:       o.Price,
+		OldQty:         o.Qty,
+		NewQty:         o.Qty,
+		OldTif:         o.Tif,
+		NewTif:         o.Tif,
+		OldDestination: o.Destination,
+		NewDestination: o.Destination,
 	}
-	o.Price = newPrice
-	return nil
+	if a.isEmpty() {
+		return r, errors.New("Can't replace order. Nothing to change")
+	}
+	if a.changesPrice() {
+		if !o.IsPriced() {
+			return r, errors.New("Can't replace order. Order should be priced")
+		}
+		r.NewPrice = a.Price
+	}
+	if a.Qty != 0 {
+		if a.Qty < o.ExecQty || a.Qty < 0 {
+			return r, errors.Errorf("Can't replace order. New qty %v is less than executed qty %v", a.Qty, o.ExecQty)
+		}
+		r.NewQty = a.Qty
+	}
+	if a.Tif != "" {
+		r.NewTif = a.Tif
+	}
+	if a.Destination != "" {
+		r.NewDestination = a.Destination
+	}
+
+	o.Price = r.NewPrice
+	o.Qty = r.NewQty
+	o.Tif = r.NewTif
+	o.Destination = r.NewDestination
+	return r, nil
 }
 
 func (o *Order) IsPriced() bool {
@@ -343,7 +455,9 @@ type Trade struct {
 	Returns         []*TradeReturn
 	ClosedPnL       float64
 	OpenPnL         float64
-	Id              string
+	//BorrowFees of short trade are already subtracted from ClosedPnL
+	BorrowFees float64
+	Id         string
 }
 
 func (t *Trade) hasConfirmedOrderWithId(ordID string) bool {
@@ -454,16 +568,28 @@ func (t *Trade) cancelOrder(id string) error {
 	return nil
 }
 
-func (t *Trade) replaceOrder(id string, newPrice float64) error {
-	if order, ok := t.ConfirmedOrders[id]; ok {
-		err := order.replace(newPrice)
-		if err != nil {
-			return err
-		}
-
-	} else {
+//replaceOrder amends confirmed order and puts the amendment to order history. Order with qty reduced to its
+//executed qty has nothing left to fill and goes to filled orders
+func (t *Trade) replaceOrder(id string, a OrderAmendment, datetime time.Time) error {
+	order, ok := t.ConfirmedOrders[id]
+	if !ok {
 		return errors.New("Can't replace order. Not found in confirmed orders")
 	}
+	r, err := order.amend(a)
+	if err != nil {
+		return err
+	}
+	r.Time = datetime
+	order.Replaces = append(order.Replaces, r)
+
+	if order.ExecQty > 0 && order.ExecQty == order.Qty {
+		order.State = FilledOrder
+		if t.FilledOrders == nil {
+			t.FilledOrders = make(map[string]*Order)
+		}
+		t.FilledOrders[id] = order
+		delete(t.ConfirmedOrders, id)
+	}
 	return nil
 }
 
@@ -666,6 +792,19 @@ func (t *Trade) executeOrder(id string, qty int64, execPrice float64, datetime t
 	return nil, nil
 }
 
+//accrueBorrowFee subtracts borrow fee of shares from closed pnl of short trade
+func (t *Trade) accrueBorrowFee(fee float64) error {
+	if t.Type != ShortTrade {
+		return errors.New("Can't accrue borrow fee. Trade is not short: " + string(t.Type))
+	}
+	if math.IsNaN(fee) || fee < 0 {
+		return errors.New("Borrow fee is NaN or negative")
+	}
+	t.BorrowFees += fee
+	t.ClosedPnL -= fee
+	return nil
+}
+
 //rejectOrder by given ID with given reject reason. Find order in NewOrdes map, change status and move it
 //to RejectedOrders map
 func (t *Trade) rejectOrder(id string, reason string) error {
@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//******* HALTS FEED **********************************************************
+
+//parseLineToHalt parses line of halts file: "symbol,unix halt time,unix resume time,reason". Empty resume
+//time means that instrument isn't resumed till the end of data
+func parseLineToHalt(l string, tickersMap map[string]*Instrument) ([]event, error) {
+	ls := strings.SplitN(strings.TrimSpace(l), ",", 4)
+	if len(ls) < 3 {
+		return nil, errors.New("Can't parse line to halt: " + l)
+	}
+
+	ticker, ok := tickersMap[ls[0]]
+	if !ok {
+		return nil, nil
+	}
+
+	haltSec, err := strconv.ParseInt(ls[1], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "Wrong halt time")
+	}
+	reason := ""
+	if len(ls) == 4 {
+		reason = ls[3]
+	}
+	res := []event{&TradingHaltEvent{BaseEvent: be(time.Unix(haltSec, 0), ticker), Reason: reason}}
+
+	if ls[2] == "" {
+		return res, nil
+	}
+	resumeSec, err := strconv.ParseInt(ls[2], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "Wrong resume time")
+	}
+	if resumeSec < haltSec {
+		return nil, errors.New("Resume time is before halt time: " + l)
+	}
+	return append(res, &TradingResumeEvent{BaseEvent: be(time.Unix(resumeSec, 0), ticker)}), nil
+}
+
+//haltDetection is trades state of symbol for detection of halts
+type haltDetection struct {
+	ticker    *Instrument
+	lastTrade time.Time
+	halted    bool
+}
+
+//detectHalt puts halt events before tick for symbols that had no trades during session longer than
+//HaltDetectionGap. Every tick checks all symbols, so halt is put at time of the previous trade plus gap as soon
+//as market data passes it and quotes within halt don't fill orders. Halted symbol is resumed before its next
+//trade
+func (m *BTM) detectHalt(e *NewTickEvent) {
+	if m.HaltDetectionGap <= 0 || e.Ticker == nil {
+		return
+	}
+	if m.haltStates == nil {
+		m.haltStates = make(map[string]*haltDetection)
+	}
+	t := e.Tick.Datetime
+
+	var halts []event
+	for _, s := range m.haltStates {
+		if s.halted {
+			continue
+		}
+		haltTime := s.lastTrade.Add(m.HaltDetectionGap)
+		if haltTime.After(t) {
+			continue
+		}
+		exchange := s.ticker.Exchange
+		if !isInSession(s.lastTrade, exchange) || !isInSession(haltTime, exchange) ||
+			!exchange.OpenTime(s.lastTrade).Equal(exchange.OpenTime(haltTime)) {
+			continue
+		}
+		s.halted = true
+		halts = append(halts, &TradingHaltEvent{BaseEvent: be(haltTime, s.ticker), Reason: "No trades"})
+	}
+	sort.SliceStable(halts, func(i, j int) bool {
+		if halts[i].getTime().Equal(halts[j].getTime()) {
+			return halts[i].getSymbol() < halts[j].getSymbol()
+		}
+		return halts[i].getTime().Before(halts[j].getTime())
+	})
+	for _, h := range halts {
+		m.newEvent(h)
+	}
+
+	if !e.Tick.HasTrade() {
+		return
+	}
+	s, ok := m.haltStates[e.Tick.Symbol]
+	if !ok {
+		s = &haltDetection{ticker: e.Ticker}
+		m.haltStates[e.Tick.Symbol] = s
+	}
+	if s.halted {
+		s.halted = false
+		m.newEvent(&TradingResumeEvent{BaseEvent: be(t, e.Ticker)})
+	}
+	s.lastTrade = t
+}
+
+//******* SIM BROKER HALTS ****************************************************
+
+func (b *simBrokerWorker) onTradingHalt(e *TradingHaltEvent) {
+	b.proceedStoredRequests(e.getTime())
+	b.mpMutext.Lock()
+	b.halted = true
+	b.reopening = false
+	b.mpMutext.Unlock()
+	b.findExecutions(e)
+}
+
+//onTradingResume waits for reopening cross. Orders queued during halt are filled by the first trade after
+//resume
+func (b *simBrokerWorker) onTradingResume(e *TradingResumeEvent) {
+	b.proceedStoredRequests(e.getTime())
+	b.mpMutext.Lock()
+	if b.halted {
+		b.halted = false
+		b.reopening = true
+	}
+	b.mpMutext.Unlock()
+	b.findExecutions(e)
+}
+
+//fillOnReopeningPrint fills market order and limit order with price within limit at price of reopening cross.
+//Order takes only participation part of cross volume, the rest stays active
+func (b *simBrokerWorker) fillOnReopeningPrint(o *simBrokerOrder, tick *Tick) []event {
+	if o.Type == LimitOrder {
+		if o.Side == OrderBuy && tick.LastPrice > o.BrokerPrice {
+			return nil
+		}
+		if o.Side == OrderSell && tick.LastPrice < o.BrokerPrice {
+			return nil
+		}
+	}
+
+	qty := b.auctionExecQty(o, tick)
+	if qty == 0 {
+		return nil
+	}
+
+	fillE := OrderFillEvent{
+		OrdId:     o.Id,
+		Price:     tick.LastPrice,
+		Qty:       qty,
+		BaseEvent: be(b.genTimeRoundTrip(tick.Datetime), o.Ticker),
+	}
+	return b.killPartialFill(o, []event{&fillE})
+}
+
+//validateHalt returns reason of rejection for immediate orders during halt. They can't wait for reopening
+func (b *simBrokerWorker) validateHalt(o *Order) string {
+	if b.halted && o.Tif.isImmediate() {
+		return "Trading is halted. TIF: " + string(o.Tif)
+	}
+	return ""
+}
+
+//******* LULD BANDS **********************************************************
+
+//luldWindow is period of trades that gives reference price of LULD bands
+const luldWindow = 5 * time.Minute
+
+type luldTrade struct {
+	time  time.Time
+	price float64
+}
+
+//updateLULD adds trade of tick to reference price window
+func (b *simBrokerWorker) updateLULD(tick *Tick) {
+	if b.luldTier == 0 || !tick.HasTrade() {
+		return
+	}
+	b.luldTrades = append(b.luldTrades, luldTrade{time: tick.Datetime, price: tick.LastPrice})
+	from := tick.Datetime.Add(-luldWindow)
+	n := 0
+	for n < len(b.luldTrades) && !b.luldTrades[n].time.After(from) {
+		n++
+	}
+	b.luldTrades = b.luldTrades[n:]
+
+	sum := 0.0
+	for _, t := range b.luldTrades {
+		sum += t.price
+	}
+	b.luldReference = sum / float64(len(b.luldTrades))
+}
+
+//luldPercent returns band percent for reference price. Tier 1 are S&P 500, Russell 1000 and some ETPs, tier 2
+//are all other stocks
+func luldPercent(tier int, reference float64) float64 {
+	switch {
+	case reference > 3:
+		if tier == 1 {
+			return 0.05
+		}
+		return 0.1
+	case reference >= 0.75:
+		return 0.2
+	}
+	return math.Min(0.75, 0.15/reference)
+}
+
+//luldBands returns lower and upper price bands at time t. Bands are doubled in the first 15 minutes of
+//session and in the last 25 minutes. False is returned if bands are off or reference price is unknown
+func (b *simBrokerWorker) luldBands(t time.Time) (float64, float64, bool) {
+	if b.luldTier == 0 || b.luldReference <= 0 {
+		return 0, 0, false
+	}
+	p := luldPercent(b.luldTier, b.luldReference)
+	exchange := b.symbol.Exchange
+	open := exchange.OpenTime(t)
+	close := exchange.CloseTime(t)
+	if t.Before(open.Add(15*time.Minute)) || !t.Before(close.Add(-25*time.Minute)) {
+		p *= 2
+	}
+	return b.luldReference * (1 - p), b.luldReference * (1 + p), true
+}
+
+//validateLULD returns reason of rejection for limit order which price is out of LULD bands: buy above upper
+//band or sell below lower band
+func (b *simBrokerWorker) validateLULD(o *Order, price float64, t time.Time) string {
+	if o.Type != LimitOrder {
+		return ""
+	}
+	lower, upper, ok := b.luldBands(t)
+	if !ok {
+		return ""
+	}
+	if (o.Side == OrderBuy && price > upper) || (o.Side == OrderSell && price < lower) {
+		return fmt.Sprintf("Limit price %v is out of LULD bands [%.4f, %.4f]", price, lower, upper)
+	}
+	return ""
+}
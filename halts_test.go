@@ -0,0 +1,316 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+type haltTestStrategy struct {
+	DummyStrategyWithLogic
+	reasons []string
+	resumes int
+}
+
+func (s *haltTestStrategy) OnTradingHalt(b *BasicStrategy, reason string) {
+	s.reasons = append(s.reasons, reason)
+}
+
+func (s *haltTestStrategy) OnTradingResume(b *BasicStrategy) {
+	s.resumes++
+}
+
+func TestHalts_ParseHalt(t *testing.T) {
+	inst := newTestInstrument()
+	tickersMap := map[string]*Instrument{"Test": inst}
+
+	t.Log("Halt with resume")
+	{
+		events, err := parseLineToHalt("Test,1262705700,1262706000,LUDP, volatility", tickersMap)
+		assert.Nil(t, err)
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, &TradingHaltEvent{BaseEvent: be(time.Unix(1262705700, 0), inst),
+				Reason: "LUDP, volatility"}, events[0])
+			assert.Equal(t, &TradingResumeEvent{BaseEvent: be(time.Unix(1262706000, 0), inst)}, events[1])
+		}
+	}
+
+	t.Log("Halt without resume and halt of unknown symbol")
+	{
+		events, err := parseLineToHalt("Test,1262705700,", tickersMap)
+		assert.Nil(t, err)
+		assert.Len(t, events, 1)
+
+		events, err = parseLineToHalt("Other,1262705700,1262706000,T1", tickersMap)
+		assert.Nil(t, err)
+		assert.Len(t, events, 0)
+	}
+
+	t.Log("Wrong halt lines")
+	{
+		for _, l := range []string{
+			"Test,1262705700",
+			"Test,time,1262706000",
+			"Test,1262705700,time",
+			"Test,1262706000,1262705700",
+		} {
+			_, err := parseLineToHalt(l, tickersMap)
+			assert.NotNil(t, err, l)
+		}
+	}
+}
+
+func TestHalts_DetectHalt(t *testing.T) {
+	inst := newTestInstrument()
+	other := newTestInstrument()
+	other.Symbol = "Other"
+	tickAt := func(ticker *Instrument, h, m int) *NewTickEvent {
+		tm := time.Date(2010, 1, 5, h, m, 0, 0, time.UTC)
+		tick := Tick{Tick: &marketdata.Tick{Datetime: tm, Symbol: ticker.Symbol, LastPrice: 10, LastSize: 100},
+			Ticker: ticker}
+		return &NewTickEvent{BaseEvent: be(tm, ticker), Tick: &tick}
+	}
+	readEvents := func(m *BTM) []event {
+		close(m.mdChan)
+		var events []event
+		for e := range m.mdChan {
+			events = append(events, e)
+		}
+		return events
+	}
+
+	t.Log("Gap in trades during session is halt till the next trade")
+	{
+		m := BTM{HaltDetectionGap: 5 * time.Minute, mdChan: make(chan event, 10)}
+		m.newTickEvent(nil, tickAt(inst, 10, 0))
+		m.newTickEvent(nil, tickAt(inst, 10, 4))
+		m.newTickEvent(nil, tickAt(inst, 10, 15))
+
+		events := readEvents(&m)
+		if assert.Len(t, events, 5) {
+			assert.Equal(t, &TradingHaltEvent{BaseEvent: be(time.Date(2010, 1, 5, 10, 9, 0, 0, time.UTC), inst),
+				Reason: "No trades"}, events[2])
+			assert.Equal(t, &TradingResumeEvent{BaseEvent: be(time.Date(2010, 1, 5, 10, 15, 0, 0, time.UTC), inst)},
+				events[3])
+			assert.IsType(t, &NewTickEvent{}, events[4])
+		}
+	}
+
+	t.Log("Halt is put with the first tick after gap, resume waits for trade of halted symbol")
+	{
+		m := BTM{HaltDetectionGap: 5 * time.Minute, mdChan: make(chan event, 10)}
+		m.newTickEvent(nil, tickAt(inst, 10, 0))
+		m.newTickEvent(nil, tickAt(other, 10, 3))
+		m.newTickEvent(nil, tickAt(other, 10, 7))
+		m.newTickEvent(nil, tickAt(inst, 10, 20))
+
+		events := readEvents(&m)
+		if assert.Len(t, events, 7) {
+			assert.Equal(t, &TradingHaltEvent{BaseEvent: be(time.Date(2010, 1, 5, 10, 5, 0, 0, time.UTC), inst),
+				Reason: "No trades"}, events[2])
+			assert.Equal(t, time.Date(2010, 1, 5, 10, 7, 0, 0, time.UTC), events[3].getTime())
+			assert.Equal(t, &TradingHaltEvent{BaseEvent: be(time.Date(2010, 1, 5, 10, 12, 0, 0, time.UTC), other),
+				Reason: "No trades"}, events[4])
+			assert.IsType(t, &TradingResumeEvent{}, events[5])
+			assert.Equal(t, "Test", events[5].getSymbol())
+		}
+	}
+
+	t.Log("Gap through market close isn't halt")
+	{
+		m := BTM{HaltDetectionGap: 5 * time.Minute, mdChan: make(chan event, 10)}
+		m.newTickEvent(nil, tickAt(inst, 15, 58))
+		m.newTickEvent(nil, tickAt(inst, 16, 30))
+		assert.Len(t, readEvents(&m), 2)
+	}
+}
+
+func TestHalts_DetectedHaltStopsExecutions(t *testing.T) {
+	t.Log("Resting order isn't filled by quote tick inside detected halt")
+	{
+		inst := newTestInstrument()
+		m := BTM{HaltDetectionGap: 5 * time.Minute, mdChan: make(chan event, 10)}
+		for _, raw := range []*marketdata.Tick{
+			{Datetime: time.Date(2010, 1, 5, 10, 1, 0, 0, time.UTC), Symbol: "Test", LastPrice: 20.1, LastSize: 100},
+			{Datetime: time.Date(2010, 1, 5, 10, 10, 0, 0, time.UTC), Symbol: "Test", LastPrice: 20.1,
+				BidPrice: 19.8, AskPrice: 19.9, BidSize: 100, AskSize: 100},
+		} {
+			tick := Tick{Tick: raw, Ticker: inst}
+			m.newTickEvent(nil, &NewTickEvent{BaseEvent: be(raw.Datetime, inst), Tick: &tick})
+		}
+		close(m.mdChan)
+
+		b := newTestSimBrokerWorker()
+		b.events = make(chan event, 10)
+		order := newTestGtcBrokerOrder(math.NaN(), OrderBuy, 100, "dh1")
+		order.Type = MarketOrder
+		order.StateUpdTime = time.Date(2010, 1, 5, 10, 2, 0, 0, time.UTC)
+		b.orders[order.Id] = order
+
+		var kinds []string
+		for e := range m.mdChan {
+			kinds = append(kinds, e.getName())
+			b.notify(e)
+		}
+		assert.Equal(t, []string{"NewTickEvent", "TradingHaltEvent", "NewTickEvent"}, kinds)
+		assert.True(t, b.halted)
+		assert.Equal(t, ConfirmedOrder, order.BrokerState)
+	}
+}
+
+func TestSimulatedBroker_Halts(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	b.events = make(chan event, 10)
+	tm := newTestOrderTime()
+
+	t.Log("Sim broker: halt and resume change worker state")
+	{
+		b.onTradingHalt(&TradingHaltEvent{BaseEvent: be(tm, b.symbol), Reason: "T1"})
+		assert.True(t, b.halted)
+		b.onTradingResume(&TradingResumeEvent{BaseEvent: be(tm.Add(time.Minute), b.symbol)})
+		assert.False(t, b.halted)
+		assert.True(t, b.reopening)
+		assert.Len(t, b.events, 2)
+		b.events = make(chan event)
+		b.reopening = false
+	}
+
+	t.Log("Sim broker: immediate orders are rejected during halt")
+	{
+		b.halted = true
+		order := newTestOrder(20, OrderBuy, 100, "h1")
+		order.Tif = IOCTIF
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, order)
+		assert.IsType(t, &OrderRejectedEvent{}, v)
+
+		order = newTestOrder(20, OrderBuy, 100, "h2")
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, order)
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+		b.generatedEvents = eventArray{}
+	}
+
+	t.Log("Sim broker: orders aren't filled during halt")
+	{
+		order := newTestGtcBrokerOrder(20.01, OrderBuy, 300, "h3")
+		tick := marketdata.Tick{Datetime: tm.Add(time.Second), Symbol: "Test", LastPrice: 20.00, LastSize: 500}
+		events, errors := putOrderAndFillOnTick(b, order, &tick)
+		assert.Len(t, errors, 0)
+		assert.Len(t, events, 0)
+		b.halted = false
+	}
+
+	t.Log("Sim broker: queued orders are filled on reopening cross at cross price")
+	{
+		b.reopening = true
+		b.auctionParticipation = 0.5
+		order := newTestGtcBrokerOrder(20.5, OrderBuy, 300, "h4")
+		tick := marketdata.Tick{Datetime: tm.Add(time.Second), Symbol: "Test", LastPrice: 20.2, LastSize: 400}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			assert.Equal(t, 20.2, events[0].(*OrderFillEvent).Price)
+			assert.Equal(t, int64(200), events[0].(*OrderFillEvent).Qty)
+		}
+		assert.Equal(t, PartialFilledOrder, order.BrokerState)
+		assert.False(t, b.reopening)
+	}
+
+	t.Log("Sim broker: limit order out of cross price isn't filled on reopening")
+	{
+		b.reopening = true
+		order := newTestGtcBrokerOrder(20.1, OrderBuy, 300, "h5")
+		tick := marketdata.Tick{Datetime: tm.Add(time.Second), Symbol: "Test", LastPrice: 20.2, LastSize: 400}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		assert.Len(t, events, 0)
+		assert.False(t, b.reopening)
+	}
+}
+
+func TestSimulatedBroker_LULD(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	b.luldTier = 2
+	tm := newTestOrderTime()
+	trade := func(t time.Time, price float64) *Tick {
+		return &Tick{Tick: &marketdata.Tick{Datetime: t, Symbol: "Test", LastPrice: price, LastSize: 100}}
+	}
+
+	t.Log("Sim broker: reference price is average of the last 5 minutes trades")
+	{
+		b.updateLULD(trade(tm.Add(-10*time.Minute), 50))
+		b.updateLULD(trade(tm.Add(-4*time.Minute), 9.9))
+		b.updateLULD(trade(tm.Add(-time.Second), 10.1))
+		assert.InDelta(t, 10.0, b.luldReference, 0.000001)
+
+		lower, upper, ok := b.luldBands(tm)
+		assert.True(t, ok)
+		assert.InDelta(t, 9.0, lower, 0.000001)
+		assert.InDelta(t, 11.0, upper, 0.000001)
+
+		lower, upper, _ = b.luldBands(time.Date(2010, 1, 5, 9, 40, 0, 0, time.UTC))
+		assert.InDelta(t, 8.0, lower, 0.000001)
+		assert.InDelta(t, 12.0, upper, 0.000001)
+	}
+
+	t.Log("Sim broker: band percents")
+	{
+		assert.Equal(t, 0.05, luldPercent(1, 10))
+		assert.Equal(t, 0.1, luldPercent(2, 10))
+		assert.Equal(t, 0.2, luldPercent(1, 2))
+		assert.InDelta(t, 0.3, luldPercent(2, 0.5), 0.000001)
+		assert.Equal(t, 0.75, luldPercent(2, 0.1))
+	}
+
+	t.Log("Sim broker: limit orders out of bands are rejected")
+	{
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(11.5, OrderBuy, 100, "l1"))
+		assert.IsType(t, &OrderRejectedEvent{}, v)
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(8.9, OrderSell, 100, "l2"))
+		assert.IsType(t, &OrderRejectedEvent{}, v)
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(8.9, OrderBuy, 100, "l3"))
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+
+		market := newTestOrder(math.NaN(), OrderBuy, 100, "l4")
+		market.Type = MarketOrder
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, market)
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+	}
+
+	t.Log("Sim broker: replace to price out of bands is rejected")
+	{
+		b.orders["l3"].BrokerState = ConfirmedOrder
+		b.onReplaceRequest(&OrderReplaceRequestEvent{BaseEvent: be(tm, b.symbol), OrdId: "l3", NewPrice: 11.5})
+		assert.IsType(t, &OrderReplaceRejectEvent{}, b.generatedEvents[len(b.generatedEvents)-1])
+		b.onReplaceRequest(&OrderReplaceRequestEvent{BaseEvent: be(tm, b.symbol), OrdId: "l3", NewPrice: 10.5})
+		assert.IsType(t, &OrderReplacedEvent{}, b.generatedEvents[len(b.generatedEvents)-1])
+	}
+}
+
+func TestBasicStrategy_Halts(t *testing.T) {
+	us := haltTestStrategy{}
+	bs := BasicStrategy{symbol: newTestInstrument(), nPeriods: 20, userStrategy: &us}
+	bs.init(CoreStrategyChannels{
+		errors:    make(chan error),
+		events:    make(chan event),
+		portfolio: make(chan *PortfolioNewPositionEvent, 5),
+	})
+	bs.mdChan = make(chan event, 1)
+	bs.mdChan <- &NewTickEvent{}
+	bs.handlersWaitGroup = &sync.WaitGroup{}
+	tm := newTestOrderTime()
+
+	t.Log("Halt and resume are delivered to strategy")
+	{
+		bs.notify(&TradingHaltEvent{BaseEvent: be(tm, bs.symbol), Reason: "LUDP"})
+		bs.handlersWaitGroup.Wait()
+		assert.True(t, bs.IsHalted())
+		assert.Equal(t, []string{"LUDP"}, us.reasons)
+
+		bs.notify(&TradingResumeEvent{BaseEvent: be(tm.Add(5*time.Minute), bs.symbol)})
+		bs.handlersWaitGroup.Wait()
+		assert.False(t, bs.IsHalted())
+		assert.Equal(t, 1, us.resumes)
+		assert.Equal(t, tm.Add(5*time.Minute), bs.Now())
+	}
+}
@@ -0,0 +1,198 @@
+package indicators
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func testPrices(n int) []float64 {
+	r := rand.New(rand.NewSource(1))
+	prices := []float64{100}
+	for i := 1; i < n; i++ {
+		prices = append(prices, prices[i-1]*(1+r.NormFloat64()*0.01))
+	}
+	return prices
+}
+
+func bruteSMA(values []float64) float64 {
+	s := 0.0
+	for _, v := range values {
+		s += v
+	}
+	return s / float64(len(values))
+}
+
+func TestMovingAverages(t *testing.T) {
+	prices := testPrices(200)
+	period := 10
+
+	t.Log("SMA, WMA and StdDev are equal to brute force calculation")
+	{
+		sma := NewSMA(period)
+		wma := NewWMA(period)
+		std := NewStdDev(period)
+		for i, p := range prices {
+			sma.Update(p)
+			wma.Update(p)
+			std.Update(p)
+			if i < period-1 {
+				assert.False(t, sma.Ready())
+				assert.True(t, math.IsNaN(sma.Value()))
+				assert.True(t, math.IsNaN(wma.Value()))
+				continue
+			}
+			w := prices[i-period+1 : i+1]
+			mean := bruteSMA(w)
+			assert.InDelta(t, mean, sma.Value(), 1e-9)
+
+			weighted, variance := 0.0, 0.0
+			for j, v := range w {
+				weighted += float64(j+1) * v
+				variance += (v - mean) * (v - mean)
+			}
+			assert.InDelta(t, weighted/float64(period*(period+1)/2), wma.Value(), 1e-9)
+			assert.InDelta(t, math.Sqrt(variance/float64(period)), std.Value(), 1e-6)
+		}
+	}
+
+	t.Log("EMA starts from SMA")
+	{
+		ema := NewEMA(3)
+		ema.Update(1)
+		ema.Update(2)
+		assert.True(t, math.IsNaN(ema.Value()))
+		ema.Update(3)
+		assert.Equal(t, 2.0, ema.Value())
+		ema.Update(6)
+		assert.Equal(t, 4.0, ema.Value())
+	}
+
+	t.Log("ZScore")
+	{
+		z := NewZScore(4)
+		for _, v := range []float64{1, 1, 1, 1} {
+			z.Update(v)
+		}
+		assert.Equal(t, 0.0, z.Value())
+		z.Update(5)
+		//Window 1, 1, 1, 5: mean 2, std sqrt(3)
+		assert.InDelta(t, 3/math.Sqrt(3), z.Value(), 1e-9)
+	}
+}
+
+func TestOscillators(t *testing.T) {
+	tm := time.Date(2010, 1, 5, 10, 0, 0, 0, time.UTC)
+
+	t.Log("RSI: only gains and wilder smoothing")
+	{
+		rsi := NewRSI(3)
+		for _, v := range []float64{1, 2, 3, 4} {
+			rsi.Update(v)
+		}
+		assert.Equal(t, 100.0, rsi.Value())
+		rsi.Update(1)
+		//avg gain = (1*2 + 0) / 3, avg loss = (0*2 + 3) / 3
+		assert.InDelta(t, 100-100/(1+(2.0/3)/1.0), rsi.Value(), 1e-9)
+	}
+
+	t.Log("MACD: signal is ready after slow and signal periods")
+	{
+		macd := NewMACD(2, 3, 2)
+		for i, v := range []float64{1, 2, 3, 4} {
+			macd.Update(v)
+			assert.Equal(t, i == 3, macd.Ready())
+		}
+		assert.InDelta(t, macd.MACD()-macd.Signal(), macd.Histogram(), 1e-12)
+	}
+
+	t.Log("Stochastic and Donchian use rolling high and low")
+	{
+		st := NewStochastic(3, 2)
+		dc := NewDonchian(3)
+		bars := [][3]float64{{10, 8, 9}, {12, 9, 11}, {11, 7, 8}, {10, 9, 10}, {9, 8.5, 9}}
+		for _, b := range bars {
+			st.OnCandle(tm, b[2], b[0], b[1], b[2], 100)
+			dc.OnCandle(tm, b[2], b[0], b[1], b[2], 100)
+		}
+		//Last 3 bars: high 11, low 7
+		assert.Equal(t, 11.0, dc.Upper())
+		assert.Equal(t, 7.0, dc.Lower())
+		assert.Equal(t, 9.0, dc.Middle())
+		assert.InDelta(t, 100*(9-7)/4.0, st.K(), 1e-9)
+		assert.InDelta(t, (100*(10-7)/5.0+100*(9-7)/4.0)/2, st.D(), 1e-9)
+	}
+
+	t.Log("ADX: strong trend has high ADX and +DI above -DI")
+	{
+		adx := NewADX(5)
+		for i := 0; i < 30; i++ {
+			p := 100 + float64(i)
+			adx.OnCandle(tm, p, p+1, p-1, p+0.5, 100)
+		}
+		assert.True(t, adx.Ready())
+		assert.True(t, adx.PlusDI() > adx.MinusDI())
+		assert.True(t, adx.Value() > 90)
+	}
+
+	t.Log("ADX: constant candles have no direction, ADX recovers when price moves")
+	{
+		adx := NewADX(5)
+		for i := 0; i < 20; i++ {
+			adx.OnCandle(tm, 100, 100, 100, 100, 100)
+		}
+		assert.True(t, adx.Ready())
+		assert.Equal(t, 0.0, adx.Value())
+		assert.True(t, math.IsNaN(adx.PlusDI()))
+
+		for i := 0; i < 30; i++ {
+			p := 100 + float64(i)
+			adx.OnCandle(tm, p, p+1, p-1, p+0.5, 100)
+		}
+		assert.False(t, math.IsNaN(adx.Value()))
+		assert.True(t, adx.Value() > 50)
+	}
+}
+
+func TestVolatilityAndVolume(t *testing.T) {
+	tm := time.Date(2010, 1, 5, 10, 0, 0, 0, time.UTC)
+
+	t.Log("ATR uses previous close")
+	{
+		atr := NewATR(2)
+		atr.OnCandle(tm, 10, 11, 9, 10, 100)
+		atr.OnCandle(tm, 13, 14, 13, 13.5, 100)
+		assert.Equal(t, 3.0, atr.Value())
+		atr.OnCandle(tm, 13, 13.5, 12.5, 13, 100)
+		assert.Equal(t, 2.0, atr.Value())
+	}
+
+	t.Log("Bollinger bands")
+	{
+		bb := NewBollinger(4, 2)
+		for _, v := range []float64{1, 1, 1, 5} {
+			bb.Update(v)
+		}
+		assert.Equal(t, 2.0, bb.Middle())
+		assert.InDelta(t, 2+2*math.Sqrt(3), bb.Upper(), 1e-9)
+		assert.InDelta(t, 2-2*math.Sqrt(3), bb.Lower(), 1e-9)
+	}
+
+	t.Log("VWAP is reset on new day, OBV follows price direction")
+	{
+		vwap := NewVWAP()
+		obv := NewOBV()
+		vwap.OnTrade(tm, 10, 100)
+		vwap.OnTrade(tm.Add(time.Minute), 20, 300)
+		assert.Equal(t, 17.5, vwap.Value())
+		vwap.OnTrade(tm.AddDate(0, 0, 1), 30, 100)
+		assert.Equal(t, 30.0, vwap.Value())
+
+		for _, tr := range [][2]float64{{10, 100}, {11, 200}, {10.5, 50}, {10.5, 70}} {
+			obv.OnTrade(tm, tr[0], int64(tr[1]))
+		}
+		assert.Equal(t, int64(150), obv.Value())
+	}
+}
@@ -0,0 +1,212 @@
+package indicators
+
+import (
+	"math"
+	"time"
+)
+
+//SMA is simple moving average of close prices
+type SMA struct {
+	w   *window
+	sum float64
+}
+
+func NewSMA(period int) *SMA {
+	return &SMA{w: newWindow(period)}
+}
+
+func (i *SMA) Update(v float64) {
+	old, evicted := i.w.push(v)
+	i.sum += v
+	if evicted {
+		i.sum -= old
+	}
+}
+
+func (i *SMA) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.Update(close)
+}
+
+func (i *SMA) OnTrade(t time.Time, price float64, size int64) {
+	i.Update(price)
+}
+
+func (i *SMA) Ready() bool {
+	return i.w.full()
+}
+
+//Value returns NaN until indicator has period values
+func (i *SMA) Value() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	return i.sum / float64(len(i.w.values))
+}
+
+//EMA is exponential moving average with alpha = 2/(period+1). It starts from SMA of the first period values
+type EMA struct {
+	period int
+	alpha  float64
+	count  int
+	sum    float64
+	value  float64
+}
+
+func NewEMA(period int) *EMA {
+	if period <= 0 {
+		panic("Indicator period should be positive")
+	}
+	return &EMA{period: period, alpha: 2 / (float64(period) + 1)}
+}
+
+func (i *EMA) Update(v float64) {
+	i.count++
+	if i.count <= i.period {
+		i.sum += v
+		if i.count == i.period {
+			i.value = i.sum / float64(i.period)
+		}
+		return
+	}
+	i.value += i.alpha * (v - i.value)
+}
+
+func (i *EMA) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.Update(close)
+}
+
+func (i *EMA) OnTrade(t time.Time, price float64, size int64) {
+	i.Update(price)
+}
+
+func (i *EMA) Ready() bool {
+	return i.count >= i.period
+}
+
+func (i *EMA) Value() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	return i.value
+}
+
+//WMA is linearly weighted moving average. The most recent value has weight period
+type WMA struct {
+	w        *window
+	sum      float64
+	weighted float64
+}
+
+func NewWMA(period int) *WMA {
+	return &WMA{w: newWindow(period)}
+}
+
+func (i *WMA) Update(v float64) {
+	n := float64(len(i.w.values))
+	k := float64(i.w.n)
+	prevSum := i.sum
+	old, evicted := i.w.push(v)
+	if evicted {
+		i.weighted += n*v - prevSum
+		i.sum += v - old
+		return
+	}
+	i.weighted += (k + 1) * v
+	i.sum += v
+}
+
+func (i *WMA) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.Update(close)
+}
+
+func (i *WMA) OnTrade(t time.Time, price float64, size int64) {
+	i.Update(price)
+}
+
+func (i *WMA) Ready() bool {
+	return i.w.full()
+}
+
+func (i *WMA) Value() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	n := float64(len(i.w.values))
+	return i.weighted / (n * (n + 1) / 2)
+}
+
+//StdDev is population standard deviation of the last period values
+type StdDev struct {
+	w     *window
+	sum   float64
+	sumSq float64
+	last  float64
+}
+
+func NewStdDev(period int) *StdDev {
+	return &StdDev{w: newWindow(period)}
+}
+
+func (i *StdDev) Update(v float64) {
+	old, evicted := i.w.push(v)
+	i.sum += v
+	i.sumSq += v * v
+	if evicted {
+		i.sum -= old
+		i.sumSq -= old * old
+	}
+	i.last = v
+}
+
+func (i *StdDev) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.Update(close)
+}
+
+func (i *StdDev) OnTrade(t time.Time, price float64, size int64) {
+	i.Update(price)
+}
+
+func (i *StdDev) Ready() bool {
+	return i.w.full()
+}
+
+func (i *StdDev) Mean() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	return i.sum / float64(len(i.w.values))
+}
+
+func (i *StdDev) Value() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	n := float64(len(i.w.values))
+	mean := i.sum / n
+	variance := i.sumSq/n - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	return math.Sqrt(variance)
+}
+
+//ZScore is distance of the last value from rolling mean in standard deviations
+type ZScore struct {
+	StdDev
+}
+
+func NewZScore(period int) *ZScore {
+	return &ZScore{StdDev{w: newWindow(period)}}
+}
+
+//Value returns NaN until ready and 0 when all values in window are equal
+func (i *ZScore) Value() float64 {
+	std := i.StdDev.Value()
+	if math.IsNaN(std) {
+		return math.NaN()
+	}
+	if std == 0 {
+		return 0
+	}
+	return (i.last - i.Mean()) / std
+}
@@ -0,0 +1,218 @@
+package indicators
+
+import (
+	"math"
+	"time"
+)
+
+//RSI is relative strength index with Wilder's smoothing
+type RSI struct {
+	gain    wilder
+	loss    wilder
+	prev    float64
+	hasPrev bool
+}
+
+func NewRSI(period int) *RSI {
+	if period <= 0 {
+		panic("Indicator period should be positive")
+	}
+	return &RSI{gain: wilder{period: period}, loss: wilder{period: period}}
+}
+
+func (i *RSI) Update(v float64) {
+	if !i.hasPrev {
+		i.prev = v
+		i.hasPrev = true
+		return
+	}
+	change := v - i.prev
+	i.prev = v
+	i.gain.update(math.Max(change, 0))
+	i.loss.update(math.Max(-change, 0))
+}
+
+func (i *RSI) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.Update(close)
+}
+
+func (i *RSI) OnTrade(t time.Time, price float64, size int64) {
+	i.Update(price)
+}
+
+func (i *RSI) Ready() bool {
+	return i.gain.ready()
+}
+
+func (i *RSI) Value() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	if i.loss.value == 0 {
+		if i.gain.value == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+i.gain.value/i.loss.value)
+}
+
+//MACD is difference of fast and slow EMA with signal EMA of this difference
+type MACD struct {
+	fast   *EMA
+	slow   *EMA
+	signal *EMA
+}
+
+func NewMACD(fast int, slow int, signal int) *MACD {
+	return &MACD{fast: NewEMA(fast), slow: NewEMA(slow), signal: NewEMA(signal)}
+}
+
+func (i *MACD) Update(v float64) {
+	i.fast.Update(v)
+	i.slow.Update(v)
+	if i.fast.Ready() && i.slow.Ready() {
+		i.signal.Update(i.MACD())
+	}
+}
+
+func (i *MACD) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.Update(close)
+}
+
+func (i *MACD) OnTrade(t time.Time, price float64, size int64) {
+	i.Update(price)
+}
+
+func (i *MACD) Ready() bool {
+	return i.signal.Ready()
+}
+
+func (i *MACD) MACD() float64 {
+	return i.fast.Value() - i.slow.Value()
+}
+
+func (i *MACD) Signal() float64 {
+	return i.signal.Value()
+}
+
+func (i *MACD) Histogram() float64 {
+	return i.MACD() - i.Signal()
+}
+
+//Stochastic oscillator. %K is position of close in high-low range of kPeriod bars, %D is SMA of %K
+type Stochastic struct {
+	highs *extremum
+	lows  *extremum
+	k     float64
+	d     *SMA
+}
+
+func NewStochastic(kPeriod int, dPeriod int) *Stochastic {
+	return &Stochastic{highs: newExtremum(kPeriod, true), lows: newExtremum(kPeriod, false), k: math.NaN(),
+		d: NewSMA(dPeriod)}
+}
+
+func (i *Stochastic) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.highs.push(high)
+	i.lows.push(low)
+	if !i.highs.ready() {
+		return
+	}
+	hh, ll := i.highs.value(), i.lows.value()
+	if hh == ll {
+		i.k = 50
+	} else {
+		i.k = 100 * (close - ll) / (hh - ll)
+	}
+	i.d.Update(i.k)
+}
+
+func (i *Stochastic) Ready() bool {
+	return i.d.Ready()
+}
+
+func (i *Stochastic) K() float64 {
+	return i.k
+}
+
+func (i *Stochastic) D() float64 {
+	return i.d.Value()
+}
+
+//ADX is average directional index with +DI and -DI lines. All values use Wilder's smoothing
+type ADX struct {
+	period    int
+	tr        wilder
+	plusDM    wilder
+	minusDM   wilder
+	adx       wilder
+	prevHigh  float64
+	prevLow   float64
+	prevClose float64
+	hasPrev   bool
+}
+
+func NewADX(period int) *ADX {
+	if period <= 0 {
+		panic("Indicator period should be positive")
+	}
+	return &ADX{period: period, tr: wilder{period: period}, plusDM: wilder{period: period},
+		minusDM: wilder{period: period}, adx: wilder{period: period}}
+}
+
+func (i *ADX) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	if !i.hasPrev {
+		i.prevHigh, i.prevLow, i.prevClose = high, low, close
+		i.hasPrev = true
+		return
+	}
+	up := high - i.prevHigh
+	down := i.prevLow - low
+	plus, minus := 0.0, 0.0
+	if up > down && up > 0 {
+		plus = up
+	}
+	if down > up && down > 0 {
+		minus = down
+	}
+	i.tr.update(trueRange(high, low, i.prevClose))
+	i.plusDM.update(plus)
+	i.minusDM.update(minus)
+	i.prevHigh, i.prevLow, i.prevClose = high, low, close
+
+	if i.tr.ready() {
+		//Without range DI lines are undefined and there is no direction
+		dx := 0.0
+		plusDI, minusDI := i.PlusDI(), i.MinusDI()
+		if !math.IsNaN(plusDI) && !math.IsNaN(minusDI) && plusDI+minusDI != 0 {
+			dx = 100 * math.Abs(plusDI-minusDI) / (plusDI + minusDI)
+		}
+		i.adx.update(dx)
+	}
+}
+
+func (i *ADX) Ready() bool {
+	return i.adx.ready()
+}
+
+func (i *ADX) PlusDI() float64 {
+	if !i.tr.ready() || i.tr.value == 0 {
+		return math.NaN()
+	}
+	return 100 * i.plusDM.value / i.tr.value
+}
+
+func (i *ADX) MinusDI() float64 {
+	if !i.tr.ready() || i.tr.value == 0 {
+		return math.NaN()
+	}
+	return 100 * i.minusDM.value / i.tr.value
+}
+
+func (i *ADX) Value() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	return i.adx.value
+}
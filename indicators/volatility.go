@@ -0,0 +1,119 @@
+package indicators
+
+import (
+	"math"
+	"time"
+)
+
+func trueRange(high, low, prevClose float64) float64 {
+	return math.Max(high-low, math.Max(math.Abs(high-prevClose), math.Abs(low-prevClose)))
+}
+
+//Bollinger bands: SMA of period values plus/minus k standard deviations
+type Bollinger struct {
+	std *StdDev
+	k   float64
+}
+
+func NewBollinger(period int, k float64) *Bollinger {
+	return &Bollinger{std: NewStdDev(period), k: k}
+}
+
+func (i *Bollinger) Update(v float64) {
+	i.std.Update(v)
+}
+
+func (i *Bollinger) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.Update(close)
+}
+
+func (i *Bollinger) OnTrade(t time.Time, price float64, size int64) {
+	i.Update(price)
+}
+
+func (i *Bollinger) Ready() bool {
+	return i.std.Ready()
+}
+
+func (i *Bollinger) Middle() float64 {
+	return i.std.Mean()
+}
+
+func (i *Bollinger) Upper() float64 {
+	return i.std.Mean() + i.k*i.std.Value()
+}
+
+func (i *Bollinger) Lower() float64 {
+	return i.std.Mean() - i.k*i.std.Value()
+}
+
+//ATR is average true range with Wilder's smoothing. True range of the first bar is high - low
+type ATR struct {
+	tr        wilder
+	prevClose float64
+	hasPrev   bool
+}
+
+func NewATR(period int) *ATR {
+	if period <= 0 {
+		panic("Indicator period should be positive")
+	}
+	return &ATR{tr: wilder{period: period}}
+}
+
+func (i *ATR) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	tr := high - low
+	if i.hasPrev {
+		tr = trueRange(high, low, i.prevClose)
+	}
+	i.tr.update(tr)
+	i.prevClose = close
+	i.hasPrev = true
+}
+
+func (i *ATR) Ready() bool {
+	return i.tr.ready()
+}
+
+func (i *ATR) Value() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	return i.tr.value
+}
+
+//Donchian channel: highest high and lowest low of the last period bars
+type Donchian struct {
+	highs *extremum
+	lows  *extremum
+}
+
+func NewDonchian(period int) *Donchian {
+	return &Donchian{highs: newExtremum(period, true), lows: newExtremum(period, false)}
+}
+
+func (i *Donchian) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.highs.push(high)
+	i.lows.push(low)
+}
+
+func (i *Donchian) OnTrade(t time.Time, price float64, size int64) {
+	i.highs.push(price)
+	i.lows.push(price)
+}
+
+func (i *Donchian) Ready() bool {
+	return i.highs.ready()
+}
+
+func (i *Donchian) Upper() float64 {
+	return i.highs.value()
+}
+
+func (i *Donchian) Lower() float64 {
+	return i.lows.value()
+}
+
+func (i *Donchian) Middle() float64 {
+	return (i.Upper() + i.Lower()) / 2
+}
@@ -0,0 +1,94 @@
+package indicators
+
+import (
+	"math"
+	"time"
+)
+
+//VWAP is volume weighted average price. It's reset when day of update time changes. Candles use typical price
+//(high + low + close) / 3
+type VWAP struct {
+	value  float64
+	volume float64
+	day    time.Time
+}
+
+func NewVWAP() *VWAP {
+	return &VWAP{}
+}
+
+func (i *VWAP) update(t time.Time, price float64, volume int64) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if !day.Equal(i.day) {
+		i.Reset()
+		i.day = day
+	}
+	if volume <= 0 {
+		return
+	}
+	i.value += price * float64(volume)
+	i.volume += float64(volume)
+}
+
+func (i *VWAP) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.update(t, (high+low+close)/3, volume)
+}
+
+func (i *VWAP) OnTrade(t time.Time, price float64, size int64) {
+	i.update(t, price, size)
+}
+
+func (i *VWAP) Reset() {
+	i.value = 0
+	i.volume = 0
+}
+
+func (i *VWAP) Ready() bool {
+	return i.volume > 0
+}
+
+func (i *VWAP) Value() float64 {
+	if !i.Ready() {
+		return math.NaN()
+	}
+	return i.value / i.volume
+}
+
+//OBV is on-balance volume: volume is added when price goes up and subtracted when price goes down
+type OBV struct {
+	value   int64
+	prev    float64
+	hasPrev bool
+}
+
+func NewOBV() *OBV {
+	return &OBV{}
+}
+
+func (i *OBV) update(price float64, volume int64) {
+	if i.hasPrev {
+		if price > i.prev {
+			i.value += volume
+		} else if price < i.prev {
+			i.value -= volume
+		}
+	}
+	i.prev = price
+	i.hasPrev = true
+}
+
+func (i *OBV) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.update(close, volume)
+}
+
+func (i *OBV) OnTrade(t time.Time, price float64, size int64) {
+	i.update(price, size)
+}
+
+func (i *OBV) Ready() bool {
+	return i.hasPrev
+}
+
+func (i *OBV) Value() int64 {
+	return i.value
+}
@@ -0,0 +1,105 @@
+package indicators
+
+import "math"
+
+//window is fixed size ring buffer of the last values
+type window struct {
+	values []float64
+	start  int
+	n      int
+}
+
+func newWindow(size int) *window {
+	if size <= 0 {
+		panic("Indicator period should be positive")
+	}
+	return &window{values: make([]float64, size)}
+}
+
+//push adds value and returns evicted value. evicted is false until window is full
+func (w *window) push(v float64) (old float64, evicted bool) {
+	size := len(w.values)
+	if w.n < size {
+		w.values[(w.start+w.n)%size] = v
+		w.n++
+		return math.NaN(), false
+	}
+	old = w.values[w.start]
+	w.values[w.start] = v
+	w.start = (w.start + 1) % size
+	return old, true
+}
+
+func (w *window) full() bool {
+	return w.n == len(w.values)
+}
+
+//extremum keeps max (or min) of the last period values with monotonic deque. Amortized O(1) per update
+type extremum struct {
+	period int
+	isMax  bool
+	idx    []int
+	vals   []float64
+	count  int
+}
+
+func newExtremum(period int, isMax bool) *extremum {
+	if period <= 0 {
+		panic("Indicator period should be positive")
+	}
+	return &extremum{period: period, isMax: isMax}
+}
+
+func (e *extremum) push(v float64) {
+	for len(e.vals) > 0 {
+		last := e.vals[len(e.vals)-1]
+		if (e.isMax && last > v) || (!e.isMax && last < v) {
+			break
+		}
+		e.vals = e.vals[:len(e.vals)-1]
+		e.idx = e.idx[:len(e.idx)-1]
+	}
+	e.vals = append(e.vals, v)
+	e.idx = append(e.idx, e.count)
+	e.count++
+	for e.idx[0] <= e.count-1-e.period {
+		e.vals = e.vals[1:]
+		e.idx = e.idx[1:]
+	}
+}
+
+func (e *extremum) value() float64 {
+	if len(e.vals) == 0 {
+		return math.NaN()
+	}
+	return e.vals[0]
+}
+
+func (e *extremum) ready() bool {
+	return e.count >= e.period
+}
+
+//wilder is Wilder's smoothing: simple average of the first period values and then
+//avg = (avg*(period-1) + v) / period
+type wilder struct {
+	period int
+	count  int
+	sum    float64
+	value  float64
+}
+
+func (w *wilder) update(v float64) {
+	w.count++
+	if w.count <= w.period {
+		w.sum += v
+		if w.count == w.period {
+			w.value = w.sum / float64(w.period)
+		}
+		return
+	}
+	w.value = (w.value*float64(w.period-1) + v) / float64(w.period)
+}
+
+func (w *wilder) ready() bool {
+	return w.count >= w.period
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Information driven bars are built from ticks by activity instead of time. Timeframe of such bars is
+//"kind:threshold", for example "tick:100", "vol:5000", "dollar:1000000", "range:0.5" or "renko:0.25".
+//Bar close time is time of the tick that completed it and is kept in Candle.CloseTime
+const (
+	infoBarTicks  = "tick"
+	infoBarVolume = "vol"
+	infoBarDollar = "dollar"
+	infoBarRange  = "range"
+	infoBarRenko  = "renko"
+)
+
+const infoBarEpsilon = 1e-9
+
+type infoBarSpec struct {
+	timeFrame string
+	kind      string
+	threshold float64
+}
+
+//parseInfoBar returns nil spec for timeframes which are not information driven bars
+func parseInfoBar(tf string) (*infoBarSpec, error) {
+	i := strings.Index(tf, ":")
+	if i < 0 {
+		return nil, nil
+	}
+	kind := tf[:i]
+	switch kind {
+	case infoBarTicks, infoBarVolume, infoBarDollar, infoBarRange, infoBarRenko:
+	default:
+		return nil, errors.New("Unknown bar type: " + tf)
+	}
+	threshold, err := strconv.ParseFloat(tf[i+1:], 64)
+	if err != nil || threshold <= 0 || math.IsInf(threshold, 0) || math.IsNaN(threshold) {
+		return nil, errors.New("Wrong bar threshold: " + tf)
+	}
+	if (kind == infoBarTicks || kind == infoBarVolume) && threshold != math.Trunc(threshold) {
+		return nil, errors.New("Bar threshold should be integer: " + tf)
+	}
+	return &infoBarSpec{timeFrame: tf, kind: kind, threshold: threshold}, nil
+}
+
+//infoBarState is bar of one symbol in progress. Renko keeps close of the last brick and its direction
+type infoBarState struct {
+	spec    *infoBarSpec
+	bar     *Candle
+	ticks   int64
+	dollars float64
+
+	lastTime  time.Time
+	renkoRef  float64
+	renkoDir  int
+	hasRef    bool
+	volume    int64
+	startTime time.Time
+}
+
+//onTrade returns events which go before tick and events which go after it. Bar completed by tick is closed
+//after the tick
+func (s *infoBarState) onTrade(t *Tick) ([]event, []event) {
+	if s.spec.kind == infoBarRenko {
+		return nil, s.onRenkoTrade(t)
+	}
+
+	var before, after []event
+	if s.bar != nil && s.spec.kind == infoBarRange {
+		high := math.Max(s.bar.High, t.LastPrice)
+		low := math.Min(s.bar.Low, t.LastPrice)
+		if high-low > s.spec.threshold+infoBarEpsilon {
+			before = append(before, s.close())
+		}
+	}
+
+	if s.bar == nil {
+		s.bar = newTickBar(t.Ticker, s.spec.timeFrame, t.Datetime, time.Time{}, t.LastPrice, t.LastSize)
+		s.ticks = 1
+		s.dollars = t.LastPrice * float64(t.LastSize)
+		before = append(before, &CandleOpenEvent{
+			BaseEvent:  be(t.Datetime, t.Ticker),
+			CandleTime: t.Datetime,
+			Price:      t.LastPrice,
+			TimeFrame:  s.spec.timeFrame,
+		})
+	} else {
+		updateBarWithTrade(s.bar, t.LastPrice, t.LastSize)
+		s.ticks++
+		s.dollars += t.LastPrice * float64(t.LastSize)
+	}
+	s.lastTime = t.Datetime
+
+	if s.isComplete() {
+		after = append(after, s.close())
+	}
+	return before, after
+}
+
+func (s *infoBarState) isComplete() bool {
+	switch s.spec.kind {
+	case infoBarTicks:
+		return float64(s.ticks) >= s.spec.threshold
+	case infoBarVolume:
+		return float64(s.bar.Volume) >= s.spec.threshold
+	case infoBarDollar:
+		return s.dollars >= s.spec.threshold
+	case infoBarRange:
+		return s.bar.High-s.bar.Low >= s.spec.threshold-infoBarEpsilon
+	}
+	return false
+}
+
+//close returns close event of bar in progress. Bar is closed at time of its last tick
+func (s *infoBarState) close() *CandleCloseEvent {
+	bar := s.bar
+	bar.CloseTime = s.lastTime
+	s.bar = nil
+	return &CandleCloseEvent{BaseEvent: be(bar.CloseTime, bar.Ticker), Candle: bar, TimeFrame: bar.TimeFrame}
+}
+
+//onRenkoTrade puts bricks when price moved for brick size from the last brick close. Reversal needs move for
+//two bricks. Volume of ticks between bricks goes to the first brick
+func (s *infoBarState) onRenkoTrade(t *Tick) []event {
+	if !s.hasRef {
+		s.renkoRef = t.LastPrice
+		s.hasRef = true
+	}
+	//Brick starts with the first tick after previous brick
+	if s.volume == 0 {
+		s.startTime = t.Datetime
+	}
+	s.volume += t.LastSize
+
+	size := s.spec.threshold
+	var events []event
+	for {
+		var open, close float64
+		switch {
+		case t.LastPrice >= s.renkoRef+size-infoBarEpsilon && s.renkoDir >= 0:
+			open, close = s.renkoRef, s.renkoRef+size
+			s.renkoDir = 1
+		case t.LastPrice <= s.renkoRef-size+infoBarEpsilon && s.renkoDir <= 0:
+			open, close = s.renkoRef, s.renkoRef-size
+			s.renkoDir = -1
+		case s.renkoDir > 0 && t.LastPrice <= s.renkoRef-2*size+infoBarEpsilon:
+			open, close = s.renkoRef-size, s.renkoRef-2*size
+			s.renkoDir = -1
+		case s.renkoDir < 0 && t.LastPrice >= s.renkoRef+2*size-infoBarEpsilon:
+			open, close = s.renkoRef+size, s.renkoRef+2*size
+			s.renkoDir = 1
+		default:
+			return events
+		}
+
+		brick := newTickBar(t.Ticker, s.spec.timeFrame, s.startTime, t.Datetime, open, s.volume)
+		brick.Close = close
+		brick.AdjClose = close
+		brick.High = math.Max(open, close)
+		brick.Low = math.Min(open, close)
+		events = append(events,
+			&CandleOpenEvent{BaseEvent: be(t.Datetime, t.Ticker), CandleTime: s.startTime, Price: open,
+				TimeFrame: s.spec.timeFrame},
+			&CandleCloseEvent{BaseEvent: be(t.Datetime, t.Ticker), Candle: brick, TimeFrame: s.spec.timeFrame})
+
+		s.renkoRef = close
+		s.volume = 0
+	}
+}
+
+//finish closes bar in progress at time of its last tick. Renko bricks are never incomplete
+func (s *infoBarState) finish() []event {
+	if s.bar == nil {
+		return nil
+	}
+	return []event{s.close()}
+}
+
+func updateBarWithTrade(bar *Candle, price float64, size int64) {
+	if price > bar.High {
+		bar.High = price
+	}
+	if price < bar.Low {
+		bar.Low = price
+	}
+	bar.Close = price
+	bar.AdjClose = price
+	bar.Volume += size
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseInfoBar(t *testing.T) {
+	spec, err := parseInfoBar("vol:5000")
+	assert.Nil(t, err)
+	assert.Equal(t, infoBarVolume, spec.kind)
+	assert.Equal(t, 5000.0, spec.threshold)
+
+	spec, err = parseInfoBar("5")
+	assert.Nil(t, err)
+	assert.Nil(t, spec)
+
+	for _, tf := range []string{"volume:10", "range:0", "tick:1.5", "renko:x", "dollar:-1"} {
+		_, err = parseInfoBar(tf)
+		assert.NotNil(t, err, tf)
+	}
+}
+
+//feedInfoBars passes ticks with prices and sizes one second apart and returns candle events
+func feedInfoBars(tf string, prices []float64, sizes []int64) []event {
+	inst := newTestInstrument()
+	a, err := newTickBarsAggregator([]string{tf}, false)
+	if err != nil {
+		panic(err)
+	}
+	tm := time.Date(2018, 3, 7, 10, 0, 0, 0, time.UTC)
+	var events []event
+	for i, p := range prices {
+		events = append(events, onTickEvents(a, newTestBarsTick(inst, tm.Add(time.Duration(i)*time.Second), p,
+			sizes[i]))...)
+	}
+	return append(events, a.finish()...)
+}
+
+func closedBars(events []event) CandleArray {
+	var res CandleArray
+	for _, e := range events {
+		if ce, ok := e.(*CandleCloseEvent); ok {
+			res = append(res, ce.Candle)
+		}
+	}
+	return res
+}
+
+func TestInfoBars(t *testing.T) {
+	tm := time.Date(2018, 3, 7, 10, 0, 0, 0, time.UTC)
+
+	t.Log("Tick bars")
+	{
+		events := feedInfoBars("tick:2", []float64{10, 11, 12, 13, 14}, []int64{100, 100, 100, 100, 100})
+		bars := closedBars(events)
+		if assert.Len(t, bars, 3) {
+			assert.Equal(t, 10.0, bars[0].Open)
+			assert.Equal(t, 11.0, bars[0].Close)
+			assert.Equal(t, tm, bars[0].Datetime)
+			assert.Equal(t, tm.Add(time.Second), bars[0].CloseTime)
+			assert.Equal(t, 14.0, bars[2].Close, "Incomplete bar is closed at the end")
+			assert.Equal(t, tm.Add(4*time.Second), bars[2].CloseTime)
+		}
+		assert.Equal(t, "tick:2", events[0].(*CandleOpenEvent).TimeFrame)
+	}
+
+	t.Log("Volume bars")
+	{
+		bars := closedBars(feedInfoBars("vol:300", []float64{10, 11, 12, 13}, []int64{100, 250, 300, 50}))
+		if assert.Len(t, bars, 3) {
+			assert.Equal(t, int64(350), bars[0].Volume)
+			assert.Equal(t, int64(300), bars[1].Volume)
+			assert.Equal(t, int64(50), bars[2].Volume)
+		}
+	}
+
+	t.Log("Dollar bars")
+	{
+		bars := closedBars(feedInfoBars("dollar:2000", []float64{10, 10, 20, 5}, []int64{100, 50, 100, 100}))
+		if assert.Len(t, bars, 2) {
+			assert.Equal(t, 20.0, bars[0].High)
+			assert.Equal(t, int64(250), bars[0].Volume)
+			assert.Equal(t, 5.0, bars[1].Open)
+		}
+	}
+
+	t.Log("Range bars: tick out of range starts new bar")
+	{
+		events := feedInfoBars("range:1", []float64{10, 10.5, 9.8, 11, 11.1}, []int64{1, 1, 1, 1, 1})
+		bars := closedBars(events)
+		if assert.Len(t, bars, 2) {
+			assert.Equal(t, 10.0, bars[0].Open)
+			assert.Equal(t, 9.8, bars[0].Close)
+			assert.Equal(t, 11.0, bars[1].Open)
+			assert.Equal(t, 11.1, bars[1].Close)
+		}
+		//Close of the first bar goes before open of the second bar
+		assert.Equal(t, []string{"O", "C", "O", "C"}, eventKinds(events))
+	}
+
+	t.Log("Range bars: bar is closed when range reached")
+	{
+		bars := closedBars(feedInfoBars("range:1", []float64{10, 10.5, 11, 11.2}, []int64{1, 1, 1, 1}))
+		if assert.Len(t, bars, 2) {
+			assert.Equal(t, 11.0, bars[0].High)
+			assert.Equal(t, 10.0, bars[0].Low)
+			assert.Equal(t, 11.2, bars[1].Open)
+		}
+	}
+
+	t.Log("Renko bricks with reversal of two bricks")
+	{
+		events := feedInfoBars("renko:1", []float64{10, 10.5, 12.1, 11.5, 10.9, 9.9},
+			[]int64{100, 100, 100, 100, 100, 100})
+		bars := closedBars(events)
+		if assert.Len(t, bars, 3) {
+			assert.Equal(t, []float64{10, 11}, []float64{bars[0].Open, bars[0].Close})
+			assert.Equal(t, []float64{11, 12}, []float64{bars[1].Open, bars[1].Close})
+			assert.Equal(t, int64(300), bars[0].Volume)
+			assert.Equal(t, int64(0), bars[1].Volume)
+			assert.Equal(t, tm, bars[0].Datetime)
+			assert.Equal(t, tm.Add(2*time.Second), bars[0].CloseTime)
+
+			assert.Equal(t, []float64{11, 10}, []float64{bars[2].Open, bars[2].Close})
+			assert.Equal(t, 11.0, bars[2].High)
+			assert.Equal(t, int64(300), bars[2].Volume)
+			assert.Equal(t, tm.Add(3*time.Second), bars[2].Datetime)
+		}
+	}
+
+	t.Log("Close event time is taken from bar")
+	{
+		bars := closedBars(feedInfoBars("tick:1", []float64{10}, []int64{100}))
+		e := CandleCloseEvent{Candle: bars[0], TimeFrame: "tick:1"}
+		e.setEventTimeFromCandle()
+		assert.Equal(t, tm, e.getTime())
+	}
+}
+
+func eventKinds(events []event) []string {
+	var res []string
+	for _, e := range events {
+		switch e.(type) {
+		case *CandleOpenEvent:
+			res = append(res, "O")
+		case *CandleCloseEvent:
+			res = append(res, "C")
+		}
+	}
+	return res
+}
@@ -0,0 +1,469 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+//JournalDivergence describes a point where replayed strategy emitted request that differs from recorded one
+type JournalDivergence struct {
+	Time     time.Time
+	Symbol   string
+	Expected event
+	Actual   event
+	Reason   string
+}
+
+func (d *JournalDivergence) String() string {
+	return fmt.Sprintf("%v [%v] %v. Expected: %v Actual: %v", d.Time, d.Symbol, d.Reason, d.Expected, d.Actual)
+}
+
+//JournalReplayer replays recorded event journal into fresh strategies. Market data and broker responses are
+//delivered exactly as recorded, strategy requests are compared with recorded ones and every mismatch is
+//stored as JournalDivergence. IMarketData and IBroker have different Init signatures, so replayer is used
+//through two facets: MarketData() and Broker()
+type JournalReplayer struct {
+	journal eventArray
+
+	errChan     chan error
+	mdChan      chan event
+	expected    map[string][]journalRequest
+	idsMap      map[string]string
+	divergences []*JournalDivergence
+	newRequest  chan struct{}
+	timeout     time.Duration
+	mut         *sync.Mutex
+	waitGroup   *sync.WaitGroup
+}
+
+//NewJournalReplayer creates replayer from one or few recorded journals (e.g. BasicStrategy.Journal()).
+//Events are merged and sorted by time. Order of events with the same time is kept
+func NewJournalReplayer(journals ...[]event) *JournalReplayer {
+	var all eventArray
+	for _, j := range journals {
+		all = append(all, j...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].getTime().Before(all[j].getTime())
+	})
+
+	r := JournalReplayer{
+		journal:    all,
+		expected:   make(map[string][]journalRequest),
+		idsMap:     make(map[string]string),
+		newRequest: make(chan struct{}, 1),
+		timeout:    2 * time.Second,
+		mut:        &sync.Mutex{},
+		waitGroup:  &sync.WaitGroup{},
+	}
+	return &r
+}
+
+//journalRequest is recorded strategy request and its index in journal
+type journalRequest struct {
+	index   int
+	request event
+}
+
+//MarketData returns IMarketData facet of replayer
+func (r *JournalReplayer) MarketData() IMarketData {
+	return &journalMarketData{r}
+}
+
+//Broker returns IBroker facet of replayer
+func (r *JournalReplayer) Broker() IBroker {
+	return &journalBroker{r}
+}
+
+//SetRequestTimeout sets how long replayer waits for strategy request before it delivers recorded broker
+//response on this request
+func (r *JournalReplayer) SetRequestTimeout(d time.Duration) {
+	r.timeout = d
+}
+
+//Divergences returns all found differences between recorded and replayed strategy requests
+func (r *JournalReplayer) Divergences() []*JournalDivergence {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	out := make([]*JournalDivergence, len(r.divergences))
+	copy(out, r.divergences)
+	return out
+}
+
+func (r *JournalReplayer) run() {
+	eodSent := false
+	for i, e := range r.journal {
+		switch e.(type) {
+		case *NewOrderEvent, *OrderCancelRequestEvent, *OrderReplaceRequestEvent:
+			continue
+		case *OrderConfirmationEvent, *OrderFillEvent, *OrderCancelEvent, *OrderCancelRejectEvent,
+			*OrderReplacedEvent, *OrderReplaceRejectEvent, *OrderRejectedEvent:
+			r.waitForRequests(i, e)
+			translated := r.translateResponse(e)
+			if translated == nil {
+				continue
+			}
+			r.mdChan <- translated
+		case *EndOfDataEvent:
+			r.checkMissingRequests()
+			r.mdChan <- e
+			eodSent = true
+		default:
+			r.mdChan <- e
+		}
+		if eodSent {
+			break
+		}
+	}
+
+	if !eodSent {
+		var lastTime time.Time
+		if len(r.journal) > 0 {
+			lastTime = r.journal[len(r.journal)-1].getTime()
+		}
+		r.checkMissingRequests()
+		r.mdChan <- &EndOfDataEvent{BaseEvent: be(lastTime, &Instrument{})}
+	}
+}
+
+//expectRecorded puts all recorded strategy requests till the end of data in queues before replay starts.
+//Replayed strategy reacts on market data asynchronously, so its request can come before run reaches the record
+func (r *JournalReplayer) expectRecorded() {
+	for i, e := range r.journal {
+		switch e.(type) {
+		case *NewOrderEvent, *OrderCancelRequestEvent, *OrderReplaceRequestEvent:
+			r.expect(i, e)
+		case *EndOfDataEvent:
+			return
+		}
+	}
+}
+
+//expect puts recorded strategy request with its journal index in the queue of requests we wait from replayed
+//strategy
+func (r *JournalReplayer) expect(index int, e event) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.expected[e.getSymbol()] = append(r.expected[e.getSymbol()], journalRequest{index: index, request: e})
+}
+
+//onRequest compares request from replayed strategy with the first not matched recorded request
+func (r *JournalReplayer) onRequest(e event) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	queue := r.expected[e.getSymbol()]
+	if len(queue) == 0 {
+		r.diverge(nil, e, "Strategy sent request that wasn't recorded")
+		return
+	}
+	recorded := queue[0].request
+	r.expected[e.getSymbol()] = queue[1:]
+
+	reason := r.compareRequests(recorded, e)
+	if reason != "" {
+		r.diverge(recorded, e, reason)
+		return
+	}
+
+	if rec, ok := recorded.(*NewOrderEvent); ok {
+		r.idsMap[rec.LinkedOrder.Id] = e.(*NewOrderEvent).LinkedOrder.Id
+	}
+
+	select {
+	case r.newRequest <- struct{}{}:
+	default:
+	}
+}
+
+//waitForRequests blocks until replayed strategy sends all requests related to the order of broker response
+//which were recorded before the response. Strategy reacts on market data asynchronously, so without it
+//responses can outrun requests
+func (r *JournalReplayer) waitForRequests(index int, response event) {
+	deadline := time.After(r.timeout)
+	for {
+		if !r.hasPendingRequests(index, response) {
+			return
+		}
+		select {
+		case <-r.newRequest:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func (r *JournalReplayer) hasPendingRequests(index int, response event) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	ordID := journalResponseOrderId(response)
+	for _, q := range r.expected[response.getSymbol()] {
+		if q.index > index {
+			break
+		}
+		switch i := q.request.(type) {
+		case *NewOrderEvent:
+			if i.LinkedOrder != nil && i.LinkedOrder.Id == ordID {
+				return true
+			}
+		case *OrderCancelRequestEvent:
+			if i.OrdId == ordID {
+				return true
+			}
+		case *OrderReplaceRequestEvent:
+			if i.OrdId == ordID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//compareRequests returns empty string if requests are the same or description of difference
+func (r *JournalReplayer) compareRequests(recorded event, actual event) string {
+	if recorded.getName() != actual.getName() {
+		return "Request type differs"
+	}
+
+	switch rec := recorded.(type) {
+	case *NewOrderEvent:
+		ro := rec.LinkedOrder
+		ao := actual.(*NewOrderEvent).LinkedOrder
+		if ro == nil || ao == nil {
+			return "Order is nil"
+		}
+		if ro.Side != ao.Side || ro.Qty != ao.Qty || ro.Type != ao.Type || ro.Tif != ao.Tif ||
+			ro.Destination != ao.Destination {
+			return fmt.Sprintf("Order params differ. Recorded: %+v Actual: %+v", *ro, *ao)
+		}
+		if !journalPricesEqual(ro.Price, ao.Price) {
+			return fmt.Sprintf("Order price differs. Recorded: %v Actual: %v", ro.Price, ao.Price)
+		}
+	case *OrderCancelRequestEvent:
+		act := actual.(*OrderCancelRequestEvent)
+		if r.idsMap[rec.OrdId] != act.OrdId {
+			return "Cancel request for different order"
+		}
+	case *OrderReplaceRequestEvent:
+		act := actual.(*OrderReplaceRequestEvent)
+		if r.idsMap[rec.OrdId] != act.OrdId {
+			return "Replace request for different order"
+		}
+		if !journalPricesEqual(rec.NewPrice, act.NewPrice) {
+			return fmt.Sprintf("Replace price differs. Recorded: %v Actual: %v", rec.NewPrice, act.NewPrice)
+		}
+		if rec.NewQty != act.NewQty || rec.NewTif != act.NewTif || rec.NewDestination != act.NewDestination {
+			return fmt.Sprintf("Replace params differ. Recorded: qty %v tif %v destination %v Actual: qty %v "+
+				"tif %v destination %v", rec.NewQty, rec.NewTif, rec.NewDestination, act.NewQty, act.NewTif,
+				act.NewDestination)
+		}
+	}
+
+	return ""
+}
+
+//translateResponse returns copy of recorded broker response with order ID used by replayed strategy.
+//Responses for orders that replayed strategy didn't send are skipped
+func (r *JournalReplayer) translateResponse(e event) event {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	switch i := e.(type) {
+	case *OrderConfirmationEvent:
+		c := *i
+		if c.OrdId = r.idsMap[i.OrdId]; c.OrdId != "" {
+			return &c
+		}
+	case *OrderFillEvent:
+		c := *i
+		if c.OrdId = r.idsMap[i.OrdId]; c.OrdId != "" {
+			return &c
+		}
+	case *OrderCancelEvent:
+		c := *i
+		if c.OrdId = r.idsMap[i.OrdId]; c.OrdId != "" {
+			return &c
+		}
+	case *OrderCancelRejectEvent:
+		c := *i
+		if c.OrdId = r.idsMap[i.OrdId]; c.OrdId != "" {
+			return &c
+		}
+	case *OrderReplacedEvent:
+		c := *i
+		if c.OrdId = r.idsMap[i.OrdId]; c.OrdId != "" {
+			return &c
+		}
+	case *OrderReplaceRejectEvent:
+		c := *i
+		if c.OrdId = r.idsMap[i.OrdId]; c.OrdId != "" {
+			return &c
+		}
+	case *OrderRejectedEvent:
+		c := *i
+		if c.OrdId = r.idsMap[i.OrdId]; c.OrdId != "" {
+			return &c
+		}
+	}
+
+	return nil
+}
+
+//checkMissingRequests marks all recorded requests that replayed strategy didn't send as divergences
+func (r *JournalReplayer) checkMissingRequests() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var symbols []string
+	for s := range r.expected {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+
+	for _, s := range symbols {
+		for _, q := range r.expected[s] {
+			r.diverge(q.request, nil, "Recorded request wasn't sent by strategy")
+		}
+		delete(r.expected, s)
+	}
+}
+
+func (r *JournalReplayer) diverge(recorded event, actual event, reason string) {
+	d := JournalDivergence{
+		Expected: recorded,
+		Actual:   actual,
+		Reason:   reason,
+	}
+	if actual != nil {
+		d.Time = actual.getTime()
+		d.Symbol = actual.getSymbol()
+	} else {
+		d.Time = recorded.getTime()
+		d.Symbol = recorded.getSymbol()
+	}
+	r.divergences = append(r.divergences, &d)
+	r.newError(fmt.Errorf("JournalReplayer: %v", d.String()))
+}
+
+func (r *JournalReplayer) newError(err error) {
+	if r.errChan == nil {
+		return
+	}
+	r.waitGroup.Add(1)
+	go func() {
+		r.errChan <- err
+		r.waitGroup.Done()
+	}()
+}
+
+func journalResponseOrderId(e event) string {
+	switch i := e.(type) {
+	case *OrderConfirmationEvent:
+		return i.OrdId
+	case *OrderFillEvent:
+		return i.OrdId
+	case *OrderCancelEvent:
+		return i.OrdId
+	case *OrderCancelRejectEvent:
+		return i.OrdId
+	case *OrderReplacedEvent:
+		return i.OrdId
+	case *OrderReplaceRejectEvent:
+		return i.OrdId
+	case *OrderRejectedEvent:
+		return i.OrdId
+	}
+	return ""
+}
+
+func journalPricesEqual(p1 float64, p2 float64) bool {
+	if math.IsNaN(p1) || math.IsNaN(p2) {
+		return math.IsNaN(p1) && math.IsNaN(p2)
+	}
+	return math.Abs(p1-p2) < 0.0000001
+}
+
+// $$$$$$$$$ JOURNAL MARKET DATA $$$$$$$$$$$$$$$$
+type journalMarketData struct {
+	*JournalReplayer
+}
+
+func (m *journalMarketData) Run() {
+	m.expectRecorded()
+	m.waitGroup.Add(1)
+	go func() {
+		m.run()
+		m.waitGroup.Done()
+	}()
+}
+
+func (m *journalMarketData) Connect() {
+	fmt.Println("Journal market data connected. ")
+}
+
+func (m *journalMarketData) Init(errChan chan error, mdChan chan event) {
+	if errChan == nil {
+		panic("Error chan is nil")
+	}
+
+	if mdChan == nil {
+		panic("Event chan is nil")
+	}
+	m.errChan = errChan
+	m.mdChan = mdChan
+}
+
+func (m *journalMarketData) SetSymbols(symbols []*Instrument) {
+
+}
+
+//SetClock does nothing. Replayed events keep recorded time and engine advances its clock with them
+func (m *journalMarketData) SetClock(clock Clock) {
+
+}
+
+func (m *journalMarketData) RequestHistoricalData(duration time.Duration) {
+
+}
+
+func (m *journalMarketData) ShutDown() {
+	m.waitGroup.Wait()
+}
+
+// $$$$$$$$$ JOURNAL BROKER $$$$$$$$$$$$$$$$
+type journalBroker struct {
+	*JournalReplayer
+}
+
+func (b *journalBroker) Connect() {
+	fmt.Println("Journal broker connected")
+}
+
+func (b *journalBroker) Disconnect() {
+	fmt.Println("Journal broker disconnected")
+}
+
+func (b *journalBroker) Init(errChan chan error, events chan event, symbols []*Instrument) {
+	b.errChan = errChan
+}
+
+func (b *journalBroker) IsSimulated() bool {
+	return false
+}
+
+func (b *journalBroker) Notify(e event) {
+	switch e.(type) {
+	case *NewOrderEvent, *OrderCancelRequestEvent, *OrderReplaceRequestEvent:
+		b.onRequest(e)
+	default:
+		panic("Unexpected event type in journal broker: " + e.getName())
+	}
+}
+
+func (b *journalBroker) shutDown() {
+	b.waitGroup.Wait()
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func newTestJournal() []event {
+	inst := newTestInstrument()
+	tm := newTestOrderTime()
+
+	ord := newTestOrder(10.05, OrderBuy, 100, "Test|B|rec1")
+	ord.Ticker = inst
+
+	return []event{
+		&NewTickEvent{BaseEvent: be(tm, inst)},
+		&NewOrderEvent{BaseEvent: be(tm.Add(time.Millisecond), inst), LinkedOrder: ord},
+		&OrderConfirmationEvent{BaseEvent: be(tm.Add(200*time.Millisecond), inst), OrdId: ord.Id},
+		&NewTickEvent{BaseEvent: be(tm.Add(time.Second), inst)},
+		&OrderFillEvent{BaseEvent: be(tm.Add(2*time.Second), inst), OrdId: ord.Id, Price: 10.05, Qty: 100},
+	}
+}
+
+func newTestJournalReplayer(journal []event) (*JournalReplayer, chan event, chan error) {
+	r := NewJournalReplayer(journal)
+	r.SetRequestTimeout(50 * time.Millisecond)
+	mdChan := make(chan event)
+	errChan := make(chan error, 100)
+	r.MarketData().Init(errChan, mdChan)
+	r.Broker().Init(errChan, make(chan event), []*Instrument{newTestInstrument()})
+	return r, mdChan, errChan
+}
+
+func TestJournalReplayer_Replay(t *testing.T) {
+	t.Log("Journal replayer: strategy sends the same request as recorded")
+	{
+		r, mdChan, _ := newTestJournalReplayer(newTestJournal())
+		md := r.MarketData()
+		md.Run()
+
+		e := <-mdChan
+		assert.IsType(t, &NewTickEvent{}, e)
+
+		live := newTestOrder(10.05, OrderBuy, 100, "Test|B|live1")
+		r.Broker().Notify(&NewOrderEvent{BaseEvent: be(e.getTime(), live.Ticker), LinkedOrder: live})
+
+		e = <-mdChan
+		if assert.IsType(t, &OrderConfirmationEvent{}, e) {
+			assert.Equal(t, "Test|B|live1", e.(*OrderConfirmationEvent).OrdId)
+		}
+		e = <-mdChan
+		assert.IsType(t, &NewTickEvent{}, e)
+		e = <-mdChan
+		if assert.IsType(t, &OrderFillEvent{}, e) {
+			assert.Equal(t, "Test|B|live1", e.(*OrderFillEvent).OrdId)
+			assert.Equal(t, int64(100), e.(*OrderFillEvent).Qty)
+		}
+		e = <-mdChan
+		assert.IsType(t, &EndOfDataEvent{}, e)
+
+		md.ShutDown()
+		assert.Len(t, r.Divergences(), 0)
+	}
+
+	t.Log("Journal replayer: request that comes before replay reaches its record isn't a divergence")
+	{
+		r, mdChan, _ := newTestJournalReplayer(newTestJournal())
+		md := r.MarketData()
+		md.Run()
+
+		live := newTestOrder(10.05, OrderBuy, 100, "Test|B|live1")
+		r.Broker().Notify(&NewOrderEvent{BaseEvent: be(live.Time, live.Ticker), LinkedOrder: live})
+
+		var received []event
+		for e := range mdChan {
+			received = append(received, e)
+			if _, ok := e.(*EndOfDataEvent); ok {
+				break
+			}
+		}
+		md.ShutDown()
+
+		assert.Len(t, received, 5)
+		assert.Len(t, r.Divergences(), 0)
+	}
+
+	t.Log("Journal replayer: strategy sends order with different price")
+	{
+		r, _, _ := newTestJournalReplayer(newTestJournal())
+		r.expect(1, newTestJournal()[1])
+
+		live := newTestOrder(10.1, OrderBuy, 100, "Test|B|live1")
+		r.Broker().Notify(&NewOrderEvent{BaseEvent: be(live.Time, live.Ticker), LinkedOrder: live})
+
+		d := r.Divergences()
+		assert.Len(t, d, 1)
+		assert.NotNil(t, d[0].Expected)
+		assert.NotNil(t, d[0].Actual)
+
+		//Responses for not matched orders are not delivered
+		conf := OrderConfirmationEvent{BaseEvent: be(live.Time, live.Ticker), OrdId: "Test|B|rec1"}
+		assert.Nil(t, r.translateResponse(&conf))
+	}
+
+	t.Log("Journal replayer: strategy sends request that wasn't recorded")
+	{
+		r, _, _ := newTestJournalReplayer(newTestJournal())
+		live := newTestOrder(math.NaN(), OrderSell, 100, "Test|S|live2")
+		live.Type = MarketOrder
+		r.Broker().Notify(&NewOrderEvent{BaseEvent: be(live.Time, live.Ticker), LinkedOrder: live})
+
+		d := r.Divergences()
+		assert.Len(t, d, 1)
+		assert.Nil(t, d[0].Expected)
+	}
+
+	t.Log("Journal replayer: strategy doesn't send recorded request")
+	{
+		r, mdChan, _ := newTestJournalReplayer(newTestJournal())
+		md := r.MarketData()
+		md.Run()
+
+		var received []event
+		for e := range mdChan {
+			received = append(received, e)
+			if _, ok := e.(*EndOfDataEvent); ok {
+				break
+			}
+		}
+		md.ShutDown()
+
+		//Only two ticks and end of data. Broker responses for unknown order are skipped
+		assert.Len(t, received, 3)
+		d := r.Divergences()
+		assert.Len(t, d, 1)
+		assert.Nil(t, d[0].Actual)
+		assert.IsType(t, &NewOrderEvent{}, d[0].Expected)
+	}
+}
+
+func TestJournalReplayer_CompareReplace(t *testing.T) {
+	r := NewJournalReplayer(nil)
+	r.idsMap["Test|B|rec1"] = "Test|B|live1"
+	inst := newTestInstrument()
+	tm := newTestOrderTime()
+	recorded := OrderReplaceRequestEvent{BaseEvent: be(tm, inst), OrdId: "Test|B|rec1", NewPrice: 10.1, NewQty: 200,
+		NewTif: DayTIF, NewDestination: "ARCA"}
+
+	t.Log("Journal replayer: replace with the same params matches")
+	{
+		actual := recorded
+		actual.OrdId = "Test|B|live1"
+		assert.Equal(t, "", r.compareRequests(&recorded, &actual))
+	}
+
+	t.Log("Journal replayer: replace with different qty, tif or destination differs")
+	{
+		for _, change := range []func(e *OrderReplaceRequestEvent){
+			func(e *OrderReplaceRequestEvent) { e.NewQty = 300 },
+			func(e *OrderReplaceRequestEvent) { e.NewTif = IOCTIF },
+			func(e *OrderReplaceRequestEvent) { e.NewDestination = "NSDQ" },
+		} {
+			actual := recorded
+			actual.OrdId = "Test|B|live1"
+			change(&actual)
+			assert.NotEqual(t, "", r.compareRequests(&recorded, &actual))
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"container/heap"
+	"github.com/pkg/errors"
+	"sort"
+	"time"
+)
+
+//Streaming market data is read from storage without prepaired file. Every symbol is a source which loads its
+//data by chunks: ticks by days, candles by months. Sources are merged by time on the fly, so only current
+//chunk of every symbol is kept in memory. Records of the same time go in order of timeframes, then in order of
+//symbols and then in order of source, the same as in prepaired file. Candles of timeframes built from smaller
+//timeframe may span chunks, so chunk is loaded up to the end of the bar which is building at its end, and only
+//bars opened in chunk are given. Storage errors are handled as in prepare: they are sent to errors channel and
+//records returned with them are used
+
+//streamCandlesChunkMonths is number of months of candles loaded by source at once
+const streamCandlesChunkMonths = 1
+
+type streamRecord struct {
+	time   time.Time
+	rank   int
+	tick   *marketdata.Tick
+	candle *Candle
+}
+
+type streamSource struct {
+	index   int
+	records []streamRecord
+	pos     int
+	next    time.Time
+	to      time.Time
+	step    func(t time.Time) time.Time
+	load    func(from, to time.Time) []streamRecord
+}
+
+//fill loads chunks until source has record or its range is over. Returns false if source is empty
+func (s *streamSource) fill() bool {
+	for s.pos >= len(s.records) {
+		if s.next.After(s.to) {
+			return false
+		}
+		from := s.next
+		s.next = s.step(from)
+		to := s.next.Add(-time.Nanosecond)
+		if to.After(s.to) {
+			to = s.to
+		}
+		s.records = s.load(from, to)
+		s.pos = 0
+	}
+	return true
+}
+
+func (s *streamSource) current() *streamRecord {
+	return &s.records[s.pos]
+}
+
+type streamHeap []*streamSource
+
+func (h streamHeap) Len() int { return len(h) }
+
+func (h streamHeap) Less(i, j int) bool {
+	ri, rj := h[i].current(), h[j].current()
+	if !ri.time.Equal(rj.time) {
+		return ri.time.Before(rj.time)
+	}
+	if ri.rank != rj.rank {
+		return ri.rank < rj.rank
+	}
+	return h[i].index < h[j].index
+}
+
+func (h streamHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *streamHeap) Push(x interface{}) { *h = append(*h, x.(*streamSource)) }
+
+func (h *streamHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+//streamMerge gives records of all sources in order of time
+type streamMerge struct {
+	sources streamHeap
+}
+
+func newStreamMerge(sources []*streamSource) *streamMerge {
+	sm := streamMerge{}
+	for _, s := range sources {
+		if s.fill() {
+			sm.sources = append(sm.sources, s)
+		}
+	}
+	heap.Init(&sm.sources)
+	return &sm
+}
+
+//next returns the earliest record of all sources or nil when sources are over
+func (sm *streamMerge) next() *streamRecord {
+	if len(sm.sources) == 0 {
+		return nil
+	}
+	s := sm.sources[0]
+	r := *s.current()
+	s.pos++
+	if s.fill() {
+		heap.Fix(&sm.sources, 0)
+	} else {
+		heap.Pop(&sm.sources)
+	}
+	return &r
+}
+
+//nextDay returns start of the next day in UTC, days of ticks are the same as days of prepaired ticks
+func nextDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+func nextCandlesChunk(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+streamCandlesChunkMonths, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (m *BTM) openStreamTicks() (func() (*marketdata.Tick, error), func(), error) {
+	loadQuotes := m.mode == MarketDataModeTicksQuotes || m.mode == MarketDataModeQuotes
+	loadTicks := m.mode == MarketDataModeTicks || m.mode == MarketDataModeTicksQuotes
+
+	storage := m.storage()
+	var sources []*streamSource
+	for i, s := range m.Symbols {
+		symbol := s.Symbol
+		sources = append(sources, &streamSource{
+			index: i,
+			next:  time.Date(m.FromDate.Year(), m.FromDate.Month(), m.FromDate.Day(), 0, 0, 0, 0, time.UTC),
+			to:    nextDay(m.ToDate).Add(-time.Nanosecond),
+			step:  nextDay,
+			load: func(from, to time.Time) []streamRecord {
+				ticks, err := storage.GetStoredTicks(symbol, marketdata.DateRange{From: from, To: to}, loadQuotes,
+					loadTicks)
+				if err != nil {
+					m.newError(err)
+				}
+				ticks.Sort()
+				var res []streamRecord
+				for _, t := range ticks {
+					if !t.HasTrade() {
+						continue
+					}
+					res = append(res, streamRecord{time: t.Datetime, tick: t})
+				}
+				return res
+			},
+		})
+	}
+
+	sm := newStreamMerge(sources)
+	next := func() (*marketdata.Tick, error) {
+		if r := sm.next(); r != nil {
+			return r.tick, nil
+		}
+		return nil, nil
+	}
+	return next, func() {}, nil
+}
+
+func (m *BTM) openStreamCandles(tickersMap map[string]*Instrument) (func() (*Candle, error), func(), error) {
+	tfs := m.timeFrames()
+	order := make(map[string]int)
+	for i, tf := range tfs {
+		order[tf] = i
+	}
+
+	storage := m.storage()
+	var sources []*streamSource
+	for i, s := range m.Symbols {
+		symbol := s
+		source := streamSource{index: i, next: m.FromDate, to: m.ToDate, step: nextCandlesChunk}
+		source.load = func(from, to time.Time) []streamRecord {
+			var candles CandleArray
+			if len(tfs) > 1 {
+				rng := marketdata.DateRange{From: from, To: m.buildingBarEnd(symbol, tfs, to)}
+				loaded, err := loadTimeFrames(storage, symbol, tfs, rng)
+				if err != nil {
+					m.newError(err)
+				}
+				for _, c := range loaded {
+					if !c.Datetime.Before(from) && !c.Datetime.After(to) {
+						candles = append(candles, c)
+					}
+				}
+			} else {
+				raw, err := storage.GetStoredCandles(symbol.Symbol, m.candlesTimeFrame,
+					marketdata.DateRange{From: from, To: to})
+				if err != nil {
+					m.newError(err)
+				}
+				for _, r := range raw {
+					candles = append(candles, &Candle{Candle: r, Ticker: tickersMap[r.Symbol],
+						TimeFrame: m.candlesTimeFrame})
+				}
+			}
+
+			res := make([]streamRecord, len(candles))
+			for i, c := range candles {
+				res[i] = streamRecord{time: c.Datetime, rank: order[c.TimeFrame], candle: c}
+			}
+			sort.SliceStable(res, func(i, j int) bool {
+				if res[i].time.Equal(res[j].time) {
+					return res[i].rank < res[j].rank
+				}
+				return res[i].time.Before(res[j].time)
+			})
+			return res
+		}
+		sources = append(sources, &source)
+	}
+
+	sm := newStreamMerge(sources)
+	loaded := false
+	next := func() (*Candle, error) {
+		if r := sm.next(); r != nil {
+			loaded = true
+			return r.candle, nil
+		}
+		if !loaded {
+			return nil, errors.New("No candles were loaded")
+		}
+		return nil, nil
+	}
+	return next, func() {}, nil
+}
+
+//buildingBarEnd returns end of the longest bar of timeframes which is building at time t. It isn't after ToDate,
+//so the last bars are built the same as in prepaired file
+func (m *BTM) buildingBarEnd(symbol *Instrument, timeFrames []string, t time.Time) time.Time {
+	end := t
+	for _, tf := range timeFrames {
+		_, e, err := candleBucket(t, tf, symbol.Exchange)
+		if err == nil && e.After(end) {
+			end = e
+		}
+	}
+	if end.After(m.ToDate) {
+		return m.ToDate
+	}
+	return end
+}
@@ -0,0 +1,247 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStreamBTM(t *testing.T, mode MarketDataMode) (*BTM, func()) {
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestStorageFile(t, filepath.Join(dir, "Test", "2010-01-04.csv"),
+		"1262615400,10.01,100,,,,\n1262615460,10.05,50,,,,\n1262615401,,,10.01,100,10.03,100\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Other", "2010-01-04.csv"),
+		"1262615400,20.5,10,,,,\n1262615430,20.6,10,,,,\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Other", "2010-01-06.csv"), "1262788200,21,10,,,,\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Test", "D.csv"),
+		"1262563200,9,10.2,8.8,10,2000\n1265155200,10,11,9.5,10.5,1000\n1262649600,10,11,9.5,10.5,1000\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Other", "D.csv"),
+		"1262563200,20,21,19,20.5,300\n1265241600,20,21,19,20.5,300\n")
+
+	m := BTM{
+		Symbols:          []*Instrument{{Symbol: "Test"}, {Symbol: "Other"}},
+		Folder:           dir,
+		FromDate:         time.Date(2010, 1, 4, 0, 0, 0, 0, time.UTC),
+		ToDate:           time.Date(2010, 2, 28, 0, 0, 0, 0, time.UTC),
+		Streaming:        true,
+		Storage:          NewCSVStorage(dir),
+		mode:             mode,
+		candlesTimeFrame: "D",
+		waitGroup:        &sync.WaitGroup{},
+	}
+	m.Init(make(chan error, 10), make(chan event, 100))
+	return &m, func() { os.RemoveAll(dir) }
+}
+
+func TestBTM_StreamingTicks(t *testing.T) {
+	m, clean := newTestStreamBTM(t, MarketDataModeTicks)
+	defer clean()
+
+	t.Log("Streaming ticks: symbols and days are merged by time, equal times go in order of symbols")
+	{
+		next, closeData, err := m.openPreparedTicks()
+		assert.Nil(t, err)
+		defer closeData()
+		var got []string
+		for {
+			tick, err := next()
+			assert.Nil(t, err)
+			if tick == nil {
+				break
+			}
+			got = append(got, tick.String())
+		}
+		assert.Len(t, got, 5)
+
+		m.Streaming = false
+		m.prepare()
+		next, closePrepared, err := m.openPreparedTicks()
+		assert.Nil(t, err)
+		defer closePrepared()
+		var prepared []string
+		for {
+			tick, err := next()
+			assert.Nil(t, err)
+			if tick == nil {
+				break
+			}
+			prepared = append(prepared, tick.String())
+		}
+		assert.Equal(t, prepared, got)
+	}
+
+	t.Log("Streaming ticks: generator doesn't need prepaired file")
+	{
+		m.Streaming = true
+		m.genTickEvents()
+		events := readTestEvents(m)
+		if assert.Len(t, events, 6) {
+			assert.Equal(t, "Test", events[0].(*NewTickEvent).Ticker.Symbol)
+			assert.Equal(t, "Other", events[1].(*NewTickEvent).Ticker.Symbol)
+			assert.Equal(t, 21.0, events[4].(*NewTickEvent).Tick.LastPrice)
+		}
+	}
+}
+
+func TestBTM_StreamingCandles(t *testing.T) {
+	t.Log("Streaming candles: chunks of months are merged by time")
+	{
+		m, clean := newTestStreamBTM(t, MarketDataModeCandles)
+		defer clean()
+		next, closeData, err := m.openPreparedCandles(m.getTickersMap())
+		assert.Nil(t, err)
+		defer closeData()
+		var got []*Candle
+		for {
+			c, err := next()
+			assert.Nil(t, err)
+			if c == nil {
+				break
+			}
+			got = append(got, c)
+		}
+		if assert.Len(t, got, 5) {
+			assert.Equal(t, []string{"Test", "Other", "Test", "Test", "Other"},
+				[]string{got[0].Symbol, got[1].Symbol, got[2].Symbol, got[3].Symbol, got[4].Symbol})
+			assert.Equal(t, time.Unix(1265155200, 0).UTC(), got[3].Datetime.UTC())
+			assert.Equal(t, "D", got[3].TimeFrame)
+		}
+	}
+
+	t.Log("Streaming candles: several timeframes go in order of timeframes on the same time")
+	{
+		m, clean := newTestStreamBTM(t, MarketDataModeCandles)
+		defer clean()
+		m.Symbols = m.Symbols[:1]
+		m.TimeFrames = []string{"W"}
+		next, closeData, err := m.openPreparedCandles(m.getTickersMap())
+		assert.Nil(t, err)
+		defer closeData()
+		var tfs []string
+		for {
+			c, err := next()
+			assert.Nil(t, err)
+			if c == nil {
+				break
+			}
+			tfs = append(tfs, c.TimeFrame)
+		}
+		assert.Equal(t, []string{"D", "W", "D", "W", "D"}, tfs)
+	}
+	t.Log("Streaming candles: bar building at the end of chunk is the same as in prepaired file")
+	{
+		m, clean := newTestStreamBTM(t, MarketDataModeCandles)
+		defer clean()
+		writeTestStorageFile(t, filepath.Join(m.Folder, "Test", "D.csv"),
+			"1262131200,9,10,8,9.5,100\n1262217600,9.5,12,9,11,200\n1262304000,11,11.5,7,8,300\n"+
+				"1262563200,8,9,7.5,8.5,400\n")
+		m.Symbols = m.Symbols[:1]
+		m.TimeFrames = []string{"W"}
+		m.FromDate = time.Date(2009, 12, 28, 0, 0, 0, 0, time.UTC)
+		m.ToDate = time.Date(2010, 1, 10, 0, 0, 0, 0, time.UTC)
+		read := func() []string {
+			next, closeData, err := m.openPreparedCandles(m.getTickersMap())
+			assert.Nil(t, err)
+			defer closeData()
+			var res []string
+			for {
+				c, err := next()
+				assert.Nil(t, err)
+				if c == nil {
+					break
+				}
+				res = append(res, c.TimeFrame+","+c.Candle.String())
+			}
+			return res
+		}
+		got := read()
+		if assert.Len(t, got, 6) {
+			assert.Contains(t, got[0], "W,")
+			assert.Contains(t, got[0], ",600")
+		}
+
+		m.Streaming = false
+		m.prepare()
+		assert.Equal(t, read(), got)
+	}
+
+	t.Log("Streaming candles: no candles is an error as in prepare")
+	{
+		m, clean := newTestStreamBTM(t, MarketDataModeCandles)
+		defer clean()
+		m.Symbols = []*Instrument{{Symbol: "Missing"}}
+		next, closeData, err := m.openPreparedCandles(m.getTickersMap())
+		assert.Nil(t, err)
+		defer closeData()
+		c, err := next()
+		assert.Nil(t, c)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestBTM_StorageRowErrors(t *testing.T) {
+	readError := func(m *BTM) error {
+		select {
+		case err := <-m.errChan:
+			return err
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	t.Log("Bad row of storage file is reported, good rows of file are kept")
+	{
+		m, clean := newTestStreamBTM(t, MarketDataModeTicks)
+		defer clean()
+		writeTestStorageFile(t, filepath.Join(m.Folder, "Test", "2010-01-04.csv"),
+			"1262615400,10.01,100,,,,\nbad,10.05,50,,,,\n1262615460,10.05,50,,,,\n")
+		read := func() []string {
+			next, closeData, err := m.openPreparedTicks()
+			assert.Nil(t, err)
+			defer closeData()
+			var res []string
+			for {
+				tick, err := next()
+				assert.Nil(t, err)
+				if tick == nil {
+					break
+				}
+				res = append(res, tick.Symbol)
+			}
+			return res
+		}
+
+		assert.Equal(t, []string{"Test", "Other", "Other", "Test", "Other"}, read())
+		assert.IsType(t, &ErrStorageFile{}, readError(m))
+
+		m.Streaming = false
+		m.prepare()
+		assert.Equal(t, []string{"Test", "Other", "Other", "Test", "Other"}, read())
+		assert.IsType(t, &ErrStorageFile{}, readError(m))
+	}
+
+	t.Log("Bad row of candles file is reported in streaming")
+	{
+		m, clean := newTestStreamBTM(t, MarketDataModeCandles)
+		defer clean()
+		m.Symbols = m.Symbols[:1]
+		writeTestStorageFile(t, filepath.Join(m.Folder, "Test", "D.csv"),
+			"1262563200,9,10.2,8.8,10,2000\n1262649600,bad,11,9.5,10.5,1000\n")
+		next, closeData, err := m.openPreparedCandles(m.getTickersMap())
+		assert.Nil(t, err)
+		defer closeData()
+		c, err := next()
+		assert.Nil(t, err)
+		if assert.NotNil(t, c) {
+			assert.Equal(t, 9.0, c.Open)
+		}
+		assert.IsType(t, &ErrStorageFile{}, readError(m))
+	}
+}
@@ -29,6 +29,7 @@ type IMarketData interface {
 	Connect()
 	Init(errChan chan error, mdChan chan event)
 	SetSymbols(symbols []*Instrument)
+	SetClock(clock Clock)
 	RequestHistoricalData(duration time.Duration)
 	ShutDown()
 }
@@ -39,7 +40,47 @@ type BTM struct {
 	FromDate         time.Time
 	ToDate           time.Time
 	UsePrepairedData bool
-	candlesTimeFrame string
+	//BinaryPrepared keeps prepaired data in columnar binary file (see prepared_binary.go) which is memory mapped
+	//on reading instead of text lines parsing. CompressPrepared compresses blocks of binary file
+	BinaryPrepared   bool
+	CompressPrepared bool
+	//Streaming reads market data from storage and merges symbols by time on the fly (see market_stream.go)
+	//instead of prepaired file. First events go without waiting for preparation
+	Streaming bool
+	//ManagePrepared keeps manifest of prepaired files in Folder (see PreparedCache): file is rebuilt when source
+	//files of storage change and the least recently used files are removed over PreparedSizeLimit bytes
+	ManagePrepared    bool
+	PreparedSizeLimit int64
+	candlesTimeFrame  string
+	//TimeFrames are additional candles timeframes of the same symbols, for example "60" and "D" for "5" minutes
+	//candles. Timeframes missing in storage are built from the smallest loaded intraday timeframe
+	TimeFrames []string
+	//TickBars are timeframes of bars built from ticks in ticks modes: time bars like "30s" or "5" and
+	//information driven bars like "vol:5000" or "renko:0.5". FillEmptyBars puts time bars without trades inside
+	//of session
+	TickBars      []string
+	FillEmptyBars bool
+	//ImbalanceFiles are optional auction imbalance feed files (see parseLineToImbalance). HaltFiles are optional
+	//files of trading halts (see parseLineToHalt). Events of feed files are put before market data events of
+	//the same time
+	ImbalanceFiles []string
+	HaltFiles      []string
+	//HaltDetectionGap turns on detection of halts in ticks modes: symbol without trades during session longer
+	//than gap is halted till its next trade
+	HaltDetectionGap time.Duration
+	//CorporateActionFiles are optional files of splits and dividends (see parseLineToCorporateAction). Positions
+	//are adjusted on splits of raw prices. AdjustForSplits puts market data and dividends adjusted by later splits
+	//instead, splits aren't sent then
+	CorporateActionFiles []string
+	AdjustForSplits      bool
+	//Cleaning turns on cleaning of market data before events are put (see DataCleaning). Report of data quality
+	//is returned by QualityReport
+	Cleaning      *DataCleaning
+	cleaner       *dataCleaner
+	qualityReport *DataQualityReport
+	feedEvents    eventArray
+	haltStates    map[string]*haltDetection
+	splitFactors  map[string][]splitFactor
 
 	errChan          chan error
 	mdChan           chan event
@@ -47,6 +88,9 @@ type BTM struct {
 	histDataTimeBack time.Duration
 	waitGroup        *sync.WaitGroup
 	mode             MarketDataMode
+	clock            Clock
+	//lastEventTime is time of the last event put in chan
+	lastEventTime time.Time
 }
 
 func (m *BTM) ShutDown() {
@@ -57,6 +101,87 @@ func (m *BTM) SetSymbols(symbols []*Instrument) {
 	m.Symbols = symbols
 }
 
+func (m *BTM) SetClock(clock Clock) {
+	m.clock = clock
+}
+
+//now returns time for events generated by BTM itself. Simulated clock is advanced by engine when it takes
+//event, so BTM is ahead of it and uses time of the last sent event
+func (m *BTM) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+	if m.clock.IsSimulated() {
+		return m.lastEventTime
+	}
+	return m.clock.Now()
+}
+
+//PrimaryTimeFrame returns timeframe of candles used by simulated broker
+func (m *BTM) PrimaryTimeFrame() string {
+	if m.BarsFromTicks() {
+		return m.TickBars[0]
+	}
+	return m.candlesTimeFrame
+}
+
+//BarsFromTicks returns true if candles are built from ticks. Simulated broker gets ticks only in this case
+func (m *BTM) BarsFromTicks() bool {
+	return m.mode != MarketDataModeCandles && len(m.TickBars) > 0
+}
+
+func (m *BTM) newTickBarsAggregator() *tickBarsAggregator {
+	if !m.BarsFromTicks() {
+		return nil
+	}
+	a, err := newTickBarsAggregator(m.TickBars, m.FillEmptyBars)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+//newTickEvent puts tick with bar events built from it. Bars completed by tick are closed after the tick. Halt
+//detected by tick is put before it
+func (m *BTM) newTickEvent(bars *tickBarsAggregator, e *NewTickEvent) {
+	m.detectHalt(e)
+	if bars == nil {
+		m.newEvent(e)
+		return
+	}
+	before, after := bars.onTick(e.Tick)
+	for _, ce := range before {
+		m.newEvent(ce)
+	}
+	m.newEvent(e)
+	for _, ce := range after {
+		m.newEvent(ce)
+	}
+}
+
+func (m *BTM) finishTickBars(bars *tickBarsAggregator) {
+	if bars == nil {
+		return
+	}
+	for _, e := range bars.finish() {
+		m.newEvent(e)
+	}
+}
+
+//timeFrames returns primary timeframe and additional timeframes without duplicates
+func (m *BTM) timeFrames() []string {
+	res := []string{m.candlesTimeFrame}
+	listed := map[string]struct{}{m.candlesTimeFrame: {}}
+	for _, tf := range m.TimeFrames {
+		if _, ok := listed[tf]; ok {
+			continue
+		}
+		listed[tf] = struct{}{}
+		res = append(res, tf)
+	}
+	return res
+}
+
 func (m *BTM) Connect() {
 	fmt.Println("Backtest market data connected. ")
 }
@@ -96,17 +221,33 @@ func (m *BTM) getFilename() (string, error) {
 	out += string(m.mode)
 	if m.mode == MarketDataModeCandles {
 		out += m.candlesTimeFrame
+		if tfs := m.timeFrames(); len(tfs) > 1 {
+			out += "," + strings.Join(tfs[1:], ",")
+		}
+	}
+
+	//Prepaired data without out of order records is kept apart
+	if m.Cleaning != nil && m.Cleaning.RemoveOutOfOrder {
+		out += ",inorder"
 	}
 
 	datesToStringLayout := "2006-01-02 15:04:05"
 	out += m.FromDate.Format(datesToStringLayout) + "," + m.ToDate.Format(datesToStringLayout)
 
+	ext := ".prep"
+	if m.BinaryPrepared {
+		ext = ".bprep"
+		if m.CompressPrepared {
+			out += ",compressed"
+		}
+	}
+
 	h := fnv.New32a()
 	_, err := h.Write([]byte(out))
 	if err != nil {
 		panic(err)
 	}
-	return strconv.FormatUint(uint64(h.Sum32()), 10) + ".prep", nil
+	return strconv.FormatUint(uint64(h.Sum32()), 10) + ext, nil
 }
 
 func (m *BTM) getPrepairedFilePath() string {
@@ -117,7 +258,9 @@ func (m *BTM) getPrepairedFilePath() string {
 	return path.Join(m.Folder, fpth)
 }
 
+//prepare writes prepaired file of storage data. Order of storage records is checked by cleaning of run
 func (m *BTM) prepare() {
+	m.initCleaning()
 	if m.prepairedDataExists() {
 		err := m.clearPrepairedData()
 		if err != nil {
@@ -143,9 +286,14 @@ func (m *BTM) prepareCandles() {
 		From: m.FromDate,
 		To:   m.ToDate,
 	}
+	if len(m.timeFrames()) > 1 {
+		m.prepareTimeFramesCandles(rng)
+		return
+	}
+	storage := m.storage()
 	var totalcandles marketdata.CandleArray
 	for _, s := range m.Symbols {
-		sc, err := m.Storage.GetStoredCandles(s.Symbol, m.candlesTimeFrame, rng)
+		sc, err := storage.GetStoredCandles(s.Symbol, m.candlesTimeFrame, rng)
 		if err != nil {
 			m.newError(err)
 		}
@@ -163,6 +311,37 @@ func (m *BTM) prepareCandles() {
 
 }
 
+func (m *BTM) prepareTimeFramesCandles(rng marketdata.DateRange) {
+	tfs := m.timeFrames()
+	order := make(map[string]int)
+	for i, tf := range tfs {
+		order[tf] = i
+	}
+
+	storage := m.storage()
+	var totalcandles CandleArray
+	for _, s := range m.Symbols {
+		sc, err := loadTimeFrames(storage, s, tfs, rng)
+		if err != nil {
+			m.newError(err)
+		}
+		totalcandles = append(totalcandles, sc...)
+	}
+
+	if len(totalcandles) == 0 {
+		panic("No candles were loaded")
+	}
+
+	//Candles opened at the same time go in order of timeframes, so primary timeframe is always the first
+	sort.SliceStable(totalcandles, func(i, j int) bool {
+		if totalcandles[i].Datetime.Equal(totalcandles[j].Datetime) {
+			return order[totalcandles[i].TimeFrame] < order[totalcandles[j].TimeFrame]
+		}
+		return totalcandles[i].Datetime.Before(totalcandles[j].Datetime)
+	})
+	m.writeTimeFramesCandles(totalcandles)
+}
+
 func (m *BTM) prepareTicks() {
 	d := m.FromDate
 	for {
@@ -184,6 +363,7 @@ func (m *BTM) clearPrepairedData() error {
 }
 
 func (m *BTM) loadDateTicks(date time.Time) {
+	storage := m.storage()
 	totalTicks := marketdata.TickArray{}
 	for _, symbol := range m.Symbols {
 		rng := marketdata.DateRange{
@@ -198,10 +378,9 @@ func (m *BTM) loadDateTicks(date time.Time) {
 		if m.mode == MarketDataModeTicksQuotes || m.mode == MarketDataModeQuotes {
 			loadQuotes = true
 		}
-		symbolTicks, err := m.Storage.GetStoredTicks(symbol.Symbol, rng, loadQuotes, loadTicks)
-		if err != nil && symbolTicks != nil {
+		symbolTicks, err := storage.GetStoredTicks(symbol.Symbol, rng, loadQuotes, loadTicks)
+		if err != nil {
 			m.newError(err)
-			continue
 		}
 
 		totalTicks = append(totalTicks, symbolTicks...)
@@ -216,6 +395,22 @@ func (m *BTM) writeDateTicks(ticks marketdata.TickArray) {
 		return
 	}
 
+	if m.BinaryPrepared {
+		var trades marketdata.TickArray
+		for _, t := range ticks {
+			if t.HasTrade() {
+				trades = append(trades, t)
+			}
+		}
+		if len(trades) == 0 {
+			return
+		}
+		if err := m.writeBinaryTicks(trades); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	f, err := os.OpenFile(m.getPrepairedFilePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
 	if err != nil {
@@ -244,6 +439,17 @@ func (m *BTM) writeCandles(candles marketdata.CandleArray) {
 		return
 	}
 
+	if m.BinaryPrepared {
+		engineCandles := make(CandleArray, len(candles))
+		for i, c := range candles {
+			engineCandles[i] = &Candle{Candle: c, TimeFrame: m.candlesTimeFrame}
+		}
+		if err := m.writeBinaryCandles(engineCandles); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	f, err := os.OpenFile(m.getPrepairedFilePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
 	if err != nil {
@@ -265,6 +471,45 @@ func (m *BTM) writeCandles(candles marketdata.CandleArray) {
 
 }
 
+//writeTimeFramesCandles writes candles with two additional fields: timeframe and close time (zero if candle
+//doesn't have it)
+func (m *BTM) writeTimeFramesCandles(candles CandleArray) {
+	if len(candles) == 0 {
+		return
+	}
+
+	if m.BinaryPrepared {
+		if err := m.writeBinaryCandles(candles); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	f, err := os.OpenFile(m.getPrepairedFilePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		panic(err)
+	}
+
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for _, c := range candles {
+		var closeTime int64
+		if !c.CloseTime.IsZero() {
+			closeTime = c.CloseTime.Unix()
+		}
+		line := fmt.Sprintf("%v,%v,%v\n", c.Candle.String(), c.TimeFrame, closeTime)
+		if _, err := f.Write([]byte(line)); err != nil {
+			m.newError(err)
+		}
+	}
+}
+
 func (m *BTM) newError(err error) {
 	m.waitGroup.Add(1)
 	go func() {
@@ -274,13 +519,87 @@ func (m *BTM) newError(err error) {
 
 }
 
+//newEvent puts events of feed files that are due and then event
 func (m *BTM) newEvent(e event) {
 	if m.mdChan == nil {
 		panic("BTM event chan is nil")
 	}
+	m.putFeedEvents(e.getTime())
+	m.sendEvent(e)
+}
+
+func (m *BTM) sendEvent(e event) {
+	if e.getTime().After(m.lastEventTime) {
+		m.lastEventTime = e.getTime()
+	}
 	m.mdChan <- e
 }
 
+//loadFeedEvents reads imbalance, halt and corporate actions files. Events of symbols that aren't traded are
+//skipped
+func (m *BTM) loadFeedEvents() error {
+	m.feedEvents = nil
+	tickersMap := m.getTickersMap()
+	files := []struct {
+		paths []string
+		parse func(string, map[string]*Instrument) ([]event, error)
+	}{
+		{m.ImbalanceFiles, parseLineToImbalance},
+		{m.HaltFiles, parseLineToHalt},
+		{m.CorporateActionFiles, parseLineToCorporateAction},
+	}
+	for _, f := range files {
+		for _, pth := range f.paths {
+			events, err := readFeedFile(pth, func(l string) ([]event, error) {
+				return f.parse(l, tickersMap)
+			})
+			if err != nil {
+				return err
+			}
+			m.feedEvents = append(m.feedEvents, events...)
+		}
+	}
+
+	sort.SliceStable(m.feedEvents, func(i, j int) bool {
+		return m.feedEvents[i].getTime().Before(m.feedEvents[j].getTime())
+	})
+	if m.AdjustForSplits {
+		m.initSplitFactors()
+	}
+	return nil
+}
+
+func readFeedFile(pth string, parse func(string) ([]event, error)) ([]event, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var res []event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		events, err := parse(scanner.Text())
+		if err != nil {
+			return nil, errors.Wrap(err, pth)
+		}
+		res = append(res, events...)
+	}
+	return res, scanner.Err()
+}
+
+//putFeedEvents puts events of feed files which time is not after t
+func (m *BTM) putFeedEvents(t time.Time) {
+	for len(m.feedEvents) > 0 && !m.feedEvents[0].getTime().After(t) {
+		e := m.feedEvents[0]
+		m.feedEvents = m.feedEvents[1:]
+		m.sendEvent(e)
+	}
+}
+
 func (m *BTM) prepairedDataExists() bool {
 	filename, err := m.getFilename()
 	if err != nil {
@@ -296,8 +615,11 @@ func (m *BTM) prepairedDataExists() bool {
 }
 
 func (m *BTM) Run() {
-	if !m.prepairedDataExists() {
-		m.prepare()
+	if !m.Streaming {
+		m.checkPrepared()
+	}
+	if err := m.loadFeedEvents(); err != nil {
+		panic(err)
 	}
 	if m.mode == MarketDataModeQuotes || m.mode == MarketDataModeTicks || m.mode == MarketDataModeTicksQuotes {
 		if m.histDataTimeBack > time.Second {
@@ -352,29 +674,32 @@ func (m *BTM) getTickersMap() map[string]*Instrument {
 }
 
 func (m *BTM) genTickEvents() {
-	if !m.prepairedDataExists() {
+	if !m.Streaming && !m.prepairedDataExists() {
 		panic("Can't genereate tick events. Prepaired data is not exists. ")
 	}
 
-	file, err := os.Open(m.getPrepairedFilePath())
+	m.initCleaning()
+	nextTick, closeData, err := m.openPreparedTicks()
 	if err != nil {
 		panic(err)
 	}
-	defer func() {
-		err := file.Close()
-		if err != nil {
-			m.newError(err)
-		}
-	}()
+	defer closeData()
 
-	scanner := bufio.NewScanner(file)
 	tickersMap := m.getTickersMap()
+	bars := m.newTickBarsAggregator()
 
-	for scanner.Scan() {
-		tickRaw, err := m.parseLineToTick(scanner.Text())
+	for {
+		tickRaw, err := nextTick()
 		if err != nil {
 			panic(err)
 		}
+		if tickRaw == nil {
+			break
+		}
+		m.adjustTick(tickRaw)
+		if !m.cleanTick(tickRaw, tickersMap[tickRaw.Symbol]) {
+			continue
+		}
 
 		ticker := tickersMap[tickRaw.Symbol]
 		tick := Tick{
@@ -387,40 +712,45 @@ func (m *BTM) genTickEvents() {
 			BaseEvent: BaseEvent{Time: tick.Datetime, Ticker: ticker},
 		}
 
-		m.newEvent(&e)
+		m.newTickEvent(bars, &e)
 
 	}
-	m.newEvent(&EndOfDataEvent{BaseEvent: be(time.Now(), &Instrument{})})
+	m.finishTickBars(bars)
+	m.finishCleaning()
+	m.newEvent(&EndOfDataEvent{BaseEvent: be(m.now(), &Instrument{})})
 
 }
 
 func (m *BTM) genTickEventsWithHistory() {
-	if !m.prepairedDataExists() {
+	if !m.Streaming && !m.prepairedDataExists() {
 		panic("Can't genereate tick events. Prepaired data is not exists. ")
 	}
 
-	file, err := os.Open(m.getPrepairedFilePath())
+	m.initCleaning()
+	nextTick, closeData, err := m.openPreparedTicks()
 	if err != nil {
 		panic(err)
 	}
-	defer func() {
-		err := file.Close()
-		if err != nil {
-			m.newError(err)
-		}
-	}()
+	defer closeData()
 
 	historyMap := make(map[string]TickArray)
 	historyLoaded := make(map[string]struct{})
 
-	scanner := bufio.NewScanner(file)
 	tickersMap := m.getTickersMap()
+	bars := m.newTickBarsAggregator()
 
-	for scanner.Scan() {
-		tickRaw, err := m.parseLineToTick(scanner.Text())
+	for {
+		tickRaw, err := nextTick()
 		if err != nil {
 			panic(err)
 		}
+		if tickRaw == nil {
+			break
+		}
+		m.adjustTick(tickRaw)
+		if !m.cleanTick(tickRaw, tickersMap[tickRaw.Symbol]) {
+			continue
+		}
 		ticker := tickersMap[tickRaw.Symbol]
 		tick := Tick{
 			Tick:   tickRaw,
@@ -433,7 +763,7 @@ func (m *BTM) genTickEventsWithHistory() {
 				Tick:      &tick,
 				BaseEvent: BaseEvent{Time: tick.Datetime, Ticker: ticker},
 			}
-			m.newEvent(&e)
+			m.newTickEvent(bars, &e)
 			continue
 		}
 
@@ -453,12 +783,21 @@ func (m *BTM) genTickEventsWithHistory() {
 				}
 				m.newEvent(&historyEvent)
 
+				if bars != nil {
+					if candles := bars.warmUp(historyMap[tick.Symbol]); len(candles) > 0 {
+						m.newEvent(&CandlesHistoryEvent{
+							BaseEvent: be(arr[len(arr)-1].Datetime, ticker),
+							Candles:   candles,
+						})
+					}
+				}
+
 				//First put out new tick event
 				e := NewTickEvent{
 					Tick:      &tick,
 					BaseEvent: BaseEvent{Time: tick.Datetime, Ticker: ticker},
 				}
-				m.newEvent(&e)
+				m.newTickEvent(bars, &e)
 
 			}
 		} else {
@@ -467,198 +806,258 @@ func (m *BTM) genTickEventsWithHistory() {
 		}
 
 	}
-	m.newEvent(&EndOfDataEvent{BaseEvent: be(time.Now(), &Instrument{})})
+	m.finishTickBars(bars)
+	m.finishCleaning()
+	m.newEvent(&EndOfDataEvent{BaseEvent: be(m.now(), &Instrument{})})
 
 }
 
-func (m *BTM) genCandlesEvents() {
-	if !m.prepairedDataExists() {
-		panic("Can't genereate tick events. Prepaired data is not exists. ")
+//newCandleEvents puts candle open event and adds candle close event to pending closes. Before candle open all
+//pending closes that are due are put out. Pending closes are sorted by time, on same time lower timeframe first
+func (m *BTM) newCandleEvents(c *Candle, candleCloses []*CandleCloseEvent) []*CandleCloseEvent {
+	e := CandleOpenEvent{
+		BaseEvent:  be(c.Datetime, c.Ticker),
+		CandleTime: c.Datetime,
+		Price:      c.Open,
+		TimeFrame:  c.TimeFrame,
 	}
 
-	file, err := os.Open(m.getPrepairedFilePath())
-	if err != nil {
-		panic(err)
+	candleCloses = m.flushCandleCloses(candleCloses, e.getTime())
+	m.newEvent(&e)
+
+	return m.queueCandleClose(c, candleCloses)
+}
+
+//queueCandleClose adds close event of candle to pending closes in order of time, lower timeframe first
+func (m *BTM) queueCandleClose(c *Candle, candleCloses []*CandleCloseEvent) []*CandleCloseEvent {
+	ce := CandleCloseEvent{
+		BaseEvent: be(c.Datetime, c.Ticker),
+		Candle:    c,
+		TimeFrame: c.TimeFrame,
 	}
-	defer func() {
-		err := file.Close()
-		if err != nil {
-			m.newError(err)
+	ce.setEventTimeFromCandle()
+	candleCloses = append(candleCloses, &ce)
+	sort.SliceStable(candleCloses, func(i, j int) bool {
+		ti, tj := candleCloses[i].getTime(), candleCloses[j].getTime()
+		if ti.Equal(tj) {
+			di, _ := parseTimeFrame(candleCloses[i].TimeFrame)
+			dj, _ := parseTimeFrame(candleCloses[j].TimeFrame)
+			return di < dj
 		}
-	}()
+		return ti.Before(tj)
+	})
+	return candleCloses
+}
+
+//flushCandleCloses puts out pending closes which time is not after t and returns the rest
+func (m *BTM) flushCandleCloses(candleCloses []*CandleCloseEvent, t time.Time) []*CandleCloseEvent {
+	n := 0
+	for n < len(candleCloses) && !candleCloses[n].getTime().After(t) {
+		m.newEvent(candleCloses[n])
+		n++
+	}
+	return candleCloses[n:]
+}
+
+func (m *BTM) flushAllCandleCloses(candleCloses []*CandleCloseEvent) {
+	for _, pce := range candleCloses {
+		m.newEvent(pce)
+	}
+}
+
+func (m *BTM) genCandlesEvents() {
+	if !m.Streaming && !m.prepairedDataExists() {
+		panic("Can't genereate tick events. Prepaired data is not exists. ")
+	}
 
-	scanner := bufio.NewScanner(file)
 	var candleCloses []*CandleCloseEvent
 	tickersMap := m.getTickersMap()
+	m.initCleaning()
+	nextCandle, closeData, err := m.openPreparedCandles(tickersMap)
+	if err != nil {
+		panic(err)
+	}
+	defer closeData()
 
-	for scanner.Scan() {
-		cRaw, err := m.parseLineToCandle(scanner.Text())
+	for {
+		c, err := nextCandle()
 		if err != nil {
 			panic(err)
 		}
-
-		ticker := tickersMap[cRaw.Symbol]
-		e := CandleOpenEvent{
-			BaseEvent:  be(cRaw.Datetime, ticker),
-			CandleTime: cRaw.Datetime,
-			Price:      cRaw.Open,
-			TimeFrame:  m.candlesTimeFrame,
-		}
-
-		//If we have stored events and current candle open event is not before first listed candle close event
-		if len(candleCloses) > 0 && !candleCloses[0].getTime().After(e.getTime()) {
-			for _, pce := range candleCloses {
-				m.newEvent(pce)
-			}
-			candleCloses = []*CandleCloseEvent{}
-		}
-
-		m.newEvent(&e)
-
-		c := Candle{
-			Candle: cRaw,
-			Ticker: ticker,
+		if c == nil {
+			break
 		}
-
-		ce := CandleCloseEvent{
-			BaseEvent: be(cRaw.Datetime, ticker),
-			Candle:    &c,
-			TimeFrame: m.candlesTimeFrame,
+		m.adjustCandle(c)
+		if !m.cleanCandle(c) {
+			continue
 		}
-		ce.setEventTimeFromCandle()
-		candleCloses = append(candleCloses, &ce)
 
+		candleCloses = m.newCandleEvents(c, candleCloses)
 	}
 
-	if len(candleCloses) > 0 {
-		for _, pce := range candleCloses {
-			m.newEvent(pce)
-		}
-	}
-	m.newEvent(&EndOfDataEvent{BaseEvent: be(time.Now(), &Instrument{})})
+	m.flushAllCandleCloses(candleCloses)
+	m.finishCleaning()
+	m.newEvent(&EndOfDataEvent{BaseEvent: be(m.now(), &Instrument{})})
 
 }
 
 func (m *BTM) genCandlesEventsWithHistory() {
-	if !m.prepairedDataExists() {
+	if !m.Streaming && !m.prepairedDataExists() {
 		panic("Can't genereate tick events. Prepaired data is not exists. ")
 	}
 
-	file, err := os.Open(m.getPrepairedFilePath())
-	if err != nil {
-		panic(err)
-	}
-	defer func() {
-		err := file.Close()
-		if err != nil {
-			m.newError(err)
-		}
-	}()
-
-	scanner := bufio.NewScanner(file)
-
 	historyMap := make(map[string]CandleArray)
 	historyLoaded := make(map[string]struct{})
 
 	var candleCloses []*CandleCloseEvent
 	tickersMap := m.getTickersMap()
+	m.initCleaning()
+	nextCandle, closeData, err := m.openPreparedCandles(tickersMap)
+	if err != nil {
+		panic(err)
+	}
+	defer closeData()
 
-	for scanner.Scan() {
-		cRaw, err := m.parseLineToCandle(scanner.Text())
+	for {
+		c, err := nextCandle()
 		if err != nil {
 			panic(err)
 		}
-
-		ticker := tickersMap[cRaw.Symbol]
-
-		c := &Candle{
-			Candle: cRaw,
-			Ticker: ticker,
+		if c == nil {
+			break
+		}
+		m.adjustCandle(c)
+		if !m.cleanCandle(c) {
+			continue
 		}
 
-		if _, ok := historyLoaded[cRaw.Symbol]; ok {
-			e := CandleOpenEvent{
-				BaseEvent:  be(cRaw.Datetime, ticker),
-				CandleTime: cRaw.Datetime,
-				Price:      cRaw.Open,
-				TimeFrame:  m.candlesTimeFrame,
-			}
-
-			//If we have stored events and current candle open event is not before first listed candle close event
-			if len(candleCloses) > 0 && !candleCloses[0].getTime().After(e.getTime()) {
-				for _, pce := range candleCloses {
-					m.newEvent(pce)
-				}
-				candleCloses = []*CandleCloseEvent{}
-			}
-
-			m.newEvent(&e)
-
-			ce := CandleCloseEvent{
-				BaseEvent: be(cRaw.Datetime, ticker),
-				Candle:    c,
-				TimeFrame: m.candlesTimeFrame,
-			}
-			ce.setEventTimeFromCandle()
-			candleCloses = append(candleCloses, &ce)
+		if _, ok := historyLoaded[c.Symbol]; ok {
+			candleCloses = m.newCandleEvents(c, candleCloses)
 			continue
 		}
 
-		if arr, ok := historyMap[cRaw.Symbol]; ok {
+		if arr, ok := historyMap[c.Symbol]; ok {
 
-			delta := cRaw.Datetime.Sub(arr[0].Datetime)
+			delta := c.Datetime.Sub(arr[0].Datetime)
 			if delta < m.histDataTimeBack {
-				historyMap[cRaw.Symbol] = append(historyMap[cRaw.Symbol], c)
+				historyMap[c.Symbol] = append(historyMap[c.Symbol], c)
 
 			} else {
-				//Than put in chan history resp event
-				historyLoaded[cRaw.Symbol] = struct{}{}
+				//Than put in chan history resp event. History has only candles closed before the first live
+				//candle, others are not finished yet and come with their close events
+				historyLoaded[c.Symbol] = struct{}{}
+				var closed CandleArray
+				for _, hc := range arr {
+					if hc.closeTime(hc.TimeFrame).After(c.Datetime) {
+						candleCloses = m.queueCandleClose(hc, candleCloses)
+						continue
+					}
+					closed = append(closed, hc)
+				}
+				candleCloses = m.flushCandleCloses(candleCloses, c.Datetime)
 				historyEvent := CandlesHistoryEvent{
-					BaseEvent: be(arr[len(arr)-1].Datetime, ticker),
-					Candles:   historyMap[cRaw.Symbol],
+					BaseEvent: be(c.Datetime, c.Ticker),
+					Candles:   closed,
 				}
 				m.newEvent(&historyEvent)
 
-				//First put out new tick event
-				e := CandleOpenEvent{
-					BaseEvent:  be(cRaw.Datetime, ticker),
-					CandleTime: cRaw.Datetime,
-					Price:      cRaw.Open,
-					TimeFrame:  m.candlesTimeFrame,
-				}
+				candleCloses = m.newCandleEvents(c, candleCloses)
+			}
+		} else {
+			historyMap[c.Symbol] = CandleArray{c}
 
-				if len(candleCloses) > 0 && !candleCloses[0].getTime().After(e.getTime()) {
-					for _, pce := range candleCloses {
-						m.newEvent(pce)
-					}
-					candleCloses = []*CandleCloseEvent{}
-				}
+		}
 
-				m.newEvent(&e)
+	}
 
-				ce := CandleCloseEvent{
-					BaseEvent: be(cRaw.Datetime, ticker),
-					Candle:    c,
-					TimeFrame: m.candlesTimeFrame,
-				}
-				ce.setEventTimeFromCandle()
-				candleCloses = append(candleCloses, &ce)
+	m.flushAllCandleCloses(candleCloses)
+	m.finishCleaning()
+	m.newEvent(&EndOfDataEvent{BaseEvent: be(m.now(), &Instrument{})})
 
-			}
-		} else {
-			historyMap[cRaw.Symbol] = CandleArray{c}
+}
 
+//openPreparedTicks returns function which gives next tick of prepaired data or nil at the end of data and
+//function which closes prepaired data. In streaming mode ticks are merged from storage
+func (m *BTM) openPreparedTicks() (func() (*marketdata.Tick, error), func(), error) {
+	if m.Streaming {
+		return m.openStreamTicks()
+	}
+	if m.BinaryPrepared {
+		return m.openBinaryPreparedTicks()
+	}
+	scanner, closeData, err := m.openPreparedText()
+	if err != nil {
+		return nil, nil, err
+	}
+	next := func() (*marketdata.Tick, error) {
+		if !scanner.Scan() {
+			return nil, scanner.Err()
 		}
+		return m.parseLineToTick(scanner.Text())
+	}
+	return next, closeData, nil
+}
 
+//openPreparedCandles is the same as openPreparedTicks for candles
+func (m *BTM) openPreparedCandles(tickersMap map[string]*Instrument) (func() (*Candle, error), func(), error) {
+	if m.Streaming {
+		return m.openStreamCandles(tickersMap)
+	}
+	if m.BinaryPrepared {
+		return m.openBinaryPreparedCandles(tickersMap)
+	}
+	scanner, closeData, err := m.openPreparedText()
+	if err != nil {
+		return nil, nil, err
 	}
+	next := func() (*Candle, error) {
+		if !scanner.Scan() {
+			return nil, scanner.Err()
+		}
+		return m.parseLineToEngineCandle(scanner.Text(), tickersMap)
+	}
+	return next, closeData, nil
+}
 
-	if len(candleCloses) > 0 {
-		for _, pce := range candleCloses {
-			m.newEvent(pce)
+func (m *BTM) openPreparedText() (*bufio.Scanner, func(), error) {
+	file, err := os.Open(m.getPrepairedFilePath())
+	if err != nil {
+		return nil, nil, err
+	}
+	closeData := func() {
+		err := file.Close()
+		if err != nil {
+			m.newError(err)
 		}
 	}
-	m.newEvent(&EndOfDataEvent{BaseEvent: be(time.Now(), &Instrument{})})
+	return bufio.NewScanner(file), closeData, nil
+}
 
+//parseLineToEngineCandle parses line of prepaired candles. Lines written for several timeframes have timeframe
+//and close time fields, other lines get primary timeframe
+func (m *BTM) parseLineToEngineCandle(l string, tickersMap map[string]*Instrument) (*Candle, error) {
+	ls := strings.Split(l, ",")
+	if len(ls) == 11 {
+		raw, err := m.parseLineToCandle(strings.Join(ls[:9], ","))
+		if err != nil {
+			return nil, err
+		}
+		c := Candle{Candle: raw, Ticker: tickersMap[raw.Symbol], TimeFrame: ls[9]}
+		closeTime, err := strconv.ParseInt(ls[10], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if closeTime != 0 {
+			c.CloseTime = time.Unix(closeTime, 0)
+		}
+		return &c, nil
+	}
+
+	raw, err := m.parseLineToCandle(l)
+	if err != nil {
+		return nil, err
+	}
+	return &Candle{Candle: raw, Ticker: tickersMap[raw.Symbol], TimeFrame: m.candlesTimeFrame}, nil
 }
 
 func (m *BTM) parseLineToTick(l string) (*marketdata.Tick, error) {
@@ -0,0 +1,605 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+//Binary prepared file starts with header: magic, version, flags, kind of records, market data mode, timeframes,
+//date range. Then blocks of records follow. Block is record count, payload length and payload: string table of
+//symbols, exchanges, conditions and timeframes and columns of records fields. Numbers are little endian fixed
+//width, so columns are decoded without parsing. Payload is compressed with flate if header has flag
+const (
+	preparedMagic          = "BTMB"
+	preparedVersion        = 1
+	preparedCompressedFlag = 1
+
+	preparedTicksKind   = "ticks"
+	preparedCandlesKind = "candles"
+
+	//preparedBlockSize is max number of records in block
+	preparedBlockSize = 1 << 16
+)
+
+type preparedHeader struct {
+	version    uint16
+	compressed bool
+	kind       string
+	mode       MarketDataMode
+	timeFrames string
+	from       time.Time
+	to         time.Time
+}
+
+func (m *BTM) newPreparedHeader(kind string) preparedHeader {
+	h := preparedHeader{
+		version:    preparedVersion,
+		compressed: m.CompressPrepared,
+		kind:       kind,
+		mode:       m.mode,
+		from:       m.FromDate,
+		to:         m.ToDate,
+	}
+	if kind == preparedCandlesKind {
+		h.timeFrames = strings.Join(m.timeFrames(), ",")
+	}
+	return h
+}
+
+func (h *preparedHeader) bytes() []byte {
+	var w binaryBlockWriter
+	w.buf.WriteString(preparedMagic)
+	w.uint16(h.version)
+	var flags uint16
+	if h.compressed {
+		flags |= preparedCompressedFlag
+	}
+	w.uint16(flags)
+	w.str(h.kind)
+	w.str(string(h.mode))
+	w.str(h.timeFrames)
+	w.int64(h.from.UnixNano())
+	w.int64(h.to.UnixNano())
+	return w.buf.Bytes()
+}
+
+//parsePreparedHeader returns header and its length
+func parsePreparedHeader(data []byte) (preparedHeader, int, error) {
+	h := preparedHeader{}
+	if len(data) < len(preparedMagic) || string(data[:len(preparedMagic)]) != preparedMagic {
+		return h, 0, errors.New("Not binary prepared file")
+	}
+	r := binaryBlockReader{data: data, pos: len(preparedMagic)}
+	h.version = r.uint16()
+	if r.err == nil && h.version != preparedVersion {
+		return h, 0, errors.Errorf("Unsupported version of binary prepared file: %v", h.version)
+	}
+	h.compressed = r.uint16()&preparedCompressedFlag != 0
+	h.kind = r.str()
+	h.mode = MarketDataMode(r.str())
+	h.timeFrames = r.str()
+	h.from = time.Unix(0, r.int64())
+	h.to = time.Unix(0, r.int64())
+	if r.err != nil {
+		return h, 0, errors.Wrap(r.err, "Broken header of binary prepared file")
+	}
+	return h, r.pos, nil
+}
+
+//matches returns true if prepared file was written for the same data
+func (h *preparedHeader) matches(other preparedHeader) bool {
+	return h.kind == other.kind && h.mode == other.mode && h.timeFrames == other.timeFrames &&
+		h.from.Equal(other.from) && h.to.Equal(other.to) && h.compressed == other.compressed
+}
+
+//******* WRITING *************************************************************
+
+type binaryBlockWriter struct {
+	buf bytes.Buffer
+	tmp [8]byte
+}
+
+func (w *binaryBlockWriter) uint16(v uint16) {
+	binary.LittleEndian.PutUint16(w.tmp[:2], v)
+	w.buf.Write(w.tmp[:2])
+}
+
+func (w *binaryBlockWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(w.tmp[:4], v)
+	w.buf.Write(w.tmp[:4])
+}
+
+func (w *binaryBlockWriter) int64(v int64) {
+	binary.LittleEndian.PutUint64(w.tmp[:], uint64(v))
+	w.buf.Write(w.tmp[:])
+}
+
+func (w *binaryBlockWriter) float(v float64) {
+	binary.LittleEndian.PutUint64(w.tmp[:], math.Float64bits(v))
+	w.buf.Write(w.tmp[:])
+}
+
+func (w *binaryBlockWriter) str(s string) {
+	w.uint32(uint32(len(s)))
+	w.buf.WriteString(s)
+}
+
+//stringTable keeps index of every string of block
+type stringTable struct {
+	index   map[string]uint32
+	strings []string
+}
+
+func (t *stringTable) id(s string) uint32 {
+	if t.index == nil {
+		t.index = make(map[string]uint32)
+	}
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := uint32(len(t.strings))
+	t.index[s] = i
+	t.strings = append(t.strings, s)
+	return i
+}
+
+func (t *stringTable) write(w *binaryBlockWriter) {
+	w.uint32(uint32(len(t.strings)))
+	for _, s := range t.strings {
+		w.str(s)
+	}
+}
+
+func encodeTicksBlock(ticks marketdata.TickArray) []byte {
+	var table stringTable
+	var cols binaryBlockWriter
+	for _, t := range ticks {
+		cols.int64(t.Datetime.UnixNano())
+	}
+	for _, t := range ticks {
+		cols.uint32(table.id(t.Symbol))
+	}
+	for _, f := range []func(*marketdata.Tick) float64{
+		func(t *marketdata.Tick) float64 { return t.LastPrice },
+		func(t *marketdata.Tick) float64 { return t.BidPrice },
+		func(t *marketdata.Tick) float64 { return t.AskPrice },
+	} {
+		for _, t := range ticks {
+			cols.float(f(t))
+		}
+	}
+	for _, f := range []func(*marketdata.Tick) int64{
+		func(t *marketdata.Tick) int64 { return t.LastSize },
+		func(t *marketdata.Tick) int64 { return t.BidSize },
+		func(t *marketdata.Tick) int64 { return t.AskSize },
+	} {
+		for _, t := range ticks {
+			cols.int64(f(t))
+		}
+	}
+	for _, f := range []func(*marketdata.Tick) string{
+		func(t *marketdata.Tick) string { return t.LastExch },
+		func(t *marketdata.Tick) string { return t.BidExch },
+		func(t *marketdata.Tick) string { return t.AskExch },
+		func(t *marketdata.Tick) string { return t.CondQuote },
+		func(t *marketdata.Tick) string { return t.Cond1 },
+		func(t *marketdata.Tick) string { return t.Cond2 },
+		func(t *marketdata.Tick) string { return t.Cond3 },
+		func(t *marketdata.Tick) string { return t.Cond4 },
+	} {
+		for _, t := range ticks {
+			cols.uint32(table.id(f(t)))
+		}
+	}
+	for _, t := range ticks {
+		var flags byte
+		if t.IsOpening {
+			flags |= 1
+		}
+		if t.IsClosing {
+			flags |= 2
+		}
+		cols.buf.WriteByte(flags)
+	}
+
+	var payload binaryBlockWriter
+	table.write(&payload)
+	payload.buf.Write(cols.buf.Bytes())
+	return payload.buf.Bytes()
+}
+
+func encodeCandlesBlock(candles CandleArray) []byte {
+	var table stringTable
+	var cols binaryBlockWriter
+	for _, c := range candles {
+		cols.int64(c.Datetime.UnixNano())
+	}
+	for _, c := range candles {
+		cols.uint32(table.id(c.Symbol))
+	}
+	for _, c := range candles {
+		cols.uint32(table.id(c.TimeFrame))
+	}
+	for _, f := range []func(*Candle) float64{
+		func(c *Candle) float64 { return c.Open },
+		func(c *Candle) float64 { return c.High },
+		func(c *Candle) float64 { return c.Low },
+		func(c *Candle) float64 { return c.Close },
+		func(c *Candle) float64 { return c.AdjClose },
+	} {
+		for _, c := range candles {
+			cols.float(f(c))
+		}
+	}
+	for _, c := range candles {
+		cols.int64(c.Volume)
+	}
+	for _, c := range candles {
+		cols.int64(c.OpenInterest)
+	}
+	for _, c := range candles {
+		var closeTime int64
+		if !c.CloseTime.IsZero() {
+			closeTime = c.CloseTime.UnixNano()
+		}
+		cols.int64(closeTime)
+	}
+
+	var payload binaryBlockWriter
+	table.write(&payload)
+	payload.buf.Write(cols.buf.Bytes())
+	return payload.buf.Bytes()
+}
+
+//appendPreparedBlocks appends blocks to binary prepared file. Header is written to new file
+func (m *BTM) appendPreparedBlocks(kind string, n int, encode func(from, to int) []byte) error {
+	f, err := os.OpenFile(m.getPrepairedFilePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h := m.newPreparedHeader(kind)
+	var out binaryBlockWriter
+	if info.Size() == 0 {
+		out.buf.Write(h.bytes())
+	}
+
+	for from := 0; from < n; from += preparedBlockSize {
+		to := from + preparedBlockSize
+		if to > n {
+			to = n
+		}
+		payload := encode(from, to)
+		if h.compressed {
+			var zbuf bytes.Buffer
+			zw, err := flate.NewWriter(&zbuf, flate.BestSpeed)
+			if err != nil {
+				return err
+			}
+			if _, err := zw.Write(payload); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			payload = zbuf.Bytes()
+		}
+		out.uint32(uint32(to - from))
+		out.uint32(uint32(len(payload)))
+		out.buf.Write(payload)
+	}
+
+	_, err = f.Write(out.buf.Bytes())
+	return err
+}
+
+func (m *BTM) writeBinaryTicks(ticks marketdata.TickArray) error {
+	return m.appendPreparedBlocks(preparedTicksKind, len(ticks), func(from, to int) []byte {
+		return encodeTicksBlock(ticks[from:to])
+	})
+}
+
+func (m *BTM) writeBinaryCandles(candles CandleArray) error {
+	return m.appendPreparedBlocks(preparedCandlesKind, len(candles), func(from, to int) []byte {
+		return encodeCandlesBlock(candles[from:to])
+	})
+}
+
+//******* READING *************************************************************
+
+type binaryBlockReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *binaryBlockReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = errors.New("Unexpected end of binary prepared data")
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *binaryBlockReader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *binaryBlockReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *binaryBlockReader) int64() int64 {
+	if b := r.take(8); b != nil {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *binaryBlockReader) str() string {
+	return string(r.take(int(r.uint32())))
+}
+
+//column returns bytes of column of n values of width size
+func (r *binaryBlockReader) column(n int, size int) []byte {
+	return r.take(n * size)
+}
+
+func (r *binaryBlockReader) stringTable() []string {
+	n := int(r.uint32())
+	if r.err != nil || n > len(r.data) {
+		r.err = errors.New("Broken string table of binary prepared data")
+		return nil
+	}
+	res := make([]string, n)
+	for i := range res {
+		res[i] = r.str()
+	}
+	return res
+}
+
+func (r *binaryBlockReader) strings(table []string, n int) []string {
+	col := r.column(n, 4)
+	if col == nil {
+		return nil
+	}
+	res := make([]string, n)
+	for i := range res {
+		id := binary.LittleEndian.Uint32(col[i*4:])
+		if int(id) >= len(table) {
+			r.err = errors.New("Wrong string id in binary prepared data")
+			return nil
+		}
+		res[i] = table[id]
+	}
+	return res
+}
+
+func floatAt(col []byte, i int) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(col[i*8:]))
+}
+
+func int64At(col []byte, i int) int64 {
+	return int64(binary.LittleEndian.Uint64(col[i*8:]))
+}
+
+func decodeTicksBlock(payload []byte, n int) (marketdata.TickArray, error) {
+	r := binaryBlockReader{data: payload}
+	table := r.stringTable()
+	times := r.column(n, 8)
+	symbols := r.strings(table, n)
+	var prices [3][]byte
+	for i := range prices {
+		prices[i] = r.column(n, 8)
+	}
+	var sizes [3][]byte
+	for i := range sizes {
+		sizes[i] = r.column(n, 8)
+	}
+	var strs [8][]string
+	for i := range strs {
+		strs[i] = r.strings(table, n)
+	}
+	flags := r.column(n, 1)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	ticks := make([]marketdata.Tick, n)
+	res := make(marketdata.TickArray, n)
+	for i := range ticks {
+		ticks[i] = marketdata.Tick{
+			Datetime:  time.Unix(0, int64At(times, i)),
+			Symbol:    symbols[i],
+			LastPrice: floatAt(prices[0], i),
+			BidPrice:  floatAt(prices[1], i),
+			AskPrice:  floatAt(prices[2], i),
+			LastSize:  int64At(sizes[0], i),
+			BidSize:   int64At(sizes[1], i),
+			AskSize:   int64At(sizes[2], i),
+			LastExch:  strs[0][i],
+			BidExch:   strs[1][i],
+			AskExch:   strs[2][i],
+			CondQuote: strs[3][i],
+			Cond1:     strs[4][i],
+			Cond2:     strs[5][i],
+			Cond3:     strs[6][i],
+			Cond4:     strs[7][i],
+			IsOpening: flags[i]&1 != 0,
+			IsClosing: flags[i]&2 != 0,
+		}
+		res[i] = &ticks[i]
+	}
+	return res, nil
+}
+
+func decodeCandlesBlock(payload []byte, n int, tickersMap map[string]*Instrument) (CandleArray, error) {
+	r := binaryBlockReader{data: payload}
+	table := r.stringTable()
+	times := r.column(n, 8)
+	symbols := r.strings(table, n)
+	timeFrames := r.strings(table, n)
+	var prices [5][]byte
+	for i := range prices {
+		prices[i] = r.column(n, 8)
+	}
+	volumes := r.column(n, 8)
+	interests := r.column(n, 8)
+	closeTimes := r.column(n, 8)
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	raw := make([]marketdata.Candle, n)
+	candles := make([]Candle, n)
+	res := make(CandleArray, n)
+	for i := range candles {
+		raw[i] = marketdata.Candle{
+			Datetime:     time.Unix(0, int64At(times, i)),
+			Symbol:       symbols[i],
+			Open:         floatAt(prices[0], i),
+			High:         floatAt(prices[1], i),
+			Low:          floatAt(prices[2], i),
+			Close:        floatAt(prices[3], i),
+			AdjClose:     floatAt(prices[4], i),
+			Volume:       int64At(volumes, i),
+			OpenInterest: int64At(interests, i),
+		}
+		candles[i] = Candle{Candle: &raw[i], Ticker: tickersMap[symbols[i]], TimeFrame: timeFrames[i]}
+		if ct := int64At(closeTimes, i); ct != 0 {
+			candles[i].CloseTime = time.Unix(0, ct)
+		}
+		res[i] = &candles[i]
+	}
+	return res, nil
+}
+
+//preparedBlocks iterates blocks of binary prepared file
+type preparedBlocks struct {
+	r          binaryBlockReader
+	compressed bool
+}
+
+//next returns records count and payload of the next block. Zero count means the end of file
+func (b *preparedBlocks) next() (int, []byte, error) {
+	if b.r.pos == len(b.r.data) {
+		return 0, nil, nil
+	}
+	n := int(b.r.uint32())
+	payload := b.r.take(int(b.r.uint32()))
+	if b.r.err != nil {
+		return 0, nil, b.r.err
+	}
+	if b.compressed {
+		zr := flate.NewReader(bytes.NewReader(payload))
+		defer zr.Close()
+		var err error
+		if payload, err = ioutil.ReadAll(zr); err != nil {
+			return 0, nil, errors.Wrap(err, "Can't decompress block of binary prepared data")
+		}
+	}
+	return n, payload, nil
+}
+
+//openBinaryPrepared maps prepared file to memory and checks that its header matches data of BTM
+func (m *BTM) openBinaryPrepared(kind string) (*preparedBlocks, func() error, error) {
+	f, err := os.Open(m.getPrepairedFilePath())
+	if err != nil {
+		return nil, nil, err
+	}
+	data, unmap, err := mmapFile(f)
+	f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h, n, err := parsePreparedHeader(data)
+	if err == nil {
+		if expected := m.newPreparedHeader(kind); !h.matches(expected) {
+			err = errors.New("Binary prepared file was written for other data")
+		}
+	}
+	if err != nil {
+		unmap()
+		return nil, nil, err
+	}
+	return &preparedBlocks{r: binaryBlockReader{data: data, pos: n}, compressed: h.compressed}, unmap, nil
+}
+
+func (m *BTM) openBinaryPreparedTicks() (func() (*marketdata.Tick, error), func(), error) {
+	blocks, unmap, err := m.openBinaryPrepared(preparedTicksKind)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ticks marketdata.TickArray
+	next := func() (*marketdata.Tick, error) {
+		for len(ticks) == 0 {
+			n, payload, err := blocks.next()
+			if err != nil || n == 0 {
+				return nil, err
+			}
+			if ticks, err = decodeTicksBlock(payload, n); err != nil {
+				return nil, err
+			}
+		}
+		t := ticks[0]
+		ticks = ticks[1:]
+		return t, nil
+	}
+	return next, m.closeBinaryPrepared(unmap), nil
+}
+
+func (m *BTM) openBinaryPreparedCandles(tickersMap map[string]*Instrument) (func() (*Candle, error), func(), error) {
+	blocks, unmap, err := m.openBinaryPrepared(preparedCandlesKind)
+	if err != nil {
+		return nil, nil, err
+	}
+	var candles CandleArray
+	next := func() (*Candle, error) {
+		for len(candles) == 0 {
+			n, payload, err := blocks.next()
+			if err != nil || n == 0 {
+				return nil, err
+			}
+			if candles, err = decodeCandlesBlock(payload, n, tickersMap); err != nil {
+				return nil, err
+			}
+		}
+		c := candles[0]
+		candles = candles[1:]
+		return c, nil
+	}
+	return next, m.closeBinaryPrepared(unmap), nil
+}
+
+func (m *BTM) closeBinaryPrepared(unmap func() error) func() {
+	return func() {
+		if err := unmap(); err != nil {
+			m.newError(err)
+		}
+	}
+}
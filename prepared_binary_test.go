@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBinaryBTM(t *testing.T, mode MarketDataMode, compress bool) (*BTM, func()) {
+	dir, err := ioutil.TempDir("", "prepared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestStorageFile(t, filepath.Join(dir, "Test", "2010-01-04.csv"),
+		"1262615400,10.01,100,10,200,10.02,300\n1262615401,,,10.01,100,10.03,100\n1262615460,10.05,50,,,,\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Other", "2010-01-05.csv"), "1262701800,20.5,10,,,,\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Test", "D.csv"),
+		"1262563200,9,10.2,8.8,10,2000\n1262649600,10,11,9.5,10.5,1000\n")
+	writeTestStorageFile(t, filepath.Join(dir, "Other", "D.csv"), "1262563200,20,21,19,20.5,300\n")
+
+	m := BTM{
+		Symbols:          []*Instrument{{Symbol: "Test"}, {Symbol: "Other"}},
+		Folder:           dir,
+		FromDate:         time.Date(2010, 1, 4, 0, 0, 0, 0, time.UTC),
+		ToDate:           time.Date(2010, 1, 5, 0, 0, 0, 0, time.UTC),
+		BinaryPrepared:   true,
+		CompressPrepared: compress,
+		Storage:          NewCSVStorage(dir),
+		mode:             mode,
+		candlesTimeFrame: "D",
+	}
+	m.Init(make(chan error, 10), make(chan event, 100))
+	return &m, func() { os.RemoveAll(dir) }
+}
+
+func readTestEvents(m *BTM) []event {
+	var res []event
+	for {
+		select {
+		case e := <-m.mdChan:
+			res = append(res, e)
+		default:
+			return res
+		}
+	}
+}
+
+func TestBTM_BinaryPreparedTicks(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		m, clean := newTestBinaryBTM(t, MarketDataModeTicks, compress)
+
+		t.Log("Binary prepared ticks: trades of all days are read back in order")
+		{
+			m.prepare()
+			f, err := m.getFilename()
+			assert.Nil(t, err)
+			assert.Equal(t, ".bprep", filepath.Ext(f))
+
+			next, closeData, err := m.openPreparedTicks()
+			if !assert.Nil(t, err) {
+				clean()
+				continue
+			}
+			var ticks marketdata.TickArray
+			for {
+				tick, err := next()
+				assert.Nil(t, err)
+				if tick == nil {
+					break
+				}
+				ticks = append(ticks, tick)
+			}
+			closeData()
+			if assert.Len(t, ticks, 3) {
+				assert.Equal(t, time.Unix(1262615400, 0), ticks[0].Datetime)
+				assert.Equal(t, "Test", ticks[0].Symbol)
+				assert.Equal(t, 10.01, ticks[0].LastPrice)
+				assert.Equal(t, int64(100), ticks[0].LastSize)
+				assert.Equal(t, 10.05, ticks[1].LastPrice)
+				assert.Equal(t, "Other", ticks[2].Symbol)
+			}
+		}
+
+		t.Log("Binary prepared ticks: generator puts tick events")
+		{
+			m.genTickEvents()
+			events := readTestEvents(m)
+			if assert.Len(t, events, 4) {
+				assert.Equal(t, 20.5, events[2].(*NewTickEvent).Tick.LastPrice)
+				assert.Equal(t, "Other", events[2].(*NewTickEvent).Ticker.Symbol)
+				assert.IsType(t, &EndOfDataEvent{}, events[3])
+			}
+		}
+		clean()
+	}
+}
+
+func TestBTM_BinaryPreparedCandles(t *testing.T) {
+	m, clean := newTestBinaryBTM(t, MarketDataModeCandles, true)
+	defer clean()
+
+	t.Log("Binary prepared candles: candles events of primary timeframe")
+	{
+		m.prepare()
+		m.genCandlesEvents()
+		events := readTestEvents(m)
+		if assert.Len(t, events, 7) {
+			open := events[0].(*CandleOpenEvent)
+			assert.Equal(t, "D", open.TimeFrame)
+			assert.Equal(t, 9.0, open.Price)
+			var closes []*CandleCloseEvent
+			for _, e := range events {
+				if ce, ok := e.(*CandleCloseEvent); ok {
+					closes = append(closes, ce)
+				}
+			}
+			if assert.Len(t, closes, 3) {
+				assert.Equal(t, 10.5, closes[2].Candle.Close)
+				assert.Equal(t, int64(1000), closes[2].Candle.Volume)
+			}
+		}
+	}
+
+	t.Log("Binary prepared candles: file of other data isn't read")
+	{
+		pth := m.getPrepairedFilePath()
+		m.candlesTimeFrame = "60"
+		assert.Nil(t, os.Rename(pth, m.getPrepairedFilePath()))
+		_, _, err := m.openPreparedCandles(m.getTickersMap())
+		assert.NotNil(t, err)
+
+		assert.Nil(t, ioutil.WriteFile(m.getPrepairedFilePath(), []byte("BTMB\x01"), 0644))
+		_, _, err = m.openPreparedCandles(m.getTickersMap())
+		assert.NotNil(t, err)
+	}
+}
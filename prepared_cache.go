@@ -0,0 +1,483 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+//PreparedManifestFile is name of manifest of prepaired files in folder of PreparedCache
+const PreparedManifestFile = "prepared_manifest.json"
+
+//IFilesStorage is storage of market data files. Files of symbols are sources of prepaired data: prepaired file is
+//rebuilt when they change. Empty timeframe is for ticks files
+type IFilesStorage interface {
+	StoredFiles(symbol string, tf string, dRange marketdata.DateRange) ([]string, error)
+}
+
+//PreparedSource is file of storage which prepaired file was built from
+type PreparedSource struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Checksum string    `json:"checksum"`
+}
+
+//PreparedCacheEntry is record of manifest about prepaired file: parameters of BTM it was built for, sources and
+//verified content
+type PreparedCacheEntry struct {
+	File       string           `json:"file"`
+	Symbols    []string         `json:"symbols"`
+	Mode       MarketDataMode   `json:"mode"`
+	TimeFrames []string         `json:"timeFrames,omitempty"`
+	From       time.Time        `json:"from"`
+	To         time.Time        `json:"to"`
+	Binary     bool             `json:"binary,omitempty"`
+	Compressed bool             `json:"compressed,omitempty"`
+	Sources    []PreparedSource `json:"sources,omitempty"`
+	Rows       int              `json:"rows"`
+	Size       int64            `json:"size"`
+	Checksum   string           `json:"checksum"`
+	Created    time.Time        `json:"created"`
+	LastUsed   time.Time        `json:"lastUsed"`
+}
+
+//sameParams returns true if entries are for the same market data
+func (e *PreparedCacheEntry) sameParams(other *PreparedCacheEntry) bool {
+	return fmt.Sprint(e.Symbols) == fmt.Sprint(other.Symbols) && e.Mode == other.Mode &&
+		fmt.Sprint(e.TimeFrames) == fmt.Sprint(other.TimeFrames) && e.From.Equal(other.From) &&
+		e.To.Equal(other.To) && e.Binary == other.Binary && e.Compressed == other.Compressed
+}
+
+//btm returns market data which reads prepaired file of entry
+func (e *PreparedCacheEntry) btm(folder string) *BTM {
+	m := BTM{
+		Folder:           folder,
+		FromDate:         e.From,
+		ToDate:           e.To,
+		BinaryPrepared:   e.Binary,
+		CompressPrepared: e.Compressed,
+		mode:             e.Mode,
+		errChan:          make(chan error, 1),
+		waitGroup:        &sync.WaitGroup{},
+	}
+	for _, s := range e.Symbols {
+		m.Symbols = append(m.Symbols, &Instrument{Symbol: s})
+	}
+	if len(e.TimeFrames) > 0 {
+		m.candlesTimeFrame = e.TimeFrames[0]
+		m.TimeFrames = e.TimeFrames[1:]
+	}
+	return &m
+}
+
+//PreparedCache keeps manifest of prepaired files of folder. Entries are checked against sources before use and
+//the least recently used files are removed when total size of files is over SizeLimit (zero is no limit)
+type PreparedCache struct {
+	Folder    string
+	SizeLimit int64
+	mut       sync.Mutex
+}
+
+func NewPreparedCache(folder string, sizeLimit int64) *PreparedCache {
+	return &PreparedCache{Folder: folder, SizeLimit: sizeLimit}
+}
+
+func (c *PreparedCache) manifestPath() string {
+	return path.Join(c.Folder, PreparedManifestFile)
+}
+
+func (c *PreparedCache) load() (map[string]*PreparedCacheEntry, error) {
+	entries := make(map[string]*PreparedCacheEntry)
+	data, err := ioutil.ReadFile(c.manifestPath())
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "Broken manifest of prepaired files")
+	}
+	return entries, nil
+}
+
+func (c *PreparedCache) save(entries map[string]*PreparedCacheEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.manifestPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.manifestPath())
+}
+
+//List returns entries of manifest, the most recently used first
+func (c *PreparedCache) List() ([]*PreparedCacheEntry, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	entries, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	var res []*PreparedCacheEntry
+	for _, e := range entries {
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastUsed.After(res[j].LastUsed)
+	})
+	return res, nil
+}
+
+//Verify checks prepaired file of entry fully: size, checksum, number of rows and their order and sources
+func (c *PreparedCache) Verify(file string) error {
+	c.mut.Lock()
+	entries, err := c.load()
+	c.mut.Unlock()
+	if err != nil {
+		return err
+	}
+	e, ok := entries[file]
+	if !ok {
+		return errors.New("Prepaired file isn't in manifest: " + file)
+	}
+	if err := c.checkFile(e); err != nil {
+		return err
+	}
+	checksum, err := fileChecksum(path.Join(c.Folder, e.File))
+	if err != nil {
+		return err
+	}
+	if checksum != e.Checksum {
+		return errors.New("Checksum of prepaired file was changed: " + file)
+	}
+	rows, err := countPreparedRows(e.btm(c.Folder))
+	if err != nil {
+		return errors.Wrap(err, file)
+	}
+	if rows != e.Rows {
+		return errors.Errorf("Prepaired file %v has %v rows instead of %v", file, rows, e.Rows)
+	}
+	_, err = checkSources(e.Sources)
+	return err
+}
+
+//Purge removes prepaired files and their entries. All files of manifest are removed if no files passed
+func (c *PreparedCache) Purge(files ...string) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	entries, err := c.load()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		for f := range entries {
+			files = append(files, f)
+		}
+	}
+	for _, f := range files {
+		if _, ok := entries[f]; !ok {
+			return errors.New("Prepaired file isn't in manifest: " + f)
+		}
+		if err := c.remove(entries, f); err != nil {
+			return err
+		}
+	}
+	return c.save(entries)
+}
+
+func (c *PreparedCache) remove(entries map[string]*PreparedCacheEntry, file string) error {
+	delete(entries, file)
+	err := os.Remove(path.Join(c.Folder, file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//evict removes the least recently used files until total size is in limit. Kept file isn't removed
+func (c *PreparedCache) evict(entries map[string]*PreparedCacheEntry, keep string) error {
+	if c.SizeLimit <= 0 {
+		return nil
+	}
+	var total int64
+	var lru []*PreparedCacheEntry
+	for _, e := range entries {
+		total += e.Size
+		if e.File != keep {
+			lru = append(lru, e)
+		}
+	}
+	sort.Slice(lru, func(i, j int) bool {
+		return lru[i].LastUsed.Before(lru[j].LastUsed)
+	})
+	for _, e := range lru {
+		if total <= c.SizeLimit {
+			break
+		}
+		if err := c.remove(entries, e.File); err != nil {
+			return err
+		}
+		total -= e.Size
+	}
+	return nil
+}
+
+//checkFile is quick check of entry: prepaired file exists and has the same size
+func (c *PreparedCache) checkFile(e *PreparedCacheEntry) error {
+	info, err := os.Stat(path.Join(c.Folder, e.File))
+	if err != nil {
+		return err
+	}
+	if info.Size() != e.Size {
+		return errors.New("Size of prepaired file was changed: " + e.File)
+	}
+	return nil
+}
+
+//valid checks that prepaired file of market data is in manifest and its sources weren't changed. Valid entry is
+//marked as used
+func (c *PreparedCache) valid(m *BTM) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	entries, err := c.load()
+	if err != nil {
+		return err
+	}
+	expected, err := m.newPreparedCacheEntry()
+	if err != nil {
+		return err
+	}
+	e, ok := entries[expected.File]
+	if !ok {
+		return errors.New("Prepaired file isn't in manifest: " + expected.File)
+	}
+	if !e.sameParams(expected) {
+		return errors.New("Prepaired file was built for other parameters: " + expected.File)
+	}
+	if err := c.checkFile(e); err != nil {
+		return err
+	}
+	if len(e.Sources) != len(expected.Sources) {
+		return errors.New("Source files of prepaired file were changed: " + e.File)
+	}
+	for i := range e.Sources {
+		if e.Sources[i].Path != expected.Sources[i].Path {
+			return errors.New("Source files of prepaired file were changed: " + e.File)
+		}
+	}
+	updated, err := checkSources(e.Sources)
+	if err != nil {
+		return err
+	}
+	e.Sources = updated
+	e.LastUsed = time.Now()
+	return c.save(entries)
+}
+
+//add puts entry of just prepaired file of market data to manifest and evicts old files
+func (c *PreparedCache) add(m *BTM) error {
+	e, err := m.newPreparedCacheEntry()
+	if err != nil {
+		return err
+	}
+	for i := range e.Sources {
+		if e.Sources[i].Checksum, err = fileChecksum(e.Sources[i].Path); err != nil {
+			return err
+		}
+	}
+	pth := path.Join(c.Folder, e.File)
+	info, err := os.Stat(pth)
+	if err != nil {
+		return err
+	}
+	e.Size = info.Size()
+	if e.Checksum, err = fileChecksum(pth); err != nil {
+		return err
+	}
+	if e.Rows, err = countPreparedRows(m); err != nil {
+		return err
+	}
+	e.Created = time.Now()
+	e.LastUsed = e.Created
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	entries, err := c.load()
+	if err != nil {
+		return err
+	}
+	entries[e.File] = e
+	if err := c.evict(entries, e.File); err != nil {
+		return err
+	}
+	return c.save(entries)
+}
+
+//checkSources returns error if source file is missing or its content was changed. Sources with changed
+//modification time but the same checksum are valid and returned with new time
+func checkSources(sources []PreparedSource) ([]PreparedSource, error) {
+	res := make([]PreparedSource, len(sources))
+	for i, s := range sources {
+		info, err := os.Stat(s.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "Source file of prepaired file")
+		}
+		if info.Size() != s.Size {
+			return nil, errors.New("Source file was changed: " + s.Path)
+		}
+		if !info.ModTime().Equal(s.ModTime) {
+			checksum, err := fileChecksum(s.Path)
+			if err != nil {
+				return nil, err
+			}
+			if checksum != s.Checksum {
+				return nil, errors.New("Source file was changed: " + s.Path)
+			}
+			s.ModTime = info.ModTime()
+		}
+		res[i] = s
+	}
+	return res, nil
+}
+
+func fileChecksum(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//countPreparedRows reads prepaired file and returns number of records. Records out of time order are error
+func countPreparedRows(m *BTM) (int, error) {
+	var rows int
+	var last time.Time
+	check := func(t time.Time) error {
+		if t.Before(last) {
+			return errors.Errorf("Record %v is out of time order", rows+1)
+		}
+		last = t
+		rows++
+		return nil
+	}
+
+	if m.mode == MarketDataModeCandles {
+		next, closeData, err := m.openPreparedCandles(m.getTickersMap())
+		if err != nil {
+			return 0, err
+		}
+		defer closeData()
+		for {
+			c, err := next()
+			if err != nil || c == nil {
+				return rows, err
+			}
+			if err := check(c.Datetime); err != nil {
+				return rows, err
+			}
+		}
+	}
+
+	next, closeData, err := m.openPreparedTicks()
+	if err != nil {
+		return 0, err
+	}
+	defer closeData()
+	for {
+		t, err := next()
+		if err != nil || t == nil {
+			return rows, err
+		}
+		if err := check(t.Datetime); err != nil {
+			return rows, err
+		}
+	}
+}
+
+//newPreparedCacheEntry returns entry of prepaired file with parameters and sources of market data
+func (m *BTM) newPreparedCacheEntry() (*PreparedCacheEntry, error) {
+	file, err := m.getFilename()
+	if err != nil {
+		return nil, err
+	}
+	e := PreparedCacheEntry{
+		File:       file,
+		Mode:       m.mode,
+		From:       m.FromDate,
+		To:         m.ToDate,
+		Binary:     m.BinaryPrepared,
+		Compressed: m.BinaryPrepared && m.CompressPrepared,
+	}
+	for _, s := range m.Symbols {
+		e.Symbols = append(e.Symbols, s.Symbol)
+	}
+	sort.Strings(e.Symbols)
+
+	tfs := []string{""}
+	rng := marketdata.DateRange{From: m.FromDate, To: nextDay(m.ToDate).Add(-time.Nanosecond)}
+	if m.mode == MarketDataModeCandles {
+		e.TimeFrames = m.timeFrames()
+		tfs = e.TimeFrames
+		rng.To = m.ToDate
+	}
+
+	fs, ok := m.Storage.(IFilesStorage)
+	if !ok {
+		return &e, nil
+	}
+	for _, symbol := range e.Symbols {
+		for _, tf := range tfs {
+			files, err := fs.StoredFiles(symbol, tf, rng)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range files {
+				info, err := os.Stat(f)
+				if err != nil {
+					return nil, err
+				}
+				e.Sources = append(e.Sources, PreparedSource{Path: f, Size: info.Size(), ModTime: info.ModTime()})
+			}
+		}
+	}
+	return &e, nil
+}
+
+//checkPrepared prepares data if prepaired file doesn't exist. With managed prepaired files it is prepared also
+//when file isn't valid for sources
+func (m *BTM) checkPrepared() {
+	if !m.ManagePrepared {
+		if !m.prepairedDataExists() {
+			m.prepare()
+		}
+		return
+	}
+
+	cache := NewPreparedCache(m.Folder, m.PreparedSizeLimit)
+	if err := cache.valid(m); err == nil {
+		return
+	}
+	m.prepare()
+	if err := cache.add(m); err != nil {
+		m.newError(err)
+	}
+}
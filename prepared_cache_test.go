@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestManagedBTM(dir string, binary bool) *BTM {
+	m := BTM{
+		Symbols:          []*Instrument{{Symbol: "Test"}},
+		Folder:           dir,
+		FromDate:         time.Date(2010, 1, 4, 0, 0, 0, 0, time.UTC),
+		ToDate:           time.Date(2010, 1, 5, 0, 0, 0, 0, time.UTC),
+		BinaryPrepared:   binary,
+		ManagePrepared:   true,
+		Storage:          NewCSVStorage(dir),
+		mode:             MarketDataModeTicks,
+		candlesTimeFrame: "D",
+		waitGroup:        &sync.WaitGroup{},
+	}
+	m.Init(make(chan error, 10), make(chan event, 100))
+	return &m
+}
+
+func TestPreparedCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prepcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "Test", "2010-01-04.csv")
+	writeTestStorageFile(t, source, "1262615400,10.01,100,,,,\n1262615460,10.05,50,,,,\n")
+	cache := NewPreparedCache(dir, 0)
+
+	m := newTestManagedBTM(dir, false)
+	file, err := m.getFilename()
+	assert.Nil(t, err)
+
+	t.Log("Prepaired file is recorded to manifest with sources and rows")
+	{
+		m.checkPrepared()
+		entries, err := cache.List()
+		assert.Nil(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, file, entries[0].File)
+			assert.Equal(t, 2, entries[0].Rows)
+			if assert.Len(t, entries[0].Sources, 1) {
+				assert.Equal(t, source, entries[0].Sources[0].Path)
+			}
+		}
+		assert.Nil(t, cache.valid(m))
+		assert.Nil(t, cache.Verify(file))
+	}
+
+	t.Log("Source with new modification time and the same content is valid")
+	{
+		later := time.Now().Add(time.Hour)
+		assert.Nil(t, os.Chtimes(source, later, later))
+		assert.Nil(t, cache.valid(m))
+	}
+
+	t.Log("Changed and new sources make file invalid, it is rebuilt")
+	{
+		writeTestStorageFile(t, source, "1262615400,10.01,100,,,,\n1262615460,10.05,50,,,,\n1262615520,10.1,50,,,,\n")
+		assert.NotNil(t, cache.valid(m))
+		m.checkPrepared()
+		assert.Nil(t, cache.valid(m))
+		entries, _ := cache.List()
+		assert.Equal(t, 3, entries[0].Rows)
+
+		writeTestStorageFile(t, filepath.Join(dir, "Test", "2010-01-05.csv"), "1262701800,10.2,10,,,,\n")
+		assert.NotNil(t, cache.valid(m))
+		m.checkPrepared()
+		assert.Nil(t, cache.valid(m))
+	}
+
+	t.Log("Verify finds changed prepaired file and wrong order of rows")
+	{
+		pth := filepath.Join(dir, file)
+		data, err := ioutil.ReadFile(pth)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(pth, append(data, []byte("1262615400,Test,1,1,,0,0,,0,0,,,,,,\n")...), 0644))
+		assert.NotNil(t, cache.Verify(file))
+		assert.NotNil(t, cache.valid(m))
+
+		rows, err := countPreparedRows(m)
+		assert.Equal(t, 4, rows)
+		assert.NotNil(t, err)
+	}
+
+	t.Log("The least recently used files are evicted over size limit")
+	{
+		m.checkPrepared()
+		binary := newTestManagedBTM(dir, true)
+		binary.PreparedSizeLimit = 1
+		binary.checkPrepared()
+		binaryFile, _ := binary.getFilename()
+
+		entries, err := cache.List()
+		assert.Nil(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, binaryFile, entries[0].File)
+		}
+		_, err = os.Stat(filepath.Join(dir, file))
+		assert.True(t, os.IsNotExist(err))
+		assert.Nil(t, cache.Verify(binaryFile))
+	}
+
+	t.Log("Purge removes files and entries")
+	{
+		assert.NotNil(t, cache.Purge("missing.prep"))
+		assert.Nil(t, cache.Purge())
+		entries, err := cache.List()
+		assert.Nil(t, err)
+		assert.Len(t, entries, 0)
+		assert.False(t, newTestManagedBTM(dir, true).prepairedDataExists())
+	}
+}
@@ -0,0 +1,385 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ITimerStrategy is optional interface of user strategy. Strategies that implement it get OnTimer calls for
+//schedules registered with BasicStrategy.Schedule* methods
+type ITimerStrategy interface {
+	OnTimer(b *BasicStrategy, scheduleId string, t time.Time)
+}
+
+//schedule is a rule of timer events for strategy. latest returns the most recent fire time not after t,
+//nextAfter returns the first fire time after t
+type schedule interface {
+	latest(t time.Time) time.Time
+	nextAfter(t time.Time) time.Time
+}
+
+//scheduleState keeps next fire time of schedule. Zero next means schedule wasn't started yet
+type scheduleState struct {
+	id   string
+	rule schedule
+	next time.Time
+}
+
+type strategySchedules struct {
+	states map[string]*scheduleState
+	mut    *sync.Mutex
+}
+
+//everySchedule fires every period. Fire times are aligned to multiples of period
+type everySchedule struct {
+	period time.Duration
+}
+
+func (s *everySchedule) latest(t time.Time) time.Time {
+	return t.Truncate(s.period)
+}
+
+func (s *everySchedule) nextAfter(t time.Time) time.Time {
+	return t.Truncate(s.period).Add(s.period)
+}
+
+//dailySchedule fires on trading days at time of day or at market open/close of exchange plus offset. Fire
+//times are wall clock times, so they don't move across DST changes. Without exchange time of day is taken in
+//location of clock time and weekends are skipped. With exchange time of day is taken in exchange location
+//and holidays and early closes of its calendar are used
+type dailySchedule struct {
+	at       TimeOfDay
+	anchor   dailyAnchor
+	offset   time.Duration
+	exchange *Exchange
+}
+
+//maxScheduleLookupDays is number of days which schedules look through for fire time. Schedule without fire
+//time in these days gives zero time
+const maxScheduleLookupDays = 366 * 5
+
+type dailyAnchor int
+
+const (
+	anchorTimeOfDay dailyAnchor = iota
+	anchorOpen
+	anchorClose
+)
+
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+func (s *dailySchedule) localDay(t time.Time) time.Time {
+	if s.exchange != nil {
+		t = s.exchange.localTime(t)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func (s *dailySchedule) isFireDay(day time.Time) bool {
+	if s.exchange == nil {
+		return !isWeekend(day)
+	}
+	return s.exchange.IsTradingDay(day)
+}
+
+//atDay returns fire time of day. Day should be midnight in schedule location
+func (s *dailySchedule) atDay(day time.Time) time.Time {
+	var base time.Time
+	switch {
+	case s.exchange != nil && s.anchor == anchorOpen:
+		base = s.exchange.OpenTime(day)
+	case s.exchange != nil && s.anchor == anchorClose:
+		base = s.exchange.CloseTime(day)
+	default:
+		base = sessionTime(day, s.at)
+	}
+	return base.Add(s.offset)
+}
+
+func (s *dailySchedule) latest(t time.Time) time.Time {
+	day := s.localDay(t)
+	for i := 0; i < maxScheduleLookupDays; i++ {
+		if s.isFireDay(day) {
+			if c := s.atDay(day); !c.After(t) {
+				return c
+			}
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return time.Time{}
+}
+
+func (s *dailySchedule) nextAfter(t time.Time) time.Time {
+	day := s.localDay(t)
+	for i := 0; i < maxScheduleLookupDays; i++ {
+		if s.isFireDay(day) {
+			if c := s.atDay(day); c.After(t) {
+				return c
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+//******* CRON ****************************************************************
+
+//cronSchedule is parsed 5 fields cron expression: minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	minutes    map[int]bool
+	hours      map[int]bool
+	days       map[int]bool
+	months     map[int]bool
+	weekdays   map[int]bool
+	anyDay     bool
+	anyWeekday bool
+	maxLookup  int
+}
+
+func parseCronField(field string, min int, max int) (map[int]bool, bool, error) {
+	values := make(map[int]bool)
+	isAny := field == "*"
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, false, errors.New("Wrong cron step: " + part)
+			}
+			step = s
+			part = part[:i]
+		}
+
+		from, to := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			f, err1 := strconv.Atoi(bounds[0])
+			t, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, false, errors.New("Wrong cron range: " + part)
+			}
+			from, to = f, t
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, false, errors.New("Wrong cron value: " + part)
+			}
+			from, to = v, v
+		}
+
+		if from < min || to > max || from > to {
+			return nil, false, fmt.Errorf("Cron value out of range [%v, %v]: %v", min, max, part)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, isAny, nil
+}
+
+//parseCron parses expression like "*/5 9-16 * * 1-5". Day of week is 0-7 where 0 and 7 are Sunday
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("Cron expression should have 5 fields: " + expr)
+	}
+	c := cronSchedule{maxLookup: maxScheduleLookupDays}
+	var err error
+	if c.minutes, _, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if c.hours, _, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if c.days, c.anyDay, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.months, _, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.weekdays, c.anyWeekday, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if c.weekdays[7] {
+		c.weekdays[0] = true
+	}
+	return &c, nil
+}
+
+//matchDay uses cron rule: if both day of month and day of week are restricted, any of them should match
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	if !c.months[int(t.Month())] {
+		return false
+	}
+	day := c.days[t.Day()]
+	weekday := c.weekdays[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+//minutesOfDay returns sorted fire minutes of day
+func (c *cronSchedule) minutesOfDay() []int {
+	var res []int
+	for h := range c.hours {
+		for m := range c.minutes {
+			res = append(res, h*60+m)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+func (c *cronSchedule) nextAfter(t time.Time) time.Time {
+	minutes := c.minutesOfDay()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for i := 0; i < c.maxLookup; i++ {
+		if c.matchDay(day) {
+			for _, m := range minutes {
+				fire := time.Date(day.Year(), day.Month(), day.Day(), m/60, m%60, 0, 0, day.Location())
+				if fire.After(t) {
+					return fire
+				}
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) latest(t time.Time) time.Time {
+	minutes := c.minutesOfDay()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for i := 0; i < c.maxLookup; i++ {
+		if c.matchDay(day) {
+			for j := len(minutes) - 1; j >= 0; j-- {
+				m := minutes[j]
+				fire := time.Date(day.Year(), day.Month(), day.Day(), m/60, m%60, 0, 0, day.Location())
+				if !fire.After(t) {
+					return fire
+				}
+			}
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return time.Time{}
+}
+
+//******* STRATEGY API ********************************************************
+
+func (b *BasicStrategy) addSchedule(id string, rule schedule) error {
+	if id == "" {
+		return errors.New("Schedule id is empty")
+	}
+	if b.schedules.mut == nil {
+		b.schedules = strategySchedules{states: make(map[string]*scheduleState), mut: &sync.Mutex{}}
+	}
+	b.schedules.mut.Lock()
+	defer b.schedules.mut.Unlock()
+	if _, ok := b.schedules.states[id]; ok {
+		return errors.New("Schedule already exists: " + id)
+	}
+	b.schedules.states[id] = &scheduleState{id: id, rule: rule}
+	return nil
+}
+
+//ScheduleEvery registers timer that fires every period. Fire times are aligned to multiples of period
+func (b *BasicStrategy) ScheduleEvery(id string, period time.Duration) error {
+	if period <= 0 {
+		return errors.New("Schedule period should be positive")
+	}
+	return b.addSchedule(id, &everySchedule{period: period})
+}
+
+//ScheduleAt registers timer that fires every week day at given time of day in location of clock time
+func (b *BasicStrategy) ScheduleAt(id string, t TimeOfDay) error {
+	return b.addSchedule(id, &dailySchedule{at: t})
+}
+
+//ScheduleAtOpen registers timer that fires every trading day at market open of strategy instrument plus
+//offset. Offset can be negative
+func (b *BasicStrategy) ScheduleAtOpen(id string, offset time.Duration) error {
+	if b.symbol == nil {
+		return errors.New("Strategy instrument is not set")
+	}
+	return b.addSchedule(id, &dailySchedule{anchor: anchorOpen, offset: offset, exchange: &b.symbol.Exchange})
+}
+
+//ScheduleAtClose registers timer that fires every trading day at market close of strategy instrument plus
+//offset. Early closes of exchange calendar are used. Use negative offset to fire before close
+func (b *BasicStrategy) ScheduleAtClose(id string, offset time.Duration) error {
+	if b.symbol == nil {
+		return errors.New("Strategy instrument is not set")
+	}
+	return b.addSchedule(id, &dailySchedule{anchor: anchorClose, offset: offset, exchange: &b.symbol.Exchange})
+}
+
+//ScheduleCron registers timer with 5 fields cron expression: minute hour day-of-month month day-of-week
+func (b *BasicStrategy) ScheduleCron(id string, expr string) error {
+	c, err := parseCron(expr)
+	if err != nil {
+		return err
+	}
+	return b.addSchedule(id, c)
+}
+
+//CancelSchedule removes timer. Returns false if there is no schedule with such id
+func (b *BasicStrategy) CancelSchedule(id string) bool {
+	if b.schedules.mut == nil {
+		return false
+	}
+	b.schedules.mut.Lock()
+	defer b.schedules.mut.Unlock()
+	if _, ok := b.schedules.states[id]; !ok {
+		return false
+	}
+	delete(b.schedules.states, id)
+	return true
+}
+
+//dueTimers returns timer events for schedules which fire time came. Schedule fires once even if several fire
+//times were missed: event has the most recent fire time. Schedules start at the first check, so timers
+//registered before market data don't fire for the past, but fire time equal to time of the first check fires
+func (b *BasicStrategy) dueTimers(now time.Time) []*TimerTickEvent {
+	if b.schedules.mut == nil {
+		return nil
+	}
+	b.schedules.mut.Lock()
+	defer b.schedules.mut.Unlock()
+
+	var events []*TimerTickEvent
+	for _, s := range b.schedules.states {
+		if s.next.IsZero() {
+			s.next = s.rule.nextAfter(now.Add(-time.Nanosecond))
+		}
+		if s.next.IsZero() || now.Before(s.next) {
+			continue
+		}
+		fireTime := s.rule.latest(now)
+		s.next = s.rule.nextAfter(now)
+		events = append(events, &TimerTickEvent{BaseEvent: be(fireTime, b.symbol), ScheduleId: s.id})
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Time.Equal(events[j].Time) {
+			return events[i].ScheduleId < events[j].ScheduleId
+		}
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
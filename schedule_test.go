@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type timerTestStrategy struct {
+	DummyStrategyWithLogic
+	fired []string
+	times []time.Time
+}
+
+func (s *timerTestStrategy) OnTimer(b *BasicStrategy, scheduleId string, t time.Time) {
+	s.fired = append(s.fired, scheduleId)
+	s.times = append(s.times, t)
+}
+
+func newTestTimerStrategy() (*BasicStrategy, *timerTestStrategy) {
+	us := timerTestStrategy{}
+	bs := BasicStrategy{symbol: newTestInstrument(), nPeriods: 20, userStrategy: &us}
+	bs.init(CoreStrategyChannels{
+		errors:    make(chan error),
+		events:    make(chan event),
+		portfolio: make(chan *PortfolioNewPositionEvent, 5),
+	})
+	bs.mdChan = make(chan event, 1)
+	bs.mdChan <- &NewTickEvent{}
+	bs.handlersWaitGroup = &sync.WaitGroup{}
+	return &bs, &us
+}
+
+func TestSchedules_Rules(t *testing.T) {
+	//2010-01-05 is Tuesday
+	tm := time.Date(2010, 1, 5, 10, 0, 7, 0, time.UTC)
+
+	t.Log("Every: fire times are aligned to period")
+	{
+		s := everySchedule{period: 5 * time.Second}
+		assert.Equal(t, tm.Add(3*time.Second), s.nextAfter(tm))
+		assert.Equal(t, tm.Add(-2*time.Second), s.latest(tm))
+	}
+
+	t.Log("Daily: weekends are skipped")
+	{
+		s := dailySchedule{at: TimeOfDay{15, 55, 0}}
+		assert.Equal(t, time.Date(2010, 1, 5, 15, 55, 0, 0, time.UTC), s.nextAfter(tm))
+		assert.Equal(t, time.Date(2010, 1, 4, 15, 55, 0, 0, time.UTC), s.latest(tm))
+
+		friday := time.Date(2010, 1, 8, 16, 0, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2010, 1, 11, 15, 55, 0, 0, time.UTC), s.nextAfter(friday))
+		monday := time.Date(2010, 1, 11, 9, 30, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2010, 1, 8, 15, 55, 0, 0, time.UTC), s.latest(monday))
+	}
+
+	t.Log("Cron: every 15 minutes during market hours on week days")
+	{
+		s, err := parseCron("*/15 10-15 * * 1-5")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2010, 1, 5, 10, 15, 0, 0, time.UTC), s.nextAfter(tm))
+		assert.Equal(t, time.Date(2010, 1, 5, 10, 0, 0, 0, time.UTC), s.latest(tm))
+
+		friday := time.Date(2010, 1, 8, 15, 50, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2010, 1, 11, 10, 0, 0, 0, time.UTC), s.nextAfter(friday))
+	}
+
+	t.Log("Cron: day of month or day of week")
+	{
+		s, err := parseCron("30 9 1,15 * 0")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2010, 1, 10, 9, 30, 0, 0, time.UTC), s.nextAfter(tm))
+		assert.Equal(t, time.Date(2010, 1, 3, 9, 30, 0, 0, time.UTC), s.latest(tm))
+	}
+
+	t.Log("Cron: wrong expressions")
+	{
+		for _, expr := range []string{"* * * *", "60 * * * *", "* 10-5 * * *", "*/0 * * * *", "a * * * *"} {
+			_, err := parseCron(expr)
+			assert.NotNil(t, err, expr)
+		}
+	}
+}
+
+func TestBasicStrategy_DueTimers(t *testing.T) {
+	tm := time.Date(2010, 1, 5, 10, 0, 7, 0, time.UTC)
+
+	t.Log("Schedules don't fire for the past and fire once for missed times")
+	{
+		bs, _ := newTestTimerStrategy()
+		assert.Nil(t, bs.ScheduleEvery("5s", 5*time.Second))
+		assert.Nil(t, bs.ScheduleAtClose("beforeClose", -5*time.Minute))
+		assert.NotNil(t, bs.ScheduleEvery("5s", time.Second))
+		assert.NotNil(t, bs.ScheduleCron("bad", "* *"))
+
+		assert.Len(t, bs.dueTimers(tm), 0)
+		assert.Len(t, bs.dueTimers(tm.Add(2*time.Second)), 0)
+
+		due := bs.dueTimers(tm.Add(4 * time.Second))
+		if assert.Len(t, due, 1) {
+			assert.Equal(t, "5s", due[0].ScheduleId)
+			assert.Equal(t, tm.Add(3*time.Second), due[0].getTime())
+			assert.Equal(t, "Test", due[0].getSymbol())
+		}
+
+		closeTime := time.Date(2010, 1, 5, 15, 56, 1, 0, time.UTC)
+		due = bs.dueTimers(closeTime)
+		if assert.Len(t, due, 2) {
+			assert.Equal(t, "beforeClose", due[0].ScheduleId)
+			assert.Equal(t, time.Date(2010, 1, 5, 15, 55, 0, 0, time.UTC), due[0].getTime())
+			assert.Equal(t, "5s", due[1].ScheduleId)
+			assert.Equal(t, closeTime.Add(-time.Second), due[1].getTime())
+		}
+
+		assert.True(t, bs.CancelSchedule("5s"))
+		assert.False(t, bs.CancelSchedule("5s"))
+		assert.Len(t, bs.dueTimers(closeTime.Add(time.Hour)), 0)
+	}
+
+	t.Log("Fire time equal to time of the first check fires, also for schedule added later")
+	{
+		bs, _ := newTestTimerStrategy()
+		assert.Nil(t, bs.ScheduleAtOpen("open", 0))
+		open := time.Date(2010, 1, 5, 9, 30, 0, 0, time.UTC)
+		due := bs.dueTimers(open)
+		if assert.Len(t, due, 1) {
+			assert.Equal(t, "open", due[0].ScheduleId)
+			assert.Equal(t, open, due[0].getTime())
+		}
+		assert.Len(t, bs.dueTimers(open.Add(time.Minute)), 0)
+
+		assert.Nil(t, bs.ScheduleEvery("1m", time.Minute))
+		due = bs.dueTimers(open.Add(2 * time.Minute))
+		if assert.Len(t, due, 1) {
+			assert.Equal(t, "1m", due[0].ScheduleId)
+			assert.Equal(t, open.Add(2*time.Minute), due[0].getTime())
+		}
+	}
+
+	t.Log("Timer events are delivered to OnTimer")
+	{
+		bs, us := newTestTimerStrategy()
+		fireTime := tm.Add(3 * time.Second)
+		bs.notify(&TimerTickEvent{BaseEvent: be(fireTime, bs.symbol), ScheduleId: "5s"})
+		bs.handlersWaitGroup.Wait()
+
+		assert.Equal(t, []string{"5s"}, us.fired)
+		assert.Equal(t, []time.Time{fireTime}, us.times)
+		assert.Equal(t, fireTime, bs.Now())
+	}
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+//Locate is short sale availability of symbol. Easy to borrow symbol can be shorted without limit. Hard to borrow
+//symbol can be shorted up to Available shares. BorrowRate is annual fee rate of borrowed shares value
+type Locate struct {
+	HardToBorrow bool
+	Available    int64
+	BorrowRate   float64
+}
+
+const (
+	//ssrDrop is intraday drop from previous close that triggers short sale restriction of Rule 201
+	ssrDrop = 0.1
+	//borrowFeeDayCount is day count of annual borrow rate
+	borrowFeeDayCount = 360
+)
+
+//pendingSellQty returns not executed qty of active sell orders except order with skipId
+func (b *simBrokerWorker) pendingSellQty(skipId string) int64 {
+	qty := int64(0)
+	for _, o := range b.orders {
+		if o.Id == skipId || o.Side != OrderSell || !o.isActive() {
+			continue
+		}
+		qty += o.BrokerQty - o.BrokerExecQty
+	}
+	return qty
+}
+
+//shortQtyAfter returns short qty of position if sell order for qty and all active sell orders are filled. Zero
+//means that order is not short sale
+func (b *simBrokerWorker) shortQtyAfter(o *Order, qty int64) int64 {
+	if o.Side != OrderSell {
+		return 0
+	}
+	after := b.position - b.pendingSellQty(o.Id) - qty
+	if after >= 0 {
+		return 0
+	}
+	return -after
+}
+
+//validateShortSale returns reason of rejection for short sale of qty that has no locate or is priced at or
+//below national best bid under SSR
+func (b *simBrokerWorker) validateShortSale(o *Order, price float64, qty int64, t time.Time) string {
+	short := b.shortQtyAfter(o, qty)
+	if short == 0 {
+		return ""
+	}
+
+	if b.checkLocates {
+		if b.locate == nil {
+			return "No locate for short sale of " + o.Ticker.Symbol
+		}
+		if b.locate.HardToBorrow && short > b.locate.Available {
+			return fmt.Sprintf("Not enough shares to borrow. Short qty: %v, available: %v", short,
+				b.locate.Available)
+		}
+	}
+
+	if b.isSSRActive(t) {
+		switch o.Type {
+		case LimitOrder, LimitOnOpen, LimitOnClose:
+			if b.lastBid > 0 && price <= b.lastBid {
+				return fmt.Sprintf("Short sale price %v is not above national best bid %v under SSR", price,
+					b.lastBid)
+			}
+		default:
+			return "Short sale must be limit order under SSR. Order type: " + string(o.Type)
+		}
+	}
+	return ""
+}
+
+//isSSRActive returns true if short sale restriction was triggered in session of t or in previous session
+func (b *simBrokerWorker) isSSRActive(t time.Time) bool {
+	if !b.ssr {
+		return false
+	}
+	if b.symbol.Exchange.OpenTime(t).Equal(b.sessionOpen) {
+		return b.ssrToday || b.ssrCarry
+	}
+	//The first market data of the next session hasn't come yet
+	return b.ssrToday
+}
+
+//updateShortSale tracks previous close and best bid and triggers SSR when trade price drops 10% below previous
+//close. Borrow fee of short position held overnight is charged at the first market data of new session
+func (b *simBrokerWorker) updateShortSale(t time.Time, price float64, bid float64) {
+	open := b.symbol.Exchange.OpenTime(t)
+	if !open.Equal(b.sessionOpen) {
+		if !b.sessionOpen.IsZero() {
+			b.chargeBorrowFee(t)
+			b.prevClose = b.lastPrice
+			b.ssrCarry = b.ssrToday
+		}
+		b.ssrToday = false
+		b.sessionOpen = open
+	}
+
+	if bid > 0 && !math.IsNaN(bid) {
+		b.lastBid = bid
+	}
+	if price <= 0 || math.IsNaN(price) {
+		return
+	}
+	b.lastPrice = price
+	if b.ssr && b.prevClose > 0 && price <= b.prevClose*(1-ssrDrop) {
+		b.ssrToday = true
+	}
+}
+
+//chargeBorrowFee generates fee of short position for calendar days since previous session at previous close
+//price
+func (b *simBrokerWorker) chargeBorrowFee(t time.Time) {
+	if b.position >= 0 || b.locate == nil || b.locate.BorrowRate <= 0 || b.lastPrice <= 0 {
+		return
+	}
+	exchange := b.symbol.Exchange
+	from := exchange.localTime(b.sessionOpen)
+	to := exchange.localTime(t)
+	days := math.Round(time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC).Sub(
+		time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	if days <= 0 {
+		return
+	}
+
+	qty := -b.position
+	e := BorrowFeeEvent{
+		BaseEvent: be(t, b.symbol),
+		Qty:       qty,
+		Price:     b.lastPrice,
+		Fee:       float64(qty) * b.lastPrice * b.locate.BorrowRate / borrowFeeDayCount * days,
+	}
+	b.addBrokerEvent(&e)
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSimulatedBroker_Locates(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	b.checkLocates = true
+	b.locate = &Locate{HardToBorrow: true, Available: 300}
+
+	t.Log("Sim broker: short sale of hard to borrow symbol is limited by available shares")
+	{
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(10, OrderSell, 400, "s1"))
+		assert.IsType(t, &OrderRejectedEvent{}, v)
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(10, OrderSell, 200, "s2"))
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+
+		//Active sell order takes located shares
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(10, OrderSell, 200, "s3"))
+		assert.IsType(t, &OrderRejectedEvent{}, v)
+	}
+
+	t.Log("Sim broker: sell of long position isn't short sale")
+	{
+		b.position = 500
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(10, OrderSell, 600, "s4"))
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(10, OrderBuy, 1000, "s5"))
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+	}
+
+	t.Log("Sim broker: replace that increases short qty over locate is rejected")
+	{
+		b.generatedEvents = eventArray{}
+		b.onReplaceRequest(&OrderReplaceRequestEvent{BaseEvent: be(newTestOrderTime(), b.symbol), OrdId: "s4",
+			NewQty: 700})
+		assert.IsType(t, &OrderReplaceRejectEvent{}, b.generatedEvents[len(b.generatedEvents)-1])
+		b.onReplaceRequest(&OrderReplaceRequestEvent{BaseEvent: be(newTestOrderTime(), b.symbol), OrdId: "s4",
+			NewQty: 550})
+		assert.IsType(t, &OrderReplacedEvent{}, b.generatedEvents[len(b.generatedEvents)-1])
+	}
+
+	t.Log("Sim broker: short sale without locate is rejected")
+	{
+		b := newTestSimBrokerWorker()
+		b.checkLocates = true
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(10, OrderSell, 100, "n1"))
+		assert.IsType(t, &OrderRejectedEvent{}, v)
+
+		b.locate = &Locate{}
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, newTestOrder(10, OrderSell, 100000, "n2"))
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+	}
+
+	t.Log("Sim broker: fills change broker position")
+	{
+		b := newTestSimBrokerWorker()
+		b.orders["f1"] = &simBrokerOrder{Order: newTestOrder(10, OrderSell, 100, "f1"), BrokerState: ConfirmedOrder,
+			BrokerQty: 100}
+		b.addBrokerEvent(&OrderFillEvent{BaseEvent: be(newTestOrderTime(), b.symbol), OrdId: "f1", Qty: 60, Price: 10})
+		assert.Equal(t, int64(-60), b.position)
+	}
+}
+
+func TestSimulatedBroker_SSR(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	b.ssr = true
+	day := func(d, h, m int) time.Time {
+		return time.Date(2010, 1, d, h, m, 0, 0, time.UTC)
+	}
+	shortAt := func(tm time.Time, price float64, id string) *Order {
+		o := newTestOrder(price, OrderSell, 100, id)
+		o.Time = tm
+		return o
+	}
+
+	t.Log("Sim broker: 10% drop from previous close triggers SSR")
+	{
+		b.updateShortSale(day(5, 15, 59), 20, 19.99)
+		b.updateShortSale(day(6, 9, 35), 18.5, 18.49)
+		assert.False(t, b.isSSRActive(day(6, 9, 35)))
+		assert.Equal(t, 20.0, b.prevClose)
+
+		b.updateShortSale(day(6, 10, 0), 17.9, 17.85)
+		assert.True(t, b.isSSRActive(day(6, 10, 0)))
+	}
+
+	t.Log("Sim broker: short sale under SSR must be priced above best bid")
+	{
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, shortAt(day(6, 10, 1), 17.85, "r1"))
+		assert.IsType(t, &OrderRejectedEvent{}, v)
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, shortAt(day(6, 10, 1), 17.86, "r2"))
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+
+		market := shortAt(day(6, 10, 1), math.NaN(), "r3")
+		market.Type = MarketOrder
+		v = putNewOrderToWorkerAndGetBrokerEvent(b, market)
+		assert.IsType(t, &OrderRejectedEvent{}, v)
+
+		b.generatedEvents = eventArray{}
+		b.onReplaceRequest(&OrderReplaceRequestEvent{BaseEvent: be(day(6, 10, 2), b.symbol), OrdId: "r2",
+			NewPrice: 17.8})
+		assert.IsType(t, &OrderReplaceRejectEvent{}, b.generatedEvents[len(b.generatedEvents)-1])
+	}
+
+	t.Log("Sim broker: sell of long position isn't restricted")
+	{
+		b.position = 1000
+		market := shortAt(day(6, 10, 3), math.NaN(), "r4")
+		market.Type = MarketOrder
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, market)
+		assert.IsType(t, &OrderConfirmationEvent{}, v)
+		b.position = 0
+	}
+
+	t.Log("Sim broker: SSR is active for the rest of day and the next day")
+	{
+		b.updateShortSale(day(7, 9, 31), 18, 17.99)
+		assert.True(t, b.isSSRActive(day(7, 9, 31)))
+		b.updateShortSale(day(8, 9, 31), 18, 17.99)
+		assert.False(t, b.isSSRActive(day(8, 9, 31)))
+	}
+}
+
+func TestSimulatedBroker_BorrowFee(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	b.locate = &Locate{HardToBorrow: true, Available: 1000, BorrowRate: 0.36}
+
+	t.Log("Sim broker: borrow fee is charged for short position held overnight")
+	{
+		b.updateShortSale(time.Date(2010, 1, 7, 15, 59, 0, 0, time.UTC), 10, 9.99)
+		b.position = -100
+		b.updateShortSale(time.Date(2010, 1, 7, 16, 0, 0, 0, time.UTC), 10.2, 10.19)
+		assert.Len(t, b.generatedEvents, 0)
+
+		//Friday close to Monday is 3 days
+		b.updateShortSale(time.Date(2010, 1, 8, 9, 31, 0, 0, time.UTC), 10.5, 10.49)
+		b.updateShortSale(time.Date(2010, 1, 11, 9, 31, 0, 0, time.UTC), 11, 10.99)
+		if assert.Len(t, b.generatedEvents, 2) {
+			fee := b.generatedEvents[0].(*BorrowFeeEvent)
+			assert.Equal(t, int64(100), fee.Qty)
+			assert.Equal(t, 10.2, fee.Price)
+			assert.InDelta(t, 1.02, fee.Fee, 0.000001)
+			assert.InDelta(t, 3.15, b.generatedEvents[1].(*BorrowFeeEvent).Fee, 0.000001)
+		}
+	}
+
+	t.Log("Sim broker: no fee for flat position")
+	{
+		b.generatedEvents = eventArray{}
+		b.position = 0
+		b.updateShortSale(time.Date(2010, 1, 12, 9, 31, 0, 0, time.UTC), 11, 10.99)
+		assert.Len(t, b.generatedEvents, 0)
+	}
+}
+
+func TestTrade_accrueBorrowFee(t *testing.T) {
+	t.Log("Borrow fee decreases closed pnl of short trade")
+	{
+		trade := newFlatTrade(newTestInstrument())
+		assert.NotNil(t, trade.accrueBorrowFee(1))
+
+		trade.Type = ShortTrade
+		trade.Qty = 100
+		assert.Nil(t, trade.accrueBorrowFee(1.5))
+		assert.Nil(t, trade.accrueBorrowFee(0.5))
+		assert.Equal(t, 2.0, trade.BorrowFees)
+		assert.Equal(t, -2.0, trade.ClosedPnL)
+		assert.NotNil(t, trade.accrueBorrowFee(math.NaN()))
+	}
+
+	t.Log("Strategy accrues borrow fee of current trade")
+	{
+		bs := BasicStrategy{symbol: newTestInstrument(), nPeriods: 20, userStrategy: &DummyStrategyWithLogic{}}
+		bs.init(CoreStrategyChannels{
+			errors:    make(chan error),
+			events:    make(chan event),
+			portfolio: make(chan *PortfolioNewPositionEvent, 5),
+		})
+		bs.handlersWaitGroup = &sync.WaitGroup{}
+		bs.currentTrade.Type = ShortTrade
+		bs.currentTrade.Qty = 100
+		tm := newTestOrderTime()
+
+		bs.notify(&BorrowFeeEvent{BaseEvent: be(tm, bs.symbol), Qty: 100, Price: 10, Fee: 0.1})
+		assert.Equal(t, 0.1, bs.currentTrade.BorrowFees)
+		assert.Equal(t, tm, bs.Now())
+	}
+}
@@ -424,12 +424,357 @@ func TestSimulatedBroker_OnReplaceRequest(t *testing.T) {
 	}
 }
 
+func TestSimulatedBroker_OnAmendRequest(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	amend := func(id string, e OrderReplaceRequestEvent) event {
+		e.OrdId = id
+		e.BaseEvent = be(newTestOrderTime().Add(time.Minute), newTestInstrument())
+		b.onReplaceRequest(&e)
+		return b.generatedEvents[len(b.generatedEvents)-1]
+	}
+
+	order := newTestGtcBrokerOrder(15, OrderBuy, 300, "Am1")
+	order.BrokerExecQty = 100
+	order.BrokerState = PartialFilledOrder
+	b.orders[order.Id] = order
+	queueTime := order.StateUpdTime
+
+	t.Log("Sim broker: qty can't be reduced below executed qty")
+	{
+		v := amend(order.Id, OrderReplaceRequestEvent{NewQty: 99})
+		assert.IsType(t, &OrderReplaceRejectEvent{}, v)
+		assert.Equal(t, int64(300), order.BrokerQty)
+	}
+
+	t.Log("Sim broker: price of market order can't be changed and tif of auction order too")
+	{
+		mkt := newTestGtcBrokerOrder(15, OrderBuy, 300, "Am2")
+		mkt.Type = MarketOrder
+		b.orders[mkt.Id] = mkt
+		assert.IsType(t, &OrderReplaceRejectEvent{}, amend(mkt.Id, OrderReplaceRequestEvent{NewPrice: 14}))
+
+		opg := newTestOpgBrokerOrder(15, OrderBuy, 300, "Am3")
+		b.orders[opg.Id] = opg
+		assert.IsType(t, &OrderReplaceRejectEvent{}, amend(opg.Id, OrderReplaceRequestEvent{NewTif: DayTIF}))
+		assert.IsType(t, &OrderReplaceRejectEvent{}, amend(order.Id, OrderReplaceRequestEvent{NewTif: IOCTIF}))
+	}
+
+	t.Log("Sim broker: qty decrease and tif change keep queue priority")
+	{
+		v := amend(order.Id, OrderReplaceRequestEvent{NewQty: 200, NewTif: DayTIF})
+		if assert.IsType(t, &OrderReplacedEvent{}, v) {
+			assert.Equal(t, int64(200), v.(*OrderReplacedEvent).NewQty)
+			assert.Equal(t, DayTIF, v.(*OrderReplacedEvent).NewTif)
+		}
+		assert.Equal(t, int64(200), order.BrokerQty)
+		assert.Equal(t, DayTIF, order.BrokerTif)
+		assert.Equal(t, queueTime, order.StateUpdTime)
+		assert.Equal(t, int64(300), order.Qty, "Broker doesn't change strategy order")
+	}
+
+	t.Log("Sim broker: qty increase loses queue priority")
+	{
+		v := amend(order.Id, OrderReplaceRequestEvent{NewQty: 400})
+		assert.IsType(t, &OrderReplacedEvent{}, v)
+		assert.Equal(t, int64(400), order.BrokerQty)
+		assert.Equal(t, v.getTime(), order.StateUpdTime)
+	}
+
+	t.Log("Sim broker: price change loses queue priority")
+	{
+		order.StateUpdTime = queueTime
+		v := amend(order.Id, OrderReplaceRequestEvent{NewPrice: 15.1})
+		assert.IsType(t, &OrderReplacedEvent{}, v)
+		assert.Equal(t, 15.1, order.BrokerPrice)
+		assert.Equal(t, v.getTime(), order.StateUpdTime)
+	}
+
+	t.Log("Sim broker: qty reduced to executed qty finishes order")
+	{
+		v := amend(order.Id, OrderReplaceRequestEvent{NewQty: 100})
+		assert.IsType(t, &OrderReplacedEvent{}, v)
+		assert.Equal(t, FilledOrder, order.BrokerState)
+		assert.False(t, order.isActive())
+	}
+}
+
+func TestSimulatedBroker_OrderTifValidation(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	day := newTestOrderTime()
+	at := func(h, m int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name     string
+		ordType  OrderType
+		tif      OrderTIF
+		time     time.Time
+		rejected bool
+	}{
+		{"MOO before open", MarketOnOpen, AuctionTIF, at(9, 0), false},
+		{"MOO after open", MarketOnOpen, AuctionTIF, at(9, 45), true},
+		{"LOO after open", LimitOnOpen, AuctionTIF, at(9, 30), true},
+		{"MOC during session", MarketOnClose, AuctionTIF, at(15, 0), false},
+		{"LOC after close", LimitOnClose, AuctionTIF, at(16, 5), true},
+		{"Auction type without auction TIF", MarketOnOpen, DayTIF, at(9, 0), true},
+		{"Auction TIF for limit order", LimitOrder, AuctionTIF, at(10, 0), true},
+		{"Day before open", LimitOrder, DayTIF, at(8, 0), false},
+		{"Day after close", LimitOrder, DayTIF, at(16, 30), true},
+		{"Day on weekend", LimitOrder, DayTIF, at(10, 0).AddDate(0, 0, 4), true},
+		{"GTC after close", LimitOrder, GTCTIF, at(20, 0), false},
+		{"IOC during session", LimitOrder, IOCTIF, at(10, 0), false},
+		{"IOC before open", MarketOrder, IOCTIF, at(9, 0), true},
+		{"IOC stop order", StopOrder, IOCTIF, at(10, 0), true},
+		{"Unknown TIF", LimitOrder, OrderTIF("GTX"), at(10, 0), true},
+	}
+
+	for i, c := range cases {
+		t.Log("Sim broker: " + c.name)
+		order := newTestOrder(10, OrderBuy, 100, fmt.Sprintf("tif%v", i))
+		order.Type = c.ordType
+		order.Tif = c.tif
+		order.Time = c.time
+		if c.ordType == MarketOrder || c.ordType == MarketOnOpen || c.ordType == MarketOnClose {
+			order.Price = math.NaN()
+		}
+
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, order)
+		if c.rejected {
+			if assert.IsType(t, &OrderRejectedEvent{}, v, c.name) {
+				assert.NotEmpty(t, v.(*OrderRejectedEvent).Reason)
+			}
+			assert.Equal(t, RejectedOrder, b.orders[order.Id].BrokerState)
+		} else {
+			assert.IsType(t, &OrderConfirmationEvent{}, v, c.name)
+		}
+	}
+}
+
+func TestSimulatedBroker_OrderTifWithoutSession(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	day := newTestOrderTime()
+	at := func(h, m int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name string
+		tif  OrderTIF
+		time time.Time
+	}{
+		{"Day order", DayTIF, at(10, 0)},
+		{"IOC order", IOCTIF, at(15, 0)},
+		{"Day order on weekend", DayTIF, at(10, 0).AddDate(0, 0, 4)},
+	}
+
+	for i, c := range cases {
+		t.Log("Sim broker: zero exchange doesn't check session. " + c.name)
+		order := newTestOrder(10, OrderBuy, 100, fmt.Sprintf("zero%v", i))
+		order.Ticker.Exchange = Exchange{}
+		order.Tif = c.tif
+		order.Time = c.time
+
+		v := putNewOrderToWorkerAndGetBrokerEvent(b, order)
+		assert.IsType(t, &OrderConfirmationEvent{}, v, c.name)
+	}
+}
+
+func TestSimulatedBroker_IOCOrder(t *testing.T) {
+	b := newTestSimBrokerWorker()
+
+	t.Log("Sim broker: IOC order is partially filled and the rest is canceled")
+	{
+		order := newTestGtcBrokerOrder(20.01, OrderBuy, 300, "ioc1")
+		order.Tif = IOCTIF
+		order.BrokerTif = IOCTIF
+
+		tick := marketdata.Tick{
+			Datetime:  newTestOrderTime().Add(time.Second),
+			Symbol:    "Test",
+			LastPrice: 20.00,
+			LastSize:  100,
+		}
+		events, errors := putOrderAndFillOnTick(b, order, &tick)
+		assert.Len(t, errors, 0)
+		if assert.Len(t, events, 2) {
+			assert.IsType(t, &OrderFillEvent{}, events[0])
+			assert.Equal(t, int64(100), events[0].(*OrderFillEvent).Qty)
+			assert.IsType(t, &OrderCancelEvent{}, events[1])
+		}
+		assert.Equal(t, CanceledOrder, order.BrokerState)
+	}
+
+	t.Log("Sim broker: IOC order without execution is canceled")
+	{
+		order := newTestGtcBrokerOrder(19.5, OrderBuy, 300, "ioc2")
+		order.Tif = IOCTIF
+		order.BrokerTif = IOCTIF
+
+		tick := marketdata.Tick{
+			Datetime:  newTestOrderTime().Add(time.Second),
+			Symbol:    "Test",
+			LastPrice: 20.00,
+			LastSize:  100,
+		}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			assert.IsType(t, &OrderCancelEvent{}, events[0])
+		}
+	}
+
+	t.Log("Sim broker: filled IOC order isn't canceled")
+	{
+		order := newTestGtcBrokerOrder(20.01, OrderBuy, 100, "ioc3")
+		order.Tif = IOCTIF
+		order.BrokerTif = IOCTIF
+
+		tick := marketdata.Tick{
+			Datetime:  newTestOrderTime().Add(time.Second),
+			Symbol:    "Test",
+			LastPrice: 20.00,
+			LastSize:  500,
+		}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			assert.IsType(t, &OrderFillEvent{}, events[0])
+		}
+		assert.Equal(t, FilledOrder, order.BrokerState)
+	}
+}
+
+func TestSimulatedBroker_ExtendedTifs(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	day := newTestOrderTime()
+	at := func(h, m int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, time.UTC)
+	}
+	extInstrument := func() *Instrument {
+		inst := newTestInstrument()
+		inst.Exchange.PreMarketOpenTime = TimeOfDay{Hour: 4}
+		inst.Exchange.AfterHoursCloseTime = TimeOfDay{Hour: 20}
+		return inst
+	}
+
+	t.Log("Sim broker: new TIFs validation")
+	{
+		vb := newTestSimBrokerWorker()
+		cases := []struct {
+			name     string
+			ordType  OrderType
+			tif      OrderTIF
+			time     time.Time
+			ext      bool
+			rejected bool
+		}{
+			{"OPG for MOO", MarketOnOpen, OPGTIF, at(9, 0), false, false},
+			{"OPG for MOC", MarketOnClose, OPGTIF, at(9, 0), false, true},
+			{"CLS for LOC", LimitOnClose, CLSTIF, at(15, 0), false, false},
+			{"FOK for limit", LimitOrder, FOKTIF, at(10, 0), false, false},
+			{"FOK for stop", StopOrder, FOKTIF, at(10, 0), false, true},
+			{"GTD without expire time", LimitOrder, GTDTIF, at(10, 0), false, true},
+			{"DayExt after close", LimitOrder, DayExtTIF, at(18, 0), true, false},
+			{"DayExt after after hours", LimitOrder, DayExtTIF, at(20, 30), true, true},
+			{"Day after close with extended hours", LimitOrder, DayTIF, at(18, 0), true, true},
+			{"DayExt without extended hours", LimitOrder, DayExtTIF, at(10, 0), false, true},
+			{"GTCExt at night", LimitOrder, GTCExtTIF, at(23, 0), true, false},
+		}
+
+		for i, c := range cases {
+			order := newTestOrder(10, OrderBuy, 100, fmt.Sprintf("ext%v", i))
+			order.Type = c.ordType
+			order.Tif = c.tif
+			order.Time = c.time
+			if c.ext {
+				order.Ticker = extInstrument()
+			}
+			if c.ordType == MarketOnOpen || c.ordType == MarketOnClose {
+				order.Price = math.NaN()
+			}
+
+			v := putNewOrderToWorkerAndGetBrokerEvent(vb, order)
+			if c.rejected {
+				assert.IsType(t, &OrderRejectedEvent{}, v, c.name)
+			} else {
+				assert.IsType(t, &OrderConfirmationEvent{}, v, c.name)
+			}
+		}
+
+		order := newTestOrder(10, OrderBuy, 100, "gtd")
+		order.Tif = GTDTIF
+		order.ExpireTime = at(15, 0)
+		assert.IsType(t, &OrderConfirmationEvent{}, putNewOrderToWorkerAndGetBrokerEvent(vb, order))
+	}
+
+	t.Log("Sim broker: FOK order isn't filled partially")
+	{
+		order := newTestGtcBrokerOrder(20.01, OrderBuy, 300, "fok1")
+		order.BrokerTif = FOKTIF
+
+		tick := marketdata.Tick{Datetime: at(10, 1), Symbol: "Test", LastPrice: 20.00, LastSize: 200}
+		events, errors := putOrderAndFillOnTick(b, order, &tick)
+		assert.Len(t, errors, 0)
+		if assert.Len(t, events, 1) {
+			assert.IsType(t, &OrderCancelEvent{}, events[0])
+		}
+		assert.Equal(t, int64(0), order.BrokerExecQty)
+	}
+
+	t.Log("Sim broker: FOK order is filled for all qty")
+	{
+		order := newTestGtcBrokerOrder(20.01, OrderBuy, 300, "fok2")
+		order.BrokerTif = FOKTIF
+
+		tick := marketdata.Tick{Datetime: at(10, 1), Symbol: "Test", LastPrice: 20.00, LastSize: 300}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			assert.IsType(t, &OrderFillEvent{}, events[0])
+			assert.Equal(t, int64(300), events[0].(*OrderFillEvent).Qty)
+		}
+	}
+
+	t.Log("Sim broker: GTD order expires at expire time")
+	{
+		order := newTestGtcBrokerOrder(20.01, OrderBuy, 300, "gtd1")
+		order.BrokerTif = GTDTIF
+		order.ExpireTime = at(11, 0)
+
+		tick := marketdata.Tick{Datetime: at(11, 30), Symbol: "Test", LastPrice: 20.00, LastSize: 300}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			assert.IsType(t, &OrderCancelEvent{}, events[0])
+			assert.Equal(t, at(11, 0), events[0].getTime())
+		}
+	}
+
+	t.Log("Sim broker: only extended hours orders are filled after close")
+	{
+		order := newTestDayBrokerOrder(20.01, OrderBuy, 100, "rth")
+		order.Ticker = extInstrument()
+
+		tick := marketdata.Tick{Datetime: at(17, 0), Symbol: "Test", LastPrice: 20.00, LastSize: 300}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		assert.Len(t, events, 0)
+
+		order = newTestDayBrokerOrder(20.01, OrderBuy, 100, "ext")
+		order.Ticker = extInstrument()
+		order.BrokerTif = DayExtTIF
+
+		events, _ = putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			assert.IsType(t, &OrderFillEvent{}, events[0])
+		}
+	}
+}
+
 func newTestGtcBrokerOrder(price float64, side OrderSide, qty int64, id string) *simBrokerOrder {
 	ord := newTestOrder(price, side, qty, id)
 	o := simBrokerOrder{
 		Order:        ord,
 		BrokerState:  ConfirmedOrder,
 		BrokerPrice:  price,
+		BrokerQty:    qty,
+		BrokerTif:    ord.Tif,
 		StateUpdTime: newTestOrderTime(),
 	}
 	return &o
@@ -443,6 +788,8 @@ func newTestOpgBrokerOrder(price float64, side OrderSide, qty int64, id string)
 		Order:        ord,
 		BrokerState:  ConfirmedOrder,
 		BrokerPrice:  price,
+		BrokerQty:    qty,
+		BrokerTif:    ord.Tif,
 		StateUpdTime: newTestOpgOrderTime(),
 	}
 	return &o
@@ -455,6 +802,8 @@ func newTestDayBrokerOrder(price float64, side OrderSide, qty int64, id string)
 		Order:        ord,
 		BrokerState:  ConfirmedOrder,
 		BrokerPrice:  price,
+		BrokerQty:    qty,
+		BrokerTif:    ord.Tif,
 		StateUpdTime: newTestOrderTime(),
 	}
 	return &o
@@ -470,6 +819,94 @@ func assertNoErrorsGeneratedByBroker(t *testing.T, b *simBrokerWorker) {
 	}
 }
 
+func TestSimulatedBroker_AuctionPrints(t *testing.T) {
+	b := newTestSimBrokerWorker()
+	b.auctionParticipation = 0.1
+	day := newTestOpgOrderTime()
+	at := func(h, m int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, time.UTC)
+	}
+
+	t.Log("Sim broker: auction order isn't filled on regular trade")
+	{
+		order := newTestOpgBrokerOrder(math.NaN(), OrderBuy, 100, "auc1")
+		order.Type = MarketOnOpen
+		tick := marketdata.Tick{Symbol: "Test", Datetime: at(9, 30), LastPrice: 20.0, LastSize: 5000, Cond1: "@"}
+		events, errors := putOrderAndFillOnTick(b, order, &tick)
+		assert.Len(t, errors, 0)
+		assert.Len(t, events, 0)
+		assert.Equal(t, ConfirmedOrder, order.BrokerState)
+	}
+
+	t.Log("Sim broker: MOO is filled on opening print found by condition code")
+	{
+		order := newTestOpgBrokerOrder(math.NaN(), OrderBuy, 100, "auc2")
+		order.Type = MarketOnOpen
+		tick := marketdata.Tick{Symbol: "Test", Datetime: at(9, 30), LastPrice: 20.05, LastSize: 5000, Cond2: "Q"}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			if assert.IsType(t, &OrderFillEvent{}, events[0]) {
+				assert.Equal(t, 20.05, events[0].(*OrderFillEvent).Price)
+				assert.Equal(t, int64(100), events[0].(*OrderFillEvent).Qty)
+			}
+		}
+		assert.Equal(t, FilledOrder, order.BrokerState)
+	}
+
+	t.Log("Sim broker: MOC takes only its part of auction volume and the rest is canceled")
+	{
+		order := newTestOpgBrokerOrder(math.NaN(), OrderSell, 800, "auc3")
+		order.Type = MarketOnClose
+		tick := marketdata.Tick{Symbol: "Test", Datetime: at(16, 0), LastPrice: 20.1, LastSize: 3000, Cond4: "6"}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 2) {
+			if assert.IsType(t, &OrderFillEvent{}, events[0]) {
+				assert.Equal(t, int64(300), events[0].(*OrderFillEvent).Qty)
+			}
+			assert.IsType(t, &OrderCancelEvent{}, events[1])
+		}
+		assert.Equal(t, int64(300), order.BrokerExecQty)
+		assert.Equal(t, CanceledOrder, order.BrokerState)
+	}
+
+	t.Log("Sim broker: LOC is filled when clearing price is within limit")
+	{
+		order := newTestOpgBrokerOrder(20.2, OrderBuy, 200, "auc4")
+		order.Type = LimitOnClose
+		tick := marketdata.Tick{Symbol: "Test", Datetime: at(16, 0), LastPrice: 20.1, LastSize: 3000, Cond1: "M"}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			if assert.IsType(t, &OrderFillEvent{}, events[0]) {
+				assert.Equal(t, 20.1, events[0].(*OrderFillEvent).Price)
+				assert.Equal(t, int64(200), events[0].(*OrderFillEvent).Qty)
+			}
+		}
+	}
+
+	t.Log("Sim broker: LOO is canceled when clearing price is out of limit")
+	{
+		order := newTestOpgBrokerOrder(20.2, OrderSell, 200, "auc5")
+		order.Type = LimitOnOpen
+		tick := marketdata.Tick{Symbol: "Test", Datetime: at(9, 30), LastPrice: 20.1, LastSize: 3000, Cond1: "O"}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			assert.IsType(t, &OrderCancelEvent{}, events[0])
+		}
+		assert.Equal(t, int64(0), order.BrokerExecQty)
+	}
+
+	t.Log("Sim broker: auction order is canceled when its part of auction volume is zero")
+	{
+		order := newTestOpgBrokerOrder(math.NaN(), OrderBuy, 100, "auc6")
+		order.Type = MarketOnOpen
+		tick := marketdata.Tick{Symbol: "Test", Datetime: at(9, 30), LastPrice: 20.0, LastSize: 5, IsOpening: true}
+		events, _ := putOrderAndFillOnTick(b, order, &tick)
+		if assert.Len(t, events, 1) {
+			assert.IsType(t, &OrderCancelEvent{}, events[0])
+		}
+	}
+}
+
 func TestSimulatedBroker_fillMarketOnTick(t *testing.T) {
 	b := newTestSimBrokerWorker()
 
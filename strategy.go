@@ -43,7 +43,11 @@ type ICoreStrategy interface {
 	candles() CandleArray
 	setPortfolio(p *portfolioHandler)
 	enableEventLogging()
+	setClock(clock Clock)
+	dueTimers(now time.Time) []*TimerTickEvent
 	notify(e event)
+	start()
+	setPrimaryTimeFrame(tf string)
 	shutDown()
 	getInstrument() *Instrument
 }
@@ -68,10 +72,17 @@ type BasicStrategy struct {
 	currentTrade               *Trade
 	Ticks                      TickArray
 	Candles                    CandleArray
+	candlesByTF                map[string]CandleArray
+	primaryTimeFrame           string
 	lastCandleOpen             float64
 	lastCandleOpenTime         time.Time
 	userStrategy               IUserStrategy
 	mostRecentTime             time.Time
+	halted                     bool
+	candleIndicators           []*candleIndicator
+	tickIndicators             []*tickIndicator
+	clock                      Clock
+	schedules                  strategySchedules
 	mut                        *sync.Mutex
 	isEventLoggingEnabled      bool
 	isEventSliceStorageEnabled bool
@@ -86,6 +97,7 @@ type BasicStrategy struct {
 
 func (b *BasicStrategy) shutDown() {
 	b.handlersWaitGroup.Wait()
+	b.stop()
 }
 
 func (b *BasicStrategy) getInstrument() *Instrument{
@@ -100,6 +112,9 @@ func (b *BasicStrategy) init(ch CoreStrategyChannels) {
 	b.terminationChan = make(chan struct{})
 	b.waitingConfirmation = make(map[string]struct{})
 	b.mut = &sync.Mutex{}
+	if b.schedules.mut == nil {
+		b.schedules = strategySchedules{states: make(map[string]*scheduleState), mut: &sync.Mutex{}}
+	}
 
 	if b.currentTrade == nil {
 		b.currentTrade = newFlatTrade(b.symbol)
@@ -116,11 +131,33 @@ func (b *BasicStrategy) setPortfolio(p *portfolioHandler) {
 	b.portfolio = p
 }
 
+func (b *BasicStrategy) setClock(clock Clock) {
+	b.clock = clock
+}
+
 //*******API CALLS************************************************
+
+//Now returns current strategy time. With simulated clock it's time of the last event handled by strategy, so
+//strategy time doesn't depend on how far market data stream went ahead of strategy handlers
+func (b *BasicStrategy) Now() time.Time {
+	if b.clock == nil || b.clock.IsSimulated() {
+		return b.mostRecentTime
+	}
+	return b.clock.Now()
+}
+
+//IsHalted returns true if trading of strategy instrument is halted. Orders sent during halt wait for
+//reopening
+func (b *BasicStrategy) IsHalted() bool {
+	return b.halted
+}
+
 func (b *BasicStrategy) GetTotalPnL() float64 {
 	return b.portfolio.totalPnL()
 }
 
+//OpenOrders returns confirmed orders of current trade. Map and orders are live strategy state, use
+//WorkingOrders to get snapshots
 func (b *BasicStrategy) OpenOrders() map[string]*Order {
 	return b.currentTrade.ConfirmedOrders
 }
@@ -142,11 +179,6 @@ func (b *BasicStrategy) IsOrderConfirmed(ordId string) bool {
 	return b.currentTrade.hasConfirmedOrderWithId(ordId)
 }
 
-func (b *BasicStrategy) OrderStatus(ordId string) OrderState {
-	//Todo
-	return ""
-}
-
 func (b *BasicStrategy) NewLimitOrder(price float64, side OrderSide, qty int64, tif OrderTIF, destination string) (string, error) {
 	order := Order{
 		Side:        side,
@@ -157,7 +189,7 @@ func (b *BasicStrategy) NewLimitOrder(price float64, side OrderSide, qty int64,
 		Type:        LimitOrder,
 		Tif:         tif,
 		Destination: destination,
-		Time:        b.mostRecentTime.Add(20 * time.Microsecond),
+		Time:        b.Now().Add(20 * time.Microsecond),
 		Id:          fmt.Sprintf("%v_%v_%v", price, LimitOrder, rand.Float64()),
 	}
 
@@ -176,7 +208,7 @@ func (b *BasicStrategy) NewMarketOrder(side OrderSide, qty int64, tif OrderTIF,
 		Type:        MarketOrder,
 		Tif:         tif,
 		Destination: destination,
-		Time:        b.mostRecentTime,
+		Time:        b.Now(),
 		Id:          fmt.Sprintf("%v_%v", MarketOrder, rand.Float64()),
 	}
 
@@ -185,6 +217,27 @@ func (b *BasicStrategy) NewMarketOrder(side OrderSide, qty int64, tif OrderTIF,
 
 }
 
+//NewGTDLimitOrder puts limit order which is active until expireTime
+func (b *BasicStrategy) NewGTDLimitOrder(price float64, side OrderSide, qty int64, expireTime time.Time, destination string) (string, error) {
+	order := Order{
+		Side:        side,
+		Qty:         qty,
+		Ticker:      b.symbol,
+		Price:       price,
+		State:       NewOrder,
+		Type:        LimitOrder,
+		Tif:         GTDTIF,
+		ExpireTime:  expireTime,
+		Destination: destination,
+		Time:        b.Now().Add(20 * time.Microsecond),
+		Id:          fmt.Sprintf("%v_%v_%v", price, LimitOrder, rand.Float64()),
+	}
+
+	err := b.newOrder(&order)
+	return order.Id, err
+
+}
+
 func (b *BasicStrategy) CancelOrder(ordID string) error {
 	//fmt.Println("Cancel order")
 	if ordID == "" {
@@ -209,7 +262,7 @@ func (b *BasicStrategy) CancelOrder(ordID string) error {
 
 	cancelReq := OrderCancelRequestEvent{
 		OrdId:     ordID,
-		BaseEvent: be(b.mostRecentTime.Add(20*time.Microsecond), b.currentTrade.ConfirmedOrders[ordID].Ticker),
+		BaseEvent: be(b.Now().Add(20*time.Microsecond), b.currentTrade.ConfirmedOrders[ordID].Ticker),
 	}
 
 	reqID := "$CAN$" + ordID
@@ -225,12 +278,19 @@ func (b *BasicStrategy) CancelOrder(ordID string) error {
 	return nil
 }
 
+//ReplaceOrder changes price of confirmed order. For stop orders it's trigger price
 func (b *BasicStrategy) ReplaceOrder(ordID string, newPrice float64) error {
+	return b.AmendOrder(ordID, OrderAmendment{Price: newPrice})
+}
+
+//AmendOrder sends request to change price, qty, tif or destination of confirmed order. Order can be partially
+//filled, new qty can't be less than executed qty. Changes are applied when broker confirms them
+func (b *BasicStrategy) AmendOrder(ordID string, a OrderAmendment) error {
 	if ordID == "" {
 		err := ErrOrderIdIncorrect{
 			OrdId:   ordID,
 			Message: "Id is empty. ",
-			Caller:  "ReplaceOrder func",
+			Caller:  "AmendOrder func",
 		}
 		return &err
 	}
@@ -240,16 +300,27 @@ func (b *BasicStrategy) ReplaceOrder(ordID string, newPrice float64) error {
 			ErrOrderNotFoundInOrdersMap{
 				OrdId:   ordID,
 				Message: "Id is empty. ",
-				Caller:  "ReplaceOrder func",
+				Caller:  "AmendOrder func",
 			},
 		}
 		return &err
 	}
 
+	if a.isEmpty() {
+		return errors.New("Amendment doesn't change order. ")
+	}
+
+	if a.Qty != 0 && a.Qty < b.currentTrade.ConfirmedOrders[ordID].ExecQty {
+		return errors.New("New qty is less than executed qty. ")
+	}
+
 	replaceReq := OrderReplaceRequestEvent{
-		OrdId:     ordID,
-		NewPrice:  newPrice,
-		BaseEvent: be(b.mostRecentTime.Add(20*time.Microsecond), b.symbol),
+		OrdId:          ordID,
+		NewPrice:       a.Price,
+		NewQty:         a.Qty,
+		NewTif:         a.Tif,
+		NewDestination: a.Destination,
+		BaseEvent:      be(b.Now().Add(20*time.Microsecond), b.symbol),
 	}
 
 	reqID := "$REP$" + ordID
@@ -265,6 +336,20 @@ func (b *BasicStrategy) ReplaceOrder(ordID string, newPrice float64) error {
 	return nil
 }
 
+//EnableJournal starts recording of all events that strategy gets and sends. Recorded journal can be
+//replayed with JournalReplayer
+func (b *BasicStrategy) EnableJournal() {
+	b.enableEventSliceStorage()
+}
+
+//Journal returns events recorded after EnableJournal call
+func (b *BasicStrategy) Journal() []event {
+	if !b.isEventSliceStorageEnabled {
+		return nil
+	}
+	return b.eventsLoggingSlice.storedEvents()
+}
+
 func (b *BasicStrategy) LastCandleOpen() float64 {
 	return b.lastCandleOpen
 }
@@ -298,18 +383,32 @@ func (b *BasicStrategy) proxyEvent(e event) {
 		b.onOrderRejectedHandler(i)
 	case *OrderFillEvent:
 		b.onOrderFillHandler(i)
+	case *BorrowFeeEvent:
+		b.onBorrowFeeHandler(i)
 	case *StrategyRequestNotDeliveredEvent:
 		b.onStrategyRequestNotDeliveredEventHandler(i)
 	case *NewTickEvent:
 		b.onTickHandler(i)
 	case *TickHistoryEvent:
 		b.onTickHistoryHandler(i)
+	case *CandlesHistoryEvent:
+		b.onCandleHistoryHandler(i)
 	case *CandleCloseEvent:
 		b.onCandleCloseHandler(i)
 	case *CandleOpenEvent:
 		b.onCandleOpenHandler(i)
 	case *EndOfDataEvent:
 		b.onEndOfDataHandler(i)
+	case *TimerTickEvent:
+		b.onTimerHandler(i)
+	case *AuctionImbalanceEvent:
+		b.onAuctionImbalanceHandler(i)
+	case *TradingHaltEvent:
+		b.onTradingHaltHandler(i)
+	case *TradingResumeEvent:
+		b.onTradingResumeHandler(i)
+	case *CorporateActionEvent:
+		b.onCorporateActionHandler(i)
 
 	default:
 		panic("Unexpected event type in BasicStrategy: " + e.getName())
@@ -343,15 +442,21 @@ func (b *BasicStrategy) onCandleCloseHandler(e *CandleCloseEvent) {
 			return
 		}
 
-		b.putNewCandle(e.Candle)
+		tf := e.TimeFrame
+		if tf == "" {
+			tf = e.Candle.TimeFrame
+		}
+		b.notePrimaryTimeFrame(tf)
+		b.putTimeFrameCandle(tf, e.Candle)
+		b.updateCandleIndicators(tf, e.Candle)
 
-		if b.currentTrade.IsOpen() {
+		if b.currentTrade.IsOpen() && b.isPrimaryTimeFrame(tf) {
 			err := b.currentTrade.updatePnL(e.Candle.Close, e.Candle.Datetime)
 			if err != nil {
 				b.newError(err)
 			}
 		}
-		if len(b.Candles) < b.nPeriods {
+		if len(b.CandlesOf(tf)) < b.nPeriods {
 
 			return
 		}
@@ -361,6 +466,160 @@ func (b *BasicStrategy) onCandleCloseHandler(e *CandleCloseEvent) {
 
 }
 
+func (b *BasicStrategy) onTimerHandler(e *TimerTickEvent) {
+	<-b.mdChan
+	b.handlersWaitGroup.Add(1)
+	go func() {
+		defer func() {
+			b.handlersWaitGroup.Done()
+			b.mdChan <- e
+
+		}()
+
+		if e == nil {
+			return
+		}
+
+		b.mut.Lock()
+		defer b.mut.Unlock()
+
+		if e.getTime().After(b.mostRecentTime) {
+			b.mostRecentTime = e.getTime()
+		}
+
+		if st, ok := b.userStrategy.(ITimerStrategy); ok {
+			st.OnTimer(b, e.ScheduleId, e.getTime())
+		}
+
+	}()
+}
+
+func (b *BasicStrategy) onAuctionImbalanceHandler(e *AuctionImbalanceEvent) {
+	<-b.mdChan
+	b.handlersWaitGroup.Add(1)
+	go func() {
+		defer func() {
+			b.handlersWaitGroup.Done()
+			b.mdChan <- e
+
+		}()
+
+		if e == nil {
+			return
+		}
+
+		b.mut.Lock()
+		defer b.mut.Unlock()
+
+		if e.getTime().After(b.mostRecentTime) {
+			b.mostRecentTime = e.getTime()
+		}
+
+		if st, ok := b.userStrategy.(IAuctionImbalanceStrategy); ok {
+			st.OnAuctionImbalance(b, e)
+		}
+
+	}()
+}
+
+func (b *BasicStrategy) onTradingHaltHandler(e *TradingHaltEvent) {
+	<-b.mdChan
+	b.handlersWaitGroup.Add(1)
+	go func() {
+		defer func() {
+			b.handlersWaitGroup.Done()
+			b.mdChan <- e
+
+		}()
+
+		if e == nil {
+			return
+		}
+
+		b.mut.Lock()
+		defer b.mut.Unlock()
+
+		if e.getTime().After(b.mostRecentTime) {
+			b.mostRecentTime = e.getTime()
+		}
+		b.halted = true
+
+		if st, ok := b.userStrategy.(IHaltStrategy); ok {
+			st.OnTradingHalt(b, e.Reason)
+		}
+
+	}()
+}
+
+func (b *BasicStrategy) onTradingResumeHandler(e *TradingResumeEvent) {
+	<-b.mdChan
+	b.handlersWaitGroup.Add(1)
+	go func() {
+		defer func() {
+			b.handlersWaitGroup.Done()
+			b.mdChan <- e
+
+		}()
+
+		if e == nil {
+			return
+		}
+
+		b.mut.Lock()
+		defer b.mut.Unlock()
+
+		if e.getTime().After(b.mostRecentTime) {
+			b.mostRecentTime = e.getTime()
+		}
+		b.halted = false
+
+		if st, ok := b.userStrategy.(IResumeStrategy); ok {
+			st.OnTradingResume(b)
+		}
+
+	}()
+}
+
+//onCorporateActionHandler adjusts current trade by split or dividend before user strategy gets the event
+func (b *BasicStrategy) onCorporateActionHandler(e *CorporateActionEvent) {
+	<-b.mdChan
+	b.handlersWaitGroup.Add(1)
+	go func() {
+		defer func() {
+			b.handlersWaitGroup.Done()
+			b.mdChan <- e
+
+		}()
+
+		if e == nil {
+			return
+		}
+
+		b.mut.Lock()
+		defer b.mut.Unlock()
+
+		if e.getTime().After(b.mostRecentTime) {
+			b.mostRecentTime = e.getTime()
+		}
+
+		var err error
+		switch e.Action {
+		case SplitAction:
+			err = b.currentTrade.applySplit(e.Ratio)
+		case DividendAction:
+			err = b.currentTrade.applyDividend(e.Amount)
+		}
+		if err != nil {
+			b.newError(err)
+		}
+
+		if st, ok := b.userStrategy.(ICorporateActionStrategy); ok {
+			st.OnCorporateAction(b, e)
+		}
+
+	}()
+}
+
 func (b *BasicStrategy) onCandleOpenHandler(e *CandleOpenEvent) {
 	<-b.mdChan
 	b.handlersWaitGroup.Add(1)
@@ -382,6 +641,11 @@ func (b *BasicStrategy) onCandleOpenHandler(e *CandleOpenEvent) {
 			b.mostRecentTime = e.CandleTime
 		}
 
+		b.notePrimaryTimeFrame(e.TimeFrame)
+		if !b.isPrimaryTimeFrame(e.TimeFrame) {
+			return
+		}
+
 		if !e.CandleTime.Before(b.lastCandleOpenTime) {
 			b.lastCandleOpen = e.Price
 			b.lastCandleOpenTime = e.CandleTime
@@ -411,36 +675,47 @@ func (b *BasicStrategy) onCandleHistoryHandler(e *CandlesHistoryEvent) {
 		return
 	}
 
-	allCandles := append(b.Candles, e.Candles...)
-	listedCandleTimes := make(map[time.Time]struct{})
-	var checkedCandles CandleArray
-
-	for _, v := range allCandles {
+	//History may have candles of several timeframes, every timeframe is merged with its own window
+	var timeFrames []string
+	byTimeFrame := make(map[string]CandleArray)
+	for _, v := range e.Candles {
 		if v == nil {
 			continue
 		}
-		if !v.isValid() {
-			continue
+		b.notePrimaryTimeFrame(v.TimeFrame)
+		tf := v.TimeFrame
+		if b.isPrimaryTimeFrame(tf) {
+			tf = b.primaryTimeFrame
 		}
-		if _, ok := listedCandleTimes[v.Datetime]; ok {
-			continue
+		if _, ok := byTimeFrame[tf]; !ok {
+			timeFrames = append(timeFrames, tf)
 		}
-
-		checkedCandles = append(checkedCandles, v)
-		listedCandleTimes[v.Datetime] = struct{}{}
+		byTimeFrame[tf] = append(byTimeFrame[tf], v)
 	}
 
-	sort.SliceStable(checkedCandles, func(i, j int) bool {
-		return checkedCandles[i].Datetime.Unix() < checkedCandles[j].Datetime.Unix()
-	})
+	for _, tf := range timeFrames {
+		allCandles := append(append(CandleArray{}, b.CandlesOf(tf)...), byTimeFrame[tf]...)
+		listedCandleTimes := make(map[time.Time]struct{})
+		var checkedCandles CandleArray
 
-	if len(checkedCandles) > b.nPeriods {
-		b.Candles = checkedCandles[len(checkedCandles)-b.nPeriods:]
-	} else {
-		b.Candles = checkedCandles
-	}
+		for _, v := range allCandles {
+			if !v.isValid() {
+				continue
+			}
+			if _, ok := listedCandleTimes[v.Datetime]; ok {
+				continue
+			}
 
-	b.updateLastCandleOpen()
+			checkedCandles = append(checkedCandles, v)
+			listedCandleTimes[v.Datetime] = struct{}{}
+		}
+
+		sort.SliceStable(checkedCandles, func(i, j int) bool {
+			return checkedCandles[i].Datetime.Unix() < checkedCandles[j].Datetime.Unix()
+		})
+		b.updateCandleIndicators(tf, checkedCandles...)
+		b.setCandlesWindow(tf, checkedCandles)
+	}
 
 	return
 }
@@ -478,6 +753,7 @@ func (b *BasicStrategy) onTickHandler(e *NewTickEvent) {
 		}
 
 		b.putNewTick(e.Tick)
+		b.updateTickIndicators(e.Tick)
 		if b.currentTrade.IsOpen() {
 			err := b.currentTrade.updatePnL(e.Tick.LastPrice, e.Tick.Datetime)
 			if err != nil {
@@ -526,6 +802,7 @@ func (b *BasicStrategy) onTickHistoryHandler(e *TickHistoryEvent) {
 	sort.SliceStable(checkedTicks, func(i, j int) bool {
 		return checkedTicks[i].Datetime.Unix() < checkedTicks[j].Datetime.Unix()
 	})
+	b.updateTickIndicators(checkedTicks...)
 
 	if len(checkedTicks) > b.nPeriods {
 		b.Ticks = checkedTicks[len(checkedTicks)-b.nPeriods:]
@@ -557,12 +834,15 @@ func (b *BasicStrategy) onOrderFillHandler(e *OrderFillEvent) {
 	}
 
 	prevState := b.currentTrade.Type
+	prevPosition := b.Position()
+	order := b.currentTrade.ConfirmedOrders[e.OrdId]
 	newPos, err := b.currentTrade.executeOrder(e.OrdId, e.Qty, e.Price, e.Time)
 
 	if err != nil {
 		b.newError(err)
 		return
 	}
+	order.Fills = append(order.Fills, OrderExecution{Time: e.getTime(), Price: e.Price, Qty: e.Qty})
 	if newPos != nil {
 		if b.currentTrade.Type != ClosedTrade {
 			b.newError(errors.New("New position opened, but previous is not closed. "))
@@ -580,6 +860,26 @@ func (b *BasicStrategy) onOrderFillHandler(e *OrderFillEvent) {
 		}
 	}
 
+	if st, ok := b.userStrategy.(IFillStrategy); ok {
+		st.OnFill(b, b.findOrder(e.OrdId), e.Price, e.Qty)
+	}
+	if st, ok := b.userStrategy.(IPositionChangedStrategy); ok && prevPosition != b.Position() {
+		st.OnPositionChanged(b, prevPosition, b.Position())
+	}
+
+}
+
+func (b *BasicStrategy) onBorrowFeeHandler(e *BorrowFeeEvent) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if e.getTime().After(b.mostRecentTime) {
+		b.mostRecentTime = e.getTime()
+	}
+
+	if err := b.currentTrade.accrueBorrowFee(e.Fee); err != nil {
+		b.newError(err)
+	}
 }
 
 func (b *BasicStrategy) onOrderCancelHandler(e *OrderCancelEvent) {
@@ -591,7 +891,7 @@ func (b *BasicStrategy) onOrderCancelHandler(e *OrderCancelEvent) {
 	}
 
 	atomic.AddInt32(&b.waitingN, -1)
-	delete(b.waitingConfirmation, "&CAN&"+e.OrdId)
+	delete(b.waitingConfirmation, "$CAN$"+e.OrdId)
 
 	err := b.currentTrade.cancelOrder(e.OrdId)
 
@@ -599,6 +899,11 @@ func (b *BasicStrategy) onOrderCancelHandler(e *OrderCancelEvent) {
 		b.newError(err)
 		return
 	}
+	b.findOrder(e.OrdId).CanceledTime = e.getTime()
+
+	if st, ok := b.userStrategy.(ICancelStrategy); ok {
+		st.OnCancel(b, b.findOrder(e.OrdId))
+	}
 
 }
 
@@ -620,7 +925,11 @@ func (b *BasicStrategy) onOrderCancelRejectHandler(e *OrderCancelRejectEvent) {
 	}
 
 	atomic.AddInt32(&b.waitingN, -1)
-	delete(b.waitingConfirmation, "&CAN&"+e.OrdId)
+	delete(b.waitingConfirmation, "$CAN$"+e.OrdId)
+
+	if st, ok := b.userStrategy.(ICancelRejectStrategy); ok {
+		st.OnCancelReject(b, e.OrdId, e.Reason)
+	}
 
 }
 
@@ -633,7 +942,11 @@ func (b *BasicStrategy) onOrderReplaceRejectHandler(e *OrderReplaceRejectEvent)
 	}
 
 	atomic.AddInt32(&b.waitingN, -1)
-	delete(b.waitingConfirmation, "&REP&"+e.OrdId)
+	delete(b.waitingConfirmation, "$REP$"+e.OrdId)
+
+	if st, ok := b.userStrategy.(IReplaceRejectStrategy); ok {
+		st.OnReplaceReject(b, e.OrdId, e.Reason)
+	}
 
 }
 
@@ -654,6 +967,11 @@ func (b *BasicStrategy) onOrderConfirmHandler(e *OrderConfirmationEvent) {
 		b.newError(err)
 		return
 	}
+	b.findOrder(e.OrdId).ConfirmedTime = e.getTime()
+
+	if st, ok := b.userStrategy.(IOrderConfirmedStrategy); ok {
+		st.OnOrderConfirmed(b, b.findOrder(e.OrdId))
+	}
 }
 
 func (b *BasicStrategy) onOrderReplacedHandler(e *OrderReplacedEvent) {
@@ -664,12 +982,17 @@ func (b *BasicStrategy) onOrderReplacedHandler(e *OrderReplacedEvent) {
 	}
 
 	atomic.AddInt32(&b.waitingN, -1)
-	delete(b.waitingConfirmation, "&REP&"+e.OrdId)
+	delete(b.waitingConfirmation, "$REP$"+e.OrdId)
 
-	err := b.currentTrade.replaceOrder(e.OrdId, e.NewPrice)
+	err := b.currentTrade.replaceOrder(e.OrdId, e.amendment(), e.getTime())
 
 	if err != nil {
 		b.newError(err)
+		return
+	}
+
+	if st, ok := b.userStrategy.(IReplacedStrategy); ok {
+		st.OnReplaced(b, b.findOrder(e.OrdId))
 	}
 
 }
@@ -690,10 +1013,32 @@ func (b *BasicStrategy) onOrderRejectedHandler(e *OrderRejectedEvent) {
 		b.newError(err)
 		return
 	}
+	b.findOrder(e.OrdId).RejectedTime = e.getTime()
+
+	if st, ok := b.userStrategy.(IRejectStrategy); ok {
+		st.OnReject(b, b.findOrder(e.OrdId), e.Reason)
+	}
 }
 
+//onEndOfDataHandler waits until all market data handlers are done and calls user OnEndOfData
 func (b *BasicStrategy) onEndOfDataHandler(e *EndOfDataEvent) {
-	b.terminationChan <- struct{}{}
+	<-b.mdChan
+	b.handlersWaitGroup.Add(1)
+	go func() {
+		defer func() {
+			b.handlersWaitGroup.Done()
+			b.mdChan <- e
+
+		}()
+
+		b.mut.Lock()
+		defer b.mut.Unlock()
+
+		if st, ok := b.userStrategy.(IEndOfDataStrategy); ok {
+			st.OnEndOfData(b)
+		}
+
+	}()
 }
 
 //Private funcs to work with data
@@ -732,7 +1077,7 @@ func (b *BasicStrategy) newOrder(order *Order) error {
 	}
 	ordEvent := NewOrderEvent{
 		LinkedOrder: order,
-		BaseEvent:   be(b.mostRecentTime, order.Ticker),
+		BaseEvent:   be(b.Now(), order.Ticker),
 	}
 
 	reqID := "$NO$" + order.Id
@@ -742,6 +1087,7 @@ func (b *BasicStrategy) newOrder(order *Order) error {
 		b.waitingConfirmation[reqID] = struct{}{}
 		atomic.AddInt32(&b.waitingN, 1)
 	}
+	order.SentTime = ordEvent.getTime()
 	b.newSignal(&ordEvent)
 	return nil
 }
@@ -783,27 +1129,8 @@ func (b *BasicStrategy) putNewCandle(candle *Candle) {
 	if candle == nil {
 		return
 	}
-
-	sortIt := false
-	if len(b.Candles) > 0 && candle.Datetime.Before(b.Candles[len(b.Candles)-1].Datetime) {
-		sortIt = true
-	}
-
-	if len(b.Candles) < b.nPeriods {
-		b.Candles = append(b.Candles, candle)
-		b.updateLastCandleOpen()
-		return
-	}
-	b.Candles = append(b.Candles[1:], candle)
-
-	if sortIt {
-		sort.SliceStable(b.Candles, func(i, j int) bool {
-			return b.Candles[i].Datetime.Unix() < b.Candles[j].Datetime.Unix()
-		})
-	}
-
+	b.Candles = appendToCandlesWindow(b.Candles, candle, b.nPeriods)
 	b.updateLastCandleOpen()
-	return
 }
 
 func (b *BasicStrategy) putNewTick(tick *Tick) {
@@ -0,0 +1,97 @@
+package engine
+
+//Optional interfaces of user strategy. BasicStrategy checks them with type assertion, so user strategy
+//implements only callbacks it needs. Order callbacks are called after BasicStrategy updated its orders and
+//position, so b.Position() and b.OpenOrders() already have new state
+
+type IStartStrategy interface {
+	OnStart(b *BasicStrategy)
+}
+
+type IStopStrategy interface {
+	OnStop(b *BasicStrategy)
+}
+
+type IEndOfDataStrategy interface {
+	OnEndOfData(b *BasicStrategy)
+}
+
+type IOrderConfirmedStrategy interface {
+	OnOrderConfirmed(b *BasicStrategy, order *Order)
+}
+
+type IFillStrategy interface {
+	OnFill(b *BasicStrategy, order *Order, price float64, qty int64)
+}
+
+type ICancelStrategy interface {
+	OnCancel(b *BasicStrategy, order *Order)
+}
+
+type IRejectStrategy interface {
+	OnReject(b *BasicStrategy, order *Order, reason string)
+}
+
+type IReplacedStrategy interface {
+	OnReplaced(b *BasicStrategy, order *Order)
+}
+
+type ICancelRejectStrategy interface {
+	OnCancelReject(b *BasicStrategy, ordId string, reason string)
+}
+
+type IReplaceRejectStrategy interface {
+	OnReplaceReject(b *BasicStrategy, ordId string, reason string)
+}
+
+type IPositionChangedStrategy interface {
+	OnPositionChanged(b *BasicStrategy, prevPosition int64, position int64)
+}
+
+type IAuctionImbalanceStrategy interface {
+	OnAuctionImbalance(b *BasicStrategy, e *AuctionImbalanceEvent)
+}
+
+type IHaltStrategy interface {
+	OnTradingHalt(b *BasicStrategy, reason string)
+}
+
+type IResumeStrategy interface {
+	OnTradingResume(b *BasicStrategy)
+}
+
+type ICorporateActionStrategy interface {
+	OnCorporateAction(b *BasicStrategy, e *CorporateActionEvent)
+}
+
+//findOrder looks for order in current trade and then in closed trades starting from the most recent
+func (b *BasicStrategy) findOrder(ordId string) *Order {
+	for _, t := range b.allTrades() {
+		if t == nil {
+			continue
+		}
+		for _, m := range []map[string]*Order{t.ConfirmedOrders, t.FilledOrders, t.CanceledOrders,
+			t.RejectedOrders, t.NewOrders} {
+			if o, ok := m[ordId]; ok {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+func (b *BasicStrategy) start() {
+	if st, ok := b.userStrategy.(IStartStrategy); ok {
+		b.mut.Lock()
+		defer b.mut.Unlock()
+		st.OnStart(b)
+	}
+}
+
+func (b *BasicStrategy) stop() {
+	if st, ok := b.userStrategy.(IStopStrategy); ok {
+		b.mut.Lock()
+		defer b.mut.Unlock()
+		st.OnStop(b)
+	}
+}
@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+type callbacksTestStrategy struct {
+	DummyStrategyWithLogic
+	calls     []string
+	positions [][2]int64
+}
+
+func (s *callbacksTestStrategy) OnStart(b *BasicStrategy) { s.calls = append(s.calls, "start") }
+func (s *callbacksTestStrategy) OnStop(b *BasicStrategy)  { s.calls = append(s.calls, "stop") }
+func (s *callbacksTestStrategy) OnEndOfData(b *BasicStrategy) {
+	s.calls = append(s.calls, "eod")
+}
+func (s *callbacksTestStrategy) OnOrderConfirmed(b *BasicStrategy, order *Order) {
+	s.calls = append(s.calls, "confirmed:"+string(order.State))
+}
+func (s *callbacksTestStrategy) OnFill(b *BasicStrategy, order *Order, price float64, qty int64) {
+	s.calls = append(s.calls, "fill:"+string(order.State))
+}
+func (s *callbacksTestStrategy) OnCancel(b *BasicStrategy, order *Order) {
+	s.calls = append(s.calls, "cancel:"+string(order.State))
+}
+func (s *callbacksTestStrategy) OnReject(b *BasicStrategy, order *Order, reason string) {
+	s.calls = append(s.calls, "reject:"+reason)
+}
+func (s *callbacksTestStrategy) OnReplaced(b *BasicStrategy, order *Order) {
+	s.calls = append(s.calls, "replaced")
+}
+func (s *callbacksTestStrategy) OnCancelReject(b *BasicStrategy, ordId string, reason string) {
+	s.calls = append(s.calls, "cancelReject:"+reason)
+}
+func (s *callbacksTestStrategy) OnReplaceReject(b *BasicStrategy, ordId string, reason string) {
+	s.calls = append(s.calls, "replaceReject:"+reason)
+}
+func (s *callbacksTestStrategy) OnPositionChanged(b *BasicStrategy, prevPosition int64, position int64) {
+	s.positions = append(s.positions, [2]int64{prevPosition, position})
+}
+
+func newTestCallbacksStrategy() (*BasicStrategy, *callbacksTestStrategy) {
+	us := callbacksTestStrategy{}
+	bs := BasicStrategy{symbol: newTestInstrument(), nPeriods: 20, userStrategy: &us}
+	bs.init(CoreStrategyChannels{
+		errors:    make(chan error, 10),
+		events:    make(chan event, 10),
+		portfolio: make(chan *PortfolioNewPositionEvent, 5),
+	})
+	bs.mdChan = make(chan event, 1)
+	bs.mdChan <- &NewTickEvent{}
+	bs.handlersWaitGroup = &sync.WaitGroup{}
+	bs.mostRecentTime = newTestOrderTime()
+	return &bs, &us
+}
+
+func TestBasicStrategy_Callbacks(t *testing.T) {
+	tm := newTestOrderTime()
+
+	t.Log("Order callbacks get orders with updated state")
+	{
+		bs, us := newTestCallbacksStrategy()
+		bs.start()
+
+		id, err := bs.NewLimitOrder(10, OrderBuy, 100, GTCTIF, "ARCA")
+		assert.Nil(t, err)
+		bs.proxyEvent(&OrderConfirmationEvent{BaseEvent: be(tm, bs.symbol), OrdId: id})
+		bs.proxyEvent(&OrderReplaceRejectEvent{BaseEvent: be(tm, bs.symbol), OrdId: id, Reason: "No"})
+		bs.proxyEvent(&OrderReplacedEvent{BaseEvent: be(tm, bs.symbol), OrdId: id, NewPrice: 10.01})
+		bs.proxyEvent(&OrderFillEvent{BaseEvent: be(tm, bs.symbol), OrdId: id, Price: 10.01, Qty: 40})
+		bs.proxyEvent(&OrderCancelRejectEvent{BaseEvent: be(tm, bs.symbol), OrdId: id, Reason: "Late"})
+		bs.proxyEvent(&OrderCancelEvent{BaseEvent: be(tm, bs.symbol), OrdId: id})
+
+		id2, err := bs.NewLimitOrder(10, OrderSell, 40, GTCTIF, "ARCA")
+		assert.Nil(t, err)
+		bs.proxyEvent(&OrderRejectedEvent{BaseEvent: be(tm, bs.symbol), OrdId: id2, Reason: "Bad"})
+
+		bs.proxyEvent(&EndOfDataEvent{BaseEvent: be(tm, &Instrument{})})
+		bs.shutDown()
+
+		assert.Equal(t, []string{"start", "confirmed:ConfirmedOrder", "replaceReject:No", "replaced",
+			"fill:PartialFilledOrder", "cancelReject:Late", "cancel:CanceledOrder", "reject:Bad", "eod", "stop"},
+			us.calls)
+		assert.Equal(t, [][2]int64{{0, 40}}, us.positions)
+	}
+
+	t.Log("Order can be canceled again after cancel reject")
+	{
+		bs, _ := newTestCallbacksStrategy()
+		bs.start()
+
+		id, err := bs.NewLimitOrder(10, OrderBuy, 100, GTCTIF, "ARCA")
+		assert.Nil(t, err)
+		bs.proxyEvent(&OrderConfirmationEvent{BaseEvent: be(tm, bs.symbol), OrdId: id})
+		bs.handlersWaitGroup.Wait()
+		assert.Nil(t, bs.CancelOrder(id))
+		assert.NotNil(t, bs.CancelOrder(id))
+		bs.proxyEvent(&OrderCancelRejectEvent{BaseEvent: be(tm, bs.symbol), OrdId: id, Reason: "Late"})
+		bs.handlersWaitGroup.Wait()
+		assert.Nil(t, bs.CancelOrder(id))
+		bs.proxyEvent(&OrderCancelEvent{BaseEvent: be(tm, bs.symbol), OrdId: id})
+		bs.handlersWaitGroup.Wait()
+		_, waiting := bs.waitingConfirmation["$CAN$"+id]
+		assert.False(t, waiting)
+
+		bs.proxyEvent(&EndOfDataEvent{BaseEvent: be(tm, &Instrument{})})
+		bs.shutDown()
+	}
+
+	t.Log("Strategies without optional callbacks work as before")
+	{
+		bs := newTestStrategyWithLogic(newTestInstrument())
+		bs.mostRecentTime = tm
+		bs.start()
+		bs.proxyEvent(&EndOfDataEvent{BaseEvent: be(tm, &Instrument{})})
+		bs.shutDown()
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import "time"
+
+//ICandleIndicator is updated with every closed candle of strategy before OnCandleClose. Indicators from
+//indicators package implement it
+type ICandleIndicator interface {
+	OnCandle(t time.Time, open, high, low, close float64, volume int64)
+}
+
+//ITickIndicator is updated with every tick with trade before OnTick
+type ITickIndicator interface {
+	OnTrade(t time.Time, price float64, size int64)
+}
+
+//candleIndicator remembers time of the last candle, so history and live candles aren't counted twice
+type candleIndicator struct {
+	indicator ICandleIndicator
+	timeFrame string
+	lastTime  time.Time
+}
+
+func (i *candleIndicator) update(c *Candle) {
+	if !i.lastTime.IsZero() && !c.Datetime.After(i.lastTime) {
+		return
+	}
+	i.indicator.OnCandle(c.Datetime, c.Open, c.High, c.Low, c.Close, c.Volume)
+	i.lastTime = c.Datetime
+}
+
+type tickIndicator struct {
+	indicator ITickIndicator
+	lastTime  time.Time
+}
+
+func (i *tickIndicator) update(t *Tick) {
+	if t.Datetime.Before(i.lastTime) || !t.HasTrade() {
+		return
+	}
+	i.indicator.OnTrade(t.Datetime, t.LastPrice, t.LastSize)
+	i.lastTime = t.Datetime
+}
+
+//AddCandleIndicator registers indicator of primary timeframe and warms it up with candles strategy already has.
+//Should be called before engine run or from strategy callbacks
+func (b *BasicStrategy) AddCandleIndicator(indicator ICandleIndicator) {
+	b.AddCandleIndicatorFor("", indicator)
+}
+
+//AddCandleIndicatorFor registers indicator updated with candles of timeframe. Empty timeframe means primary
+func (b *BasicStrategy) AddCandleIndicatorFor(tf string, indicator ICandleIndicator) {
+	i := candleIndicator{indicator: indicator, timeFrame: tf}
+	for _, c := range b.CandlesOf(tf) {
+		i.update(c)
+	}
+	b.candleIndicators = append(b.candleIndicators, &i)
+}
+
+//AddTickIndicator registers indicator and warms it up with ticks strategy already has. Should be called
+//before engine run or from strategy callbacks
+func (b *BasicStrategy) AddTickIndicator(indicator ITickIndicator) {
+	i := tickIndicator{indicator: indicator}
+	for _, t := range b.Ticks {
+		i.update(t)
+	}
+	b.tickIndicators = append(b.tickIndicators, &i)
+}
+
+func (b *BasicStrategy) updateCandleIndicators(tf string, candles ...*Candle) {
+	for _, i := range b.candleIndicators {
+		if !b.sameTimeFrame(i.timeFrame, tf) {
+			continue
+		}
+		for _, c := range candles {
+			i.update(c)
+		}
+	}
+}
+
+func (b *BasicStrategy) updateTickIndicators(ticks ...*Tick) {
+	for _, i := range b.tickIndicators {
+		for _, t := range ticks {
+			i.update(t)
+		}
+	}
+}
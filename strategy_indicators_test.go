@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingIndicator struct {
+	closes []float64
+	trades []float64
+}
+
+func (i *countingIndicator) OnCandle(t time.Time, open, high, low, close float64, volume int64) {
+	i.closes = append(i.closes, close)
+}
+
+func (i *countingIndicator) OnTrade(t time.Time, price float64, size int64) {
+	i.trades = append(i.trades, price)
+}
+
+type indicatorsTestStrategy struct {
+	indicator     *countingIndicator
+	seenOnCandles []int
+	seenOnTicks   []int
+}
+
+func (s *indicatorsTestStrategy) OnTick(b *BasicStrategy, tick *Tick) {
+	s.seenOnTicks = append(s.seenOnTicks, len(s.indicator.trades))
+}
+
+func (s *indicatorsTestStrategy) OnCandleClose(b *BasicStrategy, candle *Candle) {
+	s.seenOnCandles = append(s.seenOnCandles, len(s.indicator.closes))
+}
+
+func (s *indicatorsTestStrategy) OnCandleOpen(b *BasicStrategy, price float64) {
+
+}
+
+func newTestIndicatorCandle(inst *Instrument, t time.Time, close float64) *Candle {
+	return &Candle{Candle: &marketdata.Candle{Datetime: t, Symbol: inst.Symbol, Open: close, High: close + 1,
+		Low: close - 1, Close: close, Volume: 100}, Ticker: inst}
+}
+
+func TestBasicStrategy_Indicators(t *testing.T) {
+	tm := newTestOrderTime()
+	ind := countingIndicator{}
+	us := indicatorsTestStrategy{indicator: &ind}
+	bs := BasicStrategy{symbol: newTestInstrument(), nPeriods: 2, userStrategy: &us}
+	bs.init(CoreStrategyChannels{
+		errors:    make(chan error, 10),
+		events:    make(chan event, 10),
+		portfolio: make(chan *PortfolioNewPositionEvent, 5),
+	})
+	bs.mdChan = make(chan event, 1)
+	bs.mdChan <- &NewTickEvent{}
+	bs.handlersWaitGroup = &sync.WaitGroup{}
+
+	t.Log("Indicators are warmed up with candles strategy already has")
+	{
+		bs.proxyEvent(&CandlesHistoryEvent{BaseEvent: be(tm, bs.symbol), Candles: CandleArray{
+			newTestIndicatorCandle(bs.symbol, tm.Add(-2*time.Minute), 10),
+			newTestIndicatorCandle(bs.symbol, tm.Add(-time.Minute), 11),
+		}})
+		bs.AddCandleIndicator(&ind)
+		bs.AddTickIndicator(&ind)
+		assert.Equal(t, []float64{10, 11}, ind.closes)
+	}
+
+	t.Log("History candles are not counted twice")
+	{
+		bs.proxyEvent(&CandlesHistoryEvent{BaseEvent: be(tm, bs.symbol), Candles: CandleArray{
+			newTestIndicatorCandle(bs.symbol, tm.Add(-3*time.Minute), 9),
+			newTestIndicatorCandle(bs.symbol, tm.Add(-time.Minute), 11),
+		}})
+		assert.Equal(t, []float64{10, 11}, ind.closes)
+	}
+
+	t.Log("Indicators are updated before user callbacks")
+	{
+		c := newTestIndicatorCandle(bs.symbol, tm, 12)
+		bs.proxyEvent(&CandleCloseEvent{BaseEvent: be(tm, bs.symbol), Candle: c, TimeFrame: "1"})
+		tick := &Tick{Tick: &marketdata.Tick{Datetime: tm, Symbol: "Test", LastPrice: 12.5, LastSize: 100,
+			BidPrice: 12.4, AskPrice: 12.6, BidSize: 100, AskSize: 100}, Ticker: bs.symbol}
+		bs.proxyEvent(&NewTickEvent{BaseEvent: be(tm, bs.symbol), Tick: tick})
+		bs.proxyEvent(&NewTickEvent{BaseEvent: be(tm, bs.symbol), Tick: tick})
+		bs.handlersWaitGroup.Wait()
+
+		assert.Equal(t, []float64{10, 11, 12}, ind.closes)
+		assert.Equal(t, []int{3}, us.seenOnCandles)
+		assert.Equal(t, []float64{12.5, 12.5}, ind.trades)
+		assert.Equal(t, []int{2}, us.seenOnTicks)
+	}
+}
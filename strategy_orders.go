@@ -0,0 +1,94 @@
+package engine
+
+import "sort"
+
+//Orders query API. All methods return snapshots: copies of orders which don't change when strategy gets new
+//order events. Orders are searched in current trade and in closed trades
+
+//allTrades returns current trade and closed trades starting from the most recent
+func (b *BasicStrategy) allTrades() []*Trade {
+	trades := []*Trade{b.currentTrade}
+	for i := len(b.closedTrades) - 1; i >= 0; i-- {
+		trades = append(trades, b.closedTrades[i])
+	}
+	return trades
+}
+
+//collectOrders returns snapshots of orders from maps selected by fn, without duplicates and sorted by time
+func (b *BasicStrategy) collectOrders(fn func(t *Trade) []map[string]*Order) []Order {
+	seen := make(map[string]struct{})
+	var res []Order
+	for _, t := range b.allTrades() {
+		if t == nil {
+			continue
+		}
+		for _, m := range fn(t) {
+			for id, o := range m {
+				if _, ok := seen[id]; ok {
+					continue
+				}
+				seen[id] = struct{}{}
+				res = append(res, o.snapshot())
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Time.Equal(res[j].Time) {
+			return res[i].Id < res[j].Id
+		}
+		return res[i].Time.Before(res[j].Time)
+	})
+	return res
+}
+
+//GetOrder returns snapshot of order with state, executed qty, average execution price, fills and replaces
+func (b *BasicStrategy) GetOrder(ordId string) (Order, bool) {
+	o := b.findOrder(ordId)
+	if o == nil {
+		return Order{}, false
+	}
+	return o.snapshot(), true
+}
+
+//OrderStatus returns state of order or empty string if order is unknown
+func (b *BasicStrategy) OrderStatus(ordId string) OrderState {
+	o := b.findOrder(ordId)
+	if o == nil {
+		return ""
+	}
+	return o.State
+}
+
+//WorkingOrders returns orders that can still be filled: sent, confirmed and partially filled
+func (b *BasicStrategy) WorkingOrders() []Order {
+	return b.collectOrders(func(t *Trade) []map[string]*Order {
+		return []map[string]*Order{t.NewOrders, t.ConfirmedOrders}
+	})
+}
+
+func (b *BasicStrategy) FilledOrders() []Order {
+	return b.collectOrders(func(t *Trade) []map[string]*Order {
+		return []map[string]*Order{t.FilledOrders}
+	})
+}
+
+//CanceledOrders returns canceled orders including partially filled before cancel
+func (b *BasicStrategy) CanceledOrders() []Order {
+	return b.collectOrders(func(t *Trade) []map[string]*Order {
+		return []map[string]*Order{t.CanceledOrders}
+	})
+}
+
+func (b *BasicStrategy) RejectedOrders() []Order {
+	return b.collectOrders(func(t *Trade) []map[string]*Order {
+		return []map[string]*Order{t.RejectedOrders}
+	})
+}
+
+//AllOrders returns orders in all states
+func (b *BasicStrategy) AllOrders() []Order {
+	return b.collectOrders(func(t *Trade) []map[string]*Order {
+		return []map[string]*Order{t.NewOrders, t.ConfirmedOrders, t.FilledOrders, t.CanceledOrders,
+			t.RejectedOrders}
+	})
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBasicStrategy_OrdersQuery(t *testing.T) {
+	tm := newTestOrderTime()
+	bs, _ := newTestCallbacksStrategy()
+
+	t.Log("Order lifecycle is kept on order")
+	{
+		id, err := bs.NewLimitOrder(10, OrderBuy, 100, GTCTIF, "ARCA")
+		assert.Nil(t, err)
+		assert.Equal(t, NewOrder, bs.OrderStatus(id))
+
+		bs.proxyEvent(&OrderConfirmationEvent{BaseEvent: be(tm.Add(time.Second), bs.symbol), OrdId: id})
+		bs.proxyEvent(&OrderReplacedEvent{BaseEvent: be(tm.Add(2*time.Second), bs.symbol), OrdId: id, NewPrice: 10.2})
+		bs.proxyEvent(&OrderFillEvent{BaseEvent: be(tm.Add(3*time.Second), bs.symbol), OrdId: id, Price: 10.2, Qty: 40})
+		bs.proxyEvent(&OrderFillEvent{BaseEvent: be(tm.Add(4*time.Second), bs.symbol), OrdId: id, Price: 10.1, Qty: 60})
+
+		o, ok := bs.GetOrder(id)
+		assert.True(t, ok)
+		assert.Equal(t, FilledOrder, o.State)
+		assert.Equal(t, FilledOrder, bs.OrderStatus(id))
+		assert.Equal(t, int64(100), o.ExecQty)
+		assert.InDelta(t, 10.14, o.ExecPrice, 0.0000001)
+		assert.Equal(t, tm, o.SentTime)
+		assert.Equal(t, tm.Add(time.Second), o.ConfirmedTime)
+		assert.Equal(t, []OrderReplacement{{Time: tm.Add(2 * time.Second), OldPrice: 10, NewPrice: 10.2, OldQty: 100,
+			NewQty: 100, OldTif: GTCTIF, NewTif: GTCTIF, OldDestination: "ARCA", NewDestination: "ARCA"}}, o.Replaces)
+		assert.Equal(t, []OrderExecution{
+			{Time: tm.Add(3 * time.Second), Price: 10.2, Qty: 40},
+			{Time: tm.Add(4 * time.Second), Price: 10.1, Qty: 60},
+		}, o.Fills)
+	}
+
+	t.Log("Snapshot doesn't change with order")
+	{
+		id, _ := bs.NewLimitOrder(11, OrderSell, 100, GTCTIF, "ARCA")
+		bs.proxyEvent(&OrderConfirmationEvent{BaseEvent: be(tm.Add(5*time.Second), bs.symbol), OrdId: id})
+		before, _ := bs.GetOrder(id)
+		before.Fills = append(before.Fills, OrderExecution{Qty: 1})
+
+		bs.proxyEvent(&OrderFillEvent{BaseEvent: be(tm.Add(6*time.Second), bs.symbol), OrdId: id, Price: 11, Qty: 100})
+		after, _ := bs.GetOrder(id)
+		assert.Equal(t, ConfirmedOrder, before.State)
+		assert.Equal(t, FilledOrder, after.State)
+		assert.Len(t, after.Fills, 1)
+		assert.Equal(t, int64(100), after.Fills[0].Qty)
+	}
+
+	t.Log("Orders of closed trades are found too")
+	{
+		assert.Len(t, bs.closedTrades, 1)
+		assert.Len(t, bs.FilledOrders(), 2)
+
+		id, _ := bs.NewLimitOrder(9, OrderBuy, 100, GTCTIF, "ARCA")
+		bs.proxyEvent(&OrderConfirmationEvent{BaseEvent: be(tm.Add(7*time.Second), bs.symbol), OrdId: id})
+		bs.proxyEvent(&OrderFillEvent{BaseEvent: be(tm.Add(8*time.Second), bs.symbol), OrdId: id, Price: 9, Qty: 30})
+		bs.proxyEvent(&OrderCancelEvent{BaseEvent: be(tm.Add(9*time.Second), bs.symbol), OrdId: id})
+
+		id2, _ := bs.NewLimitOrder(9, OrderBuy, 100, GTCTIF, "ARCA")
+		bs.proxyEvent(&OrderRejectedEvent{BaseEvent: be(tm.Add(10*time.Second), bs.symbol), OrdId: id2, Reason: "No"})
+
+		id3, _ := bs.NewLimitOrder(8, OrderBuy, 100, GTCTIF, "ARCA")
+
+		canceled := bs.CanceledOrders()
+		if assert.Len(t, canceled, 1) {
+			assert.Equal(t, id, canceled[0].Id)
+			assert.Equal(t, int64(30), canceled[0].ExecQty)
+			assert.Equal(t, tm.Add(9*time.Second), canceled[0].CanceledTime)
+		}
+		rejected := bs.RejectedOrders()
+		if assert.Len(t, rejected, 1) {
+			assert.Equal(t, "No", rejected[0].Mark1)
+			assert.Equal(t, tm.Add(10*time.Second), rejected[0].RejectedTime)
+		}
+		working := bs.WorkingOrders()
+		if assert.Len(t, working, 1) {
+			assert.Equal(t, id3, working[0].Id)
+		}
+		assert.Len(t, bs.AllOrders(), 5)
+	}
+
+	t.Log("Unknown order")
+	{
+		_, ok := bs.GetOrder("Unknown")
+		assert.False(t, ok)
+		assert.Equal(t, OrderState(""), bs.OrderStatus("Unknown"))
+	}
+}
+
+func TestBasicStrategy_AmendOrder(t *testing.T) {
+	tm := newTestOrderTime()
+	bs, _ := newTestCallbacksStrategy()
+
+	id, _ := bs.NewLimitOrder(10, OrderBuy, 100, GTCTIF, "ARCA")
+	bs.proxyEvent(&OrderConfirmationEvent{BaseEvent: be(tm.Add(time.Second), bs.symbol), OrdId: id})
+	bs.proxyEvent(&OrderFillEvent{BaseEvent: be(tm.Add(2*time.Second), bs.symbol), OrdId: id, Price: 10, Qty: 40})
+
+	t.Log("Amendment is checked before request is sent")
+	{
+		assert.NotNil(t, bs.AmendOrder(id, OrderAmendment{}))
+		assert.NotNil(t, bs.AmendOrder(id, OrderAmendment{Qty: 30}))
+		assert.NotNil(t, bs.AmendOrder("Not existing", OrderAmendment{Qty: 300}))
+	}
+
+	t.Log("Qty increase of partially filled order keeps executed qty")
+	{
+		assert.Nil(t, bs.AmendOrder(id, OrderAmendment{Qty: 300, Tif: DayTIF}))
+		assert.NotNil(t, bs.AmendOrder(id, OrderAmendment{Qty: 200}), "Previous request is waiting")
+
+		bs.proxyEvent(&OrderReplacedEvent{BaseEvent: be(tm.Add(3*time.Second), bs.symbol), OrdId: id, NewQty: 300,
+			NewTif: DayTIF})
+		o, _ := bs.GetOrder(id)
+		assert.Equal(t, int64(300), o.Qty)
+		assert.Equal(t, int64(40), o.ExecQty)
+		assert.Equal(t, PartialFilledOrder, o.State)
+		assert.Equal(t, DayTIF, o.Tif)
+		assert.Equal(t, 10.0, o.Price)
+	}
+
+	t.Log("Qty reduced to executed qty fills order")
+	{
+		assert.Nil(t, bs.AmendOrder(id, OrderAmendment{Qty: 40, Destination: "NSDQ"}))
+		bs.proxyEvent(&OrderReplacedEvent{BaseEvent: be(tm.Add(4*time.Second), bs.symbol), OrdId: id, NewQty: 40,
+			NewDestination: "NSDQ"})
+
+		o, _ := bs.GetOrder(id)
+		assert.Equal(t, FilledOrder, o.State)
+		assert.Equal(t, "NSDQ", o.Destination)
+		assert.Len(t, bs.WorkingOrders(), 0)
+		assert.Equal(t, []OrderReplacement{
+			{Time: tm.Add(3 * time.Second), OldPrice: 10, NewPrice: 10, OldQty: 100, NewQty: 300, OldTif: GTCTIF,
+				NewTif: DayTIF, OldDestination: "ARCA", NewDestination: "ARCA"},
+			{Time: tm.Add(4 * time.Second), OldPrice: 10, NewPrice: 10, OldQty: 300, NewQty: 40, OldTif: DayTIF,
+				NewTif: DayTIF, OldDestination: "ARCA", NewDestination: "NSDQ"},
+		}, o.Replaces)
+	}
+}
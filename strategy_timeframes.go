@@ -0,0 +1,129 @@
+package engine
+
+import "sort"
+
+//Strategy gets candles of all timeframes market data provides for its symbol. Candles of primary timeframe are
+//kept in b.Candles, they update position PnL and last candle open. Other timeframes have their own windows of
+//nPeriods candles. OnCandleClose is called for every timeframe, use candle.TimeFrame to tell them apart.
+//OnCandleOpen is called for primary timeframe only
+
+//ITimeFramesMarketData is optional interface of market data that provides candles of several timeframes.
+//Only candles of primary timeframe are sent to simulated broker. Candles built from ticks aren't sent at all,
+//broker fills orders on ticks
+type ITimeFramesMarketData interface {
+	PrimaryTimeFrame() string
+	BarsFromTicks() bool
+}
+
+func (b *BasicStrategy) setPrimaryTimeFrame(tf string) {
+	b.primaryTimeFrame = tf
+}
+
+//notePrimaryTimeFrame makes the first seen timeframe primary if engine didn't set it
+func (b *BasicStrategy) notePrimaryTimeFrame(tf string) {
+	if b.primaryTimeFrame == "" {
+		b.primaryTimeFrame = tf
+	}
+}
+
+//isPrimaryTimeFrame returns true for candles without timeframe and while primary timeframe is unknown
+func (b *BasicStrategy) isPrimaryTimeFrame(tf string) bool {
+	return tf == "" || b.primaryTimeFrame == "" || tf == b.primaryTimeFrame
+}
+
+func (b *BasicStrategy) sameTimeFrame(tf1 string, tf2 string) bool {
+	if b.isPrimaryTimeFrame(tf1) && b.isPrimaryTimeFrame(tf2) {
+		return true
+	}
+	return tf1 == tf2
+}
+
+//PrimaryTimeFrame returns timeframe of b.Candles. It's empty until the first candle
+func (b *BasicStrategy) PrimaryTimeFrame() string {
+	return b.primaryTimeFrame
+}
+
+//TimeFrames returns timeframes strategy got candles of. Primary timeframe goes first
+func (b *BasicStrategy) TimeFrames() []string {
+	var res []string
+	if b.primaryTimeFrame != "" {
+		res = append(res, b.primaryTimeFrame)
+	}
+	var other []string
+	for tf := range b.candlesByTF {
+		other = append(other, tf)
+	}
+	sort.Strings(other)
+	return append(res, other...)
+}
+
+//CandlesOf returns window of candles of timeframe. For primary timeframe it's b.Candles
+func (b *BasicStrategy) CandlesOf(tf string) CandleArray {
+	if b.isPrimaryTimeFrame(tf) {
+		return b.Candles
+	}
+	return b.candlesByTF[tf]
+}
+
+//putTimeFrameCandle puts closed candle of timeframe to its window
+func (b *BasicStrategy) putTimeFrameCandle(tf string, candle *Candle) {
+	if b.isPrimaryTimeFrame(tf) {
+		b.putNewCandle(candle)
+		return
+	}
+	if b.candlesByTF == nil {
+		b.candlesByTF = make(map[string]CandleArray)
+	}
+	b.candlesByTF[tf] = appendToCandlesWindow(b.candlesByTF[tf], candle, b.nPeriods)
+}
+
+//setCandlesWindow replaces window of timeframe with the last nPeriods candles
+func (b *BasicStrategy) setCandlesWindow(tf string, candles CandleArray) {
+	if len(candles) > b.nPeriods {
+		candles = candles[len(candles)-b.nPeriods:]
+	}
+	if b.isPrimaryTimeFrame(tf) {
+		b.Candles = candles
+		b.updateLastCandleOpen()
+		return
+	}
+	if b.candlesByTF == nil {
+		b.candlesByTF = make(map[string]CandleArray)
+	}
+	b.candlesByTF[tf] = candles
+}
+
+func appendToCandlesWindow(candles CandleArray, candle *Candle, n int) CandleArray {
+	sortIt := false
+	if len(candles) > 0 && candle.Datetime.Before(candles[len(candles)-1].Datetime) {
+		sortIt = true
+	}
+
+	if len(candles) < n {
+		return append(candles, candle)
+	}
+	candles = append(candles[1:], candle)
+
+	if sortIt {
+		sort.SliceStable(candles, func(i, j int) bool {
+			return candles[i].Datetime.Unix() < candles[j].Datetime.Unix()
+		})
+	}
+	return candles
+}
+
+//primaryTimeFrame returns timeframe of candles sent to simulated broker. Empty string means all candles
+func (c *Engine) primaryTimeFrame() string {
+	if md, ok := c.md.(ITimeFramesMarketData); ok {
+		return md.PrimaryTimeFrame()
+	}
+	return ""
+}
+
+func (c *Engine) isBrokerTimeFrame(tf string) bool {
+	if md, ok := c.md.(ITimeFramesMarketData); ok && md.BarsFromTicks() {
+		return false
+	}
+	primary := c.primaryTimeFrame()
+	return primary == "" || tf == "" || tf == primary
+}
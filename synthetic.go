@@ -0,0 +1,507 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//tradingDaysInYear is used to convert time of session to years of models parameters
+const tradingDaysInYear = 252
+
+//******* PRICE MODELS ********************************************************
+
+//IPriceModel makes the next price of path after dt years of trading time. Models may keep state, so every symbol
+//gets its own model
+type IPriceModel interface {
+	Next(price float64, dt float64, rnd *rand.Rand) float64
+}
+
+//GBMModel is geometric Brownian motion with annual drift and volatility
+type GBMModel struct {
+	Drift      float64
+	Volatility float64
+}
+
+func (m *GBMModel) Next(price float64, dt float64, rnd *rand.Rand) float64 {
+	return price * math.Exp((m.Drift-m.Volatility*m.Volatility/2)*dt+m.Volatility*math.Sqrt(dt)*rnd.NormFloat64())
+}
+
+//JumpDiffusionModel is GBM with jumps of log price. JumpIntensity is expected number of jumps in year, sizes of
+//jumps are normal with JumpMean and JumpVolatility
+type JumpDiffusionModel struct {
+	GBMModel
+	JumpIntensity  float64
+	JumpMean       float64
+	JumpVolatility float64
+}
+
+func (m *JumpDiffusionModel) Next(price float64, dt float64, rnd *rand.Rand) float64 {
+	price = m.GBMModel.Next(price, dt, rnd)
+	for i := poisson(m.JumpIntensity*dt, rnd); i > 0; i-- {
+		price *= math.Exp(m.JumpMean + m.JumpVolatility*rnd.NormFloat64())
+	}
+	return price
+}
+
+//poisson returns random number of events with expected number lambda
+func poisson(lambda float64, rnd *rand.Rand) int {
+	if lambda <= 0 {
+		return 0
+	}
+	l := math.Exp(-lambda)
+	n := 0
+	for p := rnd.Float64(); p > l; p *= rnd.Float64() {
+		n++
+	}
+	return n
+}
+
+//OUModel is mean reverting Ornstein-Uhlenbeck process of price. Reversion is annual speed of reversion to Mean,
+//Volatility is annual volatility in price units. Price is kept positive
+type OUModel struct {
+	Mean       float64
+	Reversion  float64
+	Volatility float64
+}
+
+func (m *OUModel) Next(price float64, dt float64, rnd *rand.Rand) float64 {
+	price += m.Reversion*(m.Mean-price)*dt + m.Volatility*math.Sqrt(dt)*rnd.NormFloat64()
+	return math.Max(price, minSyntheticPrice)
+}
+
+//minSyntheticPrice is the lowest price of models which can go negative
+const minSyntheticPrice = 0.0001
+
+//RegimeSwitchingModel is GBM which volatility switches between Volatilities. SwitchIntensity is expected number of
+//switches in year, regime after switch is random. Path starts in the first regime
+type RegimeSwitchingModel struct {
+	Drift           float64
+	Volatilities    []float64
+	SwitchIntensity float64
+	regime          int
+}
+
+func (m *RegimeSwitchingModel) Next(price float64, dt float64, rnd *rand.Rand) float64 {
+	if len(m.Volatilities) > 1 && rnd.Float64() < m.SwitchIntensity*dt {
+		next := rnd.Intn(len(m.Volatilities) - 1)
+		if next >= m.regime {
+			next++
+		}
+		m.regime = next
+	}
+	vol := 0.0
+	if len(m.Volatilities) > 0 {
+		vol = m.Volatilities[m.regime]
+	}
+	gbm := GBMModel{Drift: m.Drift, Volatility: vol}
+	return gbm.Next(price, dt, rnd)
+}
+
+//Regime returns index of current volatility
+func (m *RegimeSwitchingModel) Regime() int {
+	return m.regime
+}
+
+//SyntheticShock is scripted move of price: log return of Return part (-0.2 is 20% crash) is spread over
+//Duration from Time. Zero duration is gap at Time. Empty symbol is shock of all symbols. Part of shock out of
+//sessions moves price at the next session open
+type SyntheticShock struct {
+	Symbol   string
+	Time     time.Time
+	Duration time.Duration
+	Return   float64
+}
+
+//factor returns price multiplier of shock for time from prev to t
+func (s *SyntheticShock) factor(prev, t time.Time) float64 {
+	if s.Duration <= 0 {
+		if s.Time.After(prev) && !s.Time.After(t) {
+			return 1 + s.Return
+		}
+		return 1
+	}
+	from, to := prev, t
+	if s.Time.After(from) {
+		from = s.Time
+	}
+	if end := s.Time.Add(s.Duration); end.Before(to) {
+		to = end
+	}
+	if !to.After(from) {
+		return 1
+	}
+	return math.Exp(math.Log(1+s.Return) * float64(to.Sub(from)) / float64(s.Duration))
+}
+
+//******* SYNTHETIC MARKET DATA ***********************************************
+
+//SyntheticMarketData is IMarketData which makes ticks or candles of price models without storage. Prices change
+//only inside of regular sessions of symbols exchanges, every TickInterval in ticks modes or CandleSteps times in
+//candle of TimeFrame in candles mode. Candles timeframes are intraday and "D". Ticks have trade at bid or ask
+//and quote with Spread around model price, prices are rounded to MinTick of symbol. Model returns price model of
+//symbol (GBM with 20% volatility by default), the same Seed gives the same data
+type SyntheticMarketData struct {
+	FromDate     time.Time
+	ToDate       time.Time
+	Mode         MarketDataMode
+	TimeFrame    string
+	TickInterval time.Duration
+	CandleSteps  int
+	StartPrice   float64
+	StartPrices  map[string]float64
+	Spread       float64
+	TradeSize    int64
+	QuoteSize    int64
+	Model        func(symbol *Instrument) IPriceModel
+	Shocks       []SyntheticShock
+	Seed         int64
+
+	symbols          []*Instrument
+	errChan          chan error
+	mdChan           chan event
+	clock            Clock
+	lastEventTime    time.Time
+	histDataTimeBack time.Duration
+	waitGroup        sync.WaitGroup
+}
+
+//NewSyntheticMarketData returns synthetic market data of mode with default parameters: minute ticks or 5 minutes
+//candles of GBM from 100 with 0.02 spread
+func NewSyntheticMarketData(mode MarketDataMode, from, to time.Time, seed int64) *SyntheticMarketData {
+	return &SyntheticMarketData{
+		FromDate:     from,
+		ToDate:       to,
+		Mode:         mode,
+		TimeFrame:    "5",
+		TickInterval: time.Minute,
+		CandleSteps:  20,
+		StartPrice:   100,
+		Spread:       0.02,
+		TradeSize:    100,
+		QuoteSize:    500,
+		Seed:         seed,
+	}
+}
+
+func (m *SyntheticMarketData) Run() {
+	m.waitGroup.Add(1)
+	go func() {
+		m.run()
+		m.waitGroup.Done()
+	}()
+}
+
+func (m *SyntheticMarketData) Connect() {
+	fmt.Println("Synthetic market data connected. ")
+}
+
+func (m *SyntheticMarketData) Init(errChan chan error, mdChan chan event) {
+	if errChan == nil {
+		panic("Error chan is nil")
+	}
+
+	if mdChan == nil {
+		panic("Event chan is nil")
+	}
+	m.errChan = errChan
+	m.mdChan = mdChan
+}
+
+func (m *SyntheticMarketData) SetSymbols(symbols []*Instrument) {
+	m.symbols = symbols
+}
+
+func (m *SyntheticMarketData) SetClock(clock Clock) {
+	m.clock = clock
+}
+
+//RequestHistoricalData makes data of the first duration to be sent as history events
+func (m *SyntheticMarketData) RequestHistoricalData(duration time.Duration) {
+	m.histDataTimeBack = duration
+}
+
+func (m *SyntheticMarketData) ShutDown() {
+	m.waitGroup.Wait()
+}
+
+//now is the same as BTM.now
+func (m *SyntheticMarketData) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+	if m.clock.IsSimulated() {
+		return m.lastEventTime
+	}
+	return m.clock.Now()
+}
+
+func (m *SyntheticMarketData) sendEvent(e event) {
+	if e.getTime().After(m.lastEventTime) {
+		m.lastEventTime = e.getTime()
+	}
+	m.mdChan <- e
+}
+
+//syntheticPath is state of symbol price
+type syntheticPath struct {
+	symbol  *Instrument
+	model   IPriceModel
+	price   float64
+	history []event
+	live    bool
+	//lastStep is time price was moved to
+	lastStep time.Time
+}
+
+func (m *SyntheticMarketData) newPaths() []*syntheticPath {
+	var paths []*syntheticPath
+	for _, s := range m.symbols {
+		p := syntheticPath{symbol: s, price: m.StartPrice, lastStep: m.FromDate}
+		if price, ok := m.StartPrices[s.Symbol]; ok {
+			p.price = price
+		}
+		if m.Model != nil {
+			p.model = m.Model(s)
+		} else {
+			p.model = &GBMModel{Volatility: 0.2}
+		}
+		paths = append(paths, &p)
+	}
+	return paths
+}
+
+//step moves price of path from time prev to t
+func (m *SyntheticMarketData) step(p *syntheticPath, prev, t time.Time, rnd *rand.Rand) {
+	session := p.symbol.Exchange.CloseTime(t).Sub(p.symbol.Exchange.OpenTime(t))
+	if session <= 0 {
+		session = 24 * time.Hour
+	}
+	dt := float64(t.Sub(prev)) / float64(session) / tradingDaysInYear
+	p.price = p.model.Next(p.price, dt, rnd)
+	m.applyShocks(p, prev, t)
+}
+
+//openSession moves price of path by shocks from its last step to session open. Model doesn't move price out of
+//sessions
+func (m *SyntheticMarketData) openSession(p *syntheticPath, open time.Time) {
+	if open.After(p.lastStep) {
+		m.applyShocks(p, p.lastStep, open)
+	}
+}
+
+func (m *SyntheticMarketData) applyShocks(p *syntheticPath, prev, t time.Time) {
+	for _, s := range m.Shocks {
+		if s.Symbol == "" || s.Symbol == p.symbol.Symbol {
+			p.price *= s.factor(prev, t)
+		}
+	}
+	p.lastStep = t
+}
+
+//sessions calls f with open and close of every trading day of symbols exchange in date range. Symbols of
+//different exchanges use exchange of the first symbol
+func (m *SyntheticMarketData) sessions(f func(open, close time.Time)) {
+	if len(m.symbols) == 0 {
+		return
+	}
+	e := m.symbols[0].Exchange
+	from, to := e.localTime(m.FromDate), e.localTime(m.ToDate)
+	for d := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location()); !d.After(to); d = d.AddDate(0, 0, 1) {
+		if !e.IsTradingDay(d) {
+			continue
+		}
+		open, closeTime := e.OpenTime(d), e.CloseTime(d)
+		if !closeTime.After(open) {
+			open, closeTime = d, d.AddDate(0, 0, 1)
+		}
+		f(open, closeTime)
+	}
+}
+
+func (m *SyntheticMarketData) run() {
+	rnd := rand.New(rand.NewSource(m.Seed))
+	paths := m.newPaths()
+	if m.Mode == MarketDataModeCandles {
+		m.genCandles(paths, rnd)
+	} else {
+		m.genTicks(paths, rnd)
+	}
+
+	for _, p := range paths {
+		m.flushHistory(p)
+	}
+	m.sendEvent(&EndOfDataEvent{BaseEvent: be(m.now(), &Instrument{})})
+}
+
+func (m *SyntheticMarketData) genTicks(paths []*syntheticPath, rnd *rand.Rand) {
+	interval := m.TickInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	m.sessions(func(open, closeTime time.Time) {
+		for t := open; t.Before(closeTime); t = t.Add(interval) {
+			for _, p := range paths {
+				if t.After(open) {
+					m.step(p, t.Add(-interval), t, rnd)
+				} else {
+					m.openSession(p, open)
+				}
+				tick := m.newTick(p, t, rnd)
+				m.put(p, &NewTickEvent{Tick: tick, BaseEvent: be(t, p.symbol)})
+			}
+		}
+	})
+}
+
+func (m *SyntheticMarketData) newTick(p *syntheticPath, t time.Time, rnd *rand.Rand) *Tick {
+	raw := marketdata.Tick{
+		Datetime:  t,
+		Symbol:    p.symbol.Symbol,
+		LastPrice: math.NaN(),
+		BidPrice:  math.NaN(),
+		AskPrice:  math.NaN(),
+	}
+	tick := p.symbol.MinTick
+	bid := roundSyntheticPrice(p.price-m.Spread/2, tick, math.Floor)
+	ask := roundSyntheticPrice(p.price+m.Spread/2, tick, math.Ceil)
+	if ask <= bid {
+		ask = bid + tick
+	}
+	if bid <= 0 {
+		bid = math.Max(tick, minSyntheticPrice)
+		ask = math.Max(ask, bid+tick)
+	}
+
+	if m.Mode != MarketDataModeTicks {
+		raw.BidPrice, raw.BidSize = bid, m.QuoteSize
+		raw.AskPrice, raw.AskSize = ask, m.QuoteSize
+	}
+	if m.Mode != MarketDataModeQuotes {
+		raw.LastPrice, raw.LastSize = bid, m.TradeSize
+		if rnd.Intn(2) == 1 {
+			raw.LastPrice = ask
+		}
+	}
+	return &Tick{Tick: &raw, Ticker: p.symbol}
+}
+
+//roundSyntheticPrice rounds price to tick with round function. Price isn't rounded without tick
+func roundSyntheticPrice(price, tick float64, round func(float64) float64) float64 {
+	if tick <= 0 {
+		return price
+	}
+	n := price / tick
+	if math.Abs(n-math.Round(n)) < 1e-9 {
+		n = math.Round(n)
+	}
+	return math.Round(round(n)*tick*1e8) / 1e8
+}
+
+func (m *SyntheticMarketData) genCandles(paths []*syntheticPath, rnd *rand.Rand) {
+	tf := m.TimeFrame
+	if tf == "" {
+		tf = "D"
+	}
+	d, err := parseTimeFrame(tf)
+	if err != nil || tf == "W" {
+		panic("Synthetic market data doesn't support timeframe: " + tf)
+	}
+	steps := m.CandleSteps
+	if steps <= 0 {
+		steps = 1
+	}
+
+	m.sessions(func(open, closeTime time.Time) {
+		if tf == "D" {
+			d = closeTime.Sub(open)
+		}
+		for t := open; t.Before(closeTime); t = t.Add(d) {
+			end := t.Add(d)
+			if end.After(closeTime) {
+				end = closeTime
+			}
+			candles := make([]*Candle, len(paths))
+			for i, p := range paths {
+				candles[i] = m.newCandle(p, t, end, steps, tf, rnd)
+				m.put(p, &CandleOpenEvent{BaseEvent: be(candles[i].Datetime, p.symbol), CandleTime: candles[i].Datetime,
+					Price: candles[i].Open, TimeFrame: tf})
+			}
+			for i, p := range paths {
+				ce := CandleCloseEvent{BaseEvent: be(end, p.symbol), Candle: candles[i], TimeFrame: tf}
+				m.put(p, &ce)
+			}
+		}
+	})
+}
+
+//newCandle moves price of path by steps from open to end of candle and returns candle of path
+func (m *SyntheticMarketData) newCandle(p *syntheticPath, open, end time.Time, steps int, tf string,
+	rnd *rand.Rand) *Candle {
+	m.openSession(p, open)
+	tick := p.symbol.MinTick
+	price := roundSyntheticPrice(p.price, tick, math.Round)
+	raw := marketdata.Candle{Symbol: p.symbol.Symbol, Datetime: open, Open: price, High: price, Low: price}
+	if tf == "D" {
+		raw.Datetime = time.Date(open.Year(), open.Month(), open.Day(), 0, 0, 0, 0, open.Location())
+	}
+	stepDuration := end.Sub(open) / time.Duration(steps)
+	prev := open
+	for i := 1; i <= steps; i++ {
+		t := open.Add(stepDuration * time.Duration(i))
+		if i == steps {
+			t = end
+		}
+		m.step(p, prev, t, rnd)
+		prev = t
+		price = roundSyntheticPrice(p.price, tick, math.Round)
+		raw.High = math.Max(raw.High, price)
+		raw.Low = math.Min(raw.Low, price)
+	}
+	raw.Close = price
+	raw.AdjClose = price
+	raw.Volume = m.TradeSize * int64(steps)
+	return &Candle{Candle: &raw, Ticker: p.symbol, TimeFrame: tf, CloseTime: end}
+}
+
+//put sends event or keeps it in history of path until history duration is over
+func (m *SyntheticMarketData) put(p *syntheticPath, e event) {
+	if !p.live && m.histDataTimeBack > 0 {
+		if len(p.history) == 0 || e.getTime().Sub(p.history[0].getTime()) < m.histDataTimeBack {
+			p.history = append(p.history, e)
+			return
+		}
+		m.flushHistory(p)
+	}
+	p.live = true
+	m.sendEvent(e)
+}
+
+//flushHistory sends history of path as ticks or candles history event
+func (m *SyntheticMarketData) flushHistory(p *syntheticPath) {
+	if len(p.history) == 0 {
+		return
+	}
+	last := p.history[len(p.history)-1].getTime()
+	var ticks TickArray
+	var candles CandleArray
+	for _, e := range p.history {
+		switch i := e.(type) {
+		case *NewTickEvent:
+			ticks = append(ticks, i.Tick)
+		case *CandleCloseEvent:
+			candles = append(candles, i.Candle)
+		}
+	}
+	if len(ticks) > 0 {
+		m.sendEvent(&TickHistoryEvent{BaseEvent: be(last, p.symbol), Ticks: ticks})
+	}
+	if len(candles) > 0 {
+		m.sendEvent(&CandlesHistoryEvent{BaseEvent: be(last, p.symbol), Candles: candles})
+	}
+	p.history = nil
+	p.live = true
+}
@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func runTestSyntheticMarketData(m *SyntheticMarketData, symbols ...*Instrument) []event {
+	mdChan := make(chan event, 20000)
+	m.Init(make(chan error, 1), mdChan)
+	m.SetSymbols(symbols)
+	m.Run()
+	m.ShutDown()
+	close(mdChan)
+	var res []event
+	for e := range mdChan {
+		res = append(res, e)
+	}
+	return res
+}
+
+func TestPriceModels(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	dt := 1.0 / tradingDaysInYear / 390
+	n := 50000
+
+	t.Log("GBM: volatility of log returns")
+	{
+		m := GBMModel{Volatility: 0.3}
+		price, sum, sumSq := 100.0, 0.0, 0.0
+		for i := 0; i < n; i++ {
+			next := m.Next(price, dt, rnd)
+			r := math.Log(next / price)
+			sum += r
+			sumSq += r * r
+			price = next
+		}
+		mean := sum / float64(n)
+		std := math.Sqrt(sumSq/float64(n) - mean*mean)
+		assert.InDelta(t, 0.3*math.Sqrt(dt), std, 0.3*math.Sqrt(dt)*0.03)
+	}
+
+	t.Log("Jump diffusion: number of jumps")
+	{
+		m := JumpDiffusionModel{JumpIntensity: 2000, JumpMean: 0.01}
+		price, jumps := 100.0, 0
+		for i := 0; i < n; i++ {
+			next := m.Next(price, dt, rnd)
+			jumps += int(math.Round(math.Log(next/price) / 0.01))
+			price = next
+		}
+		expected := 2000 * dt * float64(n)
+		assert.InDelta(t, expected, float64(jumps), expected*0.15)
+	}
+
+	t.Log("OU: price reverts to mean")
+	{
+		m := OUModel{Mean: 50, Reversion: 500, Volatility: 10}
+		price, sum := 100.0, 0.0
+		for i := 0; i < n; i++ {
+			price = m.Next(price, dt, rnd)
+			if i >= n/2 {
+				sum += price
+			}
+		}
+		assert.InDelta(t, 50, sum/float64(n/2), 1)
+	}
+
+	t.Log("Regime switching: regime changes only with intensity")
+	{
+		m := RegimeSwitchingModel{Volatilities: []float64{0.1, 0.5}}
+		for i := 0; i < 1000; i++ {
+			m.Next(100, dt, rnd)
+		}
+		assert.Equal(t, 0, m.Regime())
+
+		m.SwitchIntensity = 1 / dt
+		m.Next(100, dt, rnd)
+		assert.Equal(t, 1, m.Regime())
+	}
+}
+
+func TestSyntheticMarketData_Ticks(t *testing.T) {
+	from := time.Date(2010, 1, 8, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2010, 1, 11, 0, 0, 0, 0, time.UTC)
+	inst := newTestInstrument()
+	other := newTestInstrument()
+	other.Symbol = "Other"
+
+	t.Log("Ticks are made in sessions of trading days with spread around price")
+	{
+		m := NewSyntheticMarketData(MarketDataModeTicksQuotes, from, to, 7)
+		m.Spread = 0.05
+		events := runTestSyntheticMarketData(m, inst, other)
+		assert.Len(t, events, 2*2*390+1)
+		assert.IsType(t, &EndOfDataEvent{}, events[len(events)-1])
+		for _, e := range events[:len(events)-1] {
+			tick := e.(*NewTickEvent).Tick
+			assert.True(t, tick.IsValid())
+			assert.False(t, e.getTime().Weekday() == time.Saturday || e.getTime().Weekday() == time.Sunday)
+			assert.False(t, tick.Datetime.Before(inst.Exchange.OpenTime(tick.Datetime)))
+			assert.True(t, tick.Datetime.Before(inst.Exchange.CloseTime(tick.Datetime)))
+			assert.True(t, tick.AskPrice-tick.BidPrice >= 0.05-0.000001)
+			assert.True(t, tick.LastPrice == tick.BidPrice || tick.LastPrice == tick.AskPrice)
+		}
+		assert.Equal(t, "Other", events[1].(*NewTickEvent).Ticker.Symbol)
+	}
+
+	t.Log("The same seed gives the same data")
+	{
+		e1 := runTestSyntheticMarketData(NewSyntheticMarketData(MarketDataModeTicks, from, to, 7), inst)
+		e2 := runTestSyntheticMarketData(NewSyntheticMarketData(MarketDataModeTicks, from, to, 7), inst)
+		e3 := runTestSyntheticMarketData(NewSyntheticMarketData(MarketDataModeTicks, from, to, 8), inst)
+		assert.Equal(t, e1[100].(*NewTickEvent).Tick.LastPrice, e2[100].(*NewTickEvent).Tick.LastPrice)
+		assert.NotEqual(t, e1[100].(*NewTickEvent).Tick.LastPrice, e3[100].(*NewTickEvent).Tick.LastPrice)
+		assert.True(t, math.IsNaN(e1[100].(*NewTickEvent).Tick.BidPrice))
+	}
+
+	t.Log("Scripted crash moves price")
+	{
+		crash := time.Date(2010, 1, 8, 12, 0, 0, 0, time.UTC)
+		m := NewSyntheticMarketData(MarketDataModeQuotes, from, from, 1)
+		m.Spread = 0
+		m.Model = func(*Instrument) IPriceModel { return &GBMModel{} }
+		m.Shocks = []SyntheticShock{{Time: crash, Return: -0.2}}
+		var before, after float64
+		for _, e := range runTestSyntheticMarketData(m, inst) {
+			if te, ok := e.(*NewTickEvent); ok && te.Time.Equal(crash.Add(-time.Minute)) {
+				before = te.Tick.BidPrice
+			}
+			if te, ok := e.(*NewTickEvent); ok && te.Time.Equal(crash) {
+				after = te.Tick.BidPrice
+			}
+		}
+		assert.Equal(t, 100.0, before)
+		assert.Equal(t, 80.0, after)
+	}
+
+	t.Log("Gap at session open moves open price, overnight part of shock moves the next open")
+	{
+		m := NewSyntheticMarketData(MarketDataModeQuotes, from, to, 1)
+		m.Spread = 0
+		m.Model = func(*Instrument) IPriceModel { return &GBMModel{} }
+		m.Shocks = []SyntheticShock{
+			{Time: time.Date(2010, 1, 8, 9, 30, 0, 0, time.UTC), Return: -0.2},
+			{Time: time.Date(2010, 1, 8, 15, 0, 0, 0, time.UTC), Duration: 24 * time.Hour, Return: 0.5},
+		}
+		var open, nextOpen float64
+		for _, e := range runTestSyntheticMarketData(m, inst) {
+			if te, ok := e.(*NewTickEvent); ok && te.Time.Equal(time.Date(2010, 1, 8, 9, 30, 0, 0, time.UTC)) {
+				open = te.Tick.BidPrice
+			}
+			if te, ok := e.(*NewTickEvent); ok && te.Time.Equal(time.Date(2010, 1, 11, 9, 30, 0, 0, time.UTC)) {
+				nextOpen = te.Tick.BidPrice
+			}
+		}
+		assert.Equal(t, 80.0, open)
+		assert.Equal(t, 120.0, nextOpen)
+	}
+
+	t.Log("History is sent before the first events")
+	{
+		m := NewSyntheticMarketData(MarketDataModeTicks, from, from, 1)
+		m.RequestHistoricalData(time.Hour)
+		events := runTestSyntheticMarketData(m, inst)
+		if assert.IsType(t, &TickHistoryEvent{}, events[0]) {
+			assert.Len(t, events[0].(*TickHistoryEvent).Ticks, 60)
+		}
+		assert.Len(t, events, 2+390-60)
+	}
+}
+
+func TestSyntheticMarketData_Candles(t *testing.T) {
+	from := time.Date(2010, 1, 8, 0, 0, 0, 0, time.UTC)
+	inst := newTestInstrument()
+
+	t.Log("Intraday candles of session: open and close events")
+	{
+		m := NewSyntheticMarketData(MarketDataModeCandles, from, from, 3)
+		m.TimeFrame = "60"
+		events := runTestSyntheticMarketData(m, inst)
+		assert.Len(t, events, 2*7+1)
+		last := events[len(events)-2].(*CandleCloseEvent)
+		assert.Equal(t, time.Date(2010, 1, 8, 16, 0, 0, 0, time.UTC), last.getTime())
+		assert.Equal(t, time.Date(2010, 1, 8, 15, 30, 0, 0, time.UTC), last.Candle.Datetime)
+		for i := 1; i < len(events)-1; i += 2 {
+			c := events[i].(*CandleCloseEvent).Candle
+			assert.True(t, c.isValid())
+			assert.Equal(t, events[i-1].(*CandleOpenEvent).Price, c.Open)
+		}
+	}
+
+	t.Log("Shocks between sessions move open of the next candle")
+	{
+		m := NewSyntheticMarketData(MarketDataModeCandles, from, from.AddDate(0, 0, 3), 3)
+		m.TimeFrame = "D"
+		m.Model = func(*Instrument) IPriceModel { return &GBMModel{} }
+		m.Shocks = []SyntheticShock{
+			{Time: time.Date(2010, 1, 8, 9, 30, 0, 0, time.UTC), Return: -0.2},
+			{Time: time.Date(2010, 1, 9, 12, 0, 0, 0, time.UTC), Return: 0.5},
+		}
+		events := runTestSyntheticMarketData(m, inst)
+		if assert.Len(t, events, 2*2+1) {
+			assert.Equal(t, 80.0, events[0].(*CandleOpenEvent).Price)
+			assert.Equal(t, 120.0, events[2].(*CandleOpenEvent).Price)
+		}
+	}
+
+	t.Log("Daily candles close at market close")
+	{
+		m := NewSyntheticMarketData(MarketDataModeCandles, from, from.AddDate(0, 0, 3), 3)
+		m.TimeFrame = "D"
+		events := runTestSyntheticMarketData(m, inst)
+		if assert.Len(t, events, 2*2+1) {
+			ce := events[3].(*CandleCloseEvent)
+			assert.Equal(t, time.Date(2010, 1, 11, 0, 0, 0, 0, time.UTC), ce.Candle.Datetime)
+			assert.Equal(t, time.Date(2010, 1, 11, 16, 0, 0, 0, time.UTC), ce.getTime())
+		}
+	}
+}
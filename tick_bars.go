@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"sort"
+	"time"
+)
+
+//tickBarsAggregator builds time bars and information driven bars (see info_bars.go) from ticks with trades.
+//Time bars are aligned to market open of instrument exchange and the last bar of session is closed at market
+//close. Time bar is closed as soon as any tick passes its close time, so candle close events never go after
+//later ticks of other symbols
+type tickBarsAggregator struct {
+	timeFrames []string
+	infoBars   []*infoBarSpec
+	fill       bool
+	bars       map[string]map[string]*Candle
+	infoStates map[string]map[string]*infoBarState
+	lastTime   time.Time
+}
+
+func newTickBarsAggregator(timeFrames []string, fillEmpty bool) (*tickBarsAggregator, error) {
+	a := tickBarsAggregator{
+		fill:       fillEmpty,
+		bars:       make(map[string]map[string]*Candle),
+		infoStates: make(map[string]map[string]*infoBarState),
+	}
+	for _, tf := range timeFrames {
+		spec, err := parseInfoBar(tf)
+		if err != nil {
+			return nil, err
+		}
+		if spec != nil {
+			a.infoBars = append(a.infoBars, spec)
+			continue
+		}
+		if _, err := parseTimeFrame(tf); err != nil {
+			return nil, err
+		}
+		a.timeFrames = append(a.timeFrames, tf)
+	}
+	return &a, nil
+}
+
+//onTick returns candle events that should go before tick: closes of bars that are due and opens of new bars,
+//and events that should go after tick: closes of information driven bars completed by the tick
+func (a *tickBarsAggregator) onTick(t *Tick) ([]event, []event) {
+	before, after := a.process(t, "")
+	if t.Datetime.After(a.lastTime) {
+		a.lastTime = t.Datetime
+	}
+	return before, after
+}
+
+//warmUp processes history ticks of one symbol and returns bars closed by them. Bar opened by the last ticks
+//continues with live ticks
+func (a *tickBarsAggregator) warmUp(ticks TickArray) CandleArray {
+	var candles CandleArray
+	for _, t := range ticks {
+		before, after := a.process(t, t.Symbol)
+		for _, e := range append(before, after...) {
+			if ce, ok := e.(*CandleCloseEvent); ok {
+				candles = append(candles, ce.Candle)
+			}
+		}
+	}
+	return candles
+}
+
+//finish closes all bars. Information driven bars in progress are closed at time of their last tick
+func (a *tickBarsAggregator) finish() []event {
+	var events []event
+	for _, symbol := range a.symbols() {
+		for _, tf := range a.timeFrames {
+			if bar, ok := a.bars[symbol][tf]; ok {
+				events = append(events, a.closeEvent(bar))
+				delete(a.bars[symbol], tf)
+			}
+		}
+		for _, spec := range a.infoBars {
+			if st, ok := a.infoStates[symbol][spec.timeFrame]; ok {
+				events = append(events, st.finish()...)
+			}
+		}
+	}
+	sortEventsByTime(events)
+	return events
+}
+
+func (a *tickBarsAggregator) process(t *Tick, symbol string) ([]event, []event) {
+	before := a.flush(t.Datetime, symbol)
+	if !t.HasTrade() {
+		return before, nil
+	}
+
+	symbolBars, ok := a.bars[t.Symbol]
+	if !ok {
+		symbolBars = make(map[string]*Candle)
+		a.bars[t.Symbol] = symbolBars
+	}
+
+	for _, tf := range a.timeFrames {
+		if bar, ok := symbolBars[tf]; ok {
+			updateBarWithTrade(bar, t.LastPrice, t.LastSize)
+			continue
+		}
+
+		start, end, err := candleBucket(t.Datetime, tf, t.Ticker.Exchange)
+		if err != nil {
+			panic(err)
+		}
+		bar := newTickBar(t.Ticker, tf, start, end, t.LastPrice, t.LastSize)
+		symbolBars[tf] = bar
+		openTime := start
+		if a.lastTime.After(openTime) {
+			openTime = a.lastTime
+		}
+		before = append(before, a.openEvent(bar, openTime))
+	}
+
+	var after []event
+	for _, spec := range a.infoBars {
+		states, ok := a.infoStates[t.Symbol]
+		if !ok {
+			states = make(map[string]*infoBarState)
+			a.infoStates[t.Symbol] = states
+		}
+		st, ok := states[spec.timeFrame]
+		if !ok {
+			st = &infoBarState{spec: spec}
+			states[spec.timeFrame] = st
+		}
+		stBefore, stAfter := st.onTrade(t)
+		before = append(before, stBefore...)
+		after = append(after, stAfter...)
+	}
+	return before, after
+}
+
+//flush closes bars with close time not after t. Empty string symbol means all symbols. When filling is enabled
+//empty bars of the same session are put after closed bar
+func (a *tickBarsAggregator) flush(t time.Time, symbol string) []event {
+	var events []event
+	for _, s := range a.symbols() {
+		if symbol != "" && s != symbol {
+			continue
+		}
+		for _, tf := range a.timeFrames {
+			bar, ok := a.bars[s][tf]
+			if !ok || bar.CloseTime.After(t) {
+				continue
+			}
+			delete(a.bars[s], tf)
+			events = append(events, a.closeEvent(bar))
+			if a.fill {
+				events = append(events, a.fillBars(bar, t)...)
+			}
+		}
+	}
+	sortEventsByTime(events)
+	return events
+}
+
+//fillBars puts empty bars with previous close price until bar containing t or until session end
+func (a *tickBarsAggregator) fillBars(prev *Candle, t time.Time) []event {
+	var events []event
+	exchange := prev.Ticker.Exchange
+	for from := prev.CloseTime; isInSession(from, exchange); {
+		start, end, err := candleBucket(from, prev.TimeFrame, exchange)
+		if err != nil {
+			panic(err)
+		}
+		bar := newTickBar(prev.Ticker, prev.TimeFrame, start, end, prev.Close, 0)
+		events = append(events, a.openEvent(bar, start))
+		if end.After(t) {
+			a.bars[prev.Symbol][prev.TimeFrame] = bar
+			break
+		}
+		events = append(events, a.closeEvent(bar))
+		from = end
+	}
+	return events
+}
+
+func (a *tickBarsAggregator) symbols() []string {
+	var symbols []string
+	for s := range a.bars {
+		symbols = append(symbols, s)
+	}
+	for s := range a.infoStates {
+		if _, ok := a.bars[s]; !ok {
+			symbols = append(symbols, s)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func (a *tickBarsAggregator) openEvent(bar *Candle, t time.Time) *CandleOpenEvent {
+	return &CandleOpenEvent{
+		BaseEvent:  be(t, bar.Ticker),
+		CandleTime: bar.Datetime,
+		Price:      bar.Open,
+		TimeFrame:  bar.TimeFrame,
+	}
+}
+
+func (a *tickBarsAggregator) closeEvent(bar *Candle) *CandleCloseEvent {
+	return &CandleCloseEvent{
+		BaseEvent: be(bar.CloseTime, bar.Ticker),
+		Candle:    bar,
+		TimeFrame: bar.TimeFrame,
+	}
+}
+
+func newTickBar(inst *Instrument, tf string, start time.Time, end time.Time, price float64, size int64) *Candle {
+	raw := marketdata.Candle{
+		Datetime: start,
+		Symbol:   inst.Symbol,
+		Open:     price,
+		High:     price,
+		Low:      price,
+		Close:    price,
+		AdjClose: price,
+		Volume:   size,
+	}
+	return &Candle{Candle: &raw, Ticker: inst, TimeFrame: tf, CloseTime: end}
+}
+
+func isInSession(t time.Time, exchange Exchange) bool {
+	if !exchange.IsTradingDay(t) {
+		return false
+	}
+	return !t.Before(exchange.OpenTime(t)) && t.Before(exchange.CloseTime(t))
+}
+
+//sortEventsByTime keeps order of events with the same time
+func sortEventsByTime(events []event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].getTime().Before(events[j].getTime())
+	})
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestBarsTick(inst *Instrument, t time.Time, price float64, size int64) *Tick {
+	return &Tick{Tick: &marketdata.Tick{Datetime: t, Symbol: inst.Symbol, LastPrice: price, LastSize: size,
+		BidPrice: price - 0.01, AskPrice: price + 0.01}, Ticker: inst}
+}
+
+//onTickEvents returns events of aggregator in order BTM puts them. Tick itself is skipped
+func onTickEvents(a *tickBarsAggregator, t *Tick) []event {
+	before, after := a.onTick(t)
+	return append(before, after...)
+}
+
+//describeBarEvents returns short description of events like "O 09:30:00 10" and "C 09:31:00 10.2 300"
+func describeBarEvents(events []event) []string {
+	var res []string
+	for _, e := range events {
+		switch i := e.(type) {
+		case *CandleOpenEvent:
+			res = append(res, fmt.Sprintf("O%v %v %v", i.TimeFrame, i.CandleTime.Format("15:04:05"), i.Price))
+		case *CandleCloseEvent:
+			res = append(res, fmt.Sprintf("C%v %v %v %v", i.TimeFrame, i.getTime().Format("15:04:05"),
+				i.Candle.Close, i.Candle.Volume))
+		}
+	}
+	return res
+}
+
+func TestTickBarsAggregator(t *testing.T) {
+	inst := newTestInstrument()
+	at := func(h, m, s int) time.Time {
+		return time.Date(2018, 3, 7, h, m, s, 0, time.UTC)
+	}
+
+	t.Log("Bars of several timeframes from ticks")
+	{
+		a, err := newTickBarsAggregator([]string{"30s", "1"}, false)
+		assert.Nil(t, err)
+
+		ticks := TickArray{
+			newTestBarsTick(inst, at(9, 30, 5), 10, 100),
+			newTestBarsTick(inst, at(9, 30, 20), 10.5, 100),
+			newTestBarsTick(inst, at(9, 30, 40), 9.5, 200),
+			newTestBarsTick(inst, at(9, 31, 10), 10.2, 100),
+		}
+		var events []event
+		for _, tick := range ticks {
+			events = append(events, onTickEvents(a, tick)...)
+		}
+		events = append(events, a.finish()...)
+
+		assert.Equal(t, []string{
+			"O30s 09:30:00 10", "O1 09:30:00 10",
+			"C30s 09:30:30 10.5 200", "O30s 09:30:30 9.5",
+			"C30s 09:31:00 9.5 200", "C1 09:31:00 9.5 400", "O30s 09:31:00 10.2", "O1 09:31:00 10.2",
+			"C30s 09:31:30 10.2 100", "C1 09:32:00 10.2 100",
+		}, describeBarEvents(events))
+
+		c := events[5].(*CandleCloseEvent).Candle
+		assert.Equal(t, 10.5, c.High)
+		assert.Equal(t, 9.5, c.Low)
+		assert.Equal(t, 10.0, c.Open)
+		assert.Equal(t, at(9, 30, 0), c.Datetime)
+	}
+
+	t.Log("Empty intervals are skipped")
+	{
+		a, _ := newTickBarsAggregator([]string{"1"}, false)
+		var events []event
+		events = append(events, onTickEvents(a, newTestBarsTick(inst, at(10, 0, 5), 10, 100))...)
+		events = append(events, onTickEvents(a, newTestBarsTick(inst, at(10, 3, 5), 11, 100))...)
+		assert.Equal(t, []string{"O1 10:00:00 10", "C1 10:01:00 10 100", "O1 10:03:00 11"},
+			describeBarEvents(events))
+	}
+
+	t.Log("Empty intervals are filled forward")
+	{
+		a, _ := newTickBarsAggregator([]string{"1"}, true)
+		var events []event
+		events = append(events, onTickEvents(a, newTestBarsTick(inst, at(10, 0, 5), 10, 100))...)
+		events = append(events, onTickEvents(a, newTestBarsTick(inst, at(10, 3, 5), 11, 100))...)
+		assert.Equal(t, []string{
+			"O1 10:00:00 10", "C1 10:01:00 10 100",
+			"O1 10:01:00 10", "C1 10:02:00 10 0",
+			"O1 10:02:00 10", "C1 10:03:00 10 0",
+			"O1 10:03:00 10",
+		}, describeBarEvents(events))
+
+		events = a.finish()
+		assert.Equal(t, []string{"C1 10:04:00 11 100"}, describeBarEvents(events))
+		c := events[0].(*CandleCloseEvent).Candle
+		assert.Equal(t, 10.0, c.Open)
+		assert.Equal(t, 11.0, c.High)
+	}
+
+	t.Log("The last bar of session is closed at market close and isn't filled after it")
+	{
+		a, _ := newTickBarsAggregator([]string{"60"}, true)
+		var events []event
+		events = append(events, onTickEvents(a, newTestBarsTick(inst, at(15, 40, 0), 10, 100))...)
+		events = append(events, onTickEvents(a, newTestBarsTick(inst, at(16, 5, 0), 10.1, 100))...)
+		assert.Equal(t, []string{"O60 15:30:00 10", "C60 16:00:00 10 100", "O60 16:00:00 10.1"},
+			describeBarEvents(events))
+	}
+
+	t.Log("Bars of other symbols are closed by any tick")
+	{
+		other := newTestInstrument()
+		other.Symbol = "Other"
+		a, _ := newTickBarsAggregator([]string{"1"}, false)
+		var events []event
+		events = append(events, onTickEvents(a, newTestBarsTick(inst, at(10, 0, 5), 10, 100))...)
+		events = append(events, onTickEvents(a, newTestBarsTick(other, at(10, 1, 5), 20, 100))...)
+		assert.Equal(t, []string{"O1 10:00:00 10", "C1 10:01:00 10 100", "O1 10:01:00 20"},
+			describeBarEvents(events))
+		assert.Equal(t, "Other", events[2].(*CandleOpenEvent).Ticker.Symbol)
+		assert.False(t, events[2].getTime().Before(events[1].getTime()), "Open event doesn't go before close")
+	}
+
+	t.Log("History ticks warm up bars")
+	{
+		a, _ := newTickBarsAggregator([]string{"1"}, false)
+		candles := a.warmUp(TickArray{
+			newTestBarsTick(inst, at(10, 0, 5), 10, 100),
+			newTestBarsTick(inst, at(10, 1, 5), 11, 100),
+		})
+		if assert.Len(t, candles, 1) {
+			assert.Equal(t, at(10, 0, 0), candles[0].Datetime)
+		}
+		events := onTickEvents(a, newTestBarsTick(inst, at(10, 1, 30), 12, 100))
+		assert.Len(t, events, 0)
+		assert.Equal(t, []string{"C1 10:02:00 12 200"}, describeBarEvents(a.finish()))
+	}
+
+	t.Log("Wrong timeframe")
+	{
+		_, err := newTickBarsAggregator([]string{"1x"}, false)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestEngine_isBrokerTimeFrame(t *testing.T) {
+	m := &BTM{mode: MarketDataModeTicks, TickBars: []string{"1"}}
+	c := Engine{md: m}
+	assert.Equal(t, "1", m.PrimaryTimeFrame())
+	assert.False(t, c.isBrokerTimeFrame("1"))
+
+	m.mode = MarketDataModeCandles
+	m.candlesTimeFrame = "5"
+	m.TimeFrames = []string{"60"}
+	assert.True(t, c.isBrokerTimeFrame("5"))
+	assert.False(t, c.isBrokerTimeFrame("60"))
+}
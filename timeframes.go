@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//parseTimeFrame returns duration of candles timeframe. Timeframes are number of minutes ("1", "5", "60"),
+//number of seconds ("30s"), "D" for daily and "W" for weekly candles
+func parseTimeFrame(tf string) (time.Duration, error) {
+	switch tf {
+	case "D":
+		return 24 * time.Hour, nil
+	case "W":
+		return 7 * 24 * time.Hour, nil
+	}
+	unit := time.Minute
+	if strings.HasSuffix(tf, "s") {
+		unit = time.Second
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(tf, "s"), 10, 32)
+	if err != nil || n <= 0 {
+		return 0, errors.New("Unknown timeframe: " + tf)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+func isIntradayTimeFrame(tf string) bool {
+	return tf != "D" && tf != "W"
+}
+
+//closeTime returns time when candle of timeframe closes. Candles built by engine know their close time,
+//for others it's calculated from timeframe
+func (c *Candle) closeTime(tf string) time.Time {
+	if !c.CloseTime.IsZero() {
+		return c.CloseTime
+	}
+	if !isIntradayTimeFrame(tf) {
+		var exchange Exchange
+		if c.Ticker != nil {
+			exchange = c.Ticker.Exchange
+		}
+		_, end, err := candleBucket(c.Datetime, tf, exchange)
+		if err != nil {
+			panic(err)
+		}
+		return end
+	}
+	d, err := parseTimeFrame(tf)
+	if err != nil {
+		panic(err)
+	}
+	return c.Datetime.Add(d)
+}
+
+func sessionTime(day time.Time, t TimeOfDay) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour, t.Minute, t.Second, 0, day.Location())
+}
+
+//candleBucket returns open and close time of higher timeframe candle that contains time t. Times are in
+//exchange location. Intraday candles are aligned to market open and the last candle of session is closed at
+//market close or early close. Candles after market close are aligned to close. Weekly candles start on Monday
+//and close at the end of the last trading day of week
+func candleBucket(t time.Time, tf string, exchange Exchange) (time.Time, time.Time, error) {
+	t = exchange.localTime(t)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch tf {
+	case "D":
+		return day, time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, t.Location()), nil
+	case "W":
+		offset := (int(day.Weekday()) + 6) % 7
+		monday := day.AddDate(0, 0, -offset)
+		last := exchange.lastTradingDayOfWeek(day)
+		return monday, time.Date(last.Year(), last.Month(), last.Day(), 23, 59, 59, 0, t.Location()), nil
+	}
+
+	d, err := parseTimeFrame(tf)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	open := exchange.OpenTime(day)
+	close := exchange.CloseTime(day)
+	//After market close candles are aligned to close, so they don't overlap the last candle of session
+	origin := open
+	if !t.Before(close) {
+		origin = close
+	}
+	n := t.Sub(origin) / d
+	if t.Before(origin) && t.Sub(origin)%d != 0 {
+		n--
+	}
+	start := origin.Add(n * d)
+	end := start.Add(d)
+	if !t.Before(open) && t.Before(close) && end.After(close) {
+		end = close
+	}
+	return start, end, nil
+}
+
+//aggregateCandles builds candles of higher timeframe from sorted candles of one symbol. Result candles
+//have TimeFrame and CloseTime set
+func aggregateCandles(candles CandleArray, tf string, exchange Exchange) (CandleArray, error) {
+	var res CandleArray
+	var current *Candle
+	for _, c := range candles {
+		start, end, err := candleBucket(c.Datetime, tf, exchange)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || !current.Datetime.Equal(start) {
+			raw := *c.Candle
+			raw.Datetime = start
+			current = &Candle{Candle: &raw, Ticker: c.Ticker, TimeFrame: tf, CloseTime: end}
+			res = append(res, current)
+			continue
+		}
+		if c.High > current.High {
+			current.High = c.High
+		}
+		if c.Low < current.Low {
+			current.Low = c.Low
+		}
+		current.Close = c.Close
+		current.AdjClose = c.AdjClose
+		current.Volume += c.Volume
+		current.OpenInterest = c.OpenInterest
+	}
+	return res, nil
+}
+
+//loadTimeFrames loads candles of all timeframes for symbol. Timeframes missing in storage are built from the
+//smallest loaded timeframe. Symbol without candles in range gives no candles and no error. Candles of files with
+//invalid rows are used and ErrStorageFile of the first of them is returned with result
+func loadTimeFrames(storage marketdata.Storage, symbol *Instrument, timeFrames []string,
+	rng marketdata.DateRange) (CandleArray, error) {
+
+	loaded := make(map[string]CandleArray)
+	var missing []string
+	var rowsErr error
+	for _, tf := range timeFrames {
+		raw, err := storage.GetStoredCandles(symbol.Symbol, tf, rng)
+		if _, ok := err.(*ErrStorageFile); ok {
+			if rowsErr == nil {
+				rowsErr = err
+			}
+			err = nil
+		}
+		if err != nil || len(raw) == 0 {
+			missing = append(missing, tf)
+			continue
+		}
+		var arr CandleArray
+		for _, r := range raw {
+			arr = append(arr, &Candle{Candle: r, Ticker: symbol, TimeFrame: tf})
+		}
+		sort.SliceStable(arr, func(i, j int) bool { return arr[i].Datetime.Before(arr[j].Datetime) })
+		loaded[tf] = arr
+	}
+	if len(loaded) == 0 {
+		return nil, rowsErr
+	}
+
+	for _, tf := range missing {
+		target, err := parseTimeFrame(tf)
+		if err != nil {
+			return nil, err
+		}
+		base := ""
+		var baseDuration time.Duration
+		for ltf := range loaded {
+			d, err := parseTimeFrame(ltf)
+			if err != nil || d >= target || (tf != "W" && !isIntradayTimeFrame(ltf)) {
+				continue
+			}
+			if base == "" || d < baseDuration {
+				base, baseDuration = ltf, d
+			}
+		}
+		if base == "" {
+			return nil, errors.New("Can't load or build timeframe " + tf + " for " + symbol.Symbol)
+		}
+		arr, err := aggregateCandles(loaded[base], tf, symbol.Exchange)
+		if err != nil {
+			return nil, err
+		}
+		loaded[tf] = arr
+	}
+
+	var res CandleArray
+	for _, tf := range timeFrames {
+		res = append(res, loaded[tf]...)
+	}
+	return res, rowsErr
+}
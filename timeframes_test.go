@@ -0,0 +1,410 @@
+package engine
+
+import (
+	"alex/marketdata"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mapCandlesStorage struct {
+	candles map[string]marketdata.CandleArray
+}
+
+func (s *mapCandlesStorage) GetStoredTicks(symbol string, dRange marketdata.DateRange, quotes bool,
+	trades bool) (marketdata.TickArray, error) {
+	return nil, errors.New("Not implemented")
+}
+
+func (s *mapCandlesStorage) GetStoredCandles(symbol string, tf string,
+	dRange marketdata.DateRange) (marketdata.CandleArray, error) {
+	c, ok := s.candles[tf]
+	if !ok {
+		return nil, errors.New("No candles for timeframe " + tf)
+	}
+	return c, nil
+}
+
+//newTestSessionCandles returns candles of timeframe in minutes for the whole session of test instrument
+func newTestSessionCandles(day time.Time, minutes int) marketdata.CandleArray {
+	var res marketdata.CandleArray
+	open := time.Date(day.Year(), day.Month(), day.Day(), 9, 30, 0, 0, time.UTC)
+	close := time.Date(day.Year(), day.Month(), day.Day(), 16, 0, 0, 0, time.UTC)
+	price := 10.0
+	for t := open; t.Before(close); t = t.Add(time.Duration(minutes) * time.Minute) {
+		res = append(res, &marketdata.Candle{Datetime: t, Symbol: "Test", Open: price, High: price + 0.5,
+			Low: price - 0.5, Close: price + 0.1, AdjClose: price + 0.1, Volume: 100})
+		price += 0.1
+	}
+	return res
+}
+
+func TestParseTimeFrame(t *testing.T) {
+	d, err := parseTimeFrame("240")
+	assert.Nil(t, err)
+	assert.Equal(t, 4*time.Hour, d)
+
+	d, err = parseTimeFrame("D")
+	assert.Nil(t, err)
+	assert.Equal(t, 24*time.Hour, d)
+
+	_, err = parseTimeFrame("1H")
+	assert.NotNil(t, err)
+	_, err = parseTimeFrame("0")
+	assert.NotNil(t, err)
+}
+
+func TestCandleBucket(t *testing.T) {
+	ex := newTestInstrument().Exchange
+	day := time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, time.UTC)
+	}
+
+	t.Log("Intraday buckets are aligned to market open")
+	{
+		start, end, err := candleBucket(at(10, 45), "60", ex)
+		assert.Nil(t, err)
+		assert.Equal(t, at(10, 30), start)
+		assert.Equal(t, at(11, 30), end)
+	}
+
+	t.Log("The last bucket of session closes at market close")
+	{
+		start, end, err := candleBucket(at(15, 45), "60", ex)
+		assert.Nil(t, err)
+		assert.Equal(t, at(15, 30), start)
+		assert.Equal(t, at(16, 0), end)
+	}
+
+	t.Log("Premarket buckets are aligned too")
+	{
+		start, end, err := candleBucket(at(9, 0), "60", ex)
+		assert.Nil(t, err)
+		assert.Equal(t, at(8, 30), start)
+		assert.Equal(t, at(9, 30), end)
+	}
+
+	t.Log("After market close buckets are aligned to close")
+	{
+		start, end, err := candleBucket(at(16, 5), "60", ex)
+		assert.Nil(t, err)
+		assert.Equal(t, at(16, 0), start)
+		assert.Equal(t, at(17, 0), end)
+	}
+
+	t.Log("Weekly bucket starts on Monday and closes on Friday")
+	{
+		start, end, err := candleBucket(at(12, 0), "W", ex)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC), start)
+		assert.Equal(t, time.Date(2018, 3, 9, 23, 59, 59, 0, time.UTC), end)
+	}
+}
+
+func TestAggregateCandles(t *testing.T) {
+	inst := newTestInstrument()
+	day := time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)
+	var candles CandleArray
+	for _, c := range newTestSessionCandles(day, 30) {
+		candles = append(candles, &Candle{Candle: c, Ticker: inst, TimeFrame: "30"})
+	}
+	assert.Len(t, candles, 13)
+
+	t.Log("Hourly candles from 30 minutes candles")
+	{
+		hourly, err := aggregateCandles(candles, "60", inst.Exchange)
+		assert.Nil(t, err)
+		assert.Len(t, hourly, 7)
+
+		first := hourly[0]
+		assert.Equal(t, "60", first.TimeFrame)
+		assert.Equal(t, candles[0].Datetime, first.Datetime)
+		assert.Equal(t, candles[0].Open, first.Open)
+		assert.Equal(t, candles[1].High, first.High)
+		assert.Equal(t, candles[0].Low, first.Low)
+		assert.Equal(t, candles[1].Close, first.Close)
+		assert.Equal(t, int64(200), first.Volume)
+
+		last := hourly[6]
+		assert.Equal(t, candles[12].Datetime, last.Datetime)
+		assert.Equal(t, int64(100), last.Volume)
+		assert.Equal(t, time.Date(2018, 3, 7, 16, 0, 0, 0, time.UTC), last.CloseTime)
+		assert.True(t, last.isClosingForTimeFrame("60"))
+		assert.False(t, first.isClosingForTimeFrame("60"))
+
+		assert.Equal(t, "30", candles[0].TimeFrame, "Source candles are not changed")
+		assert.Equal(t, int64(100), candles[0].Volume)
+	}
+
+	t.Log("Daily candle from 30 minutes candles")
+	{
+		daily, err := aggregateCandles(candles, "D", inst.Exchange)
+		assert.Nil(t, err)
+		if assert.Len(t, daily, 1) {
+			assert.Equal(t, day, daily[0].Datetime)
+			assert.Equal(t, candles[0].Open, daily[0].Open)
+			assert.Equal(t, candles[12].Close, daily[0].Close)
+			assert.Equal(t, candles[12].High, daily[0].High)
+			assert.Equal(t, int64(1300), daily[0].Volume)
+		}
+	}
+}
+
+func TestLoadTimeFrames(t *testing.T) {
+	inst := newTestInstrument()
+	day := time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)
+	storage := mapCandlesStorage{candles: map[string]marketdata.CandleArray{
+		"30": newTestSessionCandles(day, 30),
+		"5":  newTestSessionCandles(day, 5),
+	}}
+
+	t.Log("Missing timeframes are built from the smallest loaded one")
+	{
+		candles, err := loadTimeFrames(&storage, inst, []string{"30", "60", "D", "5"}, marketdata.DateRange{})
+		assert.Nil(t, err)
+		count := make(map[string]int)
+		for _, c := range candles {
+			count[c.TimeFrame]++
+			assert.True(t, inst == c.Ticker)
+		}
+		assert.Equal(t, map[string]int{"5": 78, "30": 13, "60": 7, "D": 1}, count)
+		assert.Equal(t, "30", candles[0].TimeFrame, "Candles go in order of timeframes")
+	}
+
+	t.Log("Lower timeframe can't be built")
+	{
+		_, err := loadTimeFrames(&storage, inst, []string{"30", "1"}, marketdata.DateRange{})
+		assert.NotNil(t, err)
+	}
+
+	t.Log("Symbol without candles isn't an error")
+	{
+		other := newTestInstrument()
+		other.Symbol = "Other"
+		candles, err := loadTimeFrames(&mapCandlesStorage{}, other, []string{"30", "D"}, marketdata.DateRange{})
+		assert.Nil(t, err)
+		assert.Len(t, candles, 0)
+	}
+}
+
+func newTestTimeFramesBTM() *BTM {
+	m := BTM{candlesTimeFrame: "30", TimeFrames: []string{"60", "30"}, waitGroup: &sync.WaitGroup{}}
+	m.Init(make(chan error, 10), make(chan event, 100))
+	return &m
+}
+
+func TestBTM_TimeFrames(t *testing.T) {
+	t.Log("Timeframes without duplicates and primary first")
+	{
+		m := newTestTimeFramesBTM()
+		assert.Equal(t, []string{"30", "60"}, m.timeFrames())
+		assert.Equal(t, "30", m.PrimaryTimeFrame())
+	}
+
+	t.Log("File name is changed only by additional timeframes")
+	{
+		m := newTestTimeFramesBTM()
+		m.Symbols = []*Instrument{newTestInstrument()}
+		m.mode = MarketDataModeCandles
+		withTimeFrames, _ := m.getFilename()
+		m.TimeFrames = nil
+		single, _ := m.getFilename()
+		m.TimeFrames = []string{"30"}
+		duplicate, _ := m.getFilename()
+		assert.NotEqual(t, withTimeFrames, single)
+		assert.Equal(t, single, duplicate)
+	}
+
+	t.Log("Only due candle closes are put before candle open. Lower timeframe closes first")
+	{
+		m := newTestTimeFramesBTM()
+		inst := newTestInstrument()
+		day := time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)
+		var thirty, hourly CandleArray
+		for _, c := range newTestSessionCandles(day, 30)[:4] {
+			thirty = append(thirty, &Candle{Candle: c, Ticker: inst, TimeFrame: "30"})
+		}
+		hourly, _ = aggregateCandles(thirty, "60", inst.Exchange)
+
+		var pending []*CandleCloseEvent
+		order := CandleArray{thirty[0], hourly[0], thirty[1], thirty[2], hourly[1], thirty[3]}
+		for _, c := range order {
+			pending = m.newCandleEvents(c, pending)
+		}
+		m.flushAllCandleCloses(pending)
+		close(m.mdChan)
+
+		var got []string
+		for e := range m.mdChan {
+			switch i := e.(type) {
+			case *CandleOpenEvent:
+				got = append(got, "O"+i.TimeFrame+" "+i.CandleTime.Format("15:04"))
+			case *CandleCloseEvent:
+				got = append(got, "C"+i.TimeFrame+" "+i.getTime().Format("15:04"))
+			}
+		}
+		assert.Equal(t, []string{
+			"O30 09:30", "O60 09:30",
+			"C30 10:00", "O30 10:00",
+			"C30 10:30", "C60 10:30", "O30 10:30", "O60 10:30",
+			"C30 11:00", "O30 11:00",
+			"C30 11:30", "C60 11:30",
+		}, got)
+	}
+
+	t.Log("Prepaired line with timeframe and close time")
+	{
+		m := newTestTimeFramesBTM()
+		inst := newTestInstrument()
+		tm := time.Date(2018, 3, 7, 15, 30, 0, 0, time.UTC)
+		raw := marketdata.Candle{Datetime: tm, Symbol: "Test", Open: 10, High: 11, Low: 9, Close: 10.5,
+			AdjClose: 10.5, Volume: 100}
+		tickers := map[string]*Instrument{"Test": inst}
+
+		c, err := m.parseLineToEngineCandle(raw.String()+",60,"+"1520438400", tickers)
+		assert.Nil(t, err)
+		assert.Equal(t, "60", c.TimeFrame)
+		assert.Equal(t, int64(1520438400), c.CloseTime.Unix())
+		assert.True(t, inst == c.Ticker)
+
+		c, err = m.parseLineToEngineCandle(raw.String(), tickers)
+		assert.Nil(t, err)
+		assert.Equal(t, "30", c.TimeFrame)
+		assert.True(t, c.CloseTime.IsZero())
+	}
+}
+
+type timeFramesTestStrategy struct {
+	closes []string
+	opens  int
+}
+
+func (s *timeFramesTestStrategy) OnTick(b *BasicStrategy, tick *Tick) {
+
+}
+
+func (s *timeFramesTestStrategy) OnCandleClose(b *BasicStrategy, candle *Candle) {
+	s.closes = append(s.closes, candle.TimeFrame)
+}
+
+func (s *timeFramesTestStrategy) OnCandleOpen(b *BasicStrategy, price float64) {
+	s.opens++
+}
+
+func TestBasicStrategy_TimeFrames(t *testing.T) {
+	inst := newTestInstrument()
+	us := timeFramesTestStrategy{}
+	bs := BasicStrategy{symbol: inst, nPeriods: 2, userStrategy: &us}
+	bs.init(CoreStrategyChannels{
+		errors:    make(chan error, 10),
+		events:    make(chan event, 10),
+		portfolio: make(chan *PortfolioNewPositionEvent, 5),
+	})
+	bs.mdChan = make(chan event, 1)
+	bs.mdChan <- &NewTickEvent{}
+	bs.handlersWaitGroup = &sync.WaitGroup{}
+	bs.setPrimaryTimeFrame("30")
+
+	day := time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)
+	var thirty CandleArray
+	for _, c := range newTestSessionCandles(day, 30) {
+		thirty = append(thirty, &Candle{Candle: c, Ticker: inst, TimeFrame: "30"})
+	}
+	hourly, _ := aggregateCandles(thirty, "60", inst.Exchange)
+
+	hourlyInd := countingIndicator{}
+	bs.AddCandleIndicatorFor("60", &hourlyInd)
+	primaryInd := countingIndicator{}
+	bs.AddCandleIndicator(&primaryInd)
+
+	t.Log("History is split by timeframes")
+	{
+		bs.proxyEvent(&CandlesHistoryEvent{BaseEvent: be(thirty[2].Datetime, inst),
+			Candles: CandleArray{thirty[0], hourly[0], thirty[1], thirty[2]}})
+		assert.Equal(t, CandleArray{thirty[1], thirty[2]}, bs.Candles)
+		assert.Equal(t, CandleArray{hourly[0]}, bs.CandlesOf("60"))
+		assert.Equal(t, bs.Candles, bs.CandlesOf("30"))
+		assert.Equal(t, []string{"30", "60"}, bs.TimeFrames())
+		assert.Len(t, primaryInd.closes, 3)
+		assert.Len(t, hourlyInd.closes, 1)
+		assert.Equal(t, thirty[2].Open, bs.LastCandleOpen())
+	}
+
+	t.Log("Candle close of every timeframe goes to its window")
+	{
+		bs.proxyEvent(&CandleOpenEvent{BaseEvent: be(hourly[1].Datetime, inst), CandleTime: hourly[1].Datetime,
+			Price: 100, TimeFrame: "60"})
+		bs.handlersWaitGroup.Wait()
+		assert.Equal(t, 0, us.opens, "Candle open of other timeframe is not passed to user strategy")
+		assert.Equal(t, thirty[2].Open, bs.LastCandleOpen())
+
+		bs.proxyEvent(&CandleCloseEvent{BaseEvent: be(thirty[3].Datetime, inst), Candle: thirty[3], TimeFrame: "30"})
+		bs.proxyEvent(&CandleCloseEvent{BaseEvent: be(hourly[1].Datetime, inst), Candle: hourly[1], TimeFrame: "60"})
+		bs.handlersWaitGroup.Wait()
+
+		assert.Equal(t, CandleArray{thirty[2], thirty[3]}, bs.Candles)
+		assert.Equal(t, CandleArray{hourly[0], hourly[1]}, bs.CandlesOf("60"))
+		assert.Equal(t, []string{"30", "60"}, us.closes)
+		assert.Len(t, primaryInd.closes, 4)
+		assert.Len(t, hourlyInd.closes, 2)
+	}
+
+	t.Log("OnCandleClose waits for nPeriods candles of timeframe")
+	{
+		daily, _ := aggregateCandles(thirty, "D", inst.Exchange)
+		bs.proxyEvent(&CandleCloseEvent{BaseEvent: be(daily[0].CloseTime, inst), Candle: daily[0], TimeFrame: "D"})
+		bs.handlersWaitGroup.Wait()
+		assert.Equal(t, []string{"30", "60"}, us.closes)
+		assert.Len(t, bs.CandlesOf("D"), 1)
+	}
+}
+
+func TestBTM_CandlesHistoryTimeFrames(t *testing.T) {
+	t.Log("History has only closed candles, open daily candle comes later with its close event")
+	{
+		inst := newTestInstrument()
+		day := time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)
+		storage := mapCandlesStorage{candles: map[string]marketdata.CandleArray{"5": newTestSessionCandles(day, 5)}}
+		m := BTM{
+			Symbols:          []*Instrument{inst},
+			FromDate:         day,
+			ToDate:           day.Add(24*time.Hour - time.Second),
+			Streaming:        true,
+			Storage:          &storage,
+			mode:             MarketDataModeCandles,
+			candlesTimeFrame: "5",
+			TimeFrames:       []string{"D"},
+			waitGroup:        &sync.WaitGroup{},
+		}
+		m.Init(make(chan error, 10), make(chan event, 1000))
+		m.RequestHistoricalData(10 * time.Hour)
+		m.genCandlesEventsWithHistory()
+
+		var history *CandlesHistoryEvent
+		var dailyClose *CandleCloseEvent
+		for _, e := range readTestEvents(&m) {
+			switch i := e.(type) {
+			case *CandlesHistoryEvent:
+				history = i
+			case *CandleCloseEvent:
+				if i.TimeFrame == "D" {
+					dailyClose = i
+				}
+			}
+		}
+		if assert.NotNil(t, history) {
+			assert.Equal(t, time.Date(2018, 3, 7, 10, 0, 0, 0, time.UTC), history.getTime())
+			assert.Len(t, history.Candles, 6)
+			for _, c := range history.Candles {
+				assert.Equal(t, "5", c.TimeFrame)
+				assert.False(t, c.closeTime(c.TimeFrame).After(history.getTime()))
+			}
+		}
+		if assert.NotNil(t, dailyClose) {
+			assert.Equal(t, day, dailyClose.Candle.Datetime)
+		}
+	}
+}
@@ -8,6 +8,7 @@ type ITimerEventProducer interface {
 	Connect(errChan chan error, eventChan chan event)
 	SetDelay(d int64)
 	SetFraction(f int64)
+	SetClock(clock Clock)
 }
 
 type TimerEventProducer struct {
@@ -16,6 +17,7 @@ type TimerEventProducer struct {
 	eventChan chan event
 	errChan   chan error
 	stopChan  chan struct{}
+	clock     Clock
 }
 
 func (t *TimerEventProducer) Connect(errChan chan error, eventChan chan event) {
@@ -41,7 +43,7 @@ func (t *TimerEventProducer) Run() {
 		for {
 			select {
 			case e := <-tickChan.C:
-				te := TimerTickEvent{be(e, &Instrument{})}
+				te := TimerTickEvent{BaseEvent: be(t.now(e), &Instrument{})}
 				go t.newEvent(&te)
 			case <-t.stopChan:
 				tickChan.Stop()
@@ -53,6 +55,18 @@ func (t *TimerEventProducer) Run() {
 
 }
 
+//now returns clock time if clock is set. Otherwise time of ticker is used
+func (t *TimerEventProducer) now(tickTime time.Time) time.Time {
+	if t.clock == nil {
+		return tickTime
+	}
+	return t.clock.Now()
+}
+
+func (t *TimerEventProducer) SetClock(clock Clock) {
+	t.clock = clock
+}
+
 func (t *TimerEventProducer) newEvent(e event) {
 	t.eventChan <- e
 }
@@ -308,7 +308,7 @@ func TestTrade_OrdersFlow(t *testing.T) {
 		}
 		assert.Equal(t, 200.00, trade.ConfirmedOrders["999"].Price)
 
-		err = trade.replaceOrder("999", 150.0)
+		err = trade.replaceOrder("999", OrderAmendment{Price: 150.0}, time.Now())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -328,7 +328,7 @@ func TestTrade_OrdersFlow(t *testing.T) {
 
 		assert.True(t, math.IsNaN(trade.ConfirmedOrders["*8"].Price))
 
-		err = trade.replaceOrder("*8", 200)
+		err = trade.replaceOrder("*8", OrderAmendment{Price: 200}, time.Now())
 		assert.NotNil(t, err)
 
 		assert.True(t, math.IsNaN(trade.ConfirmedOrders["*8"].Price))
@@ -946,3 +946,56 @@ func TestTrade_updatePnL(t *testing.T) {
 
 	}
 }
+
+func TestTrade_AmendOrder(t *testing.T) {
+	trade := newFlatTrade(newTestInstrument())
+	tm := newTestOrderTime()
+
+	order := newTestOrder(20, OrderBuy, 200, "A1")
+	assert.Nil(t, trade.putNewOrder(order))
+	assert.Nil(t, trade.confirmOrder("A1"))
+	_, err := trade.executeOrder("A1", 50, 20, tm)
+	assert.Nil(t, err)
+
+	t.Log("Qty can't go below executed qty")
+	{
+		err := trade.replaceOrder("A1", OrderAmendment{Qty: 49}, tm)
+		assert.NotNil(t, err)
+		assert.Equal(t, int64(200), order.Qty)
+		assert.Len(t, order.Replaces, 0)
+	}
+
+	t.Log("Empty amendment is an error")
+	{
+		assert.NotNil(t, trade.replaceOrder("A1", OrderAmendment{Price: math.NaN()}, tm))
+	}
+
+	t.Log("Qty and price change keep leaves qty right")
+	{
+		err := trade.replaceOrder("A1", OrderAmendment{Price: 19.5, Qty: 100}, tm.Add(time.Second))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(100), order.Qty)
+		assert.Equal(t, int64(50), order.ExecQty)
+		assert.Equal(t, 19.5, order.Price)
+		assert.Equal(t, PartialFilledOrder, order.State)
+
+		_, err = trade.executeOrder("A1", 60, 19.5, tm.Add(2*time.Second))
+		assert.NotNil(t, err, "Only 50 shares are left")
+	}
+
+	t.Log("Qty reduced to executed qty moves order to filled")
+	{
+		err := trade.replaceOrder("A1", OrderAmendment{Qty: 50}, tm.Add(3*time.Second))
+		assert.Nil(t, err)
+		assert.Equal(t, FilledOrder, order.State)
+		assert.NotContains(t, trade.ConfirmedOrders, "A1")
+		assert.Contains(t, trade.FilledOrders, "A1")
+		assert.Equal(t, int64(50), trade.Qty)
+
+		if assert.Len(t, order.Replaces, 2) {
+			assert.Equal(t, OrderReplacement{Time: tm.Add(3 * time.Second), OldPrice: 19.5, NewPrice: 19.5,
+				OldQty: 100, NewQty: 50, OldTif: order.Tif, NewTif: order.Tif, OldDestination: order.Destination,
+				NewDestination: order.Destination}, order.Replaces[1])
+		}
+	}
+}